@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// requestIDHeader carries the ID a request is logged under. A caller's own ID is
+// kept, so its logs and ours can be matched; otherwise one is generated.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds a caller-supplied request ID
+const maxRequestIDLength = 128
+
+// withAccessLog logs every request at level once it has been served, with its
+// method, path, status, duration, response size, caller and request ID. The
+// request ID is echoed in the X-Request-ID response header.
+func withAccessLog(next http.Handler, logger *zap.Logger, level zapcore.Level) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if entry := logger.Check(level, "HTTP request"); entry != nil {
+			entry.Write(
+				zap.String("requestId", requestID),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int("status", rec.statusCode()),
+				zap.Duration("duration", time.Since(start)),
+				zap.Int64("bytes", rec.bytes),
+				zap.String("remoteAddr", r.RemoteAddr),
+				zap.String("userAgent", r.UserAgent()),
+			)
+		}
+	})
+}
+
+// newRequestID returns a random 128-bit hex ID
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// statusRecorder records the status code and body size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// statusCode is the status sent, 200 if the handler wrote nothing
+func (s *statusRecorder) statusCode() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
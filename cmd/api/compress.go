@@ -0,0 +1,147 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipWriters reuses gzip writers, whose buffers are large, across responses
+var gzipWriters = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(io.Discard) },
+}
+
+// withCompression gzips JSON responses of at least minBytes for callers sending
+// Accept-Encoding: gzip. Smaller responses aren't worth the overhead and are sent
+// as they are. /metrics is left alone, since the Prometheus handler negotiates its
+// own compression.
+func withCompression(next http.Handler, minBytes int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, minBytes: minBytes}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.TrimSpace(coding)
+		if !strings.EqualFold(coding, "gzip") && coding != "*" {
+			continue
+		}
+
+		// q=0 explicitly refuses the coding
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the first minBytes of a response, then decides
+// from its headers whether to compress it. A response that ends before minBytes
+// is written uncompressed by close.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minBytes int
+
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.status == 0 {
+		g.status = status
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	if g.decided {
+		return g.ResponseWriter.Write(p)
+	}
+
+	g.buf = append(g.buf, p...)
+	if len(g.buf) < g.minBytes {
+		return len(p), nil
+	}
+	if err := g.flushBuffered(true); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// flushBuffered writes the header and the buffered body, compressed if the
+// response is large enough and is JSON not already encoded by the handler
+func (g *gzipResponseWriter) flushBuffered(large bool) error {
+	g.decided = true
+	header := g.Header()
+	compress := large &&
+		header.Get("Content-Encoding") == "" &&
+		strings.HasPrefix(header.Get("Content-Type"), "application/json")
+
+	if compress {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		g.ResponseWriter.WriteHeader(g.status)
+
+		g.gz = gzipWriters.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+		_, err := g.gz.Write(g.buf)
+		g.buf = nil
+		return err
+	}
+
+	if g.status != 0 {
+		g.ResponseWriter.WriteHeader(g.status)
+	}
+	var err error
+	if len(g.buf) > 0 {
+		_, err = g.ResponseWriter.Write(g.buf)
+	}
+	g.buf = nil
+	return err
+}
+
+// close finishes the response once the handler has returned
+func (g *gzipResponseWriter) close() {
+	if g.gz != nil {
+		g.gz.Close()
+		gzipWriters.Put(g.gz)
+		return
+	}
+	if !g.decided {
+		g.flushBuffered(false)
+	}
+}
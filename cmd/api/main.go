@@ -1,19 +1,31 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"kafka-pipeline/internal/health"
 	"kafka-pipeline/internal/store"
+	"kafka-pipeline/internal/validation"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var (
@@ -33,19 +45,45 @@ var (
 		},
 		[]string{"method", "endpoint"},
 	)
+
+	orphanPayments = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "orphan_payments",
+			Help: "Payments whose order has never been ingested, as of the last orphan payment report",
+		},
+	)
+
+	sqlKeepaliveFailuresTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sql_keepalive_failures_total",
+			Help: "Total number of failed SQL keepalive probes",
+		},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(httpRequestsTotal)
 	prometheus.MustRegister(httpLatencySeconds)
+	prometheus.MustRegister(sqlKeepaliveFailuresTotal)
+	prometheus.MustRegister(orphanPayments)
 }
 
 type APIResponse struct {
-	User         *store.User    `json:"user,omitempty"`
-	RecentOrders []*store.Order `json:"recentOrders,omitempty"`
-	Order        *store.Order   `json:"order,omitempty"`
-	Payment      *store.Payment `json:"payment,omitempty"`
-	Error        string         `json:"error,omitempty"`
+	User         *store.User      `json:"user,omitempty"`
+	RecentOrders []*store.Order   `json:"recentOrders,omitempty"`
+	Order        *store.Order     `json:"order,omitempty"`
+	Payment      *store.Payment   `json:"payment,omitempty"`
+	Inventory    *store.Inventory `json:"inventory,omitempty"`
+	Error        string           `json:"error,omitempty"`
+}
+
+// writeJSONError writes {"error": msg} with the given status, so failures are JSON
+// like every successful response
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIResponse{Error: msg})
 }
 
 func main() {
@@ -58,56 +96,207 @@ func main() {
 
 	// Get configuration from environment
 	mssqlConn := getEnv("MSSQL_CONN", "server=localhost;user id=sa;password=Your_strong_pwd1;database=events;encrypt=disable")
+	mssqlReplicaConn := getEnv("MSSQL_REPLICA_CONN", "")
 	servicePort := getEnv("SERVICE_PORT", "8082")
+	sqlConnMaxIdleTime := getEnvDuration("MSSQL_CONN_MAX_IDLE_TIME", 0, logger)
+	sqlKeepaliveInterval := getEnvDuration("MSSQL_KEEPALIVE_INTERVAL", 0, logger)
+	sqlPoolConfig := sqlPoolConfigFromEnv(logger)
+	shutdownTimeout := getEnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second, logger)
+	adminToken := getEnv("ADMIN_API_TOKEN", "")
+	readinessTimeout := getEnvDuration("READINESS_TIMEOUT", 2*time.Second, logger)
+	gzipMinBytes, err := strconv.Atoi(getEnv("GZIP_MIN_BYTES", "1024"))
+	if err != nil || gzipMinBytes < 0 {
+		logger.Fatal("GZIP_MIN_BYTES must be a non-negative integer")
+	}
+	accessLogLevel, err := zapcore.ParseLevel(getEnv("ACCESS_LOG_LEVEL", "info"))
+	if err != nil {
+		logger.Fatal("Invalid ACCESS_LOG_LEVEL", zap.Error(err))
+	}
+
+	// Cancelled on SIGINT/SIGTERM to begin a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Initialize MS SQL store
-	sqlStore, err := store.NewMSSQLStore(mssqlConn, logger)
+	sqlStore, err := store.NewMSSQLStoreWithReplica(mssqlConn, mssqlReplicaConn, logger)
 	if err != nil {
 		logger.Fatal("Failed to initialize SQL store", zap.Error(err))
 	}
 	defer sqlStore.Close()
+	if mssqlReplicaConn != "" {
+		logger.Info("Routing reads to MS SQL read replica")
+	}
+	sqlStore.SetPoolConfig(sqlPoolConfig)
+	if sqlConnMaxIdleTime > 0 {
+		sqlStore.SetConnMaxIdleTime(sqlConnMaxIdleTime)
+	}
+	if sqlKeepaliveInterval > 0 {
+		go sqlStore.Keepalive(ctx, sqlKeepaliveInterval, func(error) {
+			sqlKeepaliveFailuresTotal.Inc()
+		})
+	}
 
 	// Create HTTP server
 	mux := http.NewServeMux()
 
-	// Health check endpoint
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	// Health check endpoints; /health is kept as an alias of /livez for existing probes
+	mux.HandleFunc("GET /health", health.Live)
+	mux.HandleFunc("GET /livez", health.Live)
+	mux.HandleFunc("GET /readyz", health.Ready(map[string]health.Check{
+		"sql": sqlStore.Ping,
+	}, readinessTimeout, logger))
 
 	// Metrics endpoint
-	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("GET /metrics", promhttp.Handler())
 
 	// API endpoints
-	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("GET /users", func(w http.ResponseWriter, r *http.Request) {
+		handleListUsers(w, r, sqlStore, logger)
+	})
+
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
 		handleGetUser(w, r, sqlStore, logger)
 	})
 
-	mux.HandleFunc("/orders/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("GET /users/{id}/orders", func(w http.ResponseWriter, r *http.Request) {
+		handleGetUserOrders(w, r, sqlStore, logger)
+	})
+
+	mux.HandleFunc("GET /users/{id}/export", requireAdminToken(adminToken, "/users/export", func(w http.ResponseWriter, r *http.Request) {
+		handleExportUserData(w, r, sqlStore, logger)
+	}))
+
+	mux.HandleFunc("DELETE /users/{id}", requireAdminToken(adminToken, "/users/delete", func(w http.ResponseWriter, r *http.Request) {
+		handleDeleteUser(w, r, sqlStore, logger)
+	}))
+
+	mux.HandleFunc("GET /orders", func(w http.ResponseWriter, r *http.Request) {
+		handleQueryOrders(w, r, sqlStore, logger)
+	})
+
+	mux.HandleFunc("GET /orders/{id}", func(w http.ResponseWriter, r *http.Request) {
 		handleGetOrder(w, r, sqlStore, logger)
 	})
 
-	mux.HandleFunc("/reviews/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("GET /orders/{id}/timeline", func(w http.ResponseWriter, r *http.Request) {
+		handleGetOrderTimeline(w, r, sqlStore, logger)
+	})
+
+	mux.HandleFunc("GET /inventory/{sku}", func(w http.ResponseWriter, r *http.Request) {
+		handleGetInventory(w, r, sqlStore, logger)
+	})
+
+	mux.HandleFunc("GET /inventory/{sku}/history", func(w http.ResponseWriter, r *http.Request) {
+		handleGetInventoryHistory(w, r, sqlStore, logger)
+	})
+
+	mux.HandleFunc("GET /reviews/{id}", func(w http.ResponseWriter, r *http.Request) {
 		handleGetProductReview(w, r, sqlStore, logger)
 	})
 
-	mux.HandleFunc("/products/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("GET /products/{name}", func(w http.ResponseWriter, r *http.Request) {
 		handleGetProductReviewsByProduct(w, r, sqlStore, logger)
 	})
 
+	mux.HandleFunc("GET /products/{name}/reviews", func(w http.ResponseWriter, r *http.Request) {
+		handleGetProductReviewsByProduct(w, r, sqlStore, logger)
+	})
+
+	mux.HandleFunc("GET /products/{name}/rating/trend", func(w http.ResponseWriter, r *http.Request) {
+		handleGetRatingTrend(w, r, sqlStore, logger)
+	})
+
+	mux.HandleFunc("GET /products/{name}/stats", func(w http.ResponseWriter, r *http.Request) {
+		handleGetProductReviewStats(w, r, sqlStore, logger)
+	})
+
+	mux.HandleFunc("GET /payments", func(w http.ResponseWriter, r *http.Request) {
+		handleListPayments(w, r, sqlStore, logger)
+	})
+
+	mux.HandleFunc("GET /changes", func(w http.ResponseWriter, r *http.Request) {
+		handleGetRecentlyUpdated(w, r, sqlStore, logger)
+	})
+
+	mux.HandleFunc("GET /reports/reminders", func(w http.ResponseWriter, r *http.Request) {
+		handleListReminders(w, r, sqlStore, logger)
+	})
+
+	mux.HandleFunc("GET /reports/order-status", func(w http.ResponseWriter, r *http.Request) {
+		handleOrderStatusCounts(w, r, sqlStore, logger)
+	})
+
+	mux.HandleFunc("GET /reports/orphan-payments", func(w http.ResponseWriter, r *http.Request) {
+		handleListOrphanPayments(w, r, sqlStore, logger)
+	})
+
+	mux.HandleFunc("DELETE /admin/orphan-payments/{orderId}", requireAdminToken(adminToken, "/admin/orphan-payments", func(w http.ResponseWriter, r *http.Request) {
+		handleResolveOrphanPayment(w, r, sqlStore, logger)
+	}))
+
+	mux.HandleFunc("POST /orders/{id}/reminder", requireAdminToken(adminToken, "/orders/reminder", func(w http.ResponseWriter, r *http.Request) {
+		handleMarkReminderSent(w, r, sqlStore, logger)
+	}))
+
+	mux.HandleFunc("POST /admin/reviews/verify", requireAdminToken(adminToken, "/admin/reviews/verify", func(w http.ResponseWriter, r *http.Request) {
+		handleRefreshVerifiedReviews(w, r, sqlStore, logger)
+	}))
+
+	mux.HandleFunc("POST /admin/users/import", requireAdminToken(adminToken, "/admin/users/import", func(w http.ResponseWriter, r *http.Request) {
+		handleImportUsers(w, r, sqlStore, logger)
+	}))
+
+	mux.HandleFunc("GET /consumers", func(w http.ResponseWriter, r *http.Request) {
+		handleGetConsumerHeartbeats(w, r, sqlStore, logger)
+	})
+
+	mux.HandleFunc("GET /search", func(w http.ResponseWriter, r *http.Request) {
+		handleSearch(w, r, sqlStore, logger)
+	})
+
 	// Start server
 	server := &http.Server{
 		Addr:         ":" + servicePort,
-		Handler:      mux,
+		Handler:      withAccessLog(withCompression(withRequestTimeout(mux), gzipMinBytes), logger, accessLogLevel),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
 
-	logger.Info("Starting API service", zap.String("port", servicePort))
-	if err := server.ListenAndServe(); err != nil {
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
 		logger.Fatal("Failed to start server", zap.Error(err))
 	}
+	logger.Info("Starting API service", zap.String("port", servicePort))
+	if err := serve(ctx, server, listener, shutdownTimeout, logger); err != nil {
+		logger.Fatal("Server failed", zap.Error(err))
+	}
+	logger.Info("API service stopped")
+}
+
+// serve runs server on listener until ctx is done, then stops accepting
+// connections and gives in-flight requests up to shutdownTimeout to finish. An
+// error is returned only if the server fails before shutdown begins.
+func serve(ctx context.Context, server *http.Server, listener net.Listener, shutdownTimeout time.Duration, logger *zap.Logger) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Stop accepting connections and let in-flight requests finish
+	logger.Info("Shutting down API service", zap.Duration("timeout", shutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to shut down server gracefully", zap.Error(err))
+	}
+	return nil
 }
 
 func handleGetUser(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
@@ -116,21 +305,15 @@ func handleGetUser(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQL
 		httpLatencySeconds.WithLabelValues(r.Method, "/users/").Observe(time.Since(start).Seconds())
 	}()
 
-	if r.Method != http.MethodGet {
-		httpRequestsTotal.WithLabelValues(r.Method, "/users/", "405").Inc()
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Extract user ID from URL path
-	userID := extractIDFromPath(r.URL.Path, "/users/")
+	// Extract user ID from path parameter
+	userID := r.PathValue("id")
 	if userID == "" {
 		httpRequestsTotal.WithLabelValues(r.Method, "/users/", "400").Inc()
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "User ID is required")
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(r, 10*time.Second)
 	defer cancel()
 
 	// Get user
@@ -138,22 +321,27 @@ func handleGetUser(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQL
 	if err != nil {
 		httpRequestsTotal.WithLabelValues(r.Method, "/users/", "500").Inc()
 		logger.Error("Failed to get user", zap.String("userID", userID), zap.Error(err))
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
 	if user == nil {
 		httpRequestsTotal.WithLabelValues(r.Method, "/users/", "404").Inc()
-		http.Error(w, "User not found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "User not found")
 		return
 	}
 
-	// Get recent orders
-	recentOrders, err := sqlStore.GetUserRecentOrders(ctx, userID)
+	// Get recent orders; an absent or invalid count keeps the default
+	recentLimit := store.DefaultRecentOrders
+	if v, err := strconv.Atoi(r.URL.Query().Get("recentOrders")); err == nil && v > 0 {
+		recentLimit = v
+	}
+
+	recentOrders, err := sqlStore.GetUserRecentOrders(ctx, userID, recentLimit)
 	if err != nil {
 		httpRequestsTotal.WithLabelValues(r.Method, "/users/", "500").Inc()
 		logger.Error("Failed to get user orders", zap.String("userID", userID), zap.Error(err))
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
@@ -172,27 +360,238 @@ func handleGetUser(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQL
 	}
 }
 
-func handleGetOrder(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+// handleGetUserOrders returns a user's orders, newest first, each with its payment
+// (null until settled), so a client doesn't need a request per order
+func handleGetUserOrders(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
 	start := time.Now()
 	defer func() {
-		httpLatencySeconds.WithLabelValues(r.Method, "/orders/").Observe(time.Since(start).Seconds())
+		httpLatencySeconds.WithLabelValues(r.Method, "/users/orders").Observe(time.Since(start).Seconds())
+	}()
+
+	userID := r.PathValue("id")
+	if userID == "" {
+		httpRequestsTotal.WithLabelValues(r.Method, "/users/orders", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, "User ID is required")
+		return
+	}
+
+	limit, _, err := parsePagination(r.URL.Query().Get("limit"), "", store.DefaultUserOrders, store.MaxUserOrders)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/users/orders", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	user, err := sqlStore.GetUser(ctx, userID)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/users/orders", "500").Inc()
+		logger.Error("Failed to get user", zap.String("userID", userID), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if user == nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/users/orders", "404").Inc()
+		writeJSONError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	orders, err := sqlStore.GetUserOrdersWithPayments(ctx, userID, limit)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/users/orders", "500").Inc()
+		logger.Error("Failed to get user orders with payments", zap.String("userID", userID), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	// Prepare response
+	response := map[string]interface{}{
+		"userId": userID,
+		"orders": orders,
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/users/orders", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+func handleListUsers(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/users").Observe(time.Since(start).Seconds())
+	}()
+
+	query := r.URL.Query()
+
+	limit, offset, err := parsePagination(query.Get("limit"), query.Get("offset"), 50, 500)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/users", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	search := strings.TrimSpace(query.Get("q"))
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	// List users
+	users, err := sqlStore.ListUsers(ctx, limit, offset, search)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/users", "500").Inc()
+		logger.Error("Failed to list users", zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	total, err := sqlStore.CountUsers(ctx, search)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/users", "500").Inc()
+		logger.Error("Failed to count users", zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	// Prepare response
+	response := map[string]interface{}{
+		"users":  users,
+		"count":  len(users),
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/users", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+// requireAdminToken only lets requests carrying "Authorization: Bearer <token>" through.
+// With no token configured the endpoint is disabled.
+func requireAdminToken(token, endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			httpRequestsTotal.WithLabelValues(r.Method, endpoint, "403").Inc()
+			writeJSONError(w, http.StatusForbidden, "Endpoint disabled: ADMIN_API_TOKEN is not set")
+			return
+		}
+
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			httpRequestsTotal.WithLabelValues(r.Method, endpoint, "401").Inc()
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func handleExportUserData(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/users/export").Observe(time.Since(start).Seconds())
+	}()
+
+	userID := r.PathValue("id")
+
+	ctx, cancel := requestContext(r, 30*time.Second)
+	defer cancel()
+
+	// The export may run past the server's WriteTimeout; keep the connection
+	// writable for as long as the export is allowed to take
+	if deadline, ok := ctx.Deadline(); ok {
+		http.NewResponseController(w).SetWriteDeadline(deadline.Add(time.Second))
+	}
+
+	// Gather everything held about the user
+	export, err := sqlStore.ExportUserData(ctx, userID)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/users/export", "500").Inc()
+		logger.Error("Failed to export user data", zap.String("userId", userID), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if export == nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/users/export", "404").Inc()
+		writeJSONError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	logger.Info("User data exported",
+		zap.String("userId", userID),
+		zap.Int("orders", len(export.Orders)),
+		zap.Int("payments", len(export.Payments)),
+		zap.Int("reviews", len(export.Reviews)),
+	)
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "user-"+userID+"-export.json"))
+	httpRequestsTotal.WithLabelValues(r.Method, "/users/export", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(export); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+// handleDeleteUser soft-deletes a user and their orders. The rows are kept, so
+// payments and reviews referencing them stay intact, but reads no longer return them.
+func handleDeleteUser(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/users/delete").Observe(time.Since(start).Seconds())
 	}()
 
-	if r.Method != http.MethodGet {
-		httpRequestsTotal.WithLabelValues(r.Method, "/orders/", "405").Inc()
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	userID := r.PathValue("id")
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	err := sqlStore.SoftDeleteUser(ctx, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		httpRequestsTotal.WithLabelValues(r.Method, "/users/delete", "404").Inc()
+		writeJSONError(w, http.StatusNotFound, "User not found")
 		return
 	}
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/users/delete", "500").Inc()
+		logger.Error("Failed to delete user", zap.String("userId", userID), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	logger.Info("User soft-deleted", zap.String("userId", userID))
+	httpRequestsTotal.WithLabelValues(r.Method, "/users/delete", "204").Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleGetOrder(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/orders/").Observe(time.Since(start).Seconds())
+	}()
 
-	// Extract order ID from URL path
-	orderID := extractIDFromPath(r.URL.Path, "/orders/")
+	// Extract order ID from path parameter
+	orderID := r.PathValue("id")
 	if orderID == "" {
 		httpRequestsTotal.WithLabelValues(r.Method, "/orders/", "400").Inc()
-		http.Error(w, "Order ID is required", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Order ID is required")
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(r, 10*time.Second)
 	defer cancel()
 
 	// Get order
@@ -200,13 +599,13 @@ func handleGetOrder(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQ
 	if err != nil {
 		httpRequestsTotal.WithLabelValues(r.Method, "/orders/", "500").Inc()
 		logger.Error("Failed to get order", zap.String("orderID", orderID), zap.Error(err))
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
 	if order == nil {
 		httpRequestsTotal.WithLabelValues(r.Method, "/orders/", "404").Inc()
-		http.Error(w, "Order not found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "Order not found")
 		return
 	}
 
@@ -215,7 +614,7 @@ func handleGetOrder(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQ
 	if err != nil {
 		httpRequestsTotal.WithLabelValues(r.Method, "/orders/", "500").Inc()
 		logger.Error("Failed to get payment", zap.String("orderID", orderID), zap.Error(err))
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
@@ -234,119 +633,1086 @@ func handleGetOrder(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQ
 	}
 }
 
-func extractIDFromPath(path, prefix string) string {
-	if len(path) <= len(prefix) {
-		return ""
+func handleGetInventory(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/inventory/").Observe(time.Since(start).Seconds())
+	}()
+
+	// Extract SKU from path parameter
+	sku := r.PathValue("sku")
+	if sku == "" {
+		httpRequestsTotal.WithLabelValues(r.Method, "/inventory/", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, "SKU is required")
+		return
 	}
-	return path[len(prefix):]
-}
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	// Get inventory
+	inventory, err := sqlStore.GetInventory(ctx, sku)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/inventory/", "500").Inc()
+		logger.Error("Failed to get inventory", zap.String("sku", sku), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if inventory == nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/inventory/", "404").Inc()
+		writeJSONError(w, http.StatusNotFound, "Inventory not found")
+		return
+	}
+
+	// Prepare response
+	response := APIResponse{
+		Inventory: inventory,
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/inventory/", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
 	}
-	return defaultValue
 }
 
-func handleGetProductReview(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+func handleGetInventoryHistory(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
 	start := time.Now()
 	defer func() {
-		httpLatencySeconds.WithLabelValues(r.Method, "/reviews/").Observe(time.Since(start).Seconds())
+		httpLatencySeconds.WithLabelValues(r.Method, "/inventory/history").Observe(time.Since(start).Seconds())
 	}()
 
-	if r.Method != http.MethodGet {
-		httpRequestsTotal.WithLabelValues(r.Method, "/reviews/", "405").Inc()
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Extract review ID from URL path
-	reviewID := extractIDFromPath(r.URL.Path, "/reviews/")
-	if reviewID == "" {
-		httpRequestsTotal.WithLabelValues(r.Method, "/reviews/", "400").Inc()
-		http.Error(w, "Review ID is required", http.StatusBadRequest)
+	// Extract SKU from path parameter
+	sku := r.PathValue("sku")
+	if sku == "" {
+		httpRequestsTotal.WithLabelValues(r.Method, "/inventory/history", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, "SKU is required")
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(r, 10*time.Second)
 	defer cancel()
 
-	// Get product review
-	review, err := sqlStore.GetProductReview(ctx, reviewID)
+	// Get adjustment history
+	history, err := sqlStore.GetInventoryHistory(ctx, sku)
 	if err != nil {
-		httpRequestsTotal.WithLabelValues(r.Method, "/reviews/", "500").Inc()
-		logger.Error("Failed to get product review", zap.String("reviewID", reviewID), zap.Error(err))
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		httpRequestsTotal.WithLabelValues(r.Method, "/inventory/history", "500").Inc()
+		logger.Error("Failed to get inventory history", zap.String("sku", sku), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
-	if review == nil {
-		httpRequestsTotal.WithLabelValues(r.Method, "/reviews/", "404").Inc()
-		http.Error(w, "Review not found", http.StatusNotFound)
-		return
+	// A SKU adjusted only before the history table existed still has an empty history
+	if len(history) == 0 {
+		inventory, err := sqlStore.GetInventory(ctx, sku)
+		if err != nil {
+			httpRequestsTotal.WithLabelValues(r.Method, "/inventory/history", "500").Inc()
+			logger.Error("Failed to get inventory", zap.String("sku", sku), zap.Error(err))
+			writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if inventory == nil {
+			httpRequestsTotal.WithLabelValues(r.Method, "/inventory/history", "404").Inc()
+			writeJSONError(w, http.StatusNotFound, "Inventory not found")
+			return
+		}
 	}
 
 	// Prepare response
 	response := map[string]interface{}{
-		"review": review,
+		"sku":         sku,
+		"adjustments": history,
 	}
 
 	// Set content type and write response
 	w.Header().Set("Content-Type", "application/json")
-	httpRequestsTotal.WithLabelValues(r.Method, "/reviews/", "200").Inc()
+	httpRequestsTotal.WithLabelValues(r.Method, "/inventory/history", "200").Inc()
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		logger.Error("Failed to encode response", zap.Error(err))
 	}
 }
 
-func handleGetProductReviewsByProduct(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+func handleGetOrderTimeline(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
 	start := time.Now()
 	defer func() {
-		httpLatencySeconds.WithLabelValues(r.Method, "/products/").Observe(time.Since(start).Seconds())
+		httpLatencySeconds.WithLabelValues(r.Method, "/orders/timeline").Observe(time.Since(start).Seconds())
 	}()
 
-	if r.Method != http.MethodGet {
-		httpRequestsTotal.WithLabelValues(r.Method, "/products/", "405").Inc()
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Extract product name from URL path
-	productName := extractIDFromPath(r.URL.Path, "/products/")
-	if productName == "" {
-		httpRequestsTotal.WithLabelValues(r.Method, "/products/", "400").Inc()
-		http.Error(w, "Product name is required", http.StatusBadRequest)
+	// Extract order ID from path parameter
+	orderID := r.PathValue("id")
+	if orderID == "" {
+		httpRequestsTotal.WithLabelValues(r.Method, "/orders/timeline", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, "Order ID is required")
 		return
 	}
 
-	// Remove "/reviews" suffix if present
-	productName = strings.TrimSuffix(productName, "/reviews")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(r, 10*time.Second)
 	defer cancel()
 
-	// Get product reviews
-	reviews, err := sqlStore.GetProductReviewsByProduct(ctx, productName)
+	// Get order timeline
+	timeline, err := sqlStore.GetOrderTimeline(ctx, orderID)
 	if err != nil {
-		httpRequestsTotal.WithLabelValues(r.Method, "/products/", "500").Inc()
-		logger.Error("Failed to get product reviews", zap.String("productName", productName), zap.Error(err))
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		httpRequestsTotal.WithLabelValues(r.Method, "/orders/timeline", "500").Inc()
+		logger.Error("Failed to get order timeline", zap.String("orderID", orderID), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if timeline == nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/orders/timeline", "404").Inc()
+		writeJSONError(w, http.StatusNotFound, "Order not found")
 		return
 	}
 
 	// Prepare response
 	response := map[string]interface{}{
-		"productName": productName,
-		"reviews":     reviews,
-		"count":       len(reviews),
+		"orderId":  orderID,
+		"timeline": timeline,
 	}
 
 	// Set content type and write response
 	w.Header().Set("Content-Type", "application/json")
-	httpRequestsTotal.WithLabelValues(r.Method, "/products/", "200").Inc()
+	httpRequestsTotal.WithLabelValues(r.Method, "/orders/timeline", "200").Inc()
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		logger.Error("Failed to encode response", zap.Error(err))
 	}
 }
+
+func handleQueryOrders(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/orders").Observe(time.Since(start).Seconds())
+	}()
+
+	query := r.URL.Query()
+
+	from, to, err := parseTimeRange(query.Get("from"), query.Get("to"))
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/orders", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit, offset, err := parsePagination(query.Get("limit"), query.Get("offset"), 50, store.MaxOrderQueryLimit)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/orders", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter := store.OrderFilter{
+		UserID: query.Get("userId"),
+		Status: query.Get("status"),
+		From:   from,
+		To:     to,
+		Limit:  limit,
+		Offset: offset,
+	}
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	// Query orders
+	orders, err := sqlStore.QueryOrders(ctx, filter)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/orders", "500").Inc()
+		logger.Error("Failed to query orders", zap.String("status", filter.Status), zap.String("userID", filter.UserID), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	// Prepare response
+	response := map[string]interface{}{
+		"orders": orders,
+		"count":  len(orders),
+		"limit":  limit,
+		"offset": offset,
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/orders", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+func handleListPayments(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/payments").Observe(time.Since(start).Seconds())
+	}()
+
+	query := r.URL.Query()
+
+	status := query.Get("status")
+	if !store.IsValidPaymentStatus(status) {
+		httpRequestsTotal.WithLabelValues(r.Method, "/payments", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, "A valid status is required")
+		return
+	}
+
+	from, to, err := parseTimeRange(query.Get("from"), query.Get("to"))
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/payments", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit, offset, err := parsePagination(query.Get("limit"), query.Get("offset"), 50, 500)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/payments", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	// List payments
+	payments, err := sqlStore.ListPaymentsByStatus(ctx, status, from, to, limit, offset)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/payments", "500").Inc()
+		logger.Error("Failed to list payments", zap.String("status", status), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	// Prepare response
+	response := map[string]interface{}{
+		"status":   status,
+		"payments": payments,
+		"count":    len(payments),
+		"limit":    limit,
+		"offset":   offset,
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/payments", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+func handleGetRecentlyUpdated(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/changes").Observe(time.Since(start).Seconds())
+	}()
+
+	query := r.URL.Query()
+
+	entity := query.Get("entity")
+	if !store.IsTrackedEntity(entity) {
+		httpRequestsTotal.WithLabelValues(r.Method, "/changes", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, "A valid entity is required")
+		return
+	}
+
+	since, err := time.Parse(time.RFC3339, query.Get("since"))
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/changes", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, "since must be an RFC3339 timestamp")
+		return
+	}
+	afterID := query.Get("afterId")
+
+	limit, _, err := parsePagination(query.Get("limit"), "", store.DefaultChanges, store.MaxChanges)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/changes", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	// Get changes after the watermark
+	changes, err := sqlStore.GetRecentlyUpdated(ctx, entity, since, afterID, limit)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/changes", "500").Inc()
+		logger.Error("Failed to get recently updated entities", zap.String("entity", entity), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	// The next watermark is the last change returned, or the same one if none were
+	nextSince, nextAfterID := since, afterID
+	if len(changes) > 0 {
+		last := changes[len(changes)-1]
+		nextSince, nextAfterID = last.UpdatedAt, last.ID
+	}
+
+	// Prepare response
+	response := map[string]interface{}{
+		"entity":      entity,
+		"since":       since,
+		"limit":       limit,
+		"changes":     changes,
+		"nextSince":   nextSince,
+		"nextAfterId": nextAfterID,
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/changes", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+func handleSearch(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/search").Observe(time.Since(start).Seconds())
+	}()
+
+	query := r.URL.Query()
+
+	term := query.Get("q")
+	if term == "" {
+		httpRequestsTotal.WithLabelValues(r.Method, "/search", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, "Search term is required")
+		return
+	}
+
+	limit, _, err := parsePagination(query.Get("limit"), "", 10, 50)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/search", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	// Search all entities
+	results, err := sqlStore.GlobalSearch(ctx, term, limit)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/search", "500").Inc()
+		logger.Error("Failed to search", zap.String("term", term), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/search", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+func handleGetRatingTrend(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/products/rating/trend").Observe(time.Since(start).Seconds())
+	}()
+
+	productName := r.PathValue("name")
+	query := r.URL.Query()
+
+	bucket := query.Get("bucket")
+	if bucket == "" {
+		bucket = "week"
+	}
+	if !store.IsValidRatingBucket(bucket) {
+		httpRequestsTotal.WithLabelValues(r.Method, "/products/rating/trend", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, "bucket must be week or month")
+		return
+	}
+
+	from, to, err := parseTimeRange(query.Get("from"), query.Get("to"))
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/products/rating/trend", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	// Get rating trend
+	buckets, err := sqlStore.GetRatingTrend(ctx, productName, bucket, from, to)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/products/rating/trend", "500").Inc()
+		logger.Error("Failed to get rating trend", zap.String("productName", productName), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if query.Get("fillGaps") == "true" {
+		buckets = store.FillRatingGaps(buckets, bucket, from, to)
+	}
+
+	// Prepare response
+	response := map[string]interface{}{
+		"productName": productName,
+		"bucket":      bucket,
+		"trend":       buckets,
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/products/rating/trend", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+func handleGetProductReviewStats(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/products/stats").Observe(time.Since(start).Seconds())
+	}()
+
+	productName := r.PathValue("name")
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	// Get review stats
+	avg, count, histogram, err := sqlStore.GetProductReviewStats(ctx, productName)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/products/stats", "500").Inc()
+		logger.Error("Failed to get review stats", zap.String("productName", productName), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	// Prepare response
+	response := map[string]interface{}{
+		"productName":   productName,
+		"averageRating": avg,
+		"reviewCount":   count,
+		"histogram":     histogram,
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/products/stats", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+func handleListReminders(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/reports/reminders").Observe(time.Since(start).Seconds())
+	}()
+
+	query := r.URL.Query()
+
+	days := 7
+	if v := query.Get("days"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			httpRequestsTotal.WithLabelValues(r.Method, "/reports/reminders", "400").Inc()
+			writeJSONError(w, http.StatusBadRequest, "days must be a non-negative integer")
+			return
+		}
+		days = parsed
+	}
+
+	limit, offset, err := parsePagination(query.Get("limit"), query.Get("offset"), 50, 500)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/reports/reminders", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	excludeReminded := query.Get("includeReminded") != "true"
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	// List orders needing a reminder
+	orders, err := sqlStore.ListOrdersNeedingReminder(ctx, time.Duration(days)*24*time.Hour, excludeReminded, limit, offset)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/reports/reminders", "500").Inc()
+		logger.Error("Failed to list orders needing reminders", zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	// Prepare response
+	response := map[string]interface{}{
+		"olderThanDays": days,
+		"orders":        orders,
+		"count":         len(orders),
+		"limit":         limit,
+		"offset":        offset,
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/reports/reminders", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+func handleOrderStatusCounts(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/reports/order-status").Observe(time.Since(start).Seconds())
+	}()
+
+	query := r.URL.Query()
+
+	from, to, err := parseTimeRange(query.Get("from"), query.Get("to"))
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/reports/order-status", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	// Count orders by status
+	counts, err := sqlStore.GetOrderStatusCounts(ctx, from, to)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/reports/order-status", "500").Inc()
+		logger.Error("Failed to get order status counts", zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+
+	// Prepare response
+	response := map[string]interface{}{
+		"counts": counts,
+		"total":  total,
+	}
+	if !from.IsZero() {
+		response["from"] = from
+	}
+	if !to.IsZero() {
+		response["to"] = to
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/reports/order-status", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+func handleListOrphanPayments(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/reports/orphan-payments").Observe(time.Since(start).Seconds())
+	}()
+
+	query := r.URL.Query()
+
+	limit, offset, err := parsePagination(query.Get("limit"), query.Get("offset"), 50, 500)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/reports/orphan-payments", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	// List orphan payments
+	payments, err := sqlStore.ListOrphanPayments(ctx, limit, offset)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/reports/orphan-payments", "500").Inc()
+		logger.Error("Failed to list orphan payments", zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	total, err := sqlStore.CountOrphanPayments(ctx)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/reports/orphan-payments", "500").Inc()
+		logger.Error("Failed to count orphan payments", zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	orphanPayments.Set(float64(total))
+
+	// Prepare response
+	response := map[string]interface{}{
+		"payments": payments,
+		"count":    len(payments),
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/reports/orphan-payments", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+func handleResolveOrphanPayment(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/admin/orphan-payments").Observe(time.Since(start).Seconds())
+	}()
+
+	orderID := r.PathValue("orderId")
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	resolved, err := sqlStore.ResolveOrphanPayment(ctx, orderID)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/admin/orphan-payments", "500").Inc()
+		logger.Error("Failed to resolve orphan payment", zap.String("orderID", orderID), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if !resolved {
+		httpRequestsTotal.WithLabelValues(r.Method, "/admin/orphan-payments", "404").Inc()
+		writeJSONError(w, http.StatusNotFound, "Orphan payment not found")
+		return
+	}
+
+	logger.Info("Resolved orphan payment", zap.String("orderID", orderID))
+	httpRequestsTotal.WithLabelValues(r.Method, "/admin/orphan-payments", "204").Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleMarkReminderSent(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/orders/reminder").Observe(time.Since(start).Seconds())
+	}()
+
+	orderID := r.PathValue("id")
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	found, err := sqlStore.MarkReminderSent(ctx, orderID)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/orders/reminder", "500").Inc()
+		logger.Error("Failed to mark reminder sent", zap.String("orderID", orderID), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if !found {
+		httpRequestsTotal.WithLabelValues(r.Method, "/orders/reminder", "404").Inc()
+		writeJSONError(w, http.StatusNotFound, "Order not found")
+		return
+	}
+
+	httpRequestsTotal.WithLabelValues(r.Method, "/orders/reminder", "204").Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleGetConsumerHeartbeats(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/consumers").Observe(time.Since(start).Seconds())
+	}()
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	// Get consumer heartbeats
+	heartbeats, err := sqlStore.GetConsumerHeartbeats(ctx)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/consumers", "500").Inc()
+		logger.Error("Failed to get consumer heartbeats", zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	// Prepare response
+	response := map[string]interface{}{
+		"consumers": heartbeats,
+		"count":     len(heartbeats),
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/consumers", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+func handleRefreshVerifiedReviews(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/admin/reviews/verify").Observe(time.Since(start).Seconds())
+	}()
+
+	ctx, cancel := requestContext(r, 30*time.Second)
+	defer cancel()
+
+	updated, err := sqlStore.RefreshVerifiedReviews(ctx)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/admin/reviews/verify", "500").Inc()
+		logger.Error("Failed to refresh verified reviews", zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	logger.Info("Refreshed verified reviews", zap.Int64("updated", updated))
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/admin/reviews/verify", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"updated": updated}); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+// importError describes an NDJSON record rejected by the user import
+type importError struct {
+	Line   int    `json:"line"`
+	UserID string `json:"userId,omitempty"`
+	Error  string `json:"error"`
+}
+
+// maxImportLineBytes bounds a single NDJSON record in a user import
+const maxImportLineBytes = 64 * 1024
+
+func handleImportUsers(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/admin/users/import").Observe(time.Since(start).Seconds())
+	}()
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, maxImportLineBytes), maxImportLineBytes)
+
+	users := []*store.User{}
+	failures := []importError{}
+	seen := make(map[string]bool)
+	lineNum := 0
+	now := time.Now().UTC()
+
+	for scanner.Scan() {
+		lineNum++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var user store.User
+		if err := json.Unmarshal(line, &user); err != nil {
+			failures = append(failures, importError{Line: lineNum, Error: "invalid JSON"})
+			continue
+		}
+		if err := validateImportUser(&user); err != nil {
+			failures = append(failures, importError{Line: lineNum, UserID: user.UserID, Error: err.Error()})
+			continue
+		}
+		if seen[user.UserID] {
+			failures = append(failures, importError{Line: lineNum, UserID: user.UserID, Error: "duplicate userId in import"})
+			continue
+		}
+		seen[user.UserID] = true
+
+		if user.CreatedAt.IsZero() {
+			user.CreatedAt = now
+		}
+		if user.UpdatedAt.IsZero() {
+			user.UpdatedAt = now
+		}
+		users = append(users, &user)
+
+		if len(users) > store.MaxUserBatchSize {
+			httpRequestsTotal.WithLabelValues(r.Method, "/admin/users/import", "413").Inc()
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Import exceeds maximum of %d users", store.MaxUserBatchSize))
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/admin/users/import", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Failed to read import body: %v", err))
+		return
+	}
+
+	ctx, cancel := requestContext(r, 30*time.Second)
+	defer cancel()
+
+	inserted, updated, err := sqlStore.UpsertUsersBatch(ctx, users)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/admin/users/import", "500").Inc()
+		logger.Error("Failed to import users", zap.Int("count", len(users)), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	logger.Info("Imported users",
+		zap.Int64("inserted", inserted),
+		zap.Int64("updated", updated),
+		zap.Int("rejected", len(failures)),
+	)
+
+	status := http.StatusOK
+	if len(failures) > 0 {
+		status = http.StatusMultiStatus
+	}
+	httpRequestsTotal.WithLabelValues(r.Method, "/admin/users/import", strconv.Itoa(status)).Inc()
+
+	// Prepare response
+	response := map[string]interface{}{
+		"inserted": inserted,
+		"updated":  updated,
+		"rejected": len(failures),
+		"errors":   failures,
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+// validateImportUser checks the fields required for a user import record
+func validateImportUser(user *store.User) error {
+	if strings.TrimSpace(user.UserID) == "" {
+		return fmt.Errorf("userId is required")
+	}
+	if strings.TrimSpace(user.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !validation.ValidEmail(user.Email) {
+		return fmt.Errorf("email is invalid")
+	}
+	return nil
+}
+
+// parsePagination parses limit and offset query values, applying a default and upper bound to limit
+func parsePagination(limitStr, offsetStr string, defaultLimit, maxLimit int) (int, int, error) {
+	limit := defaultLimit
+	if limitStr != "" {
+		v, err := strconv.Atoi(limitStr)
+		if err != nil || v <= 0 {
+			return 0, 0, fmt.Errorf("limit must be a positive integer")
+		}
+		limit = v
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	offset := 0
+	if offsetStr != "" {
+		v, err := strconv.Atoi(offsetStr)
+		if err != nil || v < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+		offset = v
+	}
+
+	return limit, offset, nil
+}
+
+// parseTimeRange parses optional RFC3339 from/to query values
+func parseTimeRange(fromStr, toStr string) (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+
+	if fromStr != "" {
+		if from, err = time.Parse(time.RFC3339, fromStr); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("from must be an RFC3339 timestamp")
+		}
+	}
+	if toStr != "" {
+		if to, err = time.Parse(time.RFC3339, toStr); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to must be an RFC3339 timestamp")
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must not be before from")
+	}
+
+	return from, to, nil
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func handleGetProductReview(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/reviews/").Observe(time.Since(start).Seconds())
+	}()
+
+	// Extract review ID from path parameter
+	reviewID := r.PathValue("id")
+	if reviewID == "" {
+		httpRequestsTotal.WithLabelValues(r.Method, "/reviews/", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, "Review ID is required")
+		return
+	}
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	// Get product review
+	review, err := sqlStore.GetProductReview(ctx, reviewID)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/reviews/", "500").Inc()
+		logger.Error("Failed to get product review", zap.String("reviewID", reviewID), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if review == nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/reviews/", "404").Inc()
+		writeJSONError(w, http.StatusNotFound, "Review not found")
+		return
+	}
+
+	// Prepare response
+	response := map[string]interface{}{
+		"review": review,
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/reviews/", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+func handleGetProductReviewsByProduct(w http.ResponseWriter, r *http.Request, sqlStore *store.MSSQLStore, logger *zap.Logger) {
+	start := time.Now()
+	defer func() {
+		httpLatencySeconds.WithLabelValues(r.Method, "/products/").Observe(time.Since(start).Seconds())
+	}()
+
+	// Extract product name from path parameter
+	productName := r.PathValue("name")
+	if productName == "" {
+		httpRequestsTotal.WithLabelValues(r.Method, "/products/", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, "Product name is required")
+		return
+	}
+
+	query := r.URL.Query()
+
+	limit, _, err := parsePagination(query.Get("limit"), "", 20, 100)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/products/", "400").Inc()
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	verifiedOnly := query.Get("verified") == "true"
+
+	var cursor *store.ReviewCursor
+	if token := query.Get("cursor"); token != "" {
+		cursor, err = store.DecodeReviewCursor(token)
+		if err != nil {
+			httpRequestsTotal.WithLabelValues(r.Method, "/products/", "400").Inc()
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	ctx, cancel := requestContext(r, 10*time.Second)
+	defer cancel()
+
+	// Get a page of product reviews
+	reviews, next, err := sqlStore.GetProductReviewsByProduct(ctx, productName, verifiedOnly, limit, cursor)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/products/", "500").Inc()
+		logger.Error("Failed to get product reviews", zap.String("productName", productName), zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	// Prepare response
+	response := map[string]interface{}{
+		"productName": productName,
+		"reviews":     reviews,
+		"count":       len(reviews),
+		"limit":       limit,
+		"nextCursor":  nil,
+	}
+	if next != nil {
+		response["nextCursor"] = next.Encode()
+	}
+
+	// Set content type and write response
+	w.Header().Set("Content-Type", "application/json")
+	httpRequestsTotal.WithLabelValues(r.Method, "/products/", "200").Inc()
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+func getEnvDuration(key string, defaultValue time.Duration, logger *zap.Logger) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Fatal("Invalid duration", zap.String("key", key), zap.String("value", value), zap.Error(err))
+	}
+	return d
+}
+
+// sqlPoolConfigFromEnv reads the SQL pool sizing, falling back to store.DefaultPoolConfig
+func sqlPoolConfigFromEnv(logger *zap.Logger) store.PoolConfig {
+	config := store.DefaultPoolConfig
+	for key, target := range map[string]*int{
+		"MSSQL_MAX_OPEN_CONNS": &config.MaxOpenConns,
+		"MSSQL_MAX_IDLE_CONNS": &config.MaxIdleConns,
+	} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			logger.Fatal(key+" must be a positive integer", zap.String("value", value))
+		}
+		*target = n
+	}
+	config.ConnMaxLifetime = getEnvDuration("MSSQL_CONN_MAX_LIFETIME", config.ConnMaxLifetime, logger)
+	return config
+}
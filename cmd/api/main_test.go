@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	var finished atomic.Bool
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		finished.Store(true)
+		io.WriteString(w, `{"userId":"user-1"}`)
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, server, listener, 5*time.Second, zap.NewNop())
+	}()
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/users/user-1")
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		results <- result{resp.StatusCode, string(body), err}
+	}()
+
+	// Signal shutdown while the request is still being handled
+	<-started
+	cancel()
+
+	res := <-results
+	if res.err != nil || res.status != http.StatusOK || res.body != `{"userId":"user-1"}` {
+		t.Fatalf("in-flight request = %d %q, %v; want 200 with the user", res.status, res.body, res.err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("serve() error = %v", err)
+	}
+	if !finished.Load() {
+		t.Error("serve returned before the in-flight request finished")
+	}
+}
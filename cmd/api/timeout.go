@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// requestTimeoutHeader lets a caller choose how long its request may run
+const requestTimeoutHeader = "X-Request-Timeout"
+
+// Bounds applied to X-Request-Timeout
+const (
+	minRequestTimeout = time.Second
+	maxRequestTimeout = 60 * time.Second
+)
+
+type requestTimeoutKey struct{}
+
+// withRequestTimeout reads X-Request-Timeout, either a duration such as "2.5s" or a
+// number of seconds, clamps it to [minRequestTimeout, maxRequestTimeout] and passes
+// it to the handler through the request context; see requestContext. A malformed
+// value is rejected with 400. The response write deadline is moved to match, since
+// the server's WriteTimeout would otherwise cut off longer requests.
+func withRequestTimeout(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get(requestTimeoutHeader)
+		if value == "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			seconds, convErr := strconv.ParseFloat(value, 64)
+			if convErr != nil {
+				_, pattern := mux.Handler(r)
+				httpRequestsTotal.WithLabelValues(r.Method, pattern, "400").Inc()
+				writeJSONError(w, http.StatusBadRequest, requestTimeoutHeader+" must be a duration such as 5s or a number of seconds")
+				return
+			}
+			timeout = time.Duration(seconds * float64(time.Second))
+		}
+		timeout = min(max(timeout, minRequestTimeout), maxRequestTimeout)
+
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + time.Second))
+
+		ctx := context.WithValue(r.Context(), requestTimeoutKey{}, timeout)
+		mux.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestContext returns the context for a handler's database work: bounded by the
+// caller's X-Request-Timeout when given, else by defaultTimeout, and cancelled if
+// the client goes away
+func requestContext(r *http.Request, defaultTimeout time.Duration) (context.Context, context.CancelFunc) {
+	timeout, ok := r.Context().Value(requestTimeoutKey{}).(time.Duration)
+	if !ok {
+		timeout = defaultTimeout
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"kafka-pipeline/internal/audit"
+	"kafka-pipeline/internal/kafka"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	// Parse flags
+	path := flag.String("file", getEnv("AUDIT_LOG_PATH", "audit.log"), "path to the produce-side audit log")
+	fromStr := flag.String("from", "", "replay events accepted at or after this RFC3339 time")
+	toStr := flag.String("to", "", "replay events accepted before this RFC3339 time")
+	eventType := flag.String("type", "", "only replay events of this type")
+	dryRun := flag.Bool("dry-run", false, "log what would be replayed without publishing")
+	flag.Parse()
+
+	// Initialize logger
+	logger, err := zap.NewProduction()
+	if err != nil {
+		log.Fatal("Failed to initialize logger:", err)
+	}
+	defer logger.Sync()
+
+	from, err := parseFlagTime(*fromStr)
+	if err != nil {
+		logger.Fatal("Invalid --from", zap.Error(err))
+	}
+	to, err := parseFlagTime(*toStr)
+	if err != nil {
+		logger.Fatal("Invalid --to", zap.Error(err))
+	}
+
+	// Get configuration from environment
+	kafkaBrokers := getEnv("KAFKA_BROKERS", "localhost:9092")
+	kafkaTopic := getEnv("KAFKA_TOPIC", "events")
+	kafkaSecurity, err := kafka.NewSecurity(kafka.SecurityConfig{
+		TLS:           getEnv("KAFKA_TLS_ENABLED", "false") == "true",
+		CACertPath:    getEnv("KAFKA_TLS_CA_CERT", ""),
+		SASLMechanism: getEnv("KAFKA_SASL_MECHANISM", ""),
+		Username:      getEnv("KAFKA_SASL_USERNAME", ""),
+		Password:      getEnv("KAFKA_SASL_PASSWORD", ""),
+	})
+	if err != nil {
+		logger.Fatal("Invalid Kafka security settings", zap.Error(err))
+	}
+
+	var producer *kafka.Producer
+	if !*dryRun {
+		producer = kafka.NewProducer(strings.Split(kafkaBrokers, ","), kafkaTopic, kafka.ProducerConfig{Security: kafkaSecurity}, logger)
+		defer producer.Close()
+	}
+
+	var matched, replayed, failed, skipped int
+
+	err = audit.ReadRecords(*path, func(record *audit.Record) error {
+		if !from.IsZero() && record.AcceptedAt.Before(from) {
+			skipped++
+			return nil
+		}
+		if !to.IsZero() && !record.AcceptedAt.Before(to) {
+			skipped++
+			return nil
+		}
+		if *eventType != "" && record.Event["type"] != *eventType {
+			skipped++
+			return nil
+		}
+		matched++
+
+		if *dryRun {
+			logger.Info("Would replay event",
+				zap.Any("eventId", record.Event["eventId"]),
+				zap.Any("type", record.Event["type"]),
+				zap.Time("acceptedAt", record.AcceptedAt),
+			)
+			return nil
+		}
+
+		// Keys are derived from the event itself, so republishing preserves them
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := producer.PublishEventWithHeaders(ctx, record.Event, record.Headers); err != nil {
+			failed++
+			logger.Error("Failed to replay event",
+				zap.Any("eventId", record.Event["eventId"]),
+				zap.Error(err),
+			)
+			return nil
+		}
+		replayed++
+		return nil
+	})
+	if err != nil {
+		logger.Fatal("Failed to read audit log", zap.Error(err))
+	}
+
+	logger.Info("Audit replay finished",
+		zap.Bool("dryRun", *dryRun),
+		zap.Int("matched", matched),
+		zap.Int("replayed", replayed),
+		zap.Int("failed", failed),
+		zap.Int("skipped", skipped),
+	)
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func parseFlagTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"kafka-pipeline/internal/dlq"
+	"kafka-pipeline/internal/store"
+
+	"github.com/prometheus/client_golang/prometheus"
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+var writeBatchesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "write_batches_total",
+		Help: "Total number of batched writes, by event type and whether the batch committed or fell back to per-event writes",
+	},
+	[]string{"type", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(writeBatchesTotal)
+}
+
+// batchedTypes are the event types writeBatcher buffers, in the order their
+// batches are written: orders reference users
+var batchedTypes = []string{"UserCreated", "OrderPlaced", "PaymentSettled"}
+
+// batchedEvent is a buffered message and the row it writes
+type batchedEvent struct {
+	message *kafkaGo.Message
+	event   map[string]interface{}
+	typed   *store.Event
+	row     interface{}
+}
+
+// writeBatcher buffers UserCreated, OrderPlaced and PaymentSettled events for the
+// default store and writes each type with one batch upsert when size events are
+// buffered or every interval. Offsets are committed only after the batch commits.
+// A batch that fails is retried one event at a time, so only the events that fail
+// on their own go to the DLQ.
+type writeBatcher struct {
+	mu      sync.Mutex
+	pending map[string][]batchedEvent
+	count   int
+
+	// flushMu serializes flushes so one can't commit offsets past messages another
+	// is still writing
+	flushMu sync.Mutex
+
+	size     int
+	interval time.Duration
+
+	// coalescer, when set, is flushed with every batch; see Flush
+	coalescer *inventoryCoalescer
+	consumer  bufferConsumer
+	sqlStore  batchWriter
+	// apply writes one event on its own when its batch fails
+	apply  func(ctx context.Context, event *store.Event) error
+	dlq    dlq.DLQ
+	logger *zap.Logger
+}
+
+// batchWriter is the part of store.MSSQLStore writeBatcher writes batches through
+type batchWriter interface {
+	UpsertUsersBatch(ctx context.Context, users []*store.User) (inserted, updated int64, err error)
+	UpsertOrdersBatch(ctx context.Context, orders []*store.Order) error
+	UpsertPaymentsBatch(ctx context.Context, payments []*store.Payment) error
+}
+
+func newWriteBatcher(size int, interval time.Duration, coalescer *inventoryCoalescer, consumer bufferConsumer, sqlStore *store.MSSQLStore, dlq dlq.DLQ, logger *zap.Logger) *writeBatcher {
+	return &writeBatcher{
+		pending:   make(map[string][]batchedEvent),
+		size:      size,
+		interval:  interval,
+		coalescer: coalescer,
+		consumer:  consumer,
+		sqlStore:  sqlStore,
+		apply: func(ctx context.Context, event *store.Event) error {
+			return processEventByType(ctx, event, sqlStore, logger)
+		},
+		dlq:    dlq,
+		logger: logger,
+	}
+}
+
+// Run flushes the buffer on every interval until ctx is cancelled
+func (b *writeBatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Flush(context.Background())
+			return
+		case <-ticker.C:
+			b.Flush(ctx)
+		}
+	}
+}
+
+// Add buffers an event of one of the batched types, flushing once size events are
+// buffered. It returns false for other types and for events whose data can't be
+// decoded, which the caller processes itself.
+func (b *writeBatcher) Add(ctx context.Context, message *kafkaGo.Message, event map[string]interface{}, typed *store.Event) bool {
+	var row interface{}
+	switch typed.Type {
+	case "UserCreated":
+		var data store.UserCreatedData
+		if err := typed.DecodeData(&data); err != nil {
+			return false
+		}
+		row = newUser(data)
+	case "OrderPlaced":
+		var data store.OrderPlacedData
+		if err := typed.DecodeData(&data); err != nil {
+			return false
+		}
+		row = newOrder(data)
+	case "PaymentSettled":
+		var data store.PaymentSettledData
+		if err := typed.DecodeData(&data); err != nil {
+			return false
+		}
+		row = newPayment(data)
+	default:
+		return false
+	}
+
+	b.mu.Lock()
+	b.pending[typed.Type] = append(b.pending[typed.Type], batchedEvent{message: message, event: event, typed: typed, row: row})
+	b.count++
+	full := b.count >= b.size
+	b.mu.Unlock()
+
+	if full {
+		b.Flush(ctx)
+	}
+	return true
+}
+
+// Flush writes the buffered batches and commits their offsets. Inventory
+// adjustments buffered by the coalescer are flushed before the commit as well, so
+// no offset is committed past a message that hasn't been written yet. An event
+// that fails on its own and can't be stored in the DLQ holds its partition, and
+// neither it nor any later offset of that partition is committed.
+func (b *writeBatcher) Flush(ctx context.Context) {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = make(map[string][]batchedEvent)
+	b.count = 0
+	b.mu.Unlock()
+
+	var messages []kafkaGo.Message
+	var succeeded []batchedEvent
+	var unparked []*kafkaGo.Message
+	for _, eventType := range batchedTypes {
+		events := pending[eventType]
+		if len(events) == 0 {
+			continue
+		}
+		for _, e := range events {
+			messages = append(messages, *e.message)
+		}
+
+		start := time.Now()
+		err := withProcessingTimeout(ctx, func(ctx context.Context) error {
+			return b.write(ctx, eventType, events)
+		})
+		dbLatencySeconds.WithLabelValues("upsert_batch", eventType).Observe(time.Since(start).Seconds())
+		if err == nil {
+			writeBatchesTotal.WithLabelValues(eventType, "committed").Inc()
+			messagesProcessedTotal.WithLabelValues(eventType).Add(float64(len(events)))
+			succeeded = append(succeeded, events...)
+			continue
+		}
+
+		writeBatchesTotal.WithLabelValues(eventType, "fallback").Inc()
+		b.logger.Warn("Batch write failed, retrying events one at a time",
+			zap.String("type", eventType),
+			zap.Int("events", len(events)),
+			zap.Error(err),
+		)
+		for _, e := range events {
+			err := traceEvent(ctx, e.message, e.typed, func(ctx context.Context) error {
+				return b.apply(ctx, e.typed)
+			})
+			if err == nil {
+				messagesProcessedTotal.WithLabelValues(eventType).Inc()
+				succeeded = append(succeeded, e)
+				continue
+			}
+
+			countProcessingError(eventType, processingErrorReason(err))
+			b.consumer.LogMessage("error", "Failed to process event", e.message, e.event, zap.Error(err))
+			if !pushToDLQ(ctx, b.dlq, e.message, e.event, err, b.logger) {
+				b.consumer.Hold(e.message)
+				unparked = append(unparked, e.message)
+			}
+		}
+	}
+
+	if b.coalescer != nil {
+		b.coalescer.Flush(ctx)
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	if err := b.consumer.CommitMessages(ctx, committable(messages, unparked)...); err != nil {
+		b.logger.Error("Failed to commit batched offsets", zap.Error(err))
+		return
+	}
+
+	for _, e := range succeeded {
+		b.consumer.RunCommitHooks(ctx, e.message, e.event)
+	}
+
+	b.logger.Info("Flushed write batches",
+		zap.Int("messages", len(messages)),
+		zap.Int("succeeded", len(succeeded)),
+	)
+}
+
+// write upserts one type's buffered rows in a single batch
+func (b *writeBatcher) write(ctx context.Context, eventType string, events []batchedEvent) error {
+	switch eventType {
+	case "UserCreated":
+		users := make([]*store.User, len(events))
+		for i, e := range events {
+			users[i] = e.row.(*store.User)
+		}
+		_, _, err := b.sqlStore.UpsertUsersBatch(ctx, users)
+		return err
+	case "OrderPlaced":
+		orders := make([]*store.Order, len(events))
+		for i, e := range events {
+			orders[i] = e.row.(*store.Order)
+		}
+		return b.sqlStore.UpsertOrdersBatch(ctx, orders)
+	default:
+		payments := make([]*store.Payment, len(events))
+		for i, e := range events {
+			payments[i] = e.row.(*store.Payment)
+		}
+		return b.sqlStore.UpsertPaymentsBatch(ctx, payments)
+	}
+}
+
+// flushBuffers writes and commits everything the batcher and coalescer hold, so a
+// message processed directly can't have its offset committed past them
+func flushBuffers(ctx context.Context, coalescer *inventoryCoalescer, batcher *writeBatcher) {
+	if batcher != nil {
+		// Flushes the coalescer too
+		batcher.Flush(ctx)
+	} else if coalescer != nil {
+		coalescer.Flush(ctx)
+	}
+}
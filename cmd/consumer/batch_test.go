@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"kafka-pipeline/internal/store"
+
+	"go.uber.org/zap"
+)
+
+// fakeBatchWriter fails every batch when err is set. With hang set each batch
+// waits until its context is done, as a hung query would.
+type fakeBatchWriter struct {
+	err  error
+	hang bool
+}
+
+func (f *fakeBatchWriter) UpsertUsersBatch(ctx context.Context, users []*store.User) (int64, int64, error) {
+	return int64(len(users)), 0, f.err
+}
+
+func (f *fakeBatchWriter) UpsertOrdersBatch(ctx context.Context, orders []*store.Order) error {
+	if f.hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return f.err
+}
+
+func (f *fakeBatchWriter) UpsertPaymentsBatch(ctx context.Context, payments []*store.Payment) error {
+	return f.err
+}
+
+func TestWriteBatcherFlushCommits(t *testing.T) {
+	batchErr := errors.New("batch failed")
+	rowErr := errors.New("row failed")
+
+	tests := []struct {
+		name          string
+		batchErr      error
+		failOrders    map[string]bool
+		dlqErr        error
+		wantCommitted []string
+		wantDLQ       int
+		wantHeld      int
+	}{
+		{
+			name:          "batch commits every offset",
+			wantCommitted: []string{"0/3", "0/5", "0/7", "1/2"},
+		},
+		{
+			name:          "fallback success commits every offset",
+			batchErr:      batchErr,
+			wantCommitted: []string{"0/3", "0/5", "0/7", "1/2"},
+		},
+		{
+			name:          "failed event parked in DLQ is committed",
+			batchErr:      batchErr,
+			failOrders:    map[string]bool{"order-5": true},
+			wantCommitted: []string{"0/3", "0/5", "0/7", "1/2"},
+			wantDLQ:       1,
+		},
+		{
+			name:          "failed DLQ push leaves the offset and later ones uncommitted",
+			batchErr:      batchErr,
+			failOrders:    map[string]bool{"order-5": true},
+			dlqErr:        errors.New("redis down"),
+			wantCommitted: []string{"0/3", "1/2"},
+			wantHeld:      1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consumer := &fakeConsumer{}
+			queue := &fakeDLQ{err: tt.dlqErr}
+			b := newWriteBatcher(100, 0, nil, consumer, nil, queue, zap.NewNop())
+			b.sqlStore = &fakeBatchWriter{err: tt.batchErr}
+			b.apply = func(ctx context.Context, event *store.Event) error {
+				var data store.OrderPlacedData
+				if err := event.DecodeData(&data); err != nil {
+					return err
+				}
+				if tt.failOrders[data.OrderID] {
+					return rowErr
+				}
+				return nil
+			}
+
+			for _, e := range []struct {
+				partition int
+				offset    int64
+				orderID   string
+			}{
+				{0, 3, "order-3"},
+				{0, 5, "order-5"},
+				{0, 7, "order-7"},
+				{1, 2, "order-2"},
+			} {
+				message, event, typed := testEvent(t, e.partition, e.offset, "OrderPlaced", map[string]interface{}{"orderId": e.orderID, "userId": "user-1", "total": 10})
+				if !b.Add(context.Background(), message, event, typed) {
+					t.Fatal("OrderPlaced event not batched")
+				}
+			}
+
+			b.Flush(context.Background())
+
+			if got := consumer.committedOffsets(); !reflect.DeepEqual(got, tt.wantCommitted) {
+				t.Errorf("committed %v, want %v", got, tt.wantCommitted)
+			}
+			if len(queue.pushed) != tt.wantDLQ {
+				t.Errorf("pushed %d messages to the DLQ, want %d", len(queue.pushed), tt.wantDLQ)
+			}
+			if len(consumer.held) != tt.wantHeld {
+				t.Errorf("held %d messages, want %d", len(consumer.held), tt.wantHeld)
+			}
+		})
+	}
+}
+
+func TestWriteBatcherFlushTimesOutHungBatch(t *testing.T) {
+	setProcessingTimeout(t, 20*time.Millisecond)
+
+	consumer := &fakeConsumer{}
+	b := newWriteBatcher(100, 0, nil, consumer, nil, &fakeDLQ{}, zap.NewNop())
+	b.sqlStore = &fakeBatchWriter{hang: true}
+	var applied []string
+	b.apply = func(ctx context.Context, event *store.Event) error {
+		applied = append(applied, event.EventID)
+		return nil
+	}
+
+	for offset := int64(1); offset <= 3; offset++ {
+		message, event, typed := testEvent(t, 0, offset, "OrderPlaced", map[string]interface{}{"orderId": "order-1", "userId": "user-1", "total": 10})
+		b.Add(context.Background(), message, event, typed)
+	}
+
+	flushWithin(t, 2*time.Second, b.Flush)
+
+	// The hung batch is abandoned and its events written one at a time instead
+	if len(applied) != 3 {
+		t.Errorf("applied %d events one at a time, want 3", len(applied))
+	}
+	if got := consumer.committedOffsets(); !reflect.DeepEqual(got, []string{"0/1", "0/2", "0/3"}) {
+		t.Errorf("committed %v, want [0/1 0/2 0/3]", got)
+	}
+}
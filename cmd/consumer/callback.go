@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"kafka-pipeline/internal/callback"
+	"kafka-pipeline/internal/kafka"
+
+	"github.com/prometheus/client_golang/prometheus"
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+var callbacksTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "callbacks_total",
+		Help: "Total number of completion callbacks attempted, by result",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(callbacksTotal)
+}
+
+// completionCallbacks returns a commit hook that notifies the registered callback URL,
+// if any, once an event has been persisted and committed. Delivery runs on its own
+// goroutine so a slow or failing callback endpoint can't stall consumption.
+func completionCallbacks(registry *callback.Registry, notifier *callback.Notifier, logger *zap.Logger) kafka.CommitHook {
+	return func(ctx context.Context, message *kafkaGo.Message, event map[string]interface{}) {
+		eventID, _ := event["eventId"].(string)
+		eventType, _ := event["type"].(string)
+		if eventID == "" {
+			return
+		}
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			callbackURL, err := registry.Take(ctx, eventID)
+			if err != nil {
+				logger.Error("Failed to look up callback", zap.String("eventId", eventID), zap.Error(err))
+				return
+			}
+			if callbackURL == "" {
+				return
+			}
+
+			err = notifier.Notify(ctx, callbackURL, callback.Notification{
+				EventID:     eventID,
+				Type:        eventType,
+				Status:      "persisted",
+				CompletedAt: time.Now().UTC(),
+			})
+			if err != nil {
+				callbacksTotal.WithLabelValues("failed").Inc()
+				logger.Error("Completion callback failed",
+					zap.String("eventId", eventID),
+					zap.String("callbackUrl", callbackURL),
+					zap.Error(err),
+				)
+				return
+			}
+
+			callbacksTotal.WithLabelValues("delivered").Inc()
+			logger.Info("Completion callback delivered", zap.String("eventId", eventID))
+		}()
+	}
+}
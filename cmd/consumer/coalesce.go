@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"kafka-pipeline/internal/dlq"
+	"kafka-pipeline/internal/store"
+
+	"github.com/prometheus/client_golang/prometheus"
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+var (
+	inventoryCoalescedEventsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "inventory_coalesced_events_total",
+			Help: "Total number of InventoryAdjusted events buffered for coalescing",
+		},
+	)
+
+	inventoryCoalescedWritesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "inventory_coalesced_writes_total",
+			Help: "Total number of inventory updates issued after coalescing",
+		},
+	)
+
+	inventoryCoalescingRatio = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "inventory_coalescing_ratio",
+			Help: "Events per database write in the most recent inventory flush",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(inventoryCoalescedEventsTotal)
+	prometheus.MustRegister(inventoryCoalescedWritesTotal)
+	prometheus.MustRegister(inventoryCoalescingRatio)
+}
+
+// pendingAdjustment is the buffered adjustments for one SKU within a flush window
+type pendingAdjustment struct {
+	messages []*kafkaGo.Message
+	applied  []bufferedEvent
+}
+
+// bufferedEvent is an event whose delta was included in a pending adjustment
+type bufferedEvent struct {
+	message    *kafkaGo.Message
+	event      map[string]interface{}
+	adjustment store.InventoryAdjustment
+}
+
+// inventoryCoalescer buffers InventoryAdjusted events per SKU and applies the
+// summed delta in a single update per flush. Offsets of buffered messages are
+// committed only after their flush, so nothing is acknowledged before it is written.
+type inventoryCoalescer struct {
+	mu      sync.Mutex
+	pending map[string]*pendingAdjustment
+	seen    map[string]bool
+
+	// flushMu serializes flushes, so one finding the buffer empty can't return
+	// while another is still writing the messages it took
+	flushMu sync.Mutex
+
+	interval time.Duration
+
+	consumer bufferConsumer
+	sqlStore inventoryWriter
+	dlq      dlq.DLQ
+	logger   *zap.Logger
+}
+
+func newInventoryCoalescer(interval time.Duration, consumer bufferConsumer, sqlStore inventoryWriter, dlq dlq.DLQ, logger *zap.Logger) *inventoryCoalescer {
+	return &inventoryCoalescer{
+		pending:  make(map[string]*pendingAdjustment),
+		seen:     make(map[string]bool),
+		interval: interval,
+		consumer: consumer,
+		sqlStore: sqlStore,
+		dlq:      dlq,
+		logger:   logger,
+	}
+}
+
+// Run flushes the buffer on every interval until ctx is cancelled
+func (c *inventoryCoalescer) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.Flush(context.Background())
+			return
+		case <-ticker.C:
+			c.Flush(ctx)
+		}
+	}
+}
+
+// Add buffers an InventoryAdjusted event. Redelivered copies of an event that is
+// already buffered are dropped so the delta is not applied twice in one window.
+func (c *inventoryCoalescer) Add(message *kafkaGo.Message, event map[string]interface{}, typed *store.Event) error {
+	var data store.InventoryAdjustedData
+	if err := typed.DecodeData(&data); err != nil {
+		return err
+	}
+	sku := data.SKU
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	adj, exists := c.pending[sku]
+	if !exists {
+		adj = &pendingAdjustment{}
+		c.pending[sku] = adj
+	}
+
+	// Always track the message so its offset is committed with the flush
+	adj.messages = append(adj.messages, message)
+
+	eventID := typed.EventID
+	if eventID != "" && c.seen[eventID] {
+		return nil
+	}
+	c.seen[eventID] = true
+
+	adj.applied = append(adj.applied, bufferedEvent{
+		message: message,
+		event:   event,
+		adjustment: store.InventoryAdjustment{
+			EventID:    eventID,
+			Delta:      data.Delta,
+			Reason:     data.Reason,
+			AdjustedAt: orNow(data.AdjustedAt.Time),
+		},
+	})
+	inventoryCoalescedEventsTotal.Inc()
+
+	return nil
+}
+
+// Flush applies all buffered adjustments and commits their offsets. Events for a
+// SKU whose update fails are pushed to the DLQ before their offsets are committed;
+// one the DLQ can't store holds its partition, and neither it nor any later offset
+// of that partition is committed, so it is redelivered.
+func (c *inventoryCoalescer) Flush(ctx context.Context) {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[string]*pendingAdjustment)
+	c.seen = make(map[string]bool)
+	c.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	var messages []kafkaGo.Message
+	var succeeded []bufferedEvent
+	var unparked []*kafkaGo.Message
+	events := 0
+
+	for sku, adj := range pending {
+		for _, message := range adj.messages {
+			messages = append(messages, *message)
+		}
+		if len(adj.applied) == 0 {
+			continue
+		}
+		events += len(adj.applied)
+
+		adjustments := make([]store.InventoryAdjustment, len(adj.applied))
+		for i, b := range adj.applied {
+			adjustments[i] = b.adjustment
+		}
+
+		start := time.Now()
+		var skipped int
+		err := withProcessingTimeout(ctx, func(ctx context.Context) error {
+			var err error
+			skipped, err = c.sqlStore.ApplyInventoryAdjustments(ctx, sku, adjustments)
+			return err
+		})
+		dbLatencySeconds.WithLabelValues("upsert", "InventoryAdjusted").Observe(time.Since(start).Seconds())
+		inventoryCoalescedWritesTotal.Inc()
+		if skipped > 0 {
+			eventsDeduplicatedTotal.WithLabelValues("InventoryAdjusted").Add(float64(skipped))
+		}
+
+		if err != nil {
+			c.logger.Error("Failed to apply coalesced inventory adjustment",
+				zap.String("sku", sku),
+				zap.Int("events", len(adj.applied)),
+				zap.Error(err),
+			)
+			for _, b := range adj.applied {
+				countProcessingError("InventoryAdjusted", processingErrorReason(err))
+				if !pushToDLQ(ctx, c.dlq, b.message, b.event, err, c.logger) {
+					c.consumer.Hold(b.message)
+					unparked = append(unparked, b.message)
+				}
+			}
+			continue
+		}
+
+		messagesProcessedTotal.WithLabelValues("InventoryAdjusted").Add(float64(len(adj.applied)))
+		succeeded = append(succeeded, adj.applied...)
+	}
+
+	if writes := len(pending); writes > 0 {
+		inventoryCoalescingRatio.Set(float64(events) / float64(writes))
+	}
+
+	if err := c.consumer.CommitMessages(ctx, committable(messages, unparked)...); err != nil {
+		c.logger.Error("Failed to commit coalesced offsets", zap.Error(err))
+		return
+	}
+
+	for _, applied := range succeeded {
+		c.consumer.RunCommitHooks(ctx, applied.message, applied.event)
+	}
+
+	c.logger.Info("Flushed coalesced inventory adjustments",
+		zap.Int("skus", len(pending)),
+		zap.Int("events", events),
+		zap.Int("messages", len(messages)),
+	)
+}
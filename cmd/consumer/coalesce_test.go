@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+func TestCommittable(t *testing.T) {
+	messages := []kafkaGo.Message{
+		{Partition: 0, Offset: 3},
+		{Partition: 0, Offset: 5},
+		{Partition: 0, Offset: 7},
+		{Partition: 1, Offset: 2},
+		{Partition: 1, Offset: 4},
+	}
+
+	tests := []struct {
+		name     string
+		unparked []*kafkaGo.Message
+		want     []string
+	}{
+		{"none unparked", nil, []string{"0/3", "0/5", "0/7", "1/2", "1/4"}},
+		{"later offsets of the partition withheld", []*kafkaGo.Message{{Partition: 0, Offset: 5}}, []string{"0/3", "1/2", "1/4"}},
+		{"earliest unparked offset wins", []*kafkaGo.Message{{Partition: 0, Offset: 7}, {Partition: 0, Offset: 3}}, []string{"1/2", "1/4"}},
+		{"each partition on its own", []*kafkaGo.Message{{Partition: 0, Offset: 7}, {Partition: 1, Offset: 2}}, []string{"0/3", "0/5"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := offsets(committable(messages, tt.unparked))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("committable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInventoryCoalescerFlushCommits(t *testing.T) {
+	writeErr := errors.New("write failed")
+
+	tests := []struct {
+		name          string
+		fail          map[string]error
+		dlqErr        error
+		wantCommitted []string
+		wantDLQ       int
+		wantHeld      int
+	}{
+		{
+			name:          "success commits every offset",
+			wantCommitted: []string{"0/3", "0/5", "0/7", "1/2"},
+		},
+		{
+			name:          "failed write parked in DLQ is committed",
+			fail:          map[string]error{"sku-a": writeErr},
+			wantCommitted: []string{"0/3", "0/5", "0/7", "1/2"},
+			wantDLQ:       1,
+		},
+		{
+			name:          "failed DLQ push leaves the offset and later ones uncommitted",
+			fail:          map[string]error{"sku-a": writeErr},
+			dlqErr:        errors.New("redis down"),
+			wantCommitted: []string{"0/3", "1/2"},
+			wantHeld:      1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consumer := &fakeConsumer{}
+			queue := &fakeDLQ{err: tt.dlqErr}
+			c := newInventoryCoalescer(0, consumer, &fakeInventory{fail: tt.fail}, queue, zap.NewNop())
+
+			for _, e := range []struct {
+				partition int
+				offset    int64
+				sku       string
+			}{
+				{0, 3, "sku-b"},
+				{0, 5, "sku-a"},
+				{0, 7, "sku-b"},
+				{1, 2, "sku-c"},
+			} {
+				message, event, typed := testEvent(t, e.partition, e.offset, "InventoryAdjusted", map[string]interface{}{"sku": e.sku, "delta": 1})
+				if err := c.Add(message, event, typed); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c.Flush(context.Background())
+
+			if got := consumer.committedOffsets(); !reflect.DeepEqual(got, tt.wantCommitted) {
+				t.Errorf("committed %v, want %v", got, tt.wantCommitted)
+			}
+			if len(queue.pushed) != tt.wantDLQ {
+				t.Errorf("pushed %d messages to the DLQ, want %d", len(queue.pushed), tt.wantDLQ)
+			}
+			if len(consumer.held) != tt.wantHeld {
+				t.Errorf("held %d messages, want %d", len(consumer.held), tt.wantHeld)
+			}
+		})
+	}
+}
+
+func TestInventoryCoalescerFlushWaitsForRunningFlush(t *testing.T) {
+	consumer := &fakeConsumer{}
+	inventory := &fakeInventory{block: make(chan struct{})}
+	c := newInventoryCoalescer(0, consumer, inventory, &fakeDLQ{}, zap.NewNop())
+
+	message, event, typed := testEvent(t, 0, 3, "InventoryAdjusted", map[string]interface{}{"sku": "sku-a", "delta": 1})
+	if err := c.Add(message, event, typed); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first flush takes the buffer and blocks writing it
+	first := make(chan struct{})
+	go func() {
+		c.Flush(context.Background())
+		close(first)
+	}()
+	for {
+		c.mu.Lock()
+		taken := len(c.pending) == 0
+		c.mu.Unlock()
+		if taken {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A second flush finds the buffer empty but must not return before the first
+	// has committed, or its caller could commit past the message being written
+	second := make(chan struct{})
+	go func() {
+		c.Flush(context.Background())
+		close(second)
+	}()
+
+	select {
+	case <-second:
+		t.Fatal("Flush returned while another flush was still writing")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(inventory.block)
+	<-first
+	<-second
+	if got := consumer.committedOffsets(); !reflect.DeepEqual(got, []string{"0/3"}) {
+		t.Errorf("committed %v, want [0/3]", got)
+	}
+}
+
+func TestInventoryCoalescerFlushTimesOutHungWrite(t *testing.T) {
+	setProcessingTimeout(t, 20*time.Millisecond)
+
+	consumer := &fakeConsumer{}
+	queue := &fakeDLQ{}
+	inventory := &fakeInventory{hang: map[string]bool{"sku-a": true}}
+	c := newInventoryCoalescer(0, consumer, inventory, queue, zap.NewNop())
+
+	for offset, sku := range []string{"sku-a", "sku-b"} {
+		message, event, typed := testEvent(t, 0, int64(offset), "InventoryAdjusted", map[string]interface{}{"sku": sku, "delta": 1})
+		if err := c.Add(message, event, typed); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flushWithin(t, 2*time.Second, c.Flush)
+
+	// The hung SKU's event is parked with a timeout error; the other is written
+	if len(queue.pushed) != 1 || !strings.Contains(queue.pushed[0], "processing timeout") {
+		t.Errorf("DLQ pushes = %v, want one processing timeout", queue.pushed)
+	}
+	if inventory.applied["sku-b"] != 1 {
+		t.Errorf("sku-b adjusted by %d, want 1", inventory.applied["sku-b"])
+	}
+	if got := consumer.committedOffsets(); !reflect.DeepEqual(got, []string{"0/0", "0/1"}) {
+		t.Errorf("committed %v, want [0/0 0/1]", got)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+
+	"kafka-pipeline/internal/store"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// bufferConsumer is the part of kafka.Consumer the write buffers commit through
+type bufferConsumer interface {
+	CommitMessages(ctx context.Context, messages ...kafkaGo.Message) error
+	Hold(message *kafkaGo.Message)
+	RunCommitHooks(ctx context.Context, message *kafkaGo.Message, event map[string]interface{})
+	LogMessage(level string, msg string, message *kafkaGo.Message, event map[string]interface{}, fields ...zap.Field)
+}
+
+// inventoryWriter applies coalesced inventory adjustments
+type inventoryWriter interface {
+	ApplyInventoryAdjustments(ctx context.Context, sku string, adjustments []store.InventoryAdjustment) (int, error)
+}
+
+// committable returns the messages whose offsets may be committed after those in
+// unparked were neither written nor stored in the DLQ. A committed offset covers
+// every earlier message of its partition, so nothing at or past the first unparked
+// message of a partition is committed; it is redelivered from there instead.
+func committable(messages []kafkaGo.Message, unparked []*kafkaGo.Message) []kafkaGo.Message {
+	if len(unparked) == 0 {
+		return messages
+	}
+
+	first := make(map[int]int64, len(unparked))
+	for _, m := range unparked {
+		if offset, ok := first[m.Partition]; !ok || m.Offset < offset {
+			first[m.Partition] = m.Offset
+		}
+	}
+
+	allowed := make([]kafkaGo.Message, 0, len(messages))
+	for _, m := range messages {
+		if offset, ok := first[m.Partition]; ok && m.Offset >= offset {
+			continue
+		}
+		allowed = append(allowed, m)
+	}
+	return allowed
+}
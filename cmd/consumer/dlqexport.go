@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+
+	"kafka-pipeline/internal/dlq"
+
+	"go.uber.org/zap"
+)
+
+// handleDLQExport streams a topic's DLQ list as newline-delimited JSON, oldest first,
+// reading and flushing chunkSize entries at a time so huge queues never sit in memory.
+// It answers 501 when the DLQ is configured without per-topic lists.
+func handleDLQExport(redisDLQ *dlq.RedisDLQ, defaultTopic string, chunkSize int64, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !redisDLQ.HasTopicList() {
+			http.Error(w, "The DLQ keeps no per-topic list to export; see DLQ_MODE and DLQ_ROUTING", http.StatusNotImplemented)
+			return
+		}
+
+		topic := r.URL.Query().Get("topic")
+		if topic == "" {
+			topic = defaultTopic
+		}
+
+		flusher, _ := w.(http.Flusher)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+
+		var written int64
+		err := redisDLQ.StreamMessages(r.Context(), topic, chunkSize, func(chunk []string) error {
+			for _, msg := range chunk {
+				if _, err := w.Write([]byte(msg + "\n")); err != nil {
+					return err
+				}
+			}
+			written += int64(len(chunk))
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		})
+		if err != nil {
+			// Headers are already sent once a chunk is written, so just stop the stream
+			logger.Error("DLQ export failed", zap.String("topic", topic), zap.Int64("written", written), zap.Error(err))
+			if written == 0 {
+				http.Error(w, "Failed to read DLQ", http.StatusInternalServerError)
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"kafka-pipeline/internal/dlq"
+
+	"go.uber.org/zap"
+)
+
+// handleDLQLookup returns the latest DLQ entry for the eventId in the path, from
+// the requested topic or the consumed one, or 404 if the event isn't in the DLQ
+func handleDLQLookup(redisDLQ *dlq.RedisDLQ, defaultTopic string, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eventID := r.PathValue("eventId")
+		topic := r.URL.Query().Get("topic")
+		if topic == "" {
+			topic = defaultTopic
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		msg, err := redisDLQ.FindByEventID(ctx, topic, eventID)
+		if err != nil {
+			logger.Error("Failed to look up DLQ message", zap.String("topic", topic), zap.String("eventId", eventID), zap.Error(err))
+			http.Error(w, "Failed to read DLQ", http.StatusInternalServerError)
+			return
+		}
+		if msg == nil {
+			http.Error(w, "Event not found in DLQ", http.StatusNotFound)
+			return
+		}
+
+		// Set content type and write response
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(msg); err != nil {
+			logger.Error("Failed to encode response", zap.Error(err))
+		}
+	}
+}
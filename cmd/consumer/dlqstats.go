@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"kafka-pipeline/internal/dlq"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var dlqDepth = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "dlq_depth",
+		Help: "Number of messages in the Redis DLQ, refreshed every DLQ_STATS_INTERVAL",
+	},
+	[]string{"topic"},
+)
+
+func init() {
+	prometheus.MustRegister(dlqDepth)
+}
+
+// monitorDLQDepth refreshes the dlq_depth gauge for each topic every interval until
+// ctx is cancelled, so alerts can fire on a growing DLQ
+func monitorDLQDepth(ctx context.Context, redisDLQ *dlq.RedisDLQ, topics []string, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, topic := range topics {
+			statsCtx, cancel := context.WithTimeout(ctx, interval)
+			stats, err := redisDLQ.Stats(statsCtx, topic)
+			cancel()
+			if err != nil {
+				logger.Warn("Failed to refresh DLQ depth", zap.String("topic", topic), zap.Error(err))
+				continue
+			}
+			dlqDepth.WithLabelValues(topic).Set(float64(stats.Count))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleDLQStats returns the DLQ stats of the requested topic, or of every topic
+// the consumer reads when none is given
+func handleDLQStats(redisDLQ *dlq.RedisDLQ, topics []string, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requested := topics
+		if topic := r.URL.Query().Get("topic"); topic != "" {
+			requested = []string{topic}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		all := make([]*dlq.Stats, 0, len(requested))
+		for _, topic := range requested {
+			stats, err := redisDLQ.Stats(ctx, topic)
+			if err != nil {
+				logger.Error("Failed to get DLQ stats", zap.String("topic", topic), zap.Error(err))
+				http.Error(w, "Failed to read DLQ", http.StatusInternalServerError)
+				return
+			}
+			all = append(all, stats)
+		}
+
+		// Set content type and write response
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"topics": all}); err != nil {
+			logger.Error("Failed to encode response", zap.Error(err))
+		}
+	}
+}
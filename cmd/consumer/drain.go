@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"kafka-pipeline/internal/dlq"
+	"kafka-pipeline/internal/kafka"
+	"kafka-pipeline/internal/store"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// drainDLQ reprocesses the messages that were in a topic's DLQ list at startup,
+// oldest first. Messages that fail again are put back with their retryCount
+// incremented, and only the entries present when the drain started are attempted,
+// so a message that keeps failing can't loop forever. It returns how many were
+// drained and how many remain.
+func drainDLQ(ctx context.Context, redisDLQ *dlq.RedisDLQ, topic string, consumer *kafka.Consumer, sqlStore *store.MSSQLStore, logger *zap.Logger) (int64, int64, error) {
+	pending, err := redisDLQ.Count(ctx, topic)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var drained int64
+	stillFailing := map[string]int{}
+	for i := int64(0); i < pending; i++ {
+		raw, err := redisDLQ.PopMessage(ctx, topic)
+		if err != nil {
+			return drained, pending - drained, err
+		}
+		if raw == "" {
+			break
+		}
+
+		if err := reprocessDLQMessage(ctx, raw, consumer, sqlStore, logger); err != nil {
+			logger.Warn("DLQ message still failing, requeueing", zap.Error(err))
+			raw, retryCount := incrementRetryCount(raw)
+			if err := redisDLQ.Requeue(ctx, topic, raw); err != nil {
+				return drained, pending - drained, err
+			}
+			stillFailing[retryCountLabel(retryCount)]++
+			continue
+		}
+		drained++
+	}
+
+	// Every entry left in the list was just requeued, so they are the gauge's new baseline
+	dlqMessagesByRetryCount.Reset()
+	for label, n := range stillFailing {
+		dlqMessagesByRetryCount.WithLabelValues(label).Set(float64(n))
+	}
+
+	remaining, err := redisDLQ.Count(ctx, topic)
+	if err != nil {
+		return drained, pending - drained, err
+	}
+
+	return drained, remaining, nil
+}
+
+// incrementRetryCount bumps the retryCount of a stored DLQ entry, returning the
+// updated entry and its new count. Entries that can't be decoded are returned as is.
+func incrementRetryCount(raw string) (string, int) {
+	var dlqMsg map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &dlqMsg); err != nil {
+		return raw, 0
+	}
+
+	retryCount, _ := dlqMsg["retryCount"].(float64)
+	dlqMsg["retryCount"] = int(retryCount) + 1
+
+	updated, err := json.Marshal(dlqMsg)
+	if err != nil {
+		return raw, int(retryCount)
+	}
+	return string(updated), int(retryCount) + 1
+}
+
+// reprocessDLQMessage runs a stored DLQ entry's payload through the event handlers,
+// writing it to the store of the tenant it belongs to
+func reprocessDLQMessage(ctx context.Context, raw string, consumer *kafka.Consumer, sqlStore *store.MSSQLStore, logger *zap.Logger) error {
+	var dlqMsg store.DLQMessage
+	if err := json.Unmarshal([]byte(raw), &dlqMsg); err != nil {
+		return fmt.Errorf("failed to unmarshal DLQ message: %w", err)
+	}
+
+	// Unparseable messages were stored as the raw value, parsed ones as the event
+	var value []byte
+	switch payload := dlqMsg.Payload.(type) {
+	case string:
+		value = []byte(payload)
+	default:
+		var err error
+		if value, err = json.Marshal(payload); err != nil {
+			return fmt.Errorf("failed to marshal payload: %w", err)
+		}
+	}
+
+	// Rebuild the message with its stored headers, which may carry the tenant
+	message := &kafkaGo.Message{
+		Topic:     dlqMsg.Topic,
+		Partition: dlqMsg.Partition,
+		Offset:    dlqMsg.Offset,
+		Headers:   kafka.MapToHeaders(dlqMsg.Headers),
+		Value:     value,
+	}
+	event, err := consumer.ParseEvent(message)
+	if err != nil {
+		return err
+	}
+
+	typed, err := store.DecodeEvent(value)
+	if err != nil {
+		return err
+	}
+
+	// Route to the tenant's store as processMessage does; unknown tenants stay in the DLQ
+	target, err := tenants.storeFor(message, event, sqlStore)
+	if err != nil {
+		return err
+	}
+	if err := processEventByType(ctx, typed, target, logger); err != nil {
+		return err
+	}
+
+	messagesProcessedTotal.WithLabelValues(typed.Type).Inc()
+	return nil
+}
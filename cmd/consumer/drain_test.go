@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"kafka-pipeline/internal/dlq"
+	"kafka-pipeline/internal/kafka"
+	"kafka-pipeline/internal/store"
+
+	"github.com/alicebob/miniredis/v2"
+	"go.uber.org/zap"
+)
+
+// setTenants installs a tenant router for the duration of a test
+func setTenants(t *testing.T, router *tenantRouter) {
+	t.Helper()
+
+	previous := tenants
+	tenants = router
+	t.Cleanup(func() { tenants = previous })
+}
+
+func TestReprocessDLQMessageRoutesByTenant(t *testing.T) {
+	// The acme store is never reached: every event below fails routing or decoding first
+	setTenants(t, &tenantRouter{stores: map[string]*store.MSSQLStore{"acme": nil}})
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		event   map[string]interface{}
+		wantErr string
+	}{
+		{
+			name:    "unknown tenant in the body",
+			event:   map[string]interface{}{"eventId": "evt-1", "type": "UserCreated", "tenantId": "ghost", "data": map[string]interface{}{"userId": "user-1", "name": "Ada", "email": "ada@example.com"}},
+			wantErr: "unknown tenant: ghost",
+		},
+		{
+			name:    "unknown tenant in the stored headers",
+			headers: map[string]string{tenantHeader: "ghost"},
+			event:   map[string]interface{}{"eventId": "evt-1", "type": "UserCreated", "data": map[string]interface{}{"userId": "user-1", "name": "Ada", "email": "ada@example.com"}},
+			wantErr: "unknown tenant: ghost",
+		},
+		{
+			// Routed to acme, then rejected by the data checks before any write
+			name:    "known tenant",
+			headers: map[string]string{tenantHeader: "acme"},
+			event:   map[string]interface{}{"eventId": "evt-1", "type": "UserCreated", "data": map[string]interface{}{"name": "Ada", "email": "ada@example.com"}},
+			wantErr: "userId is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := json.Marshal(store.DLQMessage{EventID: "evt-1", Topic: "events", Headers: tt.headers, Payload: tt.event})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = reprocessDLQMessage(context.Background(), string(raw), &kafka.Consumer{}, nil, zap.NewNop())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("reprocessDLQMessage() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDrainDLQRequeuesUnknownTenants(t *testing.T) {
+	setTenants(t, &tenantRouter{stores: map[string]*store.MSSQLStore{}})
+
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	redisDLQ, err := dlq.NewRedisDLQ(dlq.RedisConfig{Addrs: []string{server.Addr()}}, dlq.ModeList, dlq.RouteTopic, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redisDLQ.Close()
+
+	event := map[string]interface{}{"eventId": "evt-1", "type": "UserCreated", "tenantId": "ghost", "data": map[string]interface{}{"userId": "user-1", "name": "Ada", "email": "ada@example.com"}}
+	if err := redisDLQ.PushMessage(ctx, "events", 0, 7, nil, event, "unknown tenant: ghost", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// No default store is given, so a write to it would panic
+	drained, remaining, err := drainDLQ(ctx, redisDLQ, "events", &kafka.Consumer{}, nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drained != 0 || remaining != 1 {
+		t.Fatalf("drained %d, remaining %d; want 0, 1", drained, remaining)
+	}
+
+	entries, err := redisDLQ.GetMessages(ctx, "events", 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dlqMsg store.DLQMessage
+	if err := json.Unmarshal([]byte(entries[0]), &dlqMsg); err != nil {
+		t.Fatal(err)
+	}
+	if dlqMsg.EventID != "evt-1" || dlqMsg.RetryCount != 1 {
+		t.Errorf("requeued %s with retryCount %d, want evt-1 with 1", dlqMsg.EventID, dlqMsg.RetryCount)
+	}
+}
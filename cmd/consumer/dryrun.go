@@ -0,0 +1,47 @@
+package main
+
+import (
+	"kafka-pipeline/internal/kafka"
+	"kafka-pipeline/internal/store"
+
+	"github.com/prometheus/client_golang/prometheus"
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+var messagesWouldProcessTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "messages_would_process_total",
+		Help: "Total number of messages handled in dry-run mode, by event type and the action that would have been taken",
+	},
+	[]string{"type", "action"},
+)
+
+func init() {
+	prometheus.MustRegister(messagesWouldProcessTotal)
+}
+
+// dryRun makes the consumer parse and route messages without writing to SQL
+// Server, pushing to the DLQ or committing offsets
+var dryRun = false
+
+// dryRunMessage logs what processMessage would do with a message: apply it to
+// its store, or push it to the DLQ when parsing or tenant resolution failed
+func dryRunMessage(message *kafkaGo.Message, event map[string]interface{}, typed *store.Event, err error, consumer *kafka.Consumer, sqlStore *store.MSSQLStore) {
+	eventType := "unknown"
+	if typed != nil {
+		eventType = typed.Type
+	}
+	if err == nil {
+		_, err = tenants.storeFor(message, event, sqlStore)
+	}
+
+	if err != nil {
+		messagesWouldProcessTotal.WithLabelValues(eventType, "dlq").Inc()
+		consumer.LogMessage("info", "Dry run: would push to DLQ", message, event, zap.Error(err))
+		return
+	}
+
+	messagesWouldProcessTotal.WithLabelValues(eventType, "process").Inc()
+	consumer.LogMessage("info", "Dry run: would process event", message, event)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"kafka-pipeline/internal/store"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// fakeConsumer records commits and holds instead of talking to Kafka
+type fakeConsumer struct {
+	mu        sync.Mutex
+	committed []kafkaGo.Message
+	held      []*kafkaGo.Message
+}
+
+func (c *fakeConsumer) CommitMessages(ctx context.Context, messages ...kafkaGo.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.committed = append(c.committed, messages...)
+	return nil
+}
+
+func (c *fakeConsumer) Hold(message *kafkaGo.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.held = append(c.held, message)
+}
+
+func (c *fakeConsumer) RunCommitHooks(ctx context.Context, message *kafkaGo.Message, event map[string]interface{}) {
+}
+
+func (c *fakeConsumer) LogMessage(level string, msg string, message *kafkaGo.Message, event map[string]interface{}, fields ...zap.Field) {
+}
+
+// committedOffsets returns the committed offsets as sorted "partition/offset" strings
+func (c *fakeConsumer) committedOffsets() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return offsets(c.committed)
+}
+
+// fakeDLQ stores pushed event IDs, or fails every push when err is set
+type fakeDLQ struct {
+	mu     sync.Mutex
+	err    error
+	pushed []string
+}
+
+func (d *fakeDLQ) PushMessage(ctx context.Context, topic string, partition int, offset int64, headers map[string]string, payload interface{}, errorMsg string, retryCount int) error {
+	if d.err != nil {
+		return d.err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pushed = append(d.pushed, fmt.Sprintf("%d/%d: %s", partition, offset, errorMsg))
+	return nil
+}
+
+// GetMessages returns the recorded pushes, oldest first; the range is ignored
+func (d *fakeDLQ) GetMessages(ctx context.Context, topic string, start, stop int64) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.pushed...), nil
+}
+
+func (d *fakeDLQ) Close() error {
+	return nil
+}
+
+// fakeInventory applies adjustments in memory, failing those for SKUs in fail.
+// When block is set each write waits for it to be closed; writes for SKUs in
+// hang wait until their context is done, as a hung query would.
+type fakeInventory struct {
+	mu      sync.Mutex
+	fail    map[string]error
+	hang    map[string]bool
+	block   chan struct{}
+	applied map[string]int
+}
+
+func (f *fakeInventory) ApplyInventoryAdjustments(ctx context.Context, sku string, adjustments []store.InventoryAdjustment) (int, error) {
+	if f.block != nil {
+		<-f.block
+	}
+	if f.hang[sku] {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	if err := f.fail[sku]; err != nil {
+		return 0, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.applied == nil {
+		f.applied = make(map[string]int)
+	}
+	for _, a := range adjustments {
+		f.applied[sku] += a.Delta
+	}
+	return 0, nil
+}
+
+// testEvent builds a consumed message carrying an event of eventType with data
+func testEvent(t testing.TB, partition int, offset int64, eventType string, data map[string]interface{}) (*kafkaGo.Message, map[string]interface{}, *store.Event) {
+	t.Helper()
+
+	event := map[string]interface{}{
+		"eventId":   fmt.Sprintf("evt-%d-%d", partition, offset),
+		"type":      eventType,
+		"timestamp": "2024-01-02T03:04:05Z",
+		"data":      data,
+	}
+	value, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed, err := store.DecodeEvent(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := &kafkaGo.Message{Topic: "events", Partition: partition, Offset: offset, Value: value}
+	return message, event, typed
+}
+
+// offsets formats messages as sorted "partition/offset" strings
+func offsets(messages []kafkaGo.Message) []string {
+	out := make([]string, len(messages))
+	for i, m := range messages {
+		out[i] = fmt.Sprintf("%d/%d", m.Partition, m.Offset)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// setProcessingTimeout sets processingTimeout for the duration of a test
+func setProcessingTimeout(t *testing.T, timeout time.Duration) {
+	t.Helper()
+
+	previous := processingTimeout
+	processingTimeout = timeout
+	t.Cleanup(func() { processingTimeout = previous })
+}
+
+// flushWithin runs flush, failing the test if it hasn't returned within limit
+func flushWithin(t *testing.T, limit time.Duration, flush func(context.Context)) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		flush(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(limit):
+		t.Fatalf("Flush still running after %s; the write isn't bounded by PROCESSING_TIMEOUT", limit)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"kafka-pipeline/internal/kafka"
+	"kafka-pipeline/internal/store"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// heartbeat periodically records this instance's liveness in the consumer_heartbeats
+// table. Alongside the time it last ran, it stores when a message was last processed,
+// so an instance that is alive but stuck shows a fresh last_seen_at with a stale
+// last_processed_at.
+type heartbeat struct {
+	store         *store.MSSQLStore
+	podID         string
+	interval      time.Duration
+	startedAt     time.Time
+	lastProcessed atomic.Int64 // unix nanoseconds, 0 until the first message
+	logger        *zap.Logger
+}
+
+func newHeartbeat(sqlStore *store.MSSQLStore, podID string, interval time.Duration, logger *zap.Logger) *heartbeat {
+	return &heartbeat{
+		store:     sqlStore,
+		podID:     podID,
+		interval:  interval,
+		startedAt: time.Now(),
+		logger:    logger,
+	}
+}
+
+// commitHook records the time of every committed message
+func (h *heartbeat) commitHook() kafka.CommitHook {
+	return func(ctx context.Context, message *kafkaGo.Message, event map[string]interface{}) {
+		h.lastProcessed.Store(time.Now().UnixNano())
+	}
+}
+
+// Run writes a heartbeat immediately and then every interval until ctx is cancelled
+func (h *heartbeat) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		h.beat(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *heartbeat) beat(ctx context.Context) {
+	var lastProcessed time.Time
+	if ns := h.lastProcessed.Load(); ns != 0 {
+		lastProcessed = time.Unix(0, ns)
+	}
+
+	beatCtx, cancel := context.WithTimeout(ctx, h.interval)
+	defer cancel()
+
+	if err := h.store.RecordHeartbeat(beatCtx, h.podID, h.startedAt, lastProcessed); err != nil {
+		h.logger.Warn("Failed to record consumer heartbeat", zap.Error(err))
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"kafka-pipeline/internal/kafka"
+
+	"github.com/prometheus/client_golang/prometheus"
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// registerReaderMetrics mirrors the reader's key counters into Prometheus, read
+// from consumer.Stats at scrape time
+func registerReaderMetrics(consumer *kafka.Consumer) {
+	counter := func(name, help string, value func(s kafkaGo.ReaderStats) int64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, func() float64 {
+			return float64(value(consumer.Stats()))
+		})
+	}
+
+	prometheus.MustRegister(
+		counter("kafka_reader_messages_total", "Total number of messages read by the Kafka reader", func(s kafkaGo.ReaderStats) int64 { return s.Messages }),
+		counter("kafka_reader_bytes_total", "Total number of message bytes read by the Kafka reader", func(s kafkaGo.ReaderStats) int64 { return s.Bytes }),
+		counter("kafka_reader_errors_total", "Total number of Kafka reader errors", func(s kafkaGo.ReaderStats) int64 { return s.Errors }),
+		counter("kafka_reader_rebalances_total", "Total number of consumer group rebalances seen by the Kafka reader", func(s kafkaGo.ReaderStats) int64 { return s.Rebalances }),
+		counter("kafka_reader_timeouts_total", "Total number of Kafka reader fetch timeouts", func(s kafkaGo.ReaderStats) int64 { return s.Timeouts }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "kafka_reader_queue_length",
+			Help: "Messages fetched by the Kafka reader but not yet read by the consumer",
+		}, func() float64 {
+			return float64(consumer.Stats().QueueLength)
+		}),
+	)
+}
+
+// handleKafkaStats returns the Kafka reader's statistics as JSON. Counters are
+// totals since startup, so it is safe to poll.
+func handleKafkaStats(consumer *kafka.Consumer, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set content type and write response
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"reader": consumer.Stats()}); err != nil {
+			logger.Error("Failed to encode response", zap.Error(err))
+		}
+	}
+}
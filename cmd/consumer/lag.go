@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"kafka-pipeline/internal/kafka"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var consumerLag = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "consumer_lag",
+		Help: "Messages between the group's committed offset and the partition high-watermark, refreshed every CONSUMER_LAG_INTERVAL",
+	},
+	[]string{"partition"},
+)
+
+func init() {
+	prometheus.MustRegister(consumerLag)
+}
+
+// monitorLag refreshes the consumer_lag gauge for every partition each interval
+// until ctx is cancelled
+func monitorLag(ctx context.Context, consumer *kafka.Consumer, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		lagCtx, cancel := context.WithTimeout(ctx, interval)
+		lag, err := consumer.PartitionLag(lagCtx)
+		cancel()
+		if err != nil {
+			logger.Warn("Failed to refresh consumer lag", zap.Error(err))
+		} else {
+			for partition, messages := range lag {
+				consumerLag.WithLabelValues(strconv.Itoa(partition)).Set(float64(messages))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -2,23 +2,37 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"kafka-pipeline/internal/callback"
 	"kafka-pipeline/internal/dlq"
+	"kafka-pipeline/internal/health"
 	"kafka-pipeline/internal/kafka"
 	"kafka-pipeline/internal/store"
+	"kafka-pipeline/internal/tracing"
+	"kafka-pipeline/internal/validation"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	kafkaGo "github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+var tracer = otel.Tracer("kafka-pipeline/cmd/consumer")
+
 var (
 	messagesProcessedTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -28,6 +42,14 @@ var (
 		[]string{"type"},
 	)
 
+	eventsDeduplicatedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "events_deduplicated_total",
+			Help: "Total number of redelivered events skipped because their eventId was already applied",
+		},
+		[]string{"type"},
+	)
+
 	dlqCountTotal = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "dlq_count_total",
@@ -35,20 +57,111 @@ var (
 		},
 	)
 
+	redisUp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "redis_up",
+			Help: "Whether the last Redis health check succeeded (1) or failed (0)",
+		},
+	)
+
+	sqlKeepaliveFailuresTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sql_keepalive_failures_total",
+			Help: "Total number of failed SQL keepalive probes",
+		},
+	)
+
+	deadlockRetriesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "deadlock_retries_total",
+			Help: "Total number of SQL writes retried after being chosen as a deadlock victim",
+		},
+	)
+
+	sqlTransientRetriesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sql_transient_retries_total",
+			Help: "Total number of SQL writes retried after a transient connection or failover error",
+		},
+	)
+
+	commitRetriesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "commit_retries_total",
+			Help: "Total number of Kafka offset commits retried after a failure",
+		},
+	)
+
+	commitFailuresTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "commit_failures_total",
+			Help: "Total number of Kafka offset commits that failed after exhausting retries",
+		},
+	)
+
+	dlqDroppedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "dlq_dropped_total",
+			Help: "Total number of DLQ messages discarded because a list reached DLQ_MAX_LEN",
+		},
+	)
+
+	dlqMessagesByRetryCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dlq_messages_by_retrycount",
+			Help: "DLQ messages by how many times they were retried before failing; reset from the DLQ contents by DLQ_DRAIN_ON_START",
+		},
+		[]string{"retry_count"},
+	)
+
+	messageBytes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "message_bytes",
+			Help:    "Size of consumed message values in bytes",
+			Buckets: prometheus.ExponentialBuckets(256, 4, 8), // 256B to 4MB
+		},
+		[]string{"type"},
+	)
+
 	dbLatencySeconds = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "db_latency_seconds",
-			Help:    "Database operation latency in seconds",
-			Buckets: prometheus.DefBuckets,
+			Help:    "Database operation latency in seconds, by operation and event type",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
 		},
-		[]string{"operation"},
+		[]string{"operation", "event_type"},
 	)
 )
 
+// includeDLQHeaders controls whether Kafka message headers are stored with DLQ entries
+var includeDLQHeaders = true
+
+// messageSizeWarnBytes logs a warning for messages larger than this; 0 disables the warning
+var messageSizeWarnBytes = 0
+
+// processingTimeout bounds how long one event's database writes may take; 0 disables it
+var processingTimeout = 30 * time.Second
+
+// tenants routes events carrying a tenantId to per-tenant stores; nil when single-tenant
+var tenants *tenantRouter
+
+// validationProfile controls whether timestamp and email format problems send an event to the DLQ
+var validationProfile = validation.Lenient
+
 func init() {
 	prometheus.MustRegister(messagesProcessedTotal)
+	prometheus.MustRegister(eventsDeduplicatedTotal)
 	prometheus.MustRegister(dlqCountTotal)
 	prometheus.MustRegister(dbLatencySeconds)
+	prometheus.MustRegister(redisUp)
+	prometheus.MustRegister(sqlKeepaliveFailuresTotal)
+	prometheus.MustRegister(deadlockRetriesTotal)
+	prometheus.MustRegister(sqlTransientRetriesTotal)
+	prometheus.MustRegister(commitRetriesTotal)
+	prometheus.MustRegister(commitFailuresTotal)
+	prometheus.MustRegister(dlqDroppedTotal)
+	prometheus.MustRegister(dlqMessagesByRetryCount)
+	prometheus.MustRegister(messageBytes)
 }
 
 func main() {
@@ -59,19 +172,167 @@ func main() {
 	}
 	defer logger.Sync()
 
+	// Tag every log line with the processing pod so records can be attributed
+	processingHost := getEnv("POD_NAME", "")
+	if processingHost == "" {
+		processingHost, _ = os.Hostname()
+	}
+	logger = logger.With(zap.String("processedBy", processingHost))
+
 	// Get configuration from environment
 	kafkaBrokers := getEnv("KAFKA_BROKERS", "localhost:9092")
 	kafkaTopic := getEnv("KAFKA_TOPIC", "events")
 	kafkaGroupID := getEnv("KAFKA_GROUP_ID", "consumer-group")
+	kafkaSecurity, err := kafka.NewSecurity(kafka.SecurityConfig{
+		TLS:           getEnv("KAFKA_TLS_ENABLED", "false") == "true",
+		CACertPath:    getEnv("KAFKA_TLS_CA_CERT", ""),
+		SASLMechanism: getEnv("KAFKA_SASL_MECHANISM", ""),
+		Username:      getEnv("KAFKA_SASL_USERNAME", ""),
+		Password:      getEnv("KAFKA_SASL_PASSWORD", ""),
+	})
+	if err != nil {
+		logger.Fatal("Invalid Kafka security settings", zap.Error(err))
+	}
 	mssqlConn := getEnv("MSSQL_CONN", "server=localhost;user id=sa;password=Your_strong_pwd1;database=events;encrypt=disable")
 	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
 	redisPassword := getEnv("REDIS_PASSWORD", "")
+	redisConfig := redisConfigFromEnv(redisAddr, redisPassword, logger)
 	servicePort := getEnv("SERVICE_PORT", "8081")
+	includeDLQHeaders = getEnv("DLQ_INCLUDE_HEADERS", "true") == "true"
+	messageSizeWarnBytes, err = strconv.Atoi(getEnv("MESSAGE_SIZE_WARN_BYTES", "1048576"))
+	if err != nil || messageSizeWarnBytes < 0 {
+		logger.Fatal("MESSAGE_SIZE_WARN_BYTES must be a non-negative integer")
+	}
+	validationProfile, err = validation.ParseProfile(getEnv("VALIDATION_PROFILE", string(validation.Lenient)))
+	if err != nil {
+		logger.Fatal("Invalid VALIDATION_PROFILE", zap.Error(err))
+	}
+	coalesceInterval := getEnvDuration("INVENTORY_COALESCE_INTERVAL", 0, logger)
+	writeBatchSize, err := strconv.Atoi(getEnv("WRITE_BATCH_SIZE", "0"))
+	if err != nil || writeBatchSize < 0 || writeBatchSize > store.MaxUserBatchSize {
+		logger.Fatal("Invalid WRITE_BATCH_SIZE", zap.String("value", getEnv("WRITE_BATCH_SIZE", "0")), zap.Int("max", store.MaxUserBatchSize))
+	}
+	writeBatchInterval := getEnvDuration("WRITE_BATCH_INTERVAL", 500*time.Millisecond, logger)
+	if writeBatchSize > 0 && writeBatchInterval <= 0 {
+		logger.Fatal("WRITE_BATCH_INTERVAL must be positive when WRITE_BATCH_SIZE is set")
+	}
+	prioritizeNewest := getEnv("PRIORITIZE_NEWEST", "false") == "true"
+	prioritizeNewestLag, err := strconv.ParseInt(getEnv("PRIORITIZE_NEWEST_LAG", "10000"), 10, 64)
+	if err != nil {
+		logger.Fatal("Invalid PRIORITIZE_NEWEST_LAG", zap.Error(err))
+	}
+	dryRun = getEnv("DRY_RUN", "false") == "true"
+	processingTimeout = getEnvDuration("PROCESSING_TIMEOUT", processingTimeout, logger)
+	if processingTimeout < 0 {
+		logger.Fatal("PROCESSING_TIMEOUT must not be negative")
+	}
+	startOffset, err := kafka.ParseStartOffset(getEnv("KAFKA_START_OFFSET", "latest"))
+	if err != nil {
+		logger.Fatal("Invalid KAFKA_START_OFFSET", zap.Error(err))
+	}
+	commitMode, err := kafka.ParseCommitMode(getEnv("KAFKA_COMMIT_MODE", string(kafka.CommitManual)))
+	if err != nil {
+		logger.Fatal("Invalid KAFKA_COMMIT_MODE", zap.Error(err))
+	}
+	consumerConfig := kafka.ConsumerConfig{
+		SessionTimeout:    getEnvDuration("KAFKA_SESSION_TIMEOUT", 30*time.Second, logger),
+		HeartbeatInterval: getEnvDuration("KAFKA_HEARTBEAT_INTERVAL", 3*time.Second, logger),
+		RebalanceTimeout:  getEnvDuration("KAFKA_REBALANCE_TIMEOUT", 30*time.Second, logger),
+		MaxWait:           getEnvDuration("KAFKA_MAX_WAIT", 10*time.Second, logger),
+		Security:          kafkaSecurity,
+		ReadOnly:          dryRun,
+		CommitMode:        commitMode,
+		CommitInterval:    getEnvDuration("KAFKA_COMMIT_INTERVAL", time.Second, logger),
+		StartOffset:       startOffset,
+	}
+	sqlConnMaxIdleTime := getEnvDuration("MSSQL_CONN_MAX_IDLE_TIME", 0, logger)
+	sqlKeepaliveInterval := getEnvDuration("MSSQL_KEEPALIVE_INTERVAL", 0, logger)
+	sqlPoolConfig := sqlPoolConfigFromEnv(logger)
+	deadlockRetries, err := strconv.Atoi(getEnv("SQL_DEADLOCK_RETRIES", "3"))
+	if err != nil || deadlockRetries < 0 {
+		logger.Fatal("Invalid SQL_DEADLOCK_RETRIES", zap.String("value", getEnv("SQL_DEADLOCK_RETRIES", "3")))
+	}
+	deadlockBackoff := getEnvDuration("SQL_DEADLOCK_BACKOFF", 50*time.Millisecond, logger)
+	transientRetries, err := strconv.Atoi(getEnv("SQL_TRANSIENT_RETRIES", "5"))
+	if err != nil || transientRetries < 0 {
+		logger.Fatal("Invalid SQL_TRANSIENT_RETRIES", zap.String("value", getEnv("SQL_TRANSIENT_RETRIES", "5")))
+	}
+	transientBackoff := getEnvDuration("SQL_TRANSIENT_BACKOFF", 200*time.Millisecond, logger)
+	dlqBackend := getEnv("DLQ_BACKEND", "redis")
+	redisHealthInterval := getEnvDuration("REDIS_HEALTH_INTERVAL", 10*time.Second, logger)
+	dlqDrainOnStart := getEnv("DLQ_DRAIN_ON_START", "false") == "true"
+	dlqStreamChunkSize, err := strconv.ParseInt(getEnv("DLQ_STREAM_CHUNK_SIZE", strconv.Itoa(dlq.DefaultStreamChunkSize)), 10, 64)
+	if err != nil || dlqStreamChunkSize <= 0 {
+		logger.Fatal("DLQ_STREAM_CHUNK_SIZE must be a positive integer")
+	}
+	dlqStatsInterval := getEnvDuration("DLQ_STATS_INTERVAL", 30*time.Second, logger)
+	lagInterval := getEnvDuration("CONSUMER_LAG_INTERVAL", 15*time.Second, logger)
+	dlqMaxLen, err := strconv.ParseInt(getEnv("DLQ_MAX_LEN", "0"), 10, 64)
+	if err != nil || dlqMaxLen < 0 {
+		logger.Fatal("DLQ_MAX_LEN must be a non-negative integer")
+	}
+	dlqMode, err := dlq.ParseMode(getEnv("DLQ_MODE", string(dlq.ModeList)))
+	if err != nil {
+		logger.Fatal("Invalid DLQ_MODE", zap.Error(err))
+	}
+	heartbeatInterval := getEnvDuration("CONSUMER_HEARTBEAT_INTERVAL", 0, logger)
+	callbacksEnabled := getEnv("CALLBACKS_ENABLED", "false") == "true"
+	callbackMaxAttempts, err := strconv.Atoi(getEnv("CALLBACK_MAX_ATTEMPTS", "3"))
+	if err != nil || callbackMaxAttempts <= 0 {
+		logger.Fatal("CALLBACK_MAX_ATTEMPTS must be a positive integer")
+	}
+	callbackBackoff := getEnvDuration("CALLBACK_BACKOFF", time.Second, logger)
+	dlqRouting, err := dlq.ParseRouting(getEnv("DLQ_ROUTING", string(dlq.RouteTopic)))
+	if err != nil {
+		logger.Fatal("Invalid DLQ_ROUTING", zap.Error(err))
+	}
+	if dlqDrainOnStart && dlqMode == dlq.ModeCompact {
+		logger.Fatal("DLQ_DRAIN_ON_START reads the per-topic DLQ list, which DLQ_MODE=compact doesn't keep; use list mode")
+	}
+	if dlqDrainOnStart && dlqRouting == dlq.RouteType {
+		logger.Fatal("DLQ_DRAIN_ON_START reads the per-topic DLQ list, which DLQ_ROUTING=type doesn't keep; use topic or both routing")
+	}
+	commitRetries, err := strconv.Atoi(getEnv("COMMIT_RETRIES", "3"))
+	if err != nil || commitRetries < 0 {
+		logger.Fatal("Invalid COMMIT_RETRIES", zap.String("value", getEnv("COMMIT_RETRIES", "3")))
+	}
+	commitBackoff := getEnvDuration("COMMIT_BACKOFF", 100*time.Millisecond, logger)
+	workerCount, err := strconv.Atoi(getEnv("WORKER_COUNT", "1"))
+	if err != nil || workerCount <= 0 {
+		logger.Fatal("WORKER_COUNT must be a positive integer")
+	}
+	shutdownTimeout := getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second, logger)
+	readinessTimeout := getEnvDuration("READINESS_TIMEOUT", 2*time.Second, logger)
+
+	// Cancelled on SIGINT/SIGTERM to begin a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Init(ctx, "consumer")
+	if err != nil {
+		logger.Fatal("Failed to initialize tracing", zap.Error(err))
+	}
 
 	// Initialize Kafka consumer
 	brokers := strings.Split(kafkaBrokers, ",")
-	consumer := kafka.NewConsumer(brokers, kafkaTopic, kafkaGroupID, logger)
-	defer consumer.Close()
+	if !startOffset.At.IsZero() && !dryRun {
+		// Must happen before the group's reader joins
+		seeded, err := kafka.SeedOffsetsAt(ctx, brokers, kafkaTopic, kafkaGroupID, kafkaSecurity, startOffset.At)
+		if err != nil {
+			logger.Error("Failed to seed start offsets, uncommitted partitions will start from the newest message",
+				zap.Time("at", startOffset.At),
+				zap.Error(err),
+			)
+		} else if len(seeded) > 0 {
+			logger.Info("Seeded start offsets", zap.Time("at", startOffset.At), zap.Any("offsets", seeded))
+		}
+	}
+	consumer := kafka.NewConsumer(brokers, kafkaTopic, kafkaGroupID, consumerConfig, logger)
+	consumer.SetCommitRetry(commitRetries, commitBackoff, func() {
+		commitRetriesTotal.Inc()
+	}, func(error) {
+		commitFailuresTotal.Inc()
+	})
 
 	// Initialize MS SQL store
 	sqlStore, err := store.NewMSSQLStore(mssqlConn, logger)
@@ -80,29 +341,166 @@ func main() {
 	}
 	defer sqlStore.Close()
 
-	// Initialize Redis DLQ
-	dlq, err := dlq.NewRedisDLQ(redisAddr, redisPassword, logger)
+	sqlStore.SetDeadlockRetry(deadlockRetries, deadlockBackoff, func() {
+		deadlockRetriesTotal.Inc()
+	})
+	sqlStore.SetTransientRetry(transientRetries, transientBackoff, func() {
+		sqlTransientRetriesTotal.Inc()
+	})
+	sqlStore.SetPoolConfig(sqlPoolConfig)
+	if sqlConnMaxIdleTime > 0 {
+		sqlStore.SetConnMaxIdleTime(sqlConnMaxIdleTime)
+	}
+	if sqlKeepaliveInterval > 0 {
+		go sqlStore.Keepalive(ctx, sqlKeepaliveInterval, func(error) {
+			sqlKeepaliveFailuresTotal.Inc()
+		})
+	}
+
+	processedByColumn := getEnv("PROCESSED_BY_COLUMN", "false") == "true"
+	if processedByColumn {
+		sqlStore.EnableProcessedBy(processingHost)
+	}
+
+	forcePaymentTransitions := getEnv("PAYMENT_FORCE_TRANSITIONS", "false") == "true"
+	if forcePaymentTransitions {
+		sqlStore.SetForcePaymentTransitions(true)
+		logger.Warn("Payment status transition checks disabled")
+	}
+
+	// Optionally route tenant events to per-tenant stores
+	if tenantStores := getEnv("TENANT_STORES", ""); tenantStores != "" {
+		tenants, err = newTenantRouter(tenantStores, func(s *store.MSSQLStore) {
+			s.SetDeadlockRetry(deadlockRetries, deadlockBackoff, func() {
+				deadlockRetriesTotal.Inc()
+			})
+			s.SetTransientRetry(transientRetries, transientBackoff, func() {
+				sqlTransientRetriesTotal.Inc()
+			})
+			s.SetPoolConfig(sqlPoolConfig)
+			if sqlConnMaxIdleTime > 0 {
+				s.SetConnMaxIdleTime(sqlConnMaxIdleTime)
+			}
+			if processedByColumn {
+				s.EnableProcessedBy(processingHost)
+			}
+			s.SetForcePaymentTransitions(forcePaymentTransitions)
+		}, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize tenant stores", zap.Error(err))
+		}
+		defer tenants.Close()
+		logger.Info("Tenant routing enabled", zap.Int("tenants", len(tenants.stores)))
+	}
+
+	// Initialize DLQ
+	dlq, redisDLQ, err := newDLQ(dlqBackend, redisConfig, dlqMode, dlqRouting, brokers, kafkaSecurity, logger)
 	if err != nil {
-		logger.Fatal("Failed to initialize Redis DLQ", zap.Error(err))
+		logger.Fatal("Failed to initialize DLQ", zap.Error(err))
 	}
 	defer dlq.Close()
 
-	// Start metrics server
-	go func() {
-		mux := http.NewServeMux()
-		mux.Handle("/metrics", promhttp.Handler())
-		mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("OK"))
+	// Track DLQ depth for alerting
+	if redisDLQ != nil {
+		go monitorDLQDepth(ctx, redisDLQ, []string{kafkaTopic}, dlqStatsInterval, logger)
+	}
+
+	// Track consumer lag per partition
+	if lagInterval > 0 {
+		go monitorLag(ctx, consumer, lagInterval, logger)
+	}
+
+	// Bound the Redis DLQ lists
+	if redisDLQ != nil && dlqMaxLen > 0 {
+		redisDLQ.SetMaxLen(dlqMaxLen, func(dropped int64) {
+			dlqDroppedTotal.Add(float64(dropped))
 		})
+	}
 
-		server := &http.Server{
-			Addr:    ":" + servicePort,
-			Handler: mux,
+	// Monitor Redis connectivity
+	if redisDLQ != nil {
+		go redisDLQ.MonitorHealth(ctx, redisHealthInterval, func(up bool) {
+			if up {
+				redisUp.Set(1)
+			} else {
+				redisUp.Set(0)
+			}
+		})
+	}
+
+	if dryRun {
+		logger.Warn("Dry run enabled; no database writes, DLQ pushes or offset commits will be made")
+	}
+
+	// Record liveness in the optional consumer_heartbeats table
+	if heartbeatInterval > 0 && !dryRun {
+		hb := newHeartbeat(sqlStore, processingHost, heartbeatInterval, logger)
+		consumer.OnCommit(hb.commitHook())
+		go hb.Run(ctx)
+	}
+
+	// Notify completion callbacks once events are persisted
+	if callbacksEnabled {
+		registry, err := callback.NewRegistry(redisAddr, redisPassword, 0, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize callback registry", zap.Error(err))
+		}
+		defer registry.Close()
+		consumer.OnCommit(completionCallbacks(registry, callback.NewNotifier(callbackMaxAttempts, callbackBackoff, logger), logger))
+		logger.Info("Completion callbacks enabled")
+	}
+
+	// Optionally retry leftover DLQ entries before consuming
+	if dlqDrainOnStart {
+		if dryRun {
+			logger.Warn("DLQ_DRAIN_ON_START is ignored in dry-run mode")
+		} else if redisDLQ == nil {
+			logger.Warn("DLQ_DRAIN_ON_START requires the Redis DLQ backend, skipping drain")
+		} else {
+			drained, remaining, err := drainDLQ(ctx, redisDLQ, kafkaTopic, consumer, sqlStore, logger)
+			if err != nil {
+				logger.Error("DLQ drain on start failed", zap.Error(err))
+			}
+			logger.Info("DLQ drain on start finished",
+				zap.Int64("drained", drained),
+				zap.Int64("remaining", remaining),
+			)
 		}
+	}
 
+	// Start metrics server
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	registerReaderMetrics(consumer)
+	mux.HandleFunc("GET /debug/kafka", handleKafkaStats(consumer, logger))
+	readinessChecks := map[string]health.Check{
+		"sql": sqlStore.Ping,
+	}
+	if tenants != nil {
+		for tenant, tenantStore := range tenants.stores {
+			readinessChecks["sql:"+tenant] = tenantStore.Ping
+		}
+	}
+	if redisDLQ != nil {
+		readinessChecks["redis"] = redisDLQ.Ping
+	}
+	mux.HandleFunc("/health", health.Live)
+	mux.HandleFunc("/livez", health.Live)
+	mux.HandleFunc("/readyz", health.Ready(readinessChecks, readinessTimeout, logger))
+	if redisDLQ != nil {
+		mux.HandleFunc("GET /dlq/export", handleDLQExport(redisDLQ, kafkaTopic, dlqStreamChunkSize, logger))
+		mux.HandleFunc("GET /dlq/stats", handleDLQStats(redisDLQ, []string{kafkaTopic}, logger))
+		mux.HandleFunc("GET /dlq/message/{eventId}", handleDLQLookup(redisDLQ, kafkaTopic, logger))
+	}
+
+	server := &http.Server{
+		Addr:    ":" + servicePort,
+		Handler: mux,
+	}
+
+	go func() {
 		logger.Info("Starting metrics server", zap.String("port", servicePort))
-		if err := server.ListenAndServe(); err != nil {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			logger.Error("Metrics server error", zap.Error(err))
 		}
 	}()
@@ -113,36 +511,128 @@ func main() {
 		zap.String("groupID", kafkaGroupID),
 	)
 
-	ctx := context.Background()
+	// Optionally coalesce inventory adjustments per SKU
+	var coalescer *inventoryCoalescer
+	if coalesceInterval > 0 && !dryRun {
+		coalescer = newInventoryCoalescer(coalesceInterval, consumer, sqlStore, dlq, logger)
+		logger.Info("Inventory coalescing enabled", zap.Duration("interval", coalesceInterval))
+	}
+
+	// Optionally batch user, order and payment upserts; the batcher then also drives
+	// the coalescer's flushes so neither commits past the other's buffered messages
+	var batcher *writeBatcher
+	if writeBatchSize > 0 && !dryRun {
+		batcher = newWriteBatcher(writeBatchSize, writeBatchInterval, coalescer, consumer, sqlStore, dlq, logger)
+		go batcher.Run(ctx)
+		logger.Info("Write batching enabled", zap.Int("size", writeBatchSize), zap.Duration("interval", writeBatchInterval))
+	} else if coalescer != nil {
+		go coalescer.Run(ctx)
+	}
+
+	// Optionally process the newest messages first while lag is high
+	if prioritizeNewest {
+		nf := &newestFirst{
+			brokers:   brokers,
+			security:  kafkaSecurity,
+			topic:     kafkaTopic,
+			threshold: prioritizeNewestLag,
+			interval:  10 * time.Second,
+			consumer:  consumer,
+			sqlStore:  sqlStore,
+			dlq:       dlq,
+			logger:    logger,
+		}
+		go nf.Run(ctx)
+		logger.Warn("Newest-first processing enabled; ordering is not preserved while lag is high",
+			zap.Int64("lagThreshold", prioritizeNewestLag),
+		)
+	}
+
+	// Process message; a message already read is finished even if shutdown has begun,
+	// bounded by PROCESSING_TIMEOUT rather than by ctx
+	process := func(message *kafkaGo.Message) {
+		if err := processMessage(context.Background(), message, consumer, sqlStore, dlq, coalescer, batcher, logger); err != nil {
+			logger.Error("Failed to process message", zap.Error(err))
+		}
+	}
+
+	// Optionally process partitions in parallel
+	var workers *workerPool
+	if workerCount > 1 {
+		workers = newWorkerPool(workerCount, process)
+		logger.Info("Worker pool enabled", zap.Int("workers", workerCount))
+	}
+
 	for {
 		message, err := consumer.ReadMessage(ctx)
 		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
 			logger.Error("Failed to read message", zap.Error(err))
 			continue
 		}
 
-		// Process message
-		if err := processMessage(ctx, message, consumer, sqlStore, dlq, logger); err != nil {
-			logger.Error("Failed to process message", zap.Error(err))
+		if workers != nil {
+			workers.Submit(message)
+		} else {
+			process(message)
 		}
 	}
+
+	logger.Info("Shutting down consumer", zap.Duration("timeout", shutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if workers != nil {
+		workers.Close()
+	}
+	flushBuffers(shutdownCtx, coalescer, batcher)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to shut down metrics server", zap.Error(err))
+	}
+
+	// Closing the reader flushes any offsets not yet committed to the broker
+	if err := consumer.Close(); err != nil {
+		logger.Error("Failed to close Kafka consumer", zap.Error(err))
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("Failed to flush traces", zap.Error(err))
+	}
+	logger.Info("Consumer stopped")
 }
 
-func processMessage(ctx context.Context, message *kafkaGo.Message, consumer *kafka.Consumer, sqlStore *store.MSSQLStore, dlq *dlq.RedisDLQ, logger *zap.Logger) error {
-	// Parse event
+func processMessage(ctx context.Context, message *kafkaGo.Message, consumer *kafka.Consumer, sqlStore *store.MSSQLStore, dlq dlq.DLQ, coalescer *inventoryCoalescer, batcher *writeBatcher, logger *zap.Logger) error {
+	// Parse event and apply the validation profile
 	event, err := consumer.ParseEvent(message)
+	observeMessageSize(message, event, logger)
+	if err == nil {
+		err = checkFormats(event, logger)
+	}
+	var typed *store.Event
+	if err == nil {
+		typed, err = store.DecodeEvent(message.Value)
+	}
+	if dryRun {
+		dryRunMessage(message, event, typed, err, consumer, sqlStore)
+		return nil
+	}
 	if err != nil {
-		// Push to DLQ and commit offset
-		dlqErr := dlq.PushMessage(ctx, message.Topic, message.Partition, message.Offset, string(message.Value), err.Error())
-		if dlqErr != nil {
-			logger.Error("Failed to push to DLQ", zap.Error(dlqErr))
-		} else {
-			dlqCountTotal.Inc()
-		}
+		eventType, _ := event["type"].(string)
+		countProcessingError(eventType, reasonParse)
+
+		// Push to DLQ, and commit offset only once the message is parked there
+		parked := pushToDLQ(ctx, dlq, message, string(message.Value), err, logger)
 
 		consumer.LogMessage("error", "Failed to parse event", message, nil, zap.Error(err))
 
-		// Commit offset even after DLQ push
+		// Flush buffered writes so this commit can't skip past them
+		flushBuffers(ctx, coalescer, batcher)
+
+		if !parked {
+			consumer.Hold(message)
+			return err
+		}
 		if commitErr := consumer.CommitMessage(ctx, message); commitErr != nil {
 			logger.Error("Failed to commit offset after parse error", zap.Error(commitErr))
 		}
@@ -150,30 +640,58 @@ func processMessage(ctx context.Context, message *kafkaGo.Message, consumer *kaf
 	}
 
 	// Process based on event type
-	eventType := event["type"].(string)
+	eventType := typed.Type
+
+	// Resolve the tenant's store; unknown tenants go to the DLQ
+	target, err := tenants.storeFor(message, event, sqlStore)
+
+	if coalescer != nil || batcher != nil {
+		// Writes to the default store may be buffered and committed by a later flush
+		if err == nil && target == sqlStore {
+			if coalescer != nil && eventType == "InventoryAdjusted" {
+				if err := coalescer.Add(message, event, typed); err == nil {
+					return nil
+				}
+			}
+			if batcher != nil && batcher.Add(ctx, message, event, typed) {
+				return nil
+			}
+		}
+
+		// Flush first so this message's commit can't move the offset past buffered ones
+		flushBuffers(ctx, coalescer, batcher)
+	}
 
 	start := time.Now()
-	err = processEventByType(ctx, event, sqlStore, logger)
+	if err == nil {
+		err = traceEvent(ctx, message, typed, func(ctx context.Context) error {
+			return processEventByType(ctx, typed, target, logger)
+		})
+	}
 	duration := time.Since(start)
 
 	// Record DB latency
-	dbLatencySeconds.WithLabelValues("upsert").Observe(duration.Seconds())
+	dbLatencySeconds.WithLabelValues("upsert", eventType).Observe(duration.Seconds())
 
 	if err != nil {
-		// Push to DLQ and commit offset
-		dlqErr := dlq.PushMessage(ctx, message.Topic, message.Partition, message.Offset, event, err.Error())
-		if dlqErr != nil {
-			logger.Error("Failed to push to DLQ", zap.Error(dlqErr))
-		} else {
-			dlqCountTotal.Inc()
+		reason := reasonRouting
+		if target != nil {
+			reason = processingErrorReason(err)
 		}
+		countProcessingError(eventType, reason)
+
+		// Push to DLQ, and commit offset only once the message is parked there
+		parked := pushToDLQ(ctx, dlq, message, event, err, logger)
 
 		consumer.LogMessage("error", "Failed to process event", message, event,
 			zap.Error(err),
 			zap.Duration("latency_ms", duration),
 		)
 
-		// Commit offset even after DLQ push
+		if !parked {
+			consumer.Hold(message)
+			return err
+		}
 		if commitErr := consumer.CommitMessage(ctx, message); commitErr != nil {
 			logger.Error("Failed to commit offset after processing error", zap.Error(commitErr))
 		}
@@ -187,7 +705,7 @@ func processMessage(ctx context.Context, message *kafkaGo.Message, consumer *kaf
 		zap.Duration("latency_ms", duration),
 	)
 
-	if err := consumer.CommitMessage(ctx, message); err != nil {
+	if err := consumer.CommitEvent(ctx, message, event); err != nil {
 		logger.Error("Failed to commit offset", zap.Error(err))
 		return err
 	}
@@ -195,104 +713,320 @@ func processMessage(ctx context.Context, message *kafkaGo.Message, consumer *kaf
 	return nil
 }
 
-func processEventByType(ctx context.Context, event map[string]interface{}, sqlStore *store.MSSQLStore, logger *zap.Logger) error {
-	eventType := event["type"].(string)
-	data := event["data"].(map[string]interface{})
+// pushToDLQ parks a failed message in the DLQ and reports whether it was stored.
+// A message that wasn't stored must not have its offset committed, or it is lost.
+func pushToDLQ(ctx context.Context, dlq dlq.DLQ, message *kafkaGo.Message, payload interface{}, cause error, logger *zap.Logger) bool {
+	retryCount := messageRetryCount(message)
+	if err := dlq.PushMessage(ctx, message.Topic, message.Partition, message.Offset, dlqHeaders(message), payload, cause.Error(), retryCount); err != nil {
+		logger.Error("Failed to push to DLQ, leaving offset uncommitted", zap.Error(err))
+		return false
+	}
+
+	dlqCountTotal.Inc()
+	if errors.Is(cause, errProcessingTimeout) {
+		dlqTimeoutTotal.Inc()
+	}
+	dlqMessagesByRetryCount.WithLabelValues(retryCountLabel(retryCount)).Inc()
+	return true
+}
 
-	switch eventType {
+// newUser builds the row a UserCreated event upserts
+func newUser(data store.UserCreatedData) *store.User {
+	return &store.User{
+		UserID:    data.UserID,
+		Name:      data.Name,
+		Email:     data.Email,
+		CreatedAt: orNow(data.CreatedAt.Time),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// newOrder builds the row an OrderPlaced event upserts
+func newOrder(data store.OrderPlacedData) *store.Order {
+	return &store.Order{
+		OrderID:   data.OrderID,
+		UserID:    data.UserID,
+		Total:     data.Total,
+		Status:    "placed",
+		CreatedAt: orNow(data.CreatedAt.Time),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// newPayment builds the row a PaymentSettled event upserts
+func newPayment(data store.PaymentSettledData) *store.Payment {
+	return &store.Payment{
+		OrderID:   data.OrderID,
+		Status:    data.Status,
+		Amount:    data.Amount,
+		SettledAt: orNow(data.SettledAt.Time),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// processEventByType applies an event to sqlStore within processingTimeout
+func processEventByType(ctx context.Context, event *store.Event, sqlStore *store.MSSQLStore, logger *zap.Logger) error {
+	return withProcessingTimeout(ctx, func(ctx context.Context) error {
+		return applyEvent(ctx, event, sqlStore, logger)
+	})
+}
+
+// withProcessingTimeout runs write with a context cancelled once processingTimeout
+// has passed, so a hung query fails the write, with an error wrapping
+// errProcessingTimeout, instead of stalling the consumer. Single events, batches
+// and coalesced adjustments are each given the full timeout.
+func withProcessingTimeout(ctx context.Context, write func(ctx context.Context) error) error {
+	if processingTimeout <= 0 {
+		return write(ctx)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, processingTimeout)
+	defer cancel()
+
+	err := write(timeoutCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s: %v", errProcessingTimeout, processingTimeout, err)
+	}
+	return err
+}
+
+// applyEvent writes an event to sqlStore according to its type
+func applyEvent(ctx context.Context, event *store.Event, sqlStore *store.MSSQLStore, logger *zap.Logger) error {
+	switch event.Type {
 	case "UserCreated":
-		user := &store.User{
-			UserID:    data["userId"].(string),
-			Name:      data["name"].(string),
-			Email:     data["email"].(string),
-			CreatedAt: parseTime(data["createdAt"]),
-			UpdatedAt: time.Now(),
+		var data store.UserCreatedData
+		if err := event.DecodeData(&data); err != nil {
+			return err
+		}
+		return sqlStore.UpsertUser(ctx, newUser(data))
+
+	case "UserUpdated":
+		var data store.UserUpdatedData
+		if err := event.DecodeData(&data); err != nil {
+			return err
+		}
+		// Only fields present in the payload are updated
+		fields := make(map[string]interface{})
+		if data.Name != nil {
+			fields["name"] = *data.Name
 		}
-		return sqlStore.UpsertUser(ctx, user)
+		if data.Email != nil {
+			fields["email"] = *data.Email
+		}
+		return sqlStore.PatchUser(ctx, data.UserID, fields)
 
 	case "OrderPlaced":
-		order := &store.Order{
-			OrderID:   data["orderId"].(string),
-			UserID:    data["userId"].(string),
-			Total:     parseFloat64(data["total"]),
-			Status:    "placed",
-			CreatedAt: parseTime(data["createdAt"]),
-			UpdatedAt: time.Now(),
+		var data store.OrderPlacedData
+		if err := event.DecodeData(&data); err != nil {
+			return err
+		}
+		return sqlStore.UpsertOrder(ctx, newOrder(data))
+
+	case "OrderStatusChanged":
+		var data store.OrderStatusChangedData
+		if err := event.DecodeData(&data); err != nil {
+			return err
 		}
-		return sqlStore.UpsertOrder(ctx, order)
+		return sqlStore.UpdateOrderStatus(ctx, data.OrderID, data.Status)
 
 	case "PaymentSettled":
-		payment := &store.Payment{
-			OrderID:   data["orderId"].(string),
-			Status:    data["status"].(string),
-			Amount:    parseFloat64(data["amount"]),
-			SettledAt: parseTime(data["settledAt"]),
-			UpdatedAt: time.Now(),
+		var data store.PaymentSettledData
+		if err := event.DecodeData(&data); err != nil {
+			return err
 		}
-		return sqlStore.UpsertPayment(ctx, payment)
+		return sqlStore.UpsertPayment(ctx, newPayment(data))
 
 	case "InventoryAdjusted":
-		inventory := &store.Inventory{
-			SKU:            data["sku"].(string),
-			Quantity:       parseInt(data["delta"]),
-			LastAdjustedAt: parseTime(data["adjustedAt"]),
+		var data store.InventoryAdjustedData
+		if err := event.DecodeData(&data); err != nil {
+			return err
+		}
+		skipped, err := sqlStore.ApplyInventoryAdjustments(ctx, data.SKU, []store.InventoryAdjustment{{
+			EventID:    event.EventID,
+			Delta:      data.Delta,
+			Reason:     data.Reason,
+			AdjustedAt: orNow(data.AdjustedAt.Time),
+		}})
+		if skipped > 0 {
+			eventsDeduplicatedTotal.WithLabelValues("InventoryAdjusted").Inc()
+			logger.Info("Skipped already applied inventory adjustment", zap.String("eventId", event.EventID), zap.String("sku", data.SKU))
+		}
+		return err
+
+	case "InventoryReserved":
+		var data store.InventoryReservedData
+		if err := event.DecodeData(&data); err != nil {
+			return err
+		}
+		reserved, remaining, err := sqlStore.ReserveInventory(ctx, event.EventID, data.SKU, data.Quantity)
+		if errors.Is(err, store.ErrDuplicateEvent) {
+			eventsDeduplicatedTotal.WithLabelValues("InventoryReserved").Inc()
+			logger.Info("Skipped already applied inventory reservation", zap.String("eventId", event.EventID), zap.String("sku", data.SKU))
+			return nil
 		}
-		return sqlStore.UpsertInventory(ctx, inventory)
+		if err != nil {
+			return err
+		}
+		if !reserved {
+			return fmt.Errorf("%w for %s: requested %d, available %d", errInsufficientStock, data.SKU, data.Quantity, remaining)
+		}
+		logger.Info("Inventory reserved",
+			zap.String("sku", data.SKU),
+			zap.Int("quantity", data.Quantity),
+			zap.Int("remaining", remaining),
+		)
+		return nil
 
 	case "ProductReview":
+		var data store.ProductReviewData
+		if err := event.DecodeData(&data); err != nil {
+			return err
+		}
 		review := &store.ProductReview{
-			ReviewID:    data["reviewId"].(string),
-			ProductName: data["productName"].(string),
-			Username:    data["username"].(string),
-			Rating:      parseInt(data["rating"]),
-			Remarks:     data["remarks"].(string),
-			CreatedAt:   parseTime(data["createdAt"]),
+			ReviewID:    data.ReviewID,
+			ProductName: data.ProductName,
+			Username:    data.Username,
+			Rating:      data.Rating,
+			Remarks:     data.Remarks,
+			CreatedAt:   orNow(data.CreatedAt.Time),
 			UpdatedAt:   time.Now(),
 		}
 		return sqlStore.UpsertProductReview(ctx, review)
 
 	default:
-		return fmt.Errorf("unknown event type: %s", eventType)
+		return fmt.Errorf("%w: %s", errUnknownEventType, event.Type)
 	}
 }
 
-func parseTime(value interface{}) time.Time {
-	if str, ok := value.(string); ok {
-		if t, err := time.Parse(time.RFC3339, str); err == nil {
-			return t
-		}
+// traceEvent runs process in a consumer span that continues the producer's trace
+// from the message headers, so the store's spans are attributed to the event
+func traceEvent(ctx context.Context, message *kafkaGo.Message, event *store.Event, process func(ctx context.Context) error) error {
+	ctx, span := tracer.Start(kafka.ContextFromMessage(ctx, message), "process "+event.Type,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", message.Topic),
+			attribute.Int("messaging.kafka.partition", message.Partition),
+			attribute.Int64("messaging.kafka.offset", message.Offset),
+			attribute.String("event.id", event.EventID),
+		),
+	)
+	defer span.End()
+
+	err := process(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
-	return time.Now()
+	return err
 }
 
-func parseFloat64(value interface{}) float64 {
-	switch v := value.(type) {
-	case float64:
-		return v
-	case float32:
-		return float64(v)
-	case int:
-		return float64(v)
-	case int64:
-		return float64(v)
+// newDLQ creates the dead letter queue for the configured backend:
+// redis (default), object (S3-compatible storage), kafka (a dead-letter topic) or both.
+// The Redis queue is also returned, or nil when the backend doesn't use Redis.
+func newDLQ(backend string, redisConfig dlq.RedisConfig, mode dlq.Mode, routing dlq.Routing, brokers []string, security *kafka.Security, logger *zap.Logger) (dlq.DLQ, *dlq.RedisDLQ, error) {
+	newObjectStore := func() (*dlq.ObjectStoreDLQ, error) {
+		return dlq.NewObjectStoreDLQ(dlq.ObjectStoreConfig{
+			Endpoint:  getEnv("DLQ_S3_ENDPOINT", ""),
+			Bucket:    getEnv("DLQ_S3_BUCKET", ""),
+			Region:    getEnv("DLQ_S3_REGION", "us-east-1"),
+			AccessKey: getEnv("DLQ_S3_ACCESS_KEY", ""),
+			SecretKey: getEnv("DLQ_S3_SECRET_KEY", ""),
+		}, logger)
+	}
+
+	switch backend {
+	case "redis":
+		redisDLQ, err := dlq.NewRedisDLQ(redisConfig, mode, routing, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		return redisDLQ, redisDLQ, nil
+	case "object":
+		objectDLQ, err := newObjectStore()
+		if err != nil {
+			return nil, nil, err
+		}
+		return objectDLQ, nil, nil
+	case "kafka":
+		// An empty DLQ_KAFKA_TOPIC means "<topic>.dlq"
+		return dlq.NewKafkaDLQ(brokers, getEnv("DLQ_KAFKA_TOPIC", ""), security, logger), nil, nil
+	case "both":
+		redisDLQ, err := dlq.NewRedisDLQ(redisConfig, mode, routing, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		objectDLQ, err := newObjectStore()
+		if err != nil {
+			redisDLQ.Close()
+			return nil, nil, err
+		}
+		return dlq.NewCompositeDLQ(redisDLQ, objectDLQ), redisDLQ, nil
 	default:
-		return 0
+		return nil, nil, fmt.Errorf("unknown DLQ backend: %s", backend)
 	}
 }
 
-func parseInt(value interface{}) int {
-	switch v := value.(type) {
-	case int:
-		return v
-	case int64:
-		return int(v)
-	case float64:
-		return int(v)
-	case float32:
-		return int(v)
-	default:
-		return 0
+// dlqHeaders returns the message headers to store with a DLQ entry
+func dlqHeaders(message *kafkaGo.Message) map[string]string {
+	if !includeDLQHeaders {
+		return nil
+	}
+	return kafka.HeadersToMap(message.Headers)
+}
+
+// retryCountHeader carries how many times a message has already been retried from the DLQ
+const retryCountHeader = "retryCount"
+
+// messageRetryCount returns the retry count carried by a message, 0 for a first attempt
+func messageRetryCount(message *kafkaGo.Message) int {
+	for _, h := range message.Headers {
+		if h.Key == retryCountHeader {
+			if n, err := strconv.Atoi(string(h.Value)); err == nil && n > 0 {
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+// retryCountLabel buckets retry counts so the metric label stays low-cardinality
+func retryCountLabel(retryCount int) string {
+	if retryCount >= 5 {
+		return "5+"
+	}
+	return strconv.Itoa(retryCount)
+}
+
+// observeMessageSize records the message size per event type and warns about
+// oversized messages. event is nil for messages that couldn't be parsed.
+func observeMessageSize(message *kafkaGo.Message, event map[string]interface{}, logger *zap.Logger) {
+	eventType, _ := event["type"].(string)
+	if eventType == "" {
+		eventType = "unknown"
+	}
+
+	size := len(message.Value)
+	messageBytes.WithLabelValues(eventType).Observe(float64(size))
+
+	if messageSizeWarnBytes > 0 && size > messageSizeWarnBytes {
+		logger.Warn("Oversized message",
+			zap.Any("eventId", event["eventId"]),
+			zap.String("type", eventType),
+			zap.Int("bytes", size),
+			zap.Int("thresholdBytes", messageSizeWarnBytes),
+			zap.Int("partition", message.Partition),
+			zap.Int64("offset", message.Offset),
+		)
+	}
+}
+
+// orNow returns t, or the current time when the event omitted it
+func orNow(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now()
 	}
+	return t
 }
 
 func getEnv(key, defaultValue string) string {
@@ -301,3 +1035,85 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// checkFormats applies the validation profile's format checks. Under the lenient
+// profile problems are logged and the event is processed anyway.
+func checkFormats(event map[string]interface{}, logger *zap.Logger) error {
+	warnings, err := validationProfile.Check(event)
+	if err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+	for _, w := range warnings {
+		logger.Warn("Event failed format check", zap.Any("eventId", event["eventId"]), zap.Error(w))
+	}
+	return nil
+}
+
+// getEnvDuration reads a duration such as "30s" from the environment, exiting on an invalid value
+func getEnvDuration(key string, defaultValue time.Duration, logger *zap.Logger) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Fatal("Invalid duration", zap.String("key", key), zap.String("value", value), zap.Error(err))
+	}
+	return d
+}
+
+// redisConfigFromEnv reads the DLQ's Redis connection settings. REDIS_ADDRS, a
+// comma-separated list of seed nodes or Sentinels, defaults to REDIS_ADDR; pending
+// callbacks always use REDIS_ADDR on its own.
+func redisConfigFromEnv(addr, password string, logger *zap.Logger) dlq.RedisConfig {
+	mode, err := dlq.ParseRedisMode(getEnv("REDIS_MODE", "standalone"))
+	if err != nil {
+		logger.Fatal("Invalid REDIS_MODE", zap.Error(err))
+	}
+
+	config := dlq.RedisConfig{
+		Mode:             mode,
+		Addrs:            strings.Split(getEnv("REDIS_ADDRS", addr), ","),
+		Password:         password,
+		MasterName:       getEnv("REDIS_MASTER_NAME", ""),
+		SentinelPassword: getEnv("REDIS_SENTINEL_PASSWORD", ""),
+		DialTimeout:      getEnvDuration("REDIS_DIAL_TIMEOUT", 5*time.Second, logger),
+	}
+	for key, target := range map[string]*int{
+		"REDIS_POOL_SIZE":      &config.PoolSize,
+		"REDIS_MIN_IDLE_CONNS": &config.MinIdleConns,
+	} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			logger.Fatal(key+" must be a non-negative integer", zap.String("value", value))
+		}
+		*target = n
+	}
+	return config
+}
+
+// sqlPoolConfigFromEnv reads the SQL pool sizing, falling back to store.DefaultPoolConfig
+func sqlPoolConfigFromEnv(logger *zap.Logger) store.PoolConfig {
+	config := store.DefaultPoolConfig
+	for key, target := range map[string]*int{
+		"MSSQL_MAX_OPEN_CONNS": &config.MaxOpenConns,
+		"MSSQL_MAX_IDLE_CONNS": &config.MaxIdleConns,
+	} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			logger.Fatal(key+" must be a positive integer", zap.String("value", value))
+		}
+		*target = n
+	}
+	config.ConnMaxLifetime = getEnvDuration("MSSQL_CONN_MAX_LIFETIME", config.ConnMaxLifetime, logger)
+	return config
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"kafka-pipeline/internal/store"
+	"kafka-pipeline/internal/validation"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+func TestMalformedEventsGoToDLQ(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{"type not a string", `{"eventId":"evt-1","type":5,"data":{}}`, "failed to unmarshal event"},
+		{"data not an object", `{"eventId":"evt-1","type":"UserCreated","data":"ada"}`, "invalid UserCreated data"},
+		{"userId sent as a number", `{"eventId":"evt-1","type":"UserCreated","data":{"userId":42,"name":"Ada","email":"ada@example.com"}}`, "invalid UserCreated data"},
+		{"missing userId", `{"eventId":"evt-1","type":"UserCreated","data":{"name":"Ada","email":"ada@example.com"}}`, "userId is required"},
+		{"update without fields", `{"eventId":"evt-1","type":"UserUpdated","data":{"userId":"user-1"}}`, "name or email is required"},
+		{"total sent as a string", `{"eventId":"evt-1","type":"OrderPlaced","data":{"orderId":"order-1","userId":"user-1","total":"ten"}}`, "invalid OrderPlaced data"},
+		{"missing orderId", `{"eventId":"evt-1","type":"OrderStatusChanged","data":{"status":"shipped"}}`, "orderId is required"},
+		{"amount sent as a bool", `{"eventId":"evt-1","type":"PaymentSettled","data":{"orderId":"order-1","status":"settled","amount":true}}`, "invalid PaymentSettled data"},
+		{"delta sent as a string", `{"eventId":"evt-1","type":"InventoryAdjusted","data":{"sku":"sku-1","delta":"5"}}`, "invalid InventoryAdjusted data"},
+		{"quantity sent as an object", `{"eventId":"evt-1","type":"InventoryReserved","data":{"sku":"sku-1","quantity":{}}}`, "invalid InventoryReserved data"},
+		{"rating sent as a string", `{"eventId":"evt-1","type":"ProductReview","data":{"reviewId":"review-1","productName":"Widget","username":"ada","rating":"five"}}`, "invalid ProductReview data"},
+		{"createdAt not a timestamp", `{"eventId":"evt-1","type":"UserCreated","data":{"userId":"user-1","name":"Ada","email":"ada@example.com","createdAt":"yesterday"}}`, "not RFC3339"},
+		{"null data", `{"eventId":"evt-1","type":"OrderPlaced","data":null}`, "orderId is required"},
+		{"unknown type", `{"eventId":"evt-1","type":"OrderShipped","data":{}}`, "unknown event type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := &kafkaGo.Message{Topic: "events", Partition: 0, Offset: 7, Value: []byte(tt.value)}
+
+			// No store is given: a malformed event must be rejected before any write
+			var err error
+			var decoded bool
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Fatalf("processing panicked: %v", r)
+					}
+				}()
+				var typed *store.Event
+				typed, err = store.DecodeEvent(message.Value)
+				if decoded = err == nil; decoded {
+					err = processEventByType(context.Background(), typed, nil, zap.NewNop())
+				}
+			}()
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			// processMessage counts envelope failures as parse errors itself
+			if reason := processingErrorReason(err); decoded && (reason == reasonDB || reason == reasonTimeout) {
+				t.Errorf("processingErrorReason() = %q, want a parse or type failure", reason)
+			}
+
+			dlq := &fakeDLQ{}
+			if !pushToDLQ(context.Background(), dlq, message, string(message.Value), err, zap.NewNop()) {
+				t.Fatal("pushToDLQ() = false, want the message parked")
+			}
+			if len(dlq.pushed) != 1 || dlq.pushed[0] != "0/7: "+err.Error() {
+				t.Errorf("DLQ pushes = %q, want one for 0/7 with the error", dlq.pushed)
+			}
+		})
+	}
+}
+
+func TestPushToDLQReportsFailure(t *testing.T) {
+	message := &kafkaGo.Message{Topic: "events", Partition: 0, Offset: 7, Value: []byte(`{}`)}
+	dlq := &fakeDLQ{err: errors.New("redis down")}
+
+	// The caller holds the offset uncommitted when the push fails
+	if pushToDLQ(context.Background(), dlq, message, string(message.Value), errors.New("boom"), zap.NewNop()) {
+		t.Error("pushToDLQ() = true with a failing DLQ, want false")
+	}
+}
+
+func TestCheckFormatsProfile(t *testing.T) {
+	// The same payload the producer might have let through under a lenient profile
+	event := map[string]interface{}{
+		"eventId":   "evt-1",
+		"type":      "UserCreated",
+		"timestamp": "2024-01-02T03:04:05Z",
+		"data":      map[string]interface{}{"userId": "user-1", "name": "Ada", "email": "ada at example.com"},
+	}
+
+	tests := []struct {
+		profile validation.Profile
+		wantErr bool // a parse failure, so the message goes to the DLQ
+	}{
+		{validation.Strict, true},
+		{validation.Lenient, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.profile), func(t *testing.T) {
+			previous := validationProfile
+			validationProfile = tt.profile
+			t.Cleanup(func() { validationProfile = previous })
+
+			err := checkFormats(event, zap.NewNop())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkFormats() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"kafka-pipeline/internal/dlq"
+	"kafka-pipeline/internal/kafka"
+	"kafka-pipeline/internal/store"
+
+	"go.uber.org/zap"
+)
+
+// newestFirst processes the newest messages first while the group consumer is
+// far behind. When lag exceeds the threshold it starts one reader per partition
+// at the latest offset and processes forward from there, while the main loop
+// keeps backfilling older offsets.
+//
+// This sacrifices strict ordering: a backfilled message can be applied after a
+// newer one for the same key, and messages produced while the fast path runs are
+// processed twice (once here, once by the backfill). Upserts converge and
+// inventory adjustments and reservations are deduplicated by eventId, but
+// reservations may be refused or granted out of order. Only enable it for
+// projections that favour freshness over ordering, such as real-time alerting.
+type newestFirst struct {
+	brokers   []string
+	security  *kafka.Security
+	topic     string
+	threshold int64
+	interval  time.Duration
+
+	consumer *kafka.Consumer
+	sqlStore *store.MSSQLStore
+	dlq      dlq.DLQ
+	logger   *zap.Logger
+}
+
+// Run checks the group consumer's lag every interval until ctx is cancelled
+func (n *newestFirst) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if lag := n.consumer.Lag(); lag > n.threshold {
+				n.logger.Warn("Consumer lag above threshold, processing newest messages first",
+					zap.Int64("lag", lag),
+					zap.Int64("threshold", n.threshold),
+				)
+				n.processLatest(ctx)
+			}
+		}
+	}
+}
+
+// processLatest reads from the latest offsets until the backfill catches up
+func (n *newestFirst) processLatest(ctx context.Context) {
+	consumers, err := kafka.NewLatestConsumers(n.brokers, n.topic, n.security, n.logger)
+	if err != nil {
+		n.logger.Error("Failed to create latest-offset readers", zap.Error(err))
+		return
+	}
+
+	fastCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, c := range consumers {
+		wg.Add(1)
+		go func(c *kafka.Consumer) {
+			defer wg.Done()
+			defer c.Close()
+
+			for {
+				message, err := c.ReadMessage(fastCtx)
+				if err != nil {
+					if fastCtx.Err() != nil {
+						return
+					}
+					n.logger.Error("Failed to read latest message", zap.Error(err))
+					continue
+				}
+
+				if err := processMessage(fastCtx, message, c, n.sqlStore, n.dlq, nil, nil, n.logger); err != nil {
+					n.logger.Error("Failed to process latest message", zap.Error(err))
+				}
+			}
+		}(c)
+	}
+
+	// Stop the fast path once the backfill is back under the threshold
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			cancel()
+			wg.Wait()
+			return
+		case <-ticker.C:
+			if lag := n.consumer.Lag(); lag <= n.threshold {
+				n.logger.Info("Consumer lag back under threshold, resuming in-order processing", zap.Int64("lag", lag))
+				cancel()
+				wg.Wait()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+
+	"kafka-pipeline/internal/store"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var processingErrorsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "processing_errors_total",
+		Help: "Messages that failed processing, by event type and reason",
+	},
+	[]string{"type", "reason"},
+)
+
+var dlqTimeoutTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "dlq_timeout_total",
+		Help: "Total number of messages sent to DLQ because processing exceeded PROCESSING_TIMEOUT",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(processingErrorsTotal)
+	prometheus.MustRegister(dlqTimeoutTotal)
+}
+
+// Reasons a message fails processing, the reason label of processing_errors_total
+const (
+	// reasonParse is a message that isn't a valid event, or whose data is invalid
+	reasonParse = "parse"
+	// reasonUnknownType is an event of a type the consumer doesn't handle
+	reasonUnknownType = "unknown_type"
+	// reasonRouting is an event for a tenant without a store
+	reasonRouting = "routing"
+	// reasonRejected is a valid event the current state doesn't allow, such as an
+	// update of a missing row or an illegal payment status transition
+	reasonRejected = "rejected"
+	// reasonTimeout is an event whose writes didn't finish within PROCESSING_TIMEOUT
+	reasonTimeout = "timeout"
+	// reasonDB is a failed database write
+	reasonDB = "db"
+)
+
+var (
+	errUnknownEventType  = errors.New("unknown event type")
+	errInsufficientStock = errors.New("insufficient stock")
+	errProcessingTimeout = errors.New("processing timeout")
+)
+
+// processingErrorReason classifies an error returned by processEventByType
+func processingErrorReason(err error) string {
+	var dataErr *store.DataError
+	var transitionErr *store.PaymentTransitionError
+	switch {
+	case errors.As(err, &dataErr):
+		return reasonParse
+	case errors.Is(err, errUnknownEventType):
+		return reasonUnknownType
+	case errors.Is(err, errProcessingTimeout):
+		return reasonTimeout
+	case errors.Is(err, errInsufficientStock), errors.Is(err, store.ErrNotFound), errors.As(err, &transitionErr):
+		return reasonRejected
+	default:
+		return reasonDB
+	}
+}
+
+// countProcessingError records a failed message of eventType, "unknown" if its
+// type couldn't be read
+func countProcessingError(eventType, reason string) {
+	if eventType == "" {
+		eventType = "unknown"
+	}
+	processingErrorsTotal.WithLabelValues(eventType, reason).Inc()
+}
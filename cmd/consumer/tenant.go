@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"kafka-pipeline/internal/store"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// tenantHeader is the Kafka header checked for a tenant when the event has no tenantId
+const tenantHeader = "tenantId"
+
+// tenantRouter maps tenant IDs to the store holding that tenant's data. Events
+// without a tenant go to the default store; events for a tenant that isn't
+// registered are rejected so they land in the DLQ instead of the wrong database.
+type tenantRouter struct {
+	stores map[string]*store.MSSQLStore
+}
+
+// newTenantRouter opens a store per tenant from a JSON object of tenant ID to
+// MS SQL connection string. configure is applied to every store opened.
+func newTenantRouter(config string, configure func(*store.MSSQLStore), logger *zap.Logger) (*tenantRouter, error) {
+	var conns map[string]string
+	if err := json.Unmarshal([]byte(config), &conns); err != nil {
+		return nil, fmt.Errorf("TENANT_STORES must be a JSON object of tenantId to connection string: %w", err)
+	}
+
+	router := &tenantRouter{stores: make(map[string]*store.MSSQLStore, len(conns))}
+	for tenant, conn := range conns {
+		if tenant == "" {
+			router.Close()
+			return nil, fmt.Errorf("TENANT_STORES contains an empty tenant ID")
+		}
+
+		tenantStore, err := store.NewMSSQLStore(conn, logger.With(zap.String("tenantId", tenant)))
+		if err != nil {
+			router.Close()
+			return nil, fmt.Errorf("failed to open store for tenant %s: %w", tenant, err)
+		}
+		configure(tenantStore)
+		router.stores[tenant] = tenantStore
+	}
+
+	return router, nil
+}
+
+// Close closes every tenant store
+func (t *tenantRouter) Close() {
+	for _, s := range t.stores {
+		s.Close()
+	}
+}
+
+// storeFor returns the store an event should be written to. A nil router routes
+// everything to the default store.
+func (t *tenantRouter) storeFor(message *kafkaGo.Message, event map[string]interface{}, defaultStore *store.MSSQLStore) (*store.MSSQLStore, error) {
+	tenant, err := tenantID(message, event)
+	if err != nil {
+		return nil, err
+	}
+	if tenant == "" || t == nil {
+		return defaultStore, nil
+	}
+
+	tenantStore, ok := t.stores[tenant]
+	if !ok {
+		return nil, fmt.Errorf("unknown tenant: %s", tenant)
+	}
+	return tenantStore, nil
+}
+
+// tenantID reads the tenant from the event's tenantId field, falling back to the
+// tenantId message header. It returns "" for single-tenant events.
+func tenantID(message *kafkaGo.Message, event map[string]interface{}) (string, error) {
+	if raw, ok := event["tenantId"]; ok {
+		tenant, ok := raw.(string)
+		if !ok {
+			return "", fmt.Errorf("tenantId must be a string")
+		}
+		return tenant, nil
+	}
+
+	for _, h := range message.Headers {
+		if h.Key == tenantHeader {
+			return string(h.Value), nil
+		}
+	}
+	return "", nil
+}
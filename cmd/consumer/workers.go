@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+)
+
+// workerQueueSize is how many read-ahead messages each worker may have waiting
+const workerQueueSize = 16
+
+// workerPool processes messages on a fixed number of goroutines. Every partition
+// is pinned to one worker, so messages within a partition are still processed and
+// committed in offset order (no offset is committed ahead of an unfinished message)
+// while different partitions proceed in parallel.
+type workerPool struct {
+	queues []chan *kafkaGo.Message
+	wg     sync.WaitGroup
+}
+
+func newWorkerPool(workers int, process func(message *kafkaGo.Message)) *workerPool {
+	p := &workerPool{queues: make([]chan *kafkaGo.Message, workers)}
+	for i := range p.queues {
+		queue := make(chan *kafkaGo.Message, workerQueueSize)
+		p.queues[i] = queue
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for message := range queue {
+				process(message)
+			}
+		}()
+	}
+	return p
+}
+
+// Submit hands a message to the worker that owns its partition, blocking while
+// that worker's queue is full
+func (p *workerPool) Submit(message *kafkaGo.Message) {
+	p.queues[message.Partition%len(p.queues)] <- message
+}
+
+// Close stops accepting messages and waits for the queued ones to finish
+func (p *workerPool) Close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
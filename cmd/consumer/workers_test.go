@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+)
+
+// benchDBLatency is the simulated store round trip of processing one message
+const benchDBLatency = time.Millisecond
+
+func TestWorkerPoolKeepsPartitionOrder(t *testing.T) {
+	tests := []struct {
+		workers, partitions int
+	}{
+		{1, 4},
+		{4, 4},
+		{3, 8},
+		{8, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d workers, %d partitions", tt.workers, tt.partitions), func(t *testing.T) {
+			const perPartition = 50
+
+			var mu sync.Mutex
+			seen := make(map[int][]int64)
+			pool := newWorkerPool(tt.workers, func(message *kafkaGo.Message) {
+				// Vary the work so an unpinned pool would reorder a partition
+				time.Sleep(time.Duration(message.Offset%3) * 100 * time.Microsecond)
+				mu.Lock()
+				seen[message.Partition] = append(seen[message.Partition], message.Offset)
+				mu.Unlock()
+			})
+
+			// Interleave partitions as a consumer group read does
+			for offset := int64(0); offset < perPartition; offset++ {
+				for partition := 0; partition < tt.partitions; partition++ {
+					pool.Submit(&kafkaGo.Message{Partition: partition, Offset: offset})
+				}
+			}
+			pool.Close()
+
+			for partition := 0; partition < tt.partitions; partition++ {
+				offsets := seen[partition]
+				if len(offsets) != perPartition {
+					t.Fatalf("partition %d processed %d messages, want %d", partition, len(offsets), perPartition)
+				}
+				for i, offset := range offsets {
+					if offset != int64(i) {
+						t.Fatalf("partition %d processed offset %d at position %d: %v", partition, offset, i, offsets)
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkWorkerPool shows throughput scaling with WORKER_COUNT when each message
+// waits on a store round trip and messages are spread over 8 partitions
+func BenchmarkWorkerPool(b *testing.B) {
+	const partitions = 8
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			pool := newWorkerPool(workers, func(message *kafkaGo.Message) {
+				time.Sleep(benchDBLatency)
+			})
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				pool.Submit(&kafkaGo.Message{Partition: i % partitions, Offset: int64(i / partitions)})
+			}
+			pool.Close()
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+		})
+	}
+}
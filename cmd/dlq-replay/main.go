@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"kafka-pipeline/internal/dlq"
+	"kafka-pipeline/internal/kafka"
+	"kafka-pipeline/internal/store"
+
+	"go.uber.org/zap"
+)
+
+// retryCountHeader tells the consumer how many times a message has been retried
+const retryCountHeader = "retryCount"
+
+// errRejected marks an entry the --fix transforms rejected
+var errRejected = errors.New("rejected by transform")
+
+// errLimitReached stops a dry-run walk once --max entries have been logged
+var errLimitReached = errors.New("limit reached")
+
+func main() {
+	// Parse flags
+	topic := flag.String("topic", getEnv("KAFKA_TOPIC", "events"), "topic whose DLQ list is replayed, and the topic republished to")
+	maxMessages := flag.Int64("max", 0, "replay at most this many messages (0 replays everything queued at start)")
+	dryRun := flag.Bool("dry-run", false, "log what would be replayed without popping or publishing")
+	var fixes []dlq.Transform
+	flag.Func("fix", "repair each event before it is replayed; repeat to apply several in order. Events a fixer rejects stay in the DLQ. Fixers:\n"+dlq.FixerUsage(), func(spec string) error {
+		transform, err := dlq.ParseFixer(spec)
+		if err != nil {
+			return err
+		}
+		fixes = append(fixes, transform)
+		return nil
+	})
+	flag.Parse()
+	transform := dlq.Chain(fixes...)
+
+	// Initialize logger
+	logger, err := zap.NewProduction()
+	if err != nil {
+		log.Fatal("Failed to initialize logger:", err)
+	}
+	defer logger.Sync()
+
+	// Get configuration from environment
+	kafkaBrokers := getEnv("KAFKA_BROKERS", "localhost:9092")
+	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
+	redisPassword := getEnv("REDIS_PASSWORD", "")
+	kafkaSecurity, err := kafka.NewSecurity(kafka.SecurityConfig{
+		TLS:           getEnv("KAFKA_TLS_ENABLED", "false") == "true",
+		CACertPath:    getEnv("KAFKA_TLS_CA_CERT", ""),
+		SASLMechanism: getEnv("KAFKA_SASL_MECHANISM", ""),
+		Username:      getEnv("KAFKA_SASL_USERNAME", ""),
+		Password:      getEnv("KAFKA_SASL_PASSWORD", ""),
+	})
+	if err != nil {
+		logger.Fatal("Invalid Kafka security settings", zap.Error(err))
+	}
+
+	// Replay works on the per-topic list, which neither compact mode nor per-type
+	// routing writes
+	mode, err := dlq.ParseMode(getEnv("DLQ_MODE", string(dlq.ModeList)))
+	if err != nil {
+		logger.Fatal("Invalid DLQ_MODE", zap.Error(err))
+	}
+	if mode == dlq.ModeCompact {
+		logger.Fatal("dlq-replay reads the per-topic DLQ list, which DLQ_MODE=compact doesn't keep; use list mode")
+	}
+	routing, err := dlq.ParseRouting(getEnv("DLQ_ROUTING", string(dlq.RouteTopic)))
+	if err != nil {
+		logger.Fatal("Invalid DLQ_ROUTING", zap.Error(err))
+	}
+	if routing == dlq.RouteType {
+		logger.Fatal("dlq-replay reads the per-topic DLQ list, which DLQ_ROUTING=type doesn't keep; use topic or both routing")
+	}
+
+	redisDLQ, err := dlq.NewRedisDLQ(redisConfigFromEnv(redisAddr, redisPassword, logger), mode, routing, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize DLQ", zap.Error(err))
+	}
+	defer redisDLQ.Close()
+
+	ctx := context.Background()
+
+	// Only the messages queued now are attempted, so ones that fail again in the
+	// consumer and land back on the DLQ aren't replayed in a loop
+	pending, err := redisDLQ.Count(ctx, *topic)
+	if err != nil {
+		logger.Fatal("Failed to count DLQ messages", zap.Error(err))
+	}
+	if *maxMessages > 0 && *maxMessages < pending {
+		pending = *maxMessages
+	}
+
+	if *dryRun {
+		var seen int64
+		err := redisDLQ.StreamMessages(ctx, *topic, dlq.DefaultStreamChunkSize, func(chunk []string) error {
+			for _, raw := range chunk {
+				if seen >= pending {
+					return errLimitReached
+				}
+				seen++
+
+				dlqMsg, event, err := decode(raw)
+				if err != nil {
+					logger.Warn("Would skip undecodable DLQ message", zap.Error(err))
+					continue
+				}
+				if transform != nil {
+					if event, err = transform(event); err != nil {
+						logger.Warn("Would leave rejected message in DLQ", zap.String("eventId", dlqMsg.EventID), zap.Error(err))
+						continue
+					}
+				}
+				logger.Info("Would replay message",
+					zap.String("eventId", dlqMsg.EventID),
+					zap.Int("retryCount", dlqMsg.RetryCount),
+					zap.Time("failedAt", dlqMsg.FailedAt),
+					zap.String("error", dlqMsg.Error),
+					zap.Any("event", event),
+				)
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errLimitReached) {
+			logger.Fatal("Failed to read DLQ", zap.Error(err))
+		}
+		logger.Info("DLQ replay dry run finished", zap.String("topic", *topic), zap.Int64("matched", seen))
+		return
+	}
+
+	producer := kafka.NewProducer(strings.Split(kafkaBrokers, ","), *topic, kafka.ProducerConfig{Security: kafkaSecurity}, logger)
+	defer producer.Close()
+
+	var replayed, rejected, failed int64
+	for i := int64(0); i < pending; i++ {
+		raw, err := redisDLQ.PopMessage(ctx, *topic)
+		if err != nil {
+			logger.Fatal("Failed to pop DLQ message", zap.Error(err))
+		}
+		if raw == "" {
+			break
+		}
+
+		if err := replay(ctx, producer, raw, transform); err != nil {
+			if errors.Is(err, errRejected) {
+				rejected++
+				logger.Warn("Transform rejected DLQ message, requeueing", zap.Error(err))
+			} else {
+				failed++
+				logger.Error("Failed to replay DLQ message, requeueing", zap.Error(err))
+			}
+			if err := redisDLQ.Requeue(ctx, *topic, raw); err != nil {
+				logger.Fatal("Failed to requeue DLQ message; it has been removed from the DLQ",
+					zap.String("message", raw),
+					zap.Error(err),
+				)
+			}
+			continue
+		}
+		replayed++
+	}
+
+	logger.Info("DLQ replay finished",
+		zap.String("topic", *topic),
+		zap.Int64("replayed", replayed),
+		zap.Int64("rejected", rejected),
+		zap.Int64("failed", failed),
+	)
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// publisher republishes events; *kafka.Producer implements it
+type publisher interface {
+	PublishEventWithHeaders(ctx context.Context, event interface{}, headers map[string]string) error
+}
+
+// replay republishes a DLQ entry's original event, carrying its headers and an
+// incremented retry count. transform, if non-nil, rewrites the event first; an
+// event it rejects is returned as an error wrapping errRejected.
+func replay(ctx context.Context, producer publisher, raw string, transform dlq.Transform) error {
+	dlqMsg, event, err := decode(raw)
+	if err != nil {
+		return err
+	}
+	if transform != nil {
+		if event, err = transform(event); err != nil {
+			return fmt.Errorf("%w: event %s: %v", errRejected, dlqMsg.EventID, err)
+		}
+	}
+
+	headers := make(map[string]string, len(dlqMsg.Headers)+1)
+	for k, v := range dlqMsg.Headers {
+		headers[k] = v
+	}
+	headers[retryCountHeader] = strconv.Itoa(dlqMsg.RetryCount + 1)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return producer.PublishEventWithHeaders(ctx, event, headers)
+}
+
+// decode parses a DLQ entry and its original event. Messages that failed to parse
+// were stored as the raw value, so their payload is decoded again here.
+func decode(raw string) (*store.DLQMessage, map[string]interface{}, error) {
+	var dlqMsg store.DLQMessage
+	if err := json.Unmarshal([]byte(raw), &dlqMsg); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal DLQ message: %w", err)
+	}
+
+	switch payload := dlqMsg.Payload.(type) {
+	case map[string]interface{}:
+		return &dlqMsg, payload, nil
+	case string:
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			return &dlqMsg, nil, fmt.Errorf("payload of %s is not a JSON event: %w", dlqMsg.EventID, err)
+		}
+		return &dlqMsg, event, nil
+	default:
+		return &dlqMsg, nil, fmt.Errorf("payload of %s is not an event", dlqMsg.EventID)
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// redisConfigFromEnv reads the DLQ's Redis connection settings, as the consumer does
+func redisConfigFromEnv(addr, password string, logger *zap.Logger) dlq.RedisConfig {
+	mode, err := dlq.ParseRedisMode(getEnv("REDIS_MODE", "standalone"))
+	if err != nil {
+		logger.Fatal("Invalid REDIS_MODE", zap.Error(err))
+	}
+
+	config := dlq.RedisConfig{
+		Mode:             mode,
+		Addrs:            strings.Split(getEnv("REDIS_ADDRS", addr), ","),
+		Password:         password,
+		MasterName:       getEnv("REDIS_MASTER_NAME", ""),
+		SentinelPassword: getEnv("REDIS_SENTINEL_PASSWORD", ""),
+	}
+	if value := os.Getenv("REDIS_DIAL_TIMEOUT"); value != "" {
+		config.DialTimeout, err = time.ParseDuration(value)
+		if err != nil {
+			logger.Fatal("Invalid REDIS_DIAL_TIMEOUT", zap.String("value", value), zap.Error(err))
+		}
+	}
+	return config
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"kafka-pipeline/internal/dlq"
+	"kafka-pipeline/internal/kafka"
+
+	"github.com/alicebob/miniredis/v2"
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// fakePublisher records what replay republishes
+type fakePublisher struct {
+	err     error
+	events  []interface{}
+	headers []map[string]string
+}
+
+func (p *fakePublisher) PublishEventWithHeaders(ctx context.Context, event interface{}, headers map[string]string) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, event)
+	p.headers = append(p.headers, headers)
+	return nil
+}
+
+func TestReplayRoundTripsHeaders(t *testing.T) {
+	tests := []struct {
+		name        string
+		headers     []kafkaGo.Header
+		retryCount  int
+		wantHeaders map[string]string
+	}{
+		{
+			name: "traced message",
+			headers: []kafkaGo.Header{
+				{Key: "traceparent", Value: []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")},
+				{Key: kafka.HeaderEventType, Value: []byte("OrderPlaced")},
+				{Key: "content-type", Value: []byte("application/json")},
+			},
+			wantHeaders: map[string]string{
+				"traceparent":         "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+				kafka.HeaderEventType: "OrderPlaced",
+				"content-type":        "application/json",
+				retryCountHeader:      "1",
+			},
+		},
+		{
+			name:        "retried message",
+			headers:     []kafkaGo.Header{{Key: "tenant", Value: []byte("acme")}, {Key: retryCountHeader, Value: []byte("2")}},
+			retryCount:  2,
+			wantHeaders: map[string]string{"tenant": "acme", retryCountHeader: "3"},
+		},
+		{
+			name:        "no headers",
+			wantHeaders: map[string]string{retryCountHeader: "1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			server := miniredis.RunT(t)
+			redisDLQ, err := dlq.NewRedisDLQ(dlq.RedisConfig{Addrs: []string{server.Addr()}}, dlq.ModeList, dlq.RouteTopic, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer redisDLQ.Close()
+
+			// Park the message as the consumer does, then pop it as replay does
+			event := map[string]interface{}{"eventId": "evt-1", "type": "OrderPlaced", "data": map[string]interface{}{"orderId": "order-1"}}
+			if err := redisDLQ.PushMessage(ctx, "events", 0, 7, kafka.HeadersToMap(tt.headers), event, "boom", tt.retryCount); err != nil {
+				t.Fatal(err)
+			}
+			raw, err := redisDLQ.PopMessage(ctx, "events")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			producer := &fakePublisher{}
+			if err := replay(ctx, producer, raw, nil); err != nil {
+				t.Fatalf("replay() error = %v", err)
+			}
+
+			if len(producer.headers) != 1 {
+				t.Fatalf("published %d events, want 1", len(producer.headers))
+			}
+			if !reflect.DeepEqual(producer.headers[0], tt.wantHeaders) {
+				t.Errorf("republished headers = %v, want %v", producer.headers[0], tt.wantHeaders)
+			}
+			if !reflect.DeepEqual(producer.events[0], event) {
+				t.Errorf("republished event = %v, want %v", producer.events[0], event)
+			}
+		})
+	}
+}
+
+func TestReplayErrors(t *testing.T) {
+	rejectAll := func(event map[string]interface{}) (map[string]interface{}, error) {
+		return nil, errors.New("no")
+	}
+	errPublish := errors.New("broker down")
+
+	tests := []struct {
+		name       string
+		raw        string
+		transform  dlq.Transform
+		publishErr error
+		wantErr    error
+	}{
+		{"rejected by a fixer", `{"eventId":"evt-1","payload":{"eventId":"evt-1"}}`, rejectAll, nil, errRejected},
+		{"publish fails", `{"eventId":"evt-1","payload":{"eventId":"evt-1"}}`, nil, errPublish, errPublish},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer := &fakePublisher{err: tt.publishErr}
+			err := replay(context.Background(), producer, tt.raw, tt.transform)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("replay() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
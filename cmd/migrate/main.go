@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"kafka-pipeline/internal/store"
+	"kafka-pipeline/internal/store/migrations"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	// Parse flags
+	createDatabase := flag.Bool("create-database", false, "create the database named in MSSQL_CONN first if it doesn't exist")
+	timeout := flag.Duration("timeout", 5*time.Minute, "give up if the migrations haven't finished by then")
+	flag.Parse()
+
+	// Initialize logger
+	logger, err := zap.NewProduction()
+	if err != nil {
+		log.Fatal("Failed to initialize logger:", err)
+	}
+	defer logger.Sync()
+
+	// Get configuration from environment
+	mssqlConn := getEnv("MSSQL_CONN", "server=localhost;user id=sa;password=Your_strong_pwd1;database=events;encrypt=disable")
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if *createDatabase {
+		if err := migrations.EnsureDatabase(ctx, mssqlConn); err != nil {
+			logger.Fatal("Failed to create database", zap.Error(err))
+		}
+	}
+
+	sqlStore, err := store.NewMSSQLStore(mssqlConn, logger)
+	if err != nil {
+		logger.Fatal("Failed to connect to SQL Server", zap.Error(err))
+	}
+	defer sqlStore.Close()
+
+	applied, err := sqlStore.Migrate(ctx)
+	if err != nil {
+		logger.Error("Migration failed", zap.Strings("applied", applied), zap.Error(err))
+		sqlStore.Close()
+		os.Exit(1)
+	}
+
+	logger.Info("Database is up to date", zap.Strings("applied", applied))
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
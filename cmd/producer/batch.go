@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"kafka-pipeline/internal/audit"
+	"kafka-pipeline/internal/callback"
+	"kafka-pipeline/internal/kafka"
+
+	"go.uber.org/zap"
+)
+
+// batchItemResult reports the outcome of a single event in a batch
+type batchItemResult struct {
+	Index   int    `json:"index"`
+	EventID string `json:"eventId,omitempty"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// handleProduceBatch publishes a JSON array of events. Every event is validated
+// first and the valid ones are written to Kafka together; the response reports
+// each event by its index in the array. Arrays longer than maxEvents are rejected
+// with 413 before anything is published.
+func handleProduceBatch(w http.ResponseWriter, r *http.Request, maxEvents int, producer *kafka.Producer, auditLog *audit.FileLog, callbacks *callback.Registry, logger *zap.Logger) {
+	spanCtx, span := startRequestSpan(r, "/produce/batch")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		httpRequestsTotal.WithLabelValues(r.Method, "/produce/batch", "405").Inc()
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	raws, err := decodeBatch(r, maxEvents)
+	if errors.Is(err, errBatchTooLarge) {
+		httpRequestsTotal.WithLabelValues(r.Method, "/produce/batch", "413").Inc()
+		http.Error(w, fmt.Sprintf("Batch exceeds the maximum of %d events", maxEvents), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/produce/batch", "400").Inc()
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(spanCtx), 30*time.Second)
+	defer cancel()
+
+	results := make([]batchItemResult, len(raws))
+	events := make([]interface{}, 0, len(raws))
+	indexes := make([]int, 0, len(raws))
+	for i, raw := range raws {
+		results[i] = batchItemResult{Index: i}
+		event, err := prepareBatchEvent(ctx, raw, callbacks, logger, &results[i])
+		if err != nil {
+			results[i].Status = "failed"
+			results[i].Error = err.Error()
+			continue
+		}
+		events = append(events, event)
+		indexes = append(indexes, i)
+	}
+
+	if len(events) > 0 {
+		for j, err := range producer.PublishEvents(ctx, events) {
+			result := &results[indexes[j]]
+			if err != nil {
+				result.Status = "failed"
+				result.Error = fmt.Sprintf("failed to publish event: %v", err)
+				continue
+			}
+			result.Status = "published"
+
+			event := events[j].(map[string]interface{})
+			if err := auditLog.Append(event, nil); err != nil {
+				logger.Error("Failed to append audit record", zap.Error(err))
+			}
+			eventsProducedTotal.WithLabelValues(event["type"].(string)).Inc()
+		}
+	}
+
+	published, failed := 0, 0
+	for _, result := range results {
+		if result.Status == "published" {
+			published++
+		} else {
+			failed++
+		}
+	}
+
+	status := http.StatusOK
+	if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	httpRequestsTotal.WithLabelValues(r.Method, "/produce/batch", strconv.Itoa(status)).Inc()
+
+	logger.Info("Event batch produced",
+		zap.Int("published", published),
+		zap.Int("failed", failed),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"published": published,
+		"failed":    failed,
+		"results":   results,
+	}); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+var errBatchTooLarge = errors.New("batch exceeds the maximum number of events")
+
+// decodeBatch reads the request body as a JSON array, returning each element
+// undecoded. It stops with errBatchTooLarge as soon as the array has more than
+// maxEvents elements, without reading the rest of the body.
+func decodeBatch(r *http.Request, maxEvents int) ([]json.RawMessage, error) {
+	dec := json.NewDecoder(r.Body)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, fmt.Errorf("body must be a JSON array of events")
+	}
+
+	var raws []json.RawMessage
+	for dec.More() {
+		if len(raws) == maxEvents {
+			return nil, errBatchTooLarge
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		raws = append(raws, raw)
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if len(raws) == 0 {
+		return nil, fmt.Errorf("batch must contain at least one event")
+	}
+	return raws, nil
+}
+
+// prepareBatchEvent decodes and validates one element of a batch and registers
+// its completion callback, returning the event ready to publish
+func prepareBatchEvent(ctx context.Context, raw json.RawMessage, callbacks *callback.Registry, logger *zap.Logger, result *batchItemResult) (map[string]interface{}, error) {
+	event, err := decodeEvent(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	if eventID, ok := event["eventId"].(string); ok {
+		result.EventID = eventID
+	}
+
+	if err := validateEvent(event); err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
+	if err := checkFormats(event, logger); err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
+
+	callbackURL, err := takeCallbackURL(event, callbacks != nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
+	if callbackURL != "" {
+		if err := callbacks.Register(ctx, result.EventID, callbackURL); err != nil {
+			return nil, err
+		}
+	}
+
+	return event, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"kafka-pipeline/internal/kafka"
+
+	"github.com/prometheus/client_golang/prometheus"
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// registerWriterMetrics mirrors the writer's key counters into Prometheus, read
+// from producer.Stats at scrape time
+func registerWriterMetrics(producer *kafka.Producer) {
+	counter := func(name, help string, value func(s kafkaGo.WriterStats) int64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, func() float64 {
+			return float64(value(producer.Stats()))
+		})
+	}
+
+	prometheus.MustRegister(
+		counter("kafka_writer_writes_total", "Total number of batch writes by the Kafka writer", func(s kafkaGo.WriterStats) int64 { return s.Writes }),
+		counter("kafka_writer_messages_total", "Total number of messages written by the Kafka writer", func(s kafkaGo.WriterStats) int64 { return s.Messages }),
+		counter("kafka_writer_bytes_total", "Total number of message bytes written by the Kafka writer", func(s kafkaGo.WriterStats) int64 { return s.Bytes }),
+		counter("kafka_writer_errors_total", "Total number of Kafka writer errors", func(s kafkaGo.WriterStats) int64 { return s.Errors }),
+		counter("kafka_writer_retries_total", "Total number of batch writes retried by the Kafka writer", func(s kafkaGo.WriterStats) int64 { return s.Retries }),
+	)
+}
+
+// handleKafkaStats returns the Kafka writer's statistics as JSON. Counters are
+// totals since startup, so it is safe to poll.
+func handleKafkaStats(producer *kafka.Producer, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set content type and write response
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"writer": producer.Stats()}); err != nil {
+			logger.Error("Failed to encode response", zap.Error(err))
+		}
+	}
+}
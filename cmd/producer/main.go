@@ -1,19 +1,38 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"kafka-pipeline/internal/audit"
+	"kafka-pipeline/internal/callback"
+	"kafka-pipeline/internal/health"
 	"kafka-pipeline/internal/kafka"
+	"kafka-pipeline/internal/schema"
+	"kafka-pipeline/internal/tracing"
+	"kafka-pipeline/internal/validation"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -33,11 +52,41 @@ var (
 		},
 		[]string{"type"},
 	)
+
+	produceInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "produce_inflight_requests",
+			Help: "Number of produce requests currently being handled",
+		},
+	)
 )
 
+var tracer = otel.Tracer("kafka-pipeline/cmd/producer")
+
+// disallowUnknownFields rejects events with top-level fields outside knownEventFields
+var disallowUnknownFields = false
+
+// validationProfile controls whether timestamp and email format problems reject an event
+var validationProfile = validation.Lenient
+
+// knownEventFields are the top-level fields of an event envelope
+var knownEventFields = map[string]bool{
+	"eventId":   true,
+	"type":      true,
+	"timestamp": true,
+	"data":      true,
+	// tenantId selects the tenant store the consumer writes to
+	"tenantId": true,
+	// schemaVersion is copied to the schema-version header (default "1")
+	"schemaVersion": true,
+	// callbackUrl requests a completion callback; it is stripped before publishing
+	"callbackUrl": true,
+}
+
 func init() {
 	prometheus.MustRegister(httpRequestsTotal)
 	prometheus.MustRegister(eventsProducedTotal)
+	prometheus.MustRegister(produceInFlight)
 }
 
 func main() {
@@ -51,29 +100,157 @@ func main() {
 	// Get configuration from environment
 	kafkaBrokers := getEnv("KAFKA_BROKERS", "localhost:9092")
 	kafkaTopic := getEnv("KAFKA_TOPIC", "events")
+	kafkaSecurity, err := kafka.NewSecurity(kafka.SecurityConfig{
+		TLS:           getEnv("KAFKA_TLS_ENABLED", "false") == "true",
+		CACertPath:    getEnv("KAFKA_TLS_CA_CERT", ""),
+		SASLMechanism: getEnv("KAFKA_SASL_MECHANISM", ""),
+		Username:      getEnv("KAFKA_SASL_USERNAME", ""),
+		Password:      getEnv("KAFKA_SASL_PASSWORD", ""),
+	})
+	if err != nil {
+		logger.Fatal("Invalid Kafka security settings", zap.Error(err))
+	}
 	servicePort := getEnv("SERVICE_PORT", "8080")
+	auditLogPath := getEnv("AUDIT_LOG_PATH", "")
+	disallowUnknownFields = getEnv("PRODUCE_DISALLOW_UNKNOWN_FIELDS", "false") == "true"
+	validationProfile, err = validation.ParseProfile(getEnv("VALIDATION_PROFILE", string(validation.Lenient)))
+	if err != nil {
+		logger.Fatal("Invalid VALIDATION_PROFILE", zap.Error(err))
+	}
+	maxInFlight, err := strconv.Atoi(getEnv("MAX_INFLIGHT_PRODUCE", "100"))
+	if err != nil || maxInFlight <= 0 {
+		logger.Fatal("MAX_INFLIGHT_PRODUCE must be a positive integer")
+	}
+	rateLimitPerSecond, err := strconv.ParseFloat(getEnv("PRODUCE_RATE_LIMIT", "0"), 64)
+	if err != nil || rateLimitPerSecond < 0 {
+		logger.Fatal("PRODUCE_RATE_LIMIT must be a non-negative number")
+	}
+	batchMaxEvents, err := strconv.Atoi(getEnv("PRODUCE_BATCH_MAX_EVENTS", "500"))
+	if err != nil || batchMaxEvents <= 0 {
+		logger.Fatal("PRODUCE_BATCH_MAX_EVENTS must be a positive integer")
+	}
+	rateLimitBurst, err := strconv.Atoi(getEnv("PRODUCE_RATE_BURST", "20"))
+	if err != nil || rateLimitBurst <= 0 {
+		logger.Fatal("PRODUCE_RATE_BURST must be a positive integer")
+	}
+	rateLimitAPIKeys := strings.Split(getEnv("PRODUCE_API_KEYS", ""), ",")
+	callbacksEnabled := getEnv("CALLBACKS_ENABLED", "false") == "true"
+	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
+	redisPassword := getEnv("REDIS_PASSWORD", "")
+	callbackTTL, err := time.ParseDuration(getEnv("CALLBACK_TTL", "24h"))
+	if err != nil {
+		logger.Fatal("Invalid CALLBACK_TTL", zap.Error(err))
+	}
+	shutdownTimeout := getEnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second, logger)
+	readinessTimeout := getEnvDuration("READINESS_TIMEOUT", 2*time.Second, logger)
+
+	// Cancelled on SIGINT/SIGTERM to begin a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Init(ctx, "producer")
+	if err != nil {
+		logger.Fatal("Failed to initialize tracing", zap.Error(err))
+	}
+
+	compression, err := kafka.ParseCompression(getEnv("KAFKA_COMPRESSION", "none"))
+	if err != nil {
+		logger.Fatal("Invalid KAFKA_COMPRESSION", zap.Error(err))
+	}
+	balancer, err := kafka.ParseBalancer(getEnv("KAFKA_BALANCER", "hash"))
+	if err != nil {
+		logger.Fatal("Invalid KAFKA_BALANCER", zap.Error(err))
+	}
+	requiredAcks, err := kafka.ParseRequiredAcks(getEnv("KAFKA_REQUIRED_ACKS", "none"))
+	if err != nil {
+		logger.Fatal("Invalid KAFKA_REQUIRED_ACKS", zap.Error(err))
+	}
+	batchSize, err := strconv.Atoi(getEnv("KAFKA_BATCH_SIZE", "0"))
+	if err != nil || batchSize < 0 {
+		logger.Fatal("KAFKA_BATCH_SIZE must be a non-negative integer")
+	}
 
 	// Initialize Kafka producer
 	brokers := strings.Split(kafkaBrokers, ",")
-	producer := kafka.NewProducer(brokers, kafkaTopic, logger)
-	defer producer.Close()
+	producer := kafka.NewProducer(brokers, kafkaTopic, kafka.ProducerConfig{
+		Balancer:     balancer,
+		Compression:  compression,
+		RequiredAcks: requiredAcks,
+		BatchSize:    batchSize,
+		BatchTimeout: getEnvDuration("KAFKA_BATCH_TIMEOUT", 0, logger),
+		Security:     kafkaSecurity,
+	}, logger)
+
+	// Initialize audit log (optional)
+	var auditLog *audit.FileLog
+	if auditLogPath != "" {
+		auditLog, err = audit.NewFileLog(auditLogPath, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize audit log", zap.Error(err))
+		}
+		defer auditLog.Close()
+		logger.Info("Audit log enabled", zap.String("path", auditLogPath))
+	}
+
+	// Initialize completion callback registry (optional)
+	var callbacks *callback.Registry
+	if callbacksEnabled {
+		callbacks, err = callback.NewRegistry(redisAddr, redisPassword, callbackTTL, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize callback registry", zap.Error(err))
+		}
+		defer callbacks.Close()
+		logger.Info("Completion callbacks enabled")
+	}
 
 	// Create HTTP server
 	mux := http.NewServeMux()
 
-	// Health check endpoint
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	// Health check endpoints; /health is kept as an alias of /livez for existing probes
+	readinessChecks := map[string]health.Check{
+		"kafka": producer.Ping,
+	}
+	if callbacks != nil {
+		readinessChecks["redis"] = callbacks.Ping
+	}
+	mux.HandleFunc("/health", health.Live)
+	mux.HandleFunc("/livez", health.Live)
+	mux.HandleFunc("/readyz", health.Ready(readinessChecks, readinessTimeout, logger))
 
 	// Metrics endpoint
 	mux.Handle("/metrics", promhttp.Handler())
+	registerWriterMetrics(producer)
+	mux.HandleFunc("GET /debug/kafka", handleKafkaStats(producer, logger))
+
+	// In-flight limit shared by all produce endpoints
+	inFlight := make(chan struct{}, maxInFlight)
+
+	// Per-client rate limit shared by all produce endpoints, disabled at 0
+	var limiter *clientRateLimiter
+	if rateLimitPerSecond > 0 {
+		limiter = newClientRateLimiter(rateLimitPerSecond, rateLimitBurst, rateLimitAPIKeys)
+		go limiter.Run(ctx)
+		logger.Info("Produce rate limit enabled",
+			zap.Float64("perSecond", rateLimitPerSecond),
+			zap.Int("burst", rateLimitBurst),
+			zap.Int("apiKeys", len(limiter.apiKeys)),
+		)
+	}
 
 	// Producer endpoint
-	mux.HandleFunc("/produce", func(w http.ResponseWriter, r *http.Request) {
-		handleProduce(w, r, producer, logger)
-	})
+	mux.HandleFunc("/produce", rateLimit(limiter, "/produce", limitInFlight(inFlight, "/produce", func(w http.ResponseWriter, r *http.Request) {
+		handleProduce(w, r, producer, auditLog, callbacks, logger)
+	})))
+
+	// NDJSON streaming producer endpoint
+	mux.HandleFunc("/produce/stream", rateLimit(limiter, "/produce/stream", limitInFlight(inFlight, "/produce/stream", func(w http.ResponseWriter, r *http.Request) {
+		handleProduceStream(w, r, producer, auditLog, callbacks, logger)
+	})))
+
+	// Batch producer endpoint
+	mux.HandleFunc("/produce/batch", rateLimit(limiter, "/produce/batch", limitInFlight(inFlight, "/produce/batch", func(w http.ResponseWriter, r *http.Request) {
+		handleProduceBatch(w, r, batchMaxEvents, producer, auditLog, callbacks, logger)
+	})))
 
 	// Start server
 	server := &http.Server{
@@ -83,13 +260,92 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
-	logger.Info("Starting producer service", zap.String("port", servicePort))
-	if err := server.ListenAndServe(); err != nil {
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
 		logger.Fatal("Failed to start server", zap.Error(err))
 	}
+	logger.Info("Starting producer service", zap.String("port", servicePort))
+	if err := serve(ctx, server, listener, shutdownTimeout, logger); err != nil {
+		logger.Fatal("Server failed", zap.Error(err))
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Closing the writer flushes any buffered messages once no handler can publish
+	if err := producer.Close(); err != nil {
+		logger.Error("Failed to close Kafka producer", zap.Error(err))
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("Failed to flush traces", zap.Error(err))
+	}
+	logger.Info("Producer service stopped")
+}
+
+// serve runs server on listener until ctx is done, then stops accepting
+// connections and gives in-flight requests up to shutdownTimeout to finish. An
+// error is returned only if the server fails before shutdown begins.
+func serve(ctx context.Context, server *http.Server, listener net.Listener, shutdownTimeout time.Duration, logger *zap.Logger) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Stop accepting connections and let in-flight requests finish
+	logger.Info("Shutting down producer service", zap.Duration("timeout", shutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to shut down server gracefully", zap.Error(err))
+	}
+	return nil
 }
 
-func handleProduce(w http.ResponseWriter, r *http.Request, producer *kafka.Producer, logger *zap.Logger) {
+// startRequestSpan starts a server span for a produce request, continuing the
+// caller's trace when the request carries a traceparent header. Publishes made
+// with the returned context are its children.
+func startRequestSpan(r *http.Request, endpoint string) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return tracer.Start(ctx, r.Method+" "+endpoint,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("http.route", endpoint)),
+	)
+}
+
+// limitInFlight sheds load with a 503 once the in-flight semaphore is full,
+// rather than queueing requests that each hold a context and a writer slot
+func limitInFlight(sem chan struct{}, endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+		default:
+			httpRequestsTotal.WithLabelValues(r.Method, endpoint, "503").Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many in-flight requests", http.StatusServiceUnavailable)
+			return
+		}
+
+		produceInFlight.Inc()
+		defer func() {
+			produceInFlight.Dec()
+			<-sem
+		}()
+
+		next(w, r)
+	}
+}
+
+func handleProduce(w http.ResponseWriter, r *http.Request, producer *kafka.Producer, auditLog *audit.FileLog, callbacks *callback.Registry, logger *zap.Logger) {
+	spanCtx, span := startRequestSpan(r, "/produce")
+	defer span.End()
+
 	// Increment request counter
 	httpRequestsTotal.WithLabelValues(r.Method, "/produce", "200").Inc()
 
@@ -100,10 +356,10 @@ func handleProduce(w http.ResponseWriter, r *http.Request, producer *kafka.Produ
 	}
 
 	// Parse request body
-	var event map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+	event, err := decodeEvent(r.Body)
+	if err != nil {
 		httpRequestsTotal.WithLabelValues(r.Method, "/produce", "400").Inc()
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
 		return
 	}
 
@@ -113,18 +369,48 @@ func handleProduce(w http.ResponseWriter, r *http.Request, producer *kafka.Produ
 		http.Error(w, fmt.Sprintf("Invalid event: %v", err), http.StatusBadRequest)
 		return
 	}
+	if err := checkFormats(event, logger); err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/produce", "400").Inc()
+		http.Error(w, fmt.Sprintf("Invalid event: %v", err), http.StatusBadRequest)
+		return
+	}
 
-	// Publish to Kafka
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	callbackURL, err := takeCallbackURL(event, callbacks != nil)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(r.Method, "/produce", "400").Inc()
+		http.Error(w, fmt.Sprintf("Invalid event: %v", err), http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(attribute.String("event.id", fmt.Sprint(event["eventId"])))
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(spanCtx), 10*time.Second)
 	defer cancel()
 
+	// Register the callback before publishing so the consumer can't finish first
+	if callbackURL != "" {
+		if err := callbacks.Register(ctx, event["eventId"].(string), callbackURL); err != nil {
+			httpRequestsTotal.WithLabelValues(r.Method, "/produce", "500").Inc()
+			logger.Error("Failed to register callback", zap.Error(err))
+			http.Error(w, "Failed to register callback", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Publish to Kafka
 	if err := producer.PublishEvent(ctx, event); err != nil {
 		httpRequestsTotal.WithLabelValues(r.Method, "/produce", "500").Inc()
 		logger.Error("Failed to publish event", zap.Error(err))
 		http.Error(w, "Failed to publish event", http.StatusInternalServerError)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "publish failed")
 		return
 	}
 
+	// Record accepted event in the audit log
+	if err := auditLog.Append(event, nil); err != nil {
+		logger.Error("Failed to append audit record", zap.Error(err))
+	}
+
 	// Increment events produced counter
 	eventType := event["type"].(string)
 	eventsProducedTotal.WithLabelValues(eventType).Inc()
@@ -140,102 +426,319 @@ func handleProduce(w http.ResponseWriter, r *http.Request, producer *kafka.Produ
 	w.Write([]byte("Event produced successfully"))
 }
 
-func validateEvent(event map[string]interface{}) error {
-	// Check required fields
-	if _, ok := event["eventId"]; !ok {
-		return fmt.Errorf("eventId is required")
+// maxStreamLineBytes bounds a single NDJSON line in a streaming upload
+const maxStreamLineBytes = 1024 * 1024
+
+// streamTimeout bounds a streaming upload, from the first line read to the last
+// result written
+const streamTimeout = 60 * time.Second
+
+// lineResult reports the outcome of a single NDJSON line
+type lineResult struct {
+	Line    int    `json:"line"`
+	EventID string `json:"eventId,omitempty"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// handleProduceStream publishes newline-delimited JSON events one line at a time.
+// Each complete line is published as soon as it is read, so a truncated or invalid
+// line never discards the events before it. Blank lines are skipped.
+func handleProduceStream(w http.ResponseWriter, r *http.Request, producer *kafka.Producer, auditLog *audit.FileLog, callbacks *callback.Registry, logger *zap.Logger) {
+	spanCtx, span := startRequestSpan(r, "/produce/stream")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		httpRequestsTotal.WithLabelValues(r.Method, "/produce/stream", "405").Inc()
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(spanCtx), streamTimeout)
+	defer cancel()
+
+	// A stream takes longer than the server's read and write timeouts allow; keep
+	// the connection open for as long as the stream may run
+	deadline := time.Now().Add(streamTimeout + time.Second)
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(deadline)
+	rc.SetWriteDeadline(deadline)
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineBytes)
+
+	results := []lineResult{}
+	published, failed := 0, 0
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		result := lineResult{Line: lineNum}
+		if err := publishLine(ctx, line, producer, auditLog, callbacks, logger, &result); err != nil {
+			result.Status = "failed"
+			result.Error = err.Error()
+			failed++
+			logger.Warn("Failed to produce stream line",
+				zap.Int("line", lineNum),
+				zap.String("eventId", result.EventID),
+				zap.Error(err),
+			)
+		} else {
+			result.Status = "published"
+			published++
+		}
+		results = append(results, result)
 	}
 
-	if _, ok := event["type"]; !ok {
-		return fmt.Errorf("type is required")
+	// A read error (dropped connection, oversized line) ends the stream at the next line
+	if err := scanner.Err(); err != nil {
+		failed++
+		results = append(results, lineResult{
+			Line:   lineNum + 1,
+			Status: "failed",
+			Error:  fmt.Sprintf("failed to read line: %v", err),
+		})
 	}
 
-	if _, ok := event["timestamp"]; !ok {
-		return fmt.Errorf("timestamp is required")
+	status := http.StatusOK
+	if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	httpRequestsTotal.WithLabelValues(r.Method, "/produce/stream", strconv.Itoa(status)).Inc()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"published": published,
+		"failed":    failed,
+		"results":   results,
+	}); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
 	}
+}
 
-	if _, ok := event["data"]; !ok {
-		return fmt.Errorf("data is required")
+// publishLine decodes, validates and publishes a single NDJSON line
+func publishLine(ctx context.Context, line []byte, producer *kafka.Producer, auditLog *audit.FileLog, callbacks *callback.Registry, logger *zap.Logger, result *lineResult) error {
+	event, err := decodeEvent(bytes.NewReader(line))
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
 	}
 
-	// Validate event type
-	eventType, ok := event["type"].(string)
-	if !ok {
-		return fmt.Errorf("type must be a string")
+	if eventID, ok := event["eventId"].(string); ok {
+		result.EventID = eventID
+	}
+
+	if err := validateEvent(event); err != nil {
+		return fmt.Errorf("invalid event: %w", err)
+	}
+	if err := checkFormats(event, logger); err != nil {
+		return fmt.Errorf("invalid event: %w", err)
+	}
+
+	callbackURL, err := takeCallbackURL(event, callbacks != nil)
+	if err != nil {
+		return fmt.Errorf("invalid event: %w", err)
+	}
+	if callbackURL != "" {
+		if err := callbacks.Register(ctx, result.EventID, callbackURL); err != nil {
+			return err
+		}
+	}
+
+	if err := producer.PublishEvent(ctx, event); err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
 	}
 
-	validTypes := map[string]bool{
-		"UserCreated":       true,
-		"OrderPlaced":       true,
-		"PaymentSettled":    true,
-		"InventoryAdjusted": true,
-		"ProductReview":     true,
+	if err := auditLog.Append(event, nil); err != nil {
+		logger.Error("Failed to append audit record", zap.Error(err))
 	}
 
-	if !validTypes[eventType] {
-		return fmt.Errorf("invalid event type: %s", eventType)
+	eventsProducedTotal.WithLabelValues(event["type"].(string)).Inc()
+	return nil
+}
+
+// takeCallbackURL removes the optional callbackUrl from the event envelope and
+// returns it, so it is never published with the event
+func takeCallbackURL(event map[string]interface{}, enabled bool) (string, error) {
+	value, ok := event["callbackUrl"]
+	if !ok {
+		return "", nil
 	}
+	delete(event, "callbackUrl")
 
-	// Validate data field
-	data, ok := event["data"].(map[string]interface{})
+	callbackURL, ok := value.(string)
 	if !ok {
-		return fmt.Errorf("data must be an object")
+		return "", fmt.Errorf("callbackUrl must be a string")
+	}
+	if !enabled {
+		return "", fmt.Errorf("callbackUrl is not supported: completion callbacks are disabled")
 	}
+	if err := callback.ValidateURL(callbackURL); err != nil {
+		return "", err
+	}
+	return callbackURL, nil
+}
 
-	// Validate data fields based on event type
-	switch eventType {
-	case "UserCreated":
-		if _, ok := data["userId"]; !ok {
-			return fmt.Errorf("userId is required for UserCreated event")
-		}
-		if _, ok := data["name"]; !ok {
-			return fmt.Errorf("name is required for UserCreated event")
+// decodeEvent decodes exactly one JSON object. Unlike a plain Decode it rejects
+// duplicate top-level keys and trailing data after the object, and, when
+// disallowUnknownFields is set, top-level fields that aren't part of an event.
+func decodeEvent(body io.Reader) (map[string]interface{}, error) {
+	dec := json.NewDecoder(body)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("body must be a JSON object")
+	}
+
+	event := make(map[string]interface{})
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
 		}
-		if _, ok := data["email"]; !ok {
-			return fmt.Errorf("email is required for UserCreated event")
+		key := tok.(string)
+
+		if _, exists := event[key]; exists {
+			return nil, fmt.Errorf("duplicate field %q", key)
 		}
-	case "OrderPlaced":
-		if _, ok := data["orderId"]; !ok {
-			return fmt.Errorf("orderId is required for OrderPlaced event")
+		if disallowUnknownFields && !knownEventFields[key] {
+			return nil, fmt.Errorf("unknown field %q", key)
 		}
-		if _, ok := data["userId"]; !ok {
-			return fmt.Errorf("userId is required for OrderPlaced event")
+
+		var value interface{}
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
 		}
-		if _, ok := data["total"]; !ok {
-			return fmt.Errorf("total is required for OrderPlaced event")
+		event[key] = value
+	}
+
+	// Consume the closing brace
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	// Anything other than whitespace after the object is an error
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON object")
+	}
+
+	return event, nil
+}
+
+// validateEvent checks the event against its type's JSON Schema (see
+// internal/schema), then applies the rules a schema can't express: emails are
+// normalized and amounts coerced to numbers in place before they're checked.
+func validateEvent(event map[string]interface{}) error {
+	if err := schema.Validate(event); err != nil {
+		return err
+	}
+
+	eventType := event["type"].(string)
+	data := event["data"].(map[string]interface{})
+
+	switch eventType {
+	case "UserCreated":
+		if err := normalizeEmail(data, eventType); err != nil {
+			return err
 		}
-	case "PaymentSettled":
-		if _, ok := data["orderId"]; !ok {
-			return fmt.Errorf("orderId is required for PaymentSettled event")
+	case "UserUpdated":
+		if _, hasEmail := data["email"]; hasEmail {
+			if err := normalizeEmail(data, eventType); err != nil {
+				return err
+			}
 		}
-		if _, ok := data["status"]; !ok {
-			return fmt.Errorf("status is required for PaymentSettled event")
+	case "OrderPlaced":
+		if err := coercePositive(data, "total", eventType); err != nil {
+			return err
 		}
-		if _, ok := data["amount"]; !ok {
-			return fmt.Errorf("amount is required for PaymentSettled event")
+	case "PaymentSettled":
+		if err := coercePositive(data, "amount", eventType); err != nil {
+			return err
 		}
 	case "InventoryAdjusted":
-		if _, ok := data["sku"]; !ok {
-			return fmt.Errorf("sku is required for InventoryAdjusted event")
-		}
-		if _, ok := data["delta"]; !ok {
-			return fmt.Errorf("delta is required for InventoryAdjusted event")
+		delta, err := coerceNumber(data, "delta", eventType)
+		if err != nil {
+			return err
 		}
-	case "ProductReview":
-		if _, ok := data["reviewId"]; !ok {
-			return fmt.Errorf("reviewId is required for ProductReview event")
+		if delta == 0 || delta != math.Trunc(delta) {
+			return fmt.Errorf("delta must be a nonzero whole number for InventoryAdjusted event")
 		}
-		if _, ok := data["productName"]; !ok {
-			return fmt.Errorf("productName is required for ProductReview event")
-		}
-		if _, ok := data["username"]; !ok {
-			return fmt.Errorf("username is required for ProductReview event")
-		}
-		if _, ok := data["rating"]; !ok {
-			return fmt.Errorf("rating is required for ProductReview event")
+	}
+
+	return nil
+}
+
+// coerceNumber reads data[field] as a number, accepting numeric strings such as
+// "12.50", and stores it back as a JSON number so the consumer's typed decode accepts it
+func coerceNumber(data map[string]interface{}, field, eventType string) (float64, error) {
+	var n float64
+	switch v := data[field].(type) {
+	case float64:
+		n = v
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be a number for %s event, got %q", field, eventType, v)
 		}
+		n = parsed
+	default:
+		return 0, fmt.Errorf("%s must be a number for %s event", field, eventType)
+	}
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("%s must be a finite number for %s event", field, eventType)
 	}
+	data[field] = n
+	return n, nil
+}
+
+// coercePositive is coerceNumber for amounts, which must be greater than zero
+func coercePositive(data map[string]interface{}, field, eventType string) error {
+	n, err := coerceNumber(data, field, eventType)
+	if err != nil {
+		return err
+	}
+	if n <= 0 {
+		return fmt.Errorf("%s must be greater than 0 for %s event, got %v", field, eventType, n)
+	}
+	return nil
+}
 
+// normalizeEmail trims and lowercases data's email in place, so the published
+// event dedups by email downstream, and rejects it unless it is a bare address
+func normalizeEmail(data map[string]interface{}, eventType string) error {
+	email, ok := data["email"].(string)
+	if !ok {
+		return fmt.Errorf("email must be a string for %s event", eventType)
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return fmt.Errorf("email must not be empty for %s event", eventType)
+	}
+	if !validation.ValidEmail(email) {
+		return fmt.Errorf("email %q is not a valid address", email)
+	}
+	data["email"] = email
+	return nil
+}
+
+// checkFormats applies the validation profile's format checks. Under the lenient
+// profile problems are logged and the event is accepted.
+func checkFormats(event map[string]interface{}, logger *zap.Logger) error {
+	warnings, err := validationProfile.Check(event)
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		logger.Warn("Event failed format check", zap.Any("eventId", event["eventId"]), zap.Error(w))
+	}
 	return nil
 }
 
@@ -245,3 +748,16 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvDuration(key string, defaultValue time.Duration, logger *zap.Logger) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Fatal("Invalid duration", zap.String("key", key), zap.String("value", value), zap.Error(err))
+	}
+	return d
+}
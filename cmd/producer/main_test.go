@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"kafka-pipeline/internal/validation"
+
+	"go.uber.org/zap"
+)
+
+// streamResponse is the body handleProduceStream answers with
+type streamResponse struct {
+	Published int          `json:"published"`
+	Failed    int          `json:"failed"`
+	Results   []lineResult `json:"results"`
+}
+
+// serveStream posts body to handleProduceStream. None of the test lines pass
+// validation, so no Kafka producer is needed.
+func serveStream(t *testing.T, body io.Reader) (int, streamResponse) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/produce/stream", body)
+	rec := httptest.NewRecorder()
+	handleProduceStream(rec, req, nil, nil, nil, zap.NewNop())
+
+	var resp streamResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, resp
+}
+
+func TestHandleProduceStreamReportsEachLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      io.Reader
+		wantLines []int
+		wantRead  bool // whether the last result is a read failure
+	}{
+		{
+			name:      "every line reported, blank lines skipped",
+			body:      strings.NewReader("{}\n\nnot json\n{\"eventId\":\"evt-1\"}\n"),
+			wantLines: []int{1, 3, 4},
+		},
+		{
+			// The partial last line is still tried, then the read error reported
+			name:      "truncated stream",
+			body:      io.MultiReader(strings.NewReader("{}\n{}\n{\"event"), iotest.ErrReader(io.ErrUnexpectedEOF)),
+			wantLines: []int{1, 2, 3, 4},
+			wantRead:  true,
+		},
+		{
+			name:      "oversized line",
+			body:      strings.NewReader("{}\n" + strings.Repeat("x", maxStreamLineBytes+1) + "\n{}\n"),
+			wantLines: []int{1, 2},
+			wantRead:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, resp := serveStream(t, tt.body)
+
+			if status != http.StatusMultiStatus {
+				t.Errorf("status = %d, want %d", status, http.StatusMultiStatus)
+			}
+			if resp.Failed != len(tt.wantLines) || resp.Published != 0 {
+				t.Errorf("published %d, failed %d; want 0, %d", resp.Published, resp.Failed, len(tt.wantLines))
+			}
+			if len(resp.Results) != len(tt.wantLines) {
+				t.Fatalf("got %d results, want %d: %+v", len(resp.Results), len(tt.wantLines), resp.Results)
+			}
+			for i, result := range resp.Results {
+				if result.Line != tt.wantLines[i] || result.Status != "failed" {
+					t.Errorf("result %d = %+v, want a failure on line %d", i, result, tt.wantLines[i])
+				}
+			}
+
+			last := resp.Results[len(resp.Results)-1]
+			if got := strings.HasPrefix(last.Error, "failed to read line"); got != tt.wantRead {
+				t.Errorf("last result error = %q, want read failure %v", last.Error, tt.wantRead)
+			}
+		})
+	}
+}
+
+func TestHandleProduceStreamOutlivesServerTimeouts(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleProduceStream(w, r, nil, nil, nil, zap.NewNop())
+	}))
+	server.Config.ReadTimeout = 100 * time.Millisecond
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	// Send a line every 50ms, so the upload runs well past both timeouts
+	const lines = 8
+	body, upload := io.Pipe()
+	go func() {
+		for i := 0; i < lines; i++ {
+			fmt.Fprintf(upload, "{\"eventId\":\"evt-%d\"}\n", i)
+			time.Sleep(50 * time.Millisecond)
+		}
+		upload.Close()
+	}()
+
+	resp, err := http.Post(server.URL, "application/x-ndjson", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var result streamResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("response cut off: %v", err)
+	}
+	if len(result.Results) != lines {
+		t.Fatalf("got %d results, want %d: %+v", len(result.Results), lines, result.Results)
+	}
+	for _, r := range result.Results {
+		if strings.HasPrefix(r.Error, "failed to read line") {
+			t.Errorf("line %d: %s", r.Line, r.Error)
+		}
+	}
+}
+
+func TestValidateEventEmail(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventType string
+		email     interface{}
+		want      string // the published email, when valid
+		wantErr   string
+	}{
+		{"valid", "UserCreated", "ada@example.com", "ada@example.com", ""},
+		{"trimmed and lowercased", "UserCreated", "  Ada.Lovelace@Example.COM ", "ada.lovelace@example.com", ""},
+		{"update normalized too", "UserUpdated", "ADA@example.com", "ada@example.com", ""},
+		{"no at sign", "UserCreated", "not-an-email", "", "is not a valid address"},
+		{"display name", "UserCreated", "Ada <ada@example.com>", "", "is not a valid address"},
+		{"missing domain", "UserCreated", "ada@", "", "is not a valid address"},
+		{"empty", "UserCreated", "", "", "must not be empty"},
+		{"blank", "UserUpdated", "   ", "", "must not be empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := map[string]interface{}{"userId": "user-1", "name": "Ada", "email": tt.email}
+			event := map[string]interface{}{
+				"eventId":   "evt-1",
+				"type":      tt.eventType,
+				"timestamp": "2024-01-02T03:04:05Z",
+				"data":      data,
+			}
+
+			err := validateEvent(event)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("validateEvent() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateEvent() error = %v", err)
+			}
+			if data["email"] != tt.want {
+				t.Errorf("published email = %q, want %q", data["email"], tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleProduceRejectsInvalidEmail(t *testing.T) {
+	body := `{"eventId":"evt-1","type":"UserCreated","timestamp":"2024-01-02T03:04:05Z","data":{"userId":"user-1","name":"Ada","email":"not-an-email"}}`
+	req := httptest.NewRequest(http.MethodPost, "/produce", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	// Rejected before anything is published, so no producer is needed
+	handleProduce(rec, req, nil, nil, nil, zap.NewNop())
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), "not a valid address") {
+		t.Errorf("body = %q, want the email error", rec.Body)
+	}
+}
+
+func TestDecodeEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		strict  bool // PRODUCE_DISALLOW_UNKNOWN_FIELDS
+		wantErr string
+	}{
+		{"single object", `{"eventId":"evt-1","type":"UserCreated"}`, false, ""},
+		{"trailing whitespace", "{\"eventId\":\"evt-1\"}\n\t ", false, ""},
+		{"two objects", `{"eventId":"evt-1"}{"eventId":"evt-2"}`, false, "unexpected data after JSON object"},
+		{"trailing garbage", `{"eventId":"evt-1"} x`, false, "unexpected data after JSON object"},
+		{"trailing value", `{"eventId":"evt-1"} 5`, false, "unexpected data after JSON object"},
+		{"duplicate key", `{"eventId":"evt-1","type":"UserCreated","eventId":"evt-2"}`, false, `duplicate field "eventId"`},
+		{"duplicate nested key allowed", `{"eventId":"evt-1","data":{"name":"a","name":"b"}}`, false, ""},
+		{"array", `[{"eventId":"evt-1"}]`, false, "body must be a JSON object"},
+		{"truncated", `{"eventId":"evt-1"`, false, "unexpected end of JSON input"},
+		{"unknown field lenient", `{"eventId":"evt-1","extra":true}`, false, ""},
+		{"unknown field strict", `{"eventId":"evt-1","extra":true}`, true, `unknown field "extra"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := disallowUnknownFields
+			disallowUnknownFields = tt.strict
+			t.Cleanup(func() { disallowUnknownFields = previous })
+
+			event, err := decodeEvent(strings.NewReader(tt.body))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("decodeEvent() error = %v", err)
+				}
+				if event["eventId"] != "evt-1" {
+					t.Errorf("eventId = %v, want evt-1", event["eventId"])
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("decodeEvent() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleProduceRejectsTrailingData(t *testing.T) {
+	body := `{"eventId":"evt-1","type":"UserCreated","timestamp":"2024-01-02T03:04:05Z","data":{"userId":"user-1","name":"Ada","email":"ada@example.com"}}{"eventId":"evt-2"}`
+	req := httptest.NewRequest(http.MethodPost, "/produce", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	handleProduce(rec, req, nil, nil, nil, zap.NewNop())
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), "unexpected data after JSON object") {
+		t.Errorf("body = %q, want the trailing data error", rec.Body)
+	}
+}
+
+func TestHandleProduceValidationProfile(t *testing.T) {
+	body := `{"eventId":"evt-1","type":"OrderPlaced","timestamp":"2024-01-02 03:04:05","data":{"orderId":"order-1","userId":"user-1","total":10}}`
+
+	tests := []struct {
+		profile   validation.Profile
+		wantError bool
+	}{
+		{validation.Strict, true},
+		{validation.Lenient, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.profile), func(t *testing.T) {
+			previous := validationProfile
+			validationProfile = tt.profile
+			t.Cleanup(func() { validationProfile = previous })
+
+			event, err := decodeEvent(strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := validateEvent(event); err != nil {
+				t.Fatal(err)
+			}
+			err = checkFormats(event, zap.NewNop())
+			if (err != nil) != tt.wantError {
+				t.Fatalf("checkFormats() error = %v, want error %v", err, tt.wantError)
+			}
+			if !tt.wantError {
+				return
+			}
+
+			// Only a strict rejection reaches the client, as a 400 before publishing
+			req := httptest.NewRequest(http.MethodPost, "/produce", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			handleProduce(rec, req, nil, nil, nil, zap.NewNop())
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "RFC3339") {
+				t.Errorf("status = %d, body %q; want 400 with the timestamp error", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	var finished atomic.Bool
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		finished.Store(true)
+		io.WriteString(w, "published")
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, server, listener, 5*time.Second, zap.NewNop())
+	}()
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		results <- result{resp.StatusCode, string(body), err}
+	}()
+
+	// Signal shutdown while the request is still being handled
+	<-started
+	cancel()
+
+	res := <-results
+	if res.err != nil || res.status != http.StatusOK || res.body != "published" {
+		t.Fatalf("in-flight request = %d %q, %v; want 200 \"published\"", res.status, res.body, res.err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("serve() error = %v", err)
+	}
+	if !finished.Load() {
+		t.Error("serve returned before the in-flight request finished")
+	}
+
+	// No new connections are accepted once shut down
+	if _, err := http.Get("http://" + listener.Addr().String()); err == nil {
+		t.Error("request after shutdown succeeded, want a connection error")
+	}
+}
+
+func TestServeGivesUpAfterShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, server, listener, 100*time.Millisecond, zap.NewNop())
+	}()
+	go http.Get("http://" + listener.Addr().String())
+
+	<-started
+	cancel()
+
+	// A stuck request can't hold up shutdown past the grace period
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("serve still waiting for a stuck request after the shutdown timeout")
+	}
+}
+
+func TestServeReportsListenerFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+
+	if err := serve(context.Background(), &http.Server{}, listener, time.Second, zap.NewNop()); err == nil {
+		t.Error("serve() on a closed listener succeeded, want an error")
+	}
+}
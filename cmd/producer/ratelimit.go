@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// apiKeyHeader identifies a client for rate limiting when it carries one of the
+// configured API keys; other requests are keyed by IP
+const apiKeyHeader = "X-API-Key"
+
+// Clients whose bucket has been untouched this long are forgotten, checked every sweep interval
+const (
+	rateLimitIdleTTL       = 10 * time.Minute
+	rateLimitSweepInterval = time.Minute
+)
+
+var produceThrottledTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "produce_throttled_total",
+		Help: "Total number of produce requests rejected by the per-client rate limit",
+	},
+	[]string{"endpoint"},
+)
+
+func init() {
+	prometheus.MustRegister(produceThrottledTotal)
+}
+
+// tokenBucket holds a client's available tokens as of last
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// clientRateLimiter gives every client its own token bucket refilled at rate tokens
+// per second up to burst. Buckets of idle clients are evicted by Run, so memory is
+// bounded by the number of clients active within rateLimitIdleTTL.
+type clientRateLimiter struct {
+	rate  float64
+	burst float64
+	// apiKeys are the keys trusted to identify a client across IPs
+	apiKeys map[string]bool
+
+	mu      sync.Mutex
+	clients map[string]*tokenBucket
+}
+
+func newClientRateLimiter(rate float64, burst int, apiKeys []string) *clientRateLimiter {
+	l := &clientRateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		apiKeys: make(map[string]bool, len(apiKeys)),
+		clients: make(map[string]*tokenBucket),
+	}
+	for _, key := range apiKeys {
+		if key != "" {
+			l.apiKeys[key] = true
+		}
+	}
+	return l
+}
+
+// allow takes a token from client's bucket. When the bucket is empty it returns
+// false and how long until a token is available.
+func (l *clientRateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[client] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Run evicts idle clients every rateLimitSweepInterval until ctx is done
+func (l *clientRateLimiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(rateLimitSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			for client, b := range l.clients {
+				if now.Sub(b.last) > rateLimitIdleTTL {
+					delete(l.clients, client)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// rateLimit rejects requests over the client's rate with 429 and a Retry-After
+// header. A nil limiter lets everything through.
+func rateLimit(limiter *clientRateLimiter, endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	if limiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := limiter.allow(limiter.clientKey(r), time.Now())
+		if !ok {
+			produceThrottledTotal.WithLabelValues(endpoint).Inc()
+			httpRequestsTotal.WithLabelValues(r.Method, endpoint, "429").Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// clientKey identifies the caller by API key when it sends a configured one, else by
+// remote IP. Unknown keys are ignored, so a client can't escape its limit by sending
+// a fresh key with every request.
+func (l *clientRateLimiter) clientKey(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); l.apiKeys[key] {
+		return "key:" + key
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientKey(t *testing.T) {
+	limiter := newClientRateLimiter(1, 1, []string{"key-1", ""})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		apiKey     string
+		want       string
+	}{
+		{"configured key", "10.0.0.1:4000", "key-1", "key:key-1"},
+		{"configured key from another IP", "10.0.0.2:4000", "key-1", "key:key-1"},
+		{"unknown key", "10.0.0.1:4000", "key-2", "ip:10.0.0.1"},
+		{"no key", "10.0.0.1:4000", "", "ip:10.0.0.1"},
+		{"no port", "10.0.0.1", "", "ip:10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/produce", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.apiKey != "" {
+				r.Header.Set(apiKeyHeader, tt.apiKey)
+			}
+			if got := limiter.clientKey(r); got != tt.want {
+				t.Errorf("clientKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitIgnoresRotatedKeys(t *testing.T) {
+	limiter := newClientRateLimiter(1, 1, nil)
+	now := time.Now()
+
+	// A client sending a fresh key each time still draws from its IP's bucket
+	for i, key := range []string{"a", "b"} {
+		r := httptest.NewRequest("POST", "/produce", nil)
+		r.RemoteAddr = "10.0.0.1:4000"
+		r.Header.Set(apiKeyHeader, key)
+		ok, _ := limiter.allow(limiter.clientKey(r), now)
+		if want := i == 0; ok != want {
+			t.Errorf("request %d allowed = %v, want %v", i+1, ok, want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Record is a single accepted event in the produce-side audit log
+type Record struct {
+	AcceptedAt time.Time              `json:"acceptedAt"`
+	Headers    map[string]string      `json:"headers,omitempty"`
+	Event      map[string]interface{} `json:"event"`
+}
+
+// FileLog appends accepted events to a newline-delimited JSON file
+type FileLog struct {
+	mu     sync.Mutex
+	file   *os.File
+	logger *zap.Logger
+}
+
+func NewFileLog(path string, logger *zap.Logger) (*FileLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	return &FileLog{
+		file:   file,
+		logger: logger,
+	}, nil
+}
+
+func (l *FileLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Append writes an accepted event to the audit log. A nil FileLog is a no-op
+// so callers don't need to check whether auditing is enabled.
+func (l *FileLog) Append(event map[string]interface{}, headers map[string]string) error {
+	if l == nil {
+		return nil
+	}
+
+	jsonData, err := json.Marshal(&Record{
+		AcceptedAt: time.Now().UTC(),
+		Headers:    headers,
+		Event:      event,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Write(append(jsonData, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+
+	return nil
+}
+
+// ReadRecords calls fn for every record in the audit log at path, in the order
+// they were accepted. Iteration stops at the first error returned by fn.
+func ReadRecords(path string, fn func(*Record) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		record := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return fmt.Errorf("failed to parse audit record on line %d: %w", lineNum, err)
+		}
+
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
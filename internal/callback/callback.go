@@ -0,0 +1,173 @@
+package callback
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// Registry tracks pending completion callbacks in Redis, keyed by eventId.
+// The producer registers a URL when it accepts an event and the consumer
+// takes it once the event has been persisted.
+type Registry struct {
+	client *redis.Client
+	ttl    time.Duration
+	logger *zap.Logger
+}
+
+func NewRegistry(addr, password string, ttl time.Duration, logger *zap.Logger) (*Registry, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       0,
+	})
+
+	// Test connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &Registry{
+		client: client,
+		ttl:    ttl,
+		logger: logger,
+	}, nil
+}
+
+func (r *Registry) Close() error {
+	return r.client.Close()
+}
+
+// Ping checks that Redis can be reached
+func (r *Registry) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// Register stores the callback URL for an event. Unclaimed callbacks expire after the TTL.
+func (r *Registry) Register(ctx context.Context, eventID, callbackURL string) error {
+	if err := r.client.Set(ctx, key(eventID), callbackURL, r.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to register callback: %w", err)
+	}
+	return nil
+}
+
+// Take removes and returns the callback URL for an event, or "" if none is pending
+func (r *Registry) Take(ctx context.Context, eventID string) (string, error) {
+	pipe := r.client.TxPipeline()
+	get := pipe.Get(ctx, key(eventID))
+	pipe.Del(ctx, key(eventID))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return "", fmt.Errorf("failed to take callback: %w", err)
+	}
+
+	callbackURL, err := get.Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return callbackURL, err
+}
+
+// ValidateURL checks that a callback URL is an absolute http or https URL
+func ValidateURL(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callbackUrl must be an absolute http or https URL")
+	}
+	return nil
+}
+
+func key(eventID string) string {
+	return fmt.Sprintf("callback:%s", eventID)
+}
+
+// Notification is the body POSTed to a callback URL once an event is persisted
+type Notification struct {
+	EventID     string    `json:"eventId"`
+	Type        string    `json:"type"`
+	Status      string    `json:"status"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// Notifier delivers completion notifications, retrying failed attempts
+type Notifier struct {
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	logger      *zap.Logger
+}
+
+func NewNotifier(maxAttempts int, backoff time.Duration, logger *zap.Logger) *Notifier {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &Notifier{
+		client:      &http.Client{Timeout: 5 * time.Second},
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		logger:      logger,
+	}
+}
+
+// Notify POSTs the notification to callbackURL. Any non-2xx response counts as a
+// failure; attempts are retried with exponential backoff until maxAttempts is reached.
+func (n *Notifier) Notify(ctx context.Context, callbackURL string, notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	backoff := n.backoff
+	for attempt := 1; ; attempt++ {
+		err = n.post(ctx, callbackURL, body)
+		if err == nil {
+			return nil
+		}
+		if attempt >= n.maxAttempts {
+			return fmt.Errorf("callback failed after %d attempts: %w", attempt, err)
+		}
+
+		n.logger.Warn("Callback attempt failed, retrying",
+			zap.String("eventId", notification.EventID),
+			zap.Int("attempt", attempt),
+			zap.Error(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, callbackURL string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DLQ is a dead letter queue for messages that could not be processed
+type DLQ interface {
+	// PushMessage stores a failed message. Headers may be nil. retryCount is how many
+	// times the message had already been retried before this failure.
+	PushMessage(ctx context.Context, topic string, partition int, offset int64, headers map[string]string, payload interface{}, errorMsg string, retryCount int) error
+	// GetMessages returns a topic's stored messages newest first, as JSON entries.
+	// start and stop are inclusive indexes; negative ones count from the oldest
+	// message where the backend supports it, as for LRANGE.
+	GetMessages(ctx context.Context, topic string, start, stop int64) ([]string, error)
+	Close() error
+}
+
+// CompositeDLQ pushes every failed message to all of its queues, e.g. Redis for
+// fast triage and object storage for long-term retention
+type CompositeDLQ struct {
+	queues []DLQ
+}
+
+func NewCompositeDLQ(queues ...DLQ) *CompositeDLQ {
+	return &CompositeDLQ{queues: queues}
+}
+
+// PushMessage pushes to every queue, returning the combined error of any that failed
+func (c *CompositeDLQ) PushMessage(ctx context.Context, topic string, partition int, offset int64, headers map[string]string, payload interface{}, errorMsg string, retryCount int) error {
+	var errs []error
+	for _, q := range c.queues {
+		if err := q.PushMessage(ctx, topic, partition, offset, headers, payload, errorMsg, retryCount); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GetMessages reads from the first queue, the one each message is pushed to first
+func (c *CompositeDLQ) GetMessages(ctx context.Context, topic string, start, stop int64) ([]string, error) {
+	if len(c.queues) == 0 {
+		return []string{}, nil
+	}
+	return c.queues[0].GetMessages(ctx, topic, start, stop)
+}
+
+func (c *CompositeDLQ) Close() error {
+	var errs []error
+	for _, q := range c.queues {
+		if err := q.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// buildMessage builds the stored representation of a failed message
+func buildMessage(topic string, partition int, offset int64, headers map[string]string, payload interface{}, errorMsg string, retryCount int) map[string]interface{} {
+	dlqMsg := map[string]interface{}{
+		"eventId":    extractEventID(payload),
+		"topic":      topic,
+		"partition":  partition,
+		"offset":     offset,
+		"payload":    payload,
+		"error":      errorMsg,
+		"retryCount": retryCount,
+		"failedAt":   time.Now().UTC(),
+	}
+	if len(headers) > 0 {
+		dlqMsg["headers"] = headers
+	}
+	return dlqMsg
+}
+
+// extractEventID attempts to extract eventId from the payload
+func extractEventID(payload interface{}) string {
+	if payloadMap, ok := payload.(map[string]interface{}); ok {
+		if eventID, exists := payloadMap["eventId"]; exists {
+			if eventIDStr, ok := eventID.(string); ok {
+				return eventIDStr
+			}
+		}
+	}
+	return "unknown"
+}
+
+// extractEventType returns the event type of the payload, or "unknown" for
+// payloads that could not be parsed or carry no type
+func extractEventType(payload interface{}) string {
+	if payloadMap, ok := payload.(map[string]interface{}); ok {
+		if eventType, ok := payloadMap["type"].(string); ok && eventType != "" {
+			return eventType
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,102 @@
+package dlq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"kafka-pipeline/internal/store"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// pushIndexedScript pushes an entry onto a list, points the eventId index at it and
+// trims the list to maxLen, removing index fields whose entry was trimmed away. An
+// index field is only removed while it still holds the trimmed entry, so a newer
+// failure of the same event stays findable. It returns the list length after the
+// push, as LPUSH does.
+//
+// KEYS[1] list, KEYS[2] index; ARGV[1] entry, ARGV[2] eventId ("" if it has
+// none), ARGV[3] maxLen (0 for no cap), ARGV[4] "1" if this list owns the index.
+// It is sent with EVAL as part of the push transaction.
+const pushIndexedScript = `
+local length = redis.call('LPUSH', KEYS[1], ARGV[1])
+local indexed = ARGV[4] == '1'
+if indexed and ARGV[2] ~= '' then
+	redis.call('HSET', KEYS[2], ARGV[2], ARGV[1])
+end
+local maxLen = tonumber(ARGV[3])
+if maxLen > 0 and length > maxLen then
+	local trimmed = indexed and redis.call('LRANGE', KEYS[1], maxLen, -1) or {}
+	redis.call('LTRIM', KEYS[1], 0, maxLen - 1)
+	for _, entry in ipairs(trimmed) do
+		local ok, msg = pcall(cjson.decode, entry)
+		if ok and type(msg) == 'table' and type(msg.eventId) == 'string' then
+			if redis.call('HGET', KEYS[2], msg.eventId) == entry then
+				redis.call('HDEL', KEYS[2], msg.eventId)
+			end
+		end
+	end
+end
+return length
+`
+
+// popIndexedScript pops the oldest entry of a list and removes its index field if
+// the field still holds that entry. It returns nil when the list is empty.
+//
+// KEYS[1] list, KEYS[2] index
+var popIndexedScript = redis.NewScript(`
+local entry = redis.call('RPOP', KEYS[1])
+if not entry then
+	return false
+end
+local ok, msg = pcall(cjson.decode, entry)
+if ok and type(msg) == 'table' and type(msg.eventId) == 'string' then
+	if redis.call('HGET', KEYS[2], msg.eventId) == entry then
+		redis.call('HDEL', KEYS[2], msg.eventId)
+	end
+end
+return entry
+`)
+
+// indexedEventID returns the index field for an entry, or "" for messages without
+// an eventId, which can't be looked up
+func indexedEventID(dlqMsg map[string]interface{}) string {
+	eventID, _ := dlqMsg["eventId"].(string)
+	if eventID == "unknown" {
+		return ""
+	}
+	return eventID
+}
+
+// indexFlag encodes whether a list owns the index for pushIndexedScript
+func indexFlag(indexed bool) string {
+	if indexed {
+		return "1"
+	}
+	return "0"
+}
+
+// FindByEventID returns the latest DLQ entry for an event, or nil if the event is
+// not in the topic's DLQ. In list mode it reads the dlq:index:<topic> hash kept
+// alongside the lists, in compact mode the compacted hash itself.
+func (d *RedisDLQ) FindByEventID(ctx context.Context, topic, eventID string) (*store.DLQMessage, error) {
+	key := d.indexKey(topic)
+	if d.mode == ModeCompact {
+		key = d.compactKey(topic)
+	}
+
+	raw, err := d.client.HGet(ctx, key, eventID).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up DLQ message: %w", err)
+	}
+
+	var msg store.DLQMessage
+	if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal DLQ message: %w", err)
+	}
+	return &msg, nil
+}
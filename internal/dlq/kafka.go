@@ -0,0 +1,245 @@
+package dlq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kafka-pipeline/internal/kafka"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// Headers carrying the failure metadata on a dead-letter message
+const (
+	HeaderError      = "dlq-error"
+	HeaderTopic      = "dlq-topic"
+	HeaderPartition  = "dlq-partition"
+	HeaderOffset     = "dlq-offset"
+	HeaderRetryCount = "dlq-retry-count"
+	HeaderFailedAt   = "dlq-failed-at"
+)
+
+// KafkaDLQ produces each failed message to a dead-letter topic, keyed by eventId.
+// The message value is the original payload, so the topic can be replayed with
+// ordinary Kafka tooling, and the failure is described by the dlq-* headers.
+type KafkaDLQ struct {
+	writer  *kafkaGo.Writer
+	client  *kafkaGo.Client
+	dialer  *kafkaGo.Dialer
+	brokers []string
+	topic   string
+	logger  *zap.Logger
+}
+
+// NewKafkaDLQ creates a KafkaDLQ writing to topic, or to "<source topic>.dlq"
+// when topic is empty. Writes wait for all in-sync replicas, so a successful
+// PushMessage means the message is durably parked.
+func NewKafkaDLQ(brokers []string, topic string, security *kafka.Security, logger *zap.Logger) *KafkaDLQ {
+	return &KafkaDLQ{
+		writer: &kafkaGo.Writer{
+			Addr:                   kafkaGo.TCP(brokers...),
+			Balancer:               &kafkaGo.Hash{},
+			RequiredAcks:           kafkaGo.RequireAll,
+			Transport:              security.Transport(),
+			AllowAutoTopicCreation: true,
+		},
+		client:  &kafkaGo.Client{Addr: kafkaGo.TCP(brokers...), Transport: security.Transport(), Timeout: 10 * time.Second},
+		dialer:  security.Dialer(),
+		brokers: brokers,
+		topic:   topic,
+		logger:  logger,
+	}
+}
+
+func (d *KafkaDLQ) Close() error {
+	return d.writer.Close()
+}
+
+// topicFor returns the dead-letter topic for messages consumed from topic
+func (d *KafkaDLQ) topicFor(topic string) string {
+	if d.topic != "" {
+		return d.topic
+	}
+	return topic + ".dlq"
+}
+
+// PushMessage produces a failed message to the dead-letter topic. The original
+// headers are carried over when given, alongside the dlq-* failure headers.
+func (d *KafkaDLQ) PushMessage(ctx context.Context, topic string, partition int, offset int64, headers map[string]string, payload interface{}, errorMsg string, retryCount int) error {
+	var value []byte
+	switch p := payload.(type) {
+	case string:
+		value = []byte(p)
+	case []byte:
+		value = p
+	default:
+		var err error
+		if value, err = json.Marshal(payload); err != nil {
+			return fmt.Errorf("failed to marshal DLQ message: %w", err)
+		}
+	}
+
+	eventID := extractEventID(payload)
+	message := kafkaGo.Message{
+		Topic: d.topicFor(topic),
+		Key:   []byte(eventID),
+		Value: value,
+	}
+	for key, v := range headers {
+		if !strings.HasPrefix(key, "dlq-") {
+			message.Headers = append(message.Headers, kafkaGo.Header{Key: key, Value: []byte(v)})
+		}
+	}
+	message.Headers = append(message.Headers,
+		kafkaGo.Header{Key: HeaderError, Value: []byte(errorMsg)},
+		kafkaGo.Header{Key: HeaderTopic, Value: []byte(topic)},
+		kafkaGo.Header{Key: HeaderPartition, Value: []byte(strconv.Itoa(partition))},
+		kafkaGo.Header{Key: HeaderOffset, Value: []byte(strconv.FormatInt(offset, 10))},
+		kafkaGo.Header{Key: HeaderRetryCount, Value: []byte(strconv.Itoa(retryCount))},
+		kafkaGo.Header{Key: HeaderFailedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+	)
+
+	if err := d.writer.WriteMessages(ctx, message); err != nil {
+		return fmt.Errorf("failed to produce to DLQ topic %s: %w", message.Topic, err)
+	}
+
+	d.logger.Error("message produced to Kafka DLQ",
+		zap.String("eventId", eventID),
+		zap.String("topic", topic),
+		zap.String("dlqTopic", message.Topic),
+		zap.Int("partition", partition),
+		zap.Int64("offset", offset),
+		zap.Int("retryCount", retryCount),
+		zap.String("error", errorMsg),
+	)
+
+	return nil
+}
+
+// GetMessages returns dead letters for a topic newest first, in the same JSON form
+// as RedisDLQ entries. start and stop are inclusive indexes as for RedisDLQ, but
+// must not be negative. Only the newest stop+1 messages of each partition of the
+// dead-letter topic are read.
+func (d *KafkaDLQ) GetMessages(ctx context.Context, topic string, start, stop int64) ([]string, error) {
+	if start < 0 || stop < start {
+		return nil, fmt.Errorf("invalid range %d..%d: Kafka DLQ indexes must be non-negative", start, stop)
+	}
+	dlqTopic := d.topicFor(topic)
+
+	metadata, err := d.client.Metadata(ctx, &kafkaGo.MetadataRequest{Topics: []string{dlqTopic}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DLQ topic metadata: %w", err)
+	}
+	if len(metadata.Topics) == 0 || metadata.Topics[0].Error != nil {
+		// No dead letters have been produced yet
+		return []string{}, nil
+	}
+
+	requests := make([]kafkaGo.OffsetRequest, 0, 2*len(metadata.Topics[0].Partitions))
+	for _, p := range metadata.Topics[0].Partitions {
+		requests = append(requests, kafkaGo.FirstOffsetOf(p.ID), kafkaGo.LastOffsetOf(p.ID))
+	}
+	offsets, err := d.client.ListOffsets(ctx, &kafkaGo.ListOffsetsRequest{
+		Topics: map[string][]kafkaGo.OffsetRequest{dlqTopic: requests},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DLQ topic offsets: %w", err)
+	}
+
+	var messages []kafkaGo.Message
+	for _, p := range offsets.Topics[dlqTopic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("failed to list offsets of DLQ partition %d: %w", p.Partition, p.Error)
+		}
+		from := max(p.FirstOffset, p.LastOffset-(stop+1))
+		read, err := d.readPartition(ctx, dlqTopic, p.Partition, from, p.LastOffset)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, read...)
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Time.After(messages[j].Time)
+	})
+	if start >= int64(len(messages)) {
+		return []string{}, nil
+	}
+	messages = messages[start:min(stop+1, int64(len(messages)))]
+
+	entries := make([]string, 0, len(messages))
+	for _, message := range messages {
+		entry, err := json.Marshal(entryFromMessage(message))
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal DLQ message: %w", err)
+		}
+		entries = append(entries, string(entry))
+	}
+	return entries, nil
+}
+
+// readPartition reads the messages of a partition in [from, to)
+func (d *KafkaDLQ) readPartition(ctx context.Context, topic string, partition int, from, to int64) ([]kafkaGo.Message, error) {
+	if from >= to {
+		return nil, nil
+	}
+
+	reader := kafkaGo.NewReader(kafkaGo.ReaderConfig{
+		Brokers:   d.brokers,
+		Topic:     topic,
+		Partition: partition,
+		Dialer:    d.dialer,
+		MaxBytes:  10e6, // 10MB
+	})
+	defer reader.Close()
+
+	if err := reader.SetOffset(from); err != nil {
+		return nil, fmt.Errorf("failed to seek DLQ partition %d: %w", partition, err)
+	}
+
+	messages := make([]kafkaGo.Message, 0, to-from)
+	for {
+		message, err := reader.ReadMessage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read DLQ partition %d: %w", partition, err)
+		}
+		messages = append(messages, message)
+		if message.Offset >= to-1 {
+			return messages, nil
+		}
+	}
+}
+
+// entryFromMessage rebuilds the stored DLQ representation of a dead-letter message
+func entryFromMessage(message kafkaGo.Message) map[string]interface{} {
+	headers := kafka.HeadersToMap(message.Headers)
+
+	var payload interface{} = string(message.Value)
+	var event map[string]interface{}
+	if err := json.Unmarshal(message.Value, &event); err == nil {
+		payload = event
+	}
+
+	partition, _ := strconv.Atoi(headers[HeaderPartition])
+	offset, _ := strconv.ParseInt(headers[HeaderOffset], 10, 64)
+	retryCount, _ := strconv.Atoi(headers[HeaderRetryCount])
+
+	original := make(map[string]string, len(headers))
+	for key, v := range headers {
+		if !strings.HasPrefix(key, "dlq-") {
+			original[key] = v
+		}
+	}
+
+	entry := buildMessage(headers[HeaderTopic], partition, offset, original, payload, headers[HeaderError], retryCount)
+	if failedAt, err := time.Parse(time.RFC3339Nano, headers[HeaderFailedAt]); err == nil {
+		entry["failedAt"] = failedAt
+	}
+	return entry
+}
@@ -0,0 +1,340 @@
+package dlq
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ObjectStoreConfig configures an S3-compatible bucket for dead letters
+type ObjectStoreConfig struct {
+	Endpoint  string // e.g. https://s3.eu-west-1.amazonaws.com or http://minio:9000
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// ObjectStoreDLQ writes each failed message as an object in an S3-compatible
+// bucket, keyed by topic/date/eventId, for durable long-term retention
+type ObjectStoreDLQ struct {
+	endpoint *url.URL
+	config   ObjectStoreConfig
+	client   *http.Client
+	logger   *zap.Logger
+}
+
+func NewObjectStoreDLQ(config ObjectStoreConfig, logger *zap.Logger) (*ObjectStoreDLQ, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid object store endpoint: %s", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("object store bucket is required")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+
+	return &ObjectStoreDLQ{
+		endpoint: endpoint,
+		config:   config,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		logger:   logger,
+	}, nil
+}
+
+func (d *ObjectStoreDLQ) Close() error {
+	d.client.CloseIdleConnections()
+	return nil
+}
+
+// PushMessage writes a failed message to <topic>/<yyyy>/<mm>/<dd>/<eventId>-<partition>-<offset>.json
+func (d *ObjectStoreDLQ) PushMessage(ctx context.Context, topic string, partition int, offset int64, headers map[string]string, payload interface{}, errorMsg string, retryCount int) error {
+	dlqMsg := buildMessage(topic, partition, offset, headers, payload, errorMsg, retryCount)
+
+	jsonData, err := json.Marshal(dlqMsg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal DLQ message: %w", err)
+	}
+
+	failedAt := dlqMsg["failedAt"].(time.Time)
+	key := fmt.Sprintf("%s%s-%d-%d.json", dayPrefix(topic, failedAt), dlqMsg["eventId"], partition, offset)
+
+	resp, err := d.do(ctx, http.MethodPut, key, nil, jsonData)
+	if err != nil {
+		return fmt.Errorf("failed to write DLQ object: %w", err)
+	}
+	resp.Body.Close()
+
+	d.logger.Error("message written to object store DLQ",
+		zap.String("eventId", dlqMsg["eventId"].(string)),
+		zap.String("topic", topic),
+		zap.Int("partition", partition),
+		zap.Int64("offset", offset),
+		zap.String("key", key),
+		zap.String("error", errorMsg),
+	)
+
+	return nil
+}
+
+// ListMessages retrieves all messages that failed on the given UTC day for a topic
+func (d *ObjectStoreDLQ) ListMessages(ctx context.Context, topic string, day time.Time) ([]string, error) {
+	objects, err := d.listObjects(ctx, dayPrefix(topic, day))
+	if err != nil {
+		return nil, err
+	}
+	return d.readObjects(ctx, objects)
+}
+
+// GetMessages returns a topic's messages newest first, by the time each object was
+// written, with start and stop as for LRANGE. Every key of the topic is listed, but
+// only the objects in the range are read.
+func (d *ObjectStoreDLQ) GetMessages(ctx context.Context, topic string, start, stop int64) ([]string, error) {
+	objects, err := d.listObjects(ctx, topic+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(objects, func(i, j int) bool {
+		if !objects[i].LastModified.Equal(objects[j].LastModified) {
+			return objects[i].LastModified.After(objects[j].LastModified)
+		}
+		return objects[i].Key > objects[j].Key
+	})
+
+	n := int64(len(objects))
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return []string{}, nil
+	}
+	return d.readObjects(ctx, objects[start:stop+1])
+}
+
+// readObjects reads the bodies of objects in order
+func (d *ObjectStoreDLQ) readObjects(ctx context.Context, objects []listedObject) ([]string, error) {
+	messages := make([]string, 0, len(objects))
+	for _, object := range objects {
+		resp, err := d.do(ctx, http.MethodGet, object.Key, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read DLQ object %s: %w", object.Key, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read DLQ object %s: %w", object.Key, err)
+		}
+		messages = append(messages, string(body))
+	}
+
+	return messages, nil
+}
+
+// listedObject is an object in a ListObjectsV2 response
+type listedObject struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// listBucketResult is the subset of the ListObjectsV2 response we use
+type listBucketResult struct {
+	Contents              []listedObject `xml:"Contents"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	NextContinuationToken string         `xml:"NextContinuationToken"`
+}
+
+// listObjects lists every object under prefix, following continuation tokens
+func (d *ObjectStoreDLQ) listObjects(ctx context.Context, prefix string) ([]listedObject, error) {
+	var objects []listedObject
+	token := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := d.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list DLQ objects: %w", err)
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode object listing: %w", err)
+		}
+
+		objects = append(objects, result.Contents...)
+
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// do sends a path-style request signed with AWS Signature Version 4.
+// Non-2xx responses are returned as errors.
+func (d *ObjectStoreDLQ) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	path := "/" + d.config.Bucket
+	if key != "" {
+		path += "/" + key
+	}
+
+	reqURL := *d.endpoint
+	reqURL.Path = path
+	reqURL.RawPath = awsEscapePath(path)
+	reqURL.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	d.sign(req, body, time.Now().UTC())
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("object store returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	return resp, nil
+}
+
+// sign adds SigV4 authentication headers to req
+func (d *ObjectStoreDLQ) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Content-Type") != "" {
+		signedHeaders = []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, h := range signedHeaders {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + d.config.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	signingKey := hmacSHA256([]byte("AWS4"+d.config.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, d.config.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		d.config.AccessKey, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+// dayPrefix returns the object key prefix for a topic's messages on a UTC day
+func dayPrefix(topic string, day time.Time) string {
+	return fmt.Sprintf("%s/%s/", topic, day.UTC().Format("2006/01/02"))
+}
+
+// canonicalQuery encodes query parameters sorted by key, as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscapePath escapes each segment of an object path, keeping the slashes
+func awsEscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = awsEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// awsEscape percent-encodes everything except the SigV4 unreserved characters
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
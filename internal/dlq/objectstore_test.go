@@ -0,0 +1,159 @@
+package dlq
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeBucket is an in-memory S3 bucket serving the PUT, GET and ListObjectsV2
+// requests ObjectStoreDLQ sends. Signatures aren't checked.
+type fakeBucket struct {
+	mu      sync.Mutex
+	objects map[string]listedObject
+	bodies  map[string]string
+	now     time.Time
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/dlq"), "/")
+	switch {
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		// Each write is a second after the last, so LastModified orders them
+		b.now = b.now.Add(time.Second)
+		b.objects[key] = listedObject{Key: key, LastModified: b.now}
+		b.bodies[key] = string(body)
+	case key == "" && r.URL.Query().Get("list-type") == "2":
+		var result listBucketResult
+		for k, object := range b.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				result.Contents = append(result.Contents, object)
+			}
+		}
+		sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+		xml.NewEncoder(w).Encode(result)
+	default:
+		body, ok := b.bodies[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, body)
+	}
+}
+
+func newTestObjectStoreDLQ(t *testing.T) *ObjectStoreDLQ {
+	t.Helper()
+
+	bucket := &fakeBucket{
+		objects: map[string]listedObject{},
+		bodies:  map[string]string{},
+		now:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	server := httptest.NewServer(bucket)
+	t.Cleanup(server.Close)
+
+	d, err := NewObjectStoreDLQ(ObjectStoreConfig{Endpoint: server.URL, Bucket: "dlq"}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// eventIDs extracts the eventId of each JSON entry
+func eventIDs(t *testing.T, entries []string) []string {
+	t.Helper()
+
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		var msg struct {
+			EventID string `json:"eventId"`
+		}
+		if err := json.Unmarshal([]byte(entry), &msg); err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = msg.EventID
+	}
+	return ids
+}
+
+func TestObjectStoreDLQGetMessages(t *testing.T) {
+	ctx := context.Background()
+	d := newTestObjectStoreDLQ(t)
+
+	for i, id := range []string{"evt-a", "evt-b", "evt-c"} {
+		payload := map[string]interface{}{"eventId": id, "type": "OrderPlaced"}
+		if err := d.PushMessage(ctx, "events", 0, int64(i), nil, payload, "boom", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Another topic's dead letters aren't returned
+	if err := d.PushMessage(ctx, "audit", 0, 0, nil, map[string]interface{}{"eventId": "evt-x"}, "boom", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		start, stop int64
+		want        []string
+	}{
+		{"everything newest first", 0, -1, []string{"evt-c", "evt-b", "evt-a"}},
+		{"first page", 0, 1, []string{"evt-c", "evt-b"}},
+		{"oldest only", -1, -1, []string{"evt-a"}},
+		{"stop past the end", 1, 10, []string{"evt-b", "evt-a"}},
+		{"start past the end", 5, 10, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := d.GetMessages(ctx, "events", tt.start, tt.stop)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := eventIDs(t, entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetMessages(%d, %d) = %v, want %v", tt.start, tt.stop, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompositeDLQGetMessagesReadsFirstQueue(t *testing.T) {
+	ctx := context.Background()
+	redisDLQ, _ := newTestRedisDLQ(t, ModeList, RouteTopic)
+	objectDLQ := newTestObjectStoreDLQ(t)
+	composite := NewCompositeDLQ(redisDLQ, objectDLQ)
+
+	payload := map[string]interface{}{"eventId": "evt-a", "type": "OrderPlaced"}
+	if err := composite.PushMessage(ctx, "events", 0, 1, nil, payload, "boom", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := composite.GetMessages(ctx, "events", 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := eventIDs(t, entries); !reflect.DeepEqual(got, []string{"evt-a"}) {
+		t.Errorf("GetMessages() = %v, want [evt-a]", got)
+	}
+
+	// A DLQ of nothing has nothing stored
+	entries, err = NewCompositeDLQ().GetMessages(ctx, "events", 0, -1)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("empty GetMessages() = %v, %v; want no entries", entries, err)
+	}
+}
@@ -3,66 +3,199 @@ package dlq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
 )
 
+// Mode selects how failed messages are stored
+type Mode string
+
+const (
+	// ModeList appends every failure to a per-topic list (newest first)
+	ModeList Mode = "list"
+	// ModeCompact keeps one entry per eventId in a per-topic hash and counts attempts,
+	// so repeated failures of the same poison event don't flood the queue
+	ModeCompact Mode = "compact"
+)
+
+// ParseMode validates a mode name
+func ParseMode(value string) (Mode, error) {
+	switch Mode(value) {
+	case ModeList, ModeCompact:
+		return Mode(value), nil
+	default:
+		return "", fmt.Errorf("unknown DLQ mode: %s", value)
+	}
+}
+
+// Routing selects which list keys a failed message is pushed to in list mode
+type Routing string
+
+const (
+	// RouteTopic pushes to the per-topic list dlq:<topic>
+	RouteTopic Routing = "topic"
+	// RouteType pushes to a per-event-type list dlq:<topic>:<eventType>, so failures
+	// of one type can be inspected and replayed on their own
+	RouteType Routing = "type"
+	// RouteBoth pushes to both the per-topic and the per-type list
+	RouteBoth Routing = "both"
+)
+
+// ParseRouting validates a routing scheme name
+func ParseRouting(value string) (Routing, error) {
+	switch Routing(value) {
+	case RouteTopic, RouteType, RouteBoth:
+		return Routing(value), nil
+	default:
+		return "", fmt.Errorf("unknown DLQ routing: %s", value)
+	}
+}
+
+// ErrNoTopicList is returned by the per-topic list readers when failures are kept
+// elsewhere: in the compacted hash in compact mode, or only in the per-type lists
+// with RouteType routing
+var ErrNoTopicList = errors.New("DLQ keeps no per-topic list in compact mode or with per-type routing")
+
 type RedisDLQ struct {
-	client *redis.Client
-	logger *zap.Logger
+	client  redis.UniversalClient
+	cluster bool
+	mode    Mode
+	routing Routing
+	healthy atomic.Bool
+	logger  *zap.Logger
+
+	maxLen int64
+	onDrop func(dropped int64)
 }
 
-func NewRedisDLQ(addr, password string, logger *zap.Logger) (*RedisDLQ, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       0,
-	})
+func NewRedisDLQ(config RedisConfig, mode Mode, routing Routing, logger *zap.Logger) (*RedisDLQ, error) {
+	client, err := newRedisClient(config)
+	if err != nil {
+		return nil, err
+	}
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err := client.Ping(ctx).Result()
+	_, err = client.Ping(ctx).Result()
 	if err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
-	return &RedisDLQ{
-		client: client,
-		logger: logger,
-	}, nil
+	d := &RedisDLQ{
+		client:  client,
+		cluster: config.Mode == RedisCluster,
+		mode:    mode,
+		routing: routing,
+		logger:  logger,
+	}
+	d.healthy.Store(true)
+
+	return d, nil
 }
 
-func (d *RedisDLQ) Close() error {
-	return d.client.Close()
+// Healthy reports whether the most recent health check reached Redis
+func (d *RedisDLQ) Healthy() bool {
+	return d.healthy.Load()
+}
+
+// Ping checks that Redis can be reached
+func (d *RedisDLQ) Ping(ctx context.Context) error {
+	return d.client.Ping(ctx).Err()
 }
 
-// PushMessage pushes a failed message to the dead letter queue
-func (d *RedisDLQ) PushMessage(ctx context.Context, topic string, partition int, offset int64, payload interface{}, errorMsg string) error {
-	dlqMsg := map[string]interface{}{
-		"eventId":   extractEventID(payload),
-		"topic":     topic,
-		"partition": partition,
-		"offset":    offset,
-		"payload":   payload,
-		"error":     errorMsg,
-		"failedAt":  time.Now().UTC(),
+// MonitorHealth pings Redis every interval until ctx is cancelled, logging when the
+// connection is lost or restored. onChange, if non-nil, is called with the initial
+// state and on every transition. go-redis reconnects transparently, so this only
+// observes connectivity; it doesn't manage connections.
+func (d *RedisDLQ) MonitorHealth(ctx context.Context, interval time.Duration, onChange func(up bool)) {
+	if onChange != nil {
+		onChange(d.Healthy())
 	}
 
-	jsonData, err := json.Marshal(dlqMsg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal DLQ message: %w", err)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := d.client.Ping(pingCtx).Err()
+		cancel()
+
+		up := err == nil
+		if d.healthy.Swap(up) == up {
+			continue
+		}
+
+		if up {
+			d.logger.Info("Redis connection restored")
+		} else {
+			d.logger.Error("Redis connection lost", zap.Error(err))
+		}
+		if onChange != nil {
+			onChange(up)
+		}
 	}
+}
 
-	// Push to Redis list (newest first)
-	key := fmt.Sprintf("dlq:%s", topic)
-	err = d.client.LPush(ctx, key, jsonData).Err()
-	if err != nil {
-		return fmt.Errorf("failed to push to DLQ: %w", err)
+func (d *RedisDLQ) Close() error {
+	return d.client.Close()
+}
+
+// SetMaxLen caps every DLQ list at maxLen entries; 0 leaves them unbounded. Once a
+// list is full each push drops its oldest entries. onDrop, if non-nil, is called
+// with the number of entries dropped so discarded failures are never silent.
+func (d *RedisDLQ) SetMaxLen(maxLen int64, onDrop func(dropped int64)) {
+	d.maxLen = maxLen
+	d.onDrop = onDrop
+}
+
+// PushMessage pushes a failed message to the dead letter queue.
+// Headers are stored alongside the payload so a replay can re-attach them;
+// pass nil to omit them.
+func (d *RedisDLQ) PushMessage(ctx context.Context, topic string, partition int, offset int64, headers map[string]string, payload interface{}, errorMsg string, retryCount int) error {
+	dlqMsg := buildMessage(topic, partition, offset, headers, payload, errorMsg, retryCount)
+
+	if d.mode == ModeCompact {
+		if err := d.pushCompacted(ctx, topic, partition, offset, dlqMsg); err != nil {
+			return err
+		}
+	} else {
+		jsonData, err := json.Marshal(dlqMsg)
+		if err != nil {
+			return fmt.Errorf("failed to marshal DLQ message: %w", err)
+		}
+
+		// Push to Redis lists (newest first). The eventId index follows the
+		// per-topic list, or the per-type list when that is the only one.
+		eventID := indexedEventID(dlqMsg)
+		pipe := d.client.TxPipeline()
+		var pushes []*redis.Cmd
+		if d.routing != RouteType {
+			pushes = append(pushes, d.pushList(ctx, pipe, d.listKey(topic), jsonData, topic, eventID, true))
+		}
+		if d.routing == RouteType || d.routing == RouteBoth {
+			eventType := extractEventType(payload)
+			pushes = append(pushes, d.pushList(ctx, pipe, d.typeKey(topic, eventType), jsonData, topic, eventID, d.routing == RouteType))
+			pipe.SAdd(ctx, d.typesKey(topic), eventType)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("failed to push to DLQ: %w", err)
+		}
+		d.reportDropped(topic, pushes)
 	}
 
 	// Log the DLQ push
@@ -72,26 +205,282 @@ func (d *RedisDLQ) PushMessage(ctx context.Context, topic string, partition int,
 		zap.String("topic", topic),
 		zap.Int("partition", partition),
 		zap.Int64("offset", offset),
+		zap.Int("retryCount", retryCount),
 		zap.String("error", errorMsg),
 	)
 
 	return nil
 }
 
-// GetMessages retrieves messages from the dead letter queue
+// pushList queues an LPUSH onto key, trimmed to maxLen when a cap is set. When
+// indexed, the list owns the topic's eventId index, which is kept pointing at
+// entries still in the list.
+func (d *RedisDLQ) pushList(ctx context.Context, pipe redis.Pipeliner, key string, value []byte, topic, eventID string, indexed bool) *redis.Cmd {
+	return pipe.Eval(ctx, pushIndexedScript, []string{key, d.indexKey(topic)}, value, eventID, d.maxLen, indexFlag(indexed))
+}
+
+// reportDropped works out from the list lengths after each push how many of the
+// oldest entries LTRIM discarded
+func (d *RedisDLQ) reportDropped(topic string, pushes []*redis.Cmd) {
+	if d.maxLen <= 0 {
+		return
+	}
+
+	for _, push := range pushes {
+		length, _ := push.Int64()
+		dropped := length - d.maxLen
+		if dropped <= 0 {
+			continue
+		}
+
+		d.logger.Warn("DLQ list full, dropped oldest messages",
+			zap.String("topic", topic),
+			zap.Int64("dropped", dropped),
+			zap.Int64("maxLen", d.maxLen),
+		)
+		if d.onDrop != nil {
+			d.onDrop(dropped)
+		}
+	}
+}
+
+// pushCompacted stores the latest failure for an eventId and increments its attempt count
+func (d *RedisDLQ) pushCompacted(ctx context.Context, topic string, partition int, offset int64, dlqMsg map[string]interface{}) error {
+	// Messages without an eventId can't be deduplicated, so keep them distinct
+	field := dlqMsg["eventId"].(string)
+	if field == "unknown" {
+		field = fmt.Sprintf("unknown:%d:%d", partition, offset)
+	}
+
+	attempts, err := d.client.HIncrBy(ctx, d.attemptsKey(topic), field, 1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to increment DLQ attempts: %w", err)
+	}
+	dlqMsg["attempts"] = attempts
+
+	jsonData, err := json.Marshal(dlqMsg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal DLQ message: %w", err)
+	}
+
+	err = d.client.HSet(ctx, d.compactKey(topic), field, jsonData).Err()
+	if err != nil {
+		return fmt.Errorf("failed to push to DLQ: %w", err)
+	}
+
+	return nil
+}
+
+// HasTopicList reports whether failures are kept in the per-topic list that
+// GetMessages, StreamMessages, PopMessage and Requeue work on
+func (d *RedisDLQ) HasTopicList() bool {
+	return d.mode != ModeCompact && d.routing != RouteType
+}
+
+// GetMessages retrieves messages from the dead letter queue. It loads the whole
+// range into memory; use StreamMessages for large queues.
 func (d *RedisDLQ) GetMessages(ctx context.Context, topic string, start, stop int64) ([]string, error) {
-	key := fmt.Sprintf("dlq:%s", topic)
+	if !d.HasTopicList() {
+		return nil, ErrNoTopicList
+	}
+	key := d.listKey(topic)
 	return d.client.LRange(ctx, key, start, stop).Result()
 }
 
-// extractEventID attempts to extract eventId from the payload
-func extractEventID(payload interface{}) string {
-	if payloadMap, ok := payload.(map[string]interface{}); ok {
-		if eventID, exists := payloadMap["eventId"]; exists {
-			if eventIDStr, ok := eventID.(string); ok {
-				return eventIDStr
-			}
+// DefaultStreamChunkSize is the chunk size StreamMessages uses when none is given
+const DefaultStreamChunkSize = 500
+
+// StreamMessages walks a topic's list oldest first, passing chunks of at most
+// chunkSize messages to fn, so very large queues can be inspected without loading
+// them into memory at once. Returning an error from fn stops the walk and returns
+// that error. Indexes are counted from the tail, so messages pushed while streaming
+// don't shift the chunks; they are picked up if the walk reaches them.
+func (d *RedisDLQ) StreamMessages(ctx context.Context, topic string, chunkSize int64, fn func(chunk []string) error) error {
+	if !d.HasTopicList() {
+		return ErrNoTopicList
+	}
+	if chunkSize <= 0 {
+		chunkSize = DefaultStreamChunkSize
+	}
+	key := d.listKey(topic)
+
+	for start := int64(0); ; start += chunkSize {
+		// -1 is the oldest message; read [-(start+chunkSize), -(start+1)]
+		chunk, err := d.client.LRange(ctx, key, -(start + chunkSize), -(start + 1)).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read DLQ chunk: %w", err)
+		}
+		if len(chunk) == 0 {
+			return nil
+		}
+
+		// LRANGE returns newest first within the range; present oldest first
+		for i, j := 0, len(chunk)-1; i < j; i, j = i+1, j-1 {
+			chunk[i], chunk[j] = chunk[j], chunk[i]
+		}
+		if err := fn(chunk); err != nil {
+			return err
+		}
+
+		if int64(len(chunk)) < chunkSize {
+			return nil
+		}
+	}
+}
+
+// PopMessage removes and returns the oldest message in a topic's list, or ""
+// if the list is empty. Popping from the tail preserves FIFO retry order.
+func (d *RedisDLQ) PopMessage(ctx context.Context, topic string) (string, error) {
+	if !d.HasTopicList() {
+		return "", ErrNoTopicList
+	}
+	key := d.listKey(topic)
+	msg, err := popIndexedScript.Run(ctx, d.client, []string{key, d.indexKey(topic)}).Text()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to pop from DLQ: %w", err)
+	}
+	return msg, nil
+}
+
+// Requeue puts a previously popped message back at the head of a topic's list
+func (d *RedisDLQ) Requeue(ctx context.Context, topic string, msg string) error {
+	if !d.HasTopicList() {
+		return ErrNoTopicList
+	}
+	key := d.listKey(topic)
+
+	var dlqMsg map[string]interface{}
+	_ = json.Unmarshal([]byte(msg), &dlqMsg)
+
+	err := d.client.Eval(ctx, pushIndexedScript, []string{key, d.indexKey(topic)}, msg, indexedEventID(dlqMsg), 0, indexFlag(true)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to requeue DLQ message: %w", err)
+	}
+	return nil
+}
+
+// Count returns the number of messages in a topic's list. In compact mode it is
+// the number of distinct failed events, and with per-type routing the total across
+// the topic's per-type lists.
+func (d *RedisDLQ) Count(ctx context.Context, topic string) (int64, error) {
+	if d.mode == ModeCompact {
+		return d.client.HLen(ctx, d.compactKey(topic)).Result()
+	}
+	if d.routing == RouteType {
+		counts, err := d.CountsByType(ctx, topic)
+		if err != nil {
+			return 0, err
+		}
+		var total int64
+		for _, count := range counts {
+			total += count
+		}
+		return total, nil
+	}
+	key := d.listKey(topic)
+	return d.client.LLen(ctx, key).Result()
+}
+
+// Stats summarises a topic's DLQ. In compact mode Count is the number of distinct
+// failed events and OldestFailedAt is not tracked. With per-type routing Count is
+// the total of ByType and OldestFailedAt is not tracked either.
+type Stats struct {
+	Topic          string           `json:"topic"`
+	Count          int64            `json:"count"`
+	OldestFailedAt *time.Time       `json:"oldestFailedAt,omitempty"`
+	ByType         map[string]int64 `json:"byType,omitempty"`
+}
+
+// Stats returns the depth of a topic's DLQ, the failure time of its oldest entry
+// and, with per-type routing, the depth of each per-type list
+func (d *RedisDLQ) Stats(ctx context.Context, topic string) (*Stats, error) {
+	stats := &Stats{Topic: topic}
+
+	if d.mode == ModeCompact {
+		count, err := d.client.HLen(ctx, d.compactKey(topic)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to count compacted DLQ: %w", err)
+		}
+		stats.Count = count
+		return stats, nil
+	}
+
+	count, err := d.Count(ctx, topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count DLQ: %w", err)
+	}
+	stats.Count = count
+
+	// The oldest entry is at the tail of the list
+	if count > 0 && d.HasTopicList() {
+		oldest, err := d.client.LIndex(ctx, d.listKey(topic), -1).Result()
+		if err != nil && err != redis.Nil {
+			return nil, fmt.Errorf("failed to read oldest DLQ message: %w", err)
+		}
+		var msg struct {
+			FailedAt time.Time `json:"failedAt"`
+		}
+		if json.Unmarshal([]byte(oldest), &msg) == nil && !msg.FailedAt.IsZero() {
+			stats.OldestFailedAt = &msg.FailedAt
+		}
+	}
+
+	if d.routing == RouteType || d.routing == RouteBoth {
+		byType, err := d.CountsByType(ctx, topic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count DLQ by type: %w", err)
+		}
+		stats.ByType = byType
+	}
+
+	return stats, nil
+}
+
+// GetCompactedMessages retrieves the compacted DLQ entries for a topic keyed by eventId.
+// Each entry carries an "attempts" count of how many times that event has failed.
+func (d *RedisDLQ) GetCompactedMessages(ctx context.Context, topic string) (map[string]string, error) {
+	key := d.compactKey(topic)
+	return d.client.HGetAll(ctx, key).Result()
+}
+
+// GetMessagesByType retrieves messages from a topic's per-type list (RouteType or RouteBoth)
+func (d *RedisDLQ) GetMessagesByType(ctx context.Context, topic, eventType string, start, stop int64) ([]string, error) {
+	return d.client.LRange(ctx, d.typeKey(topic, eventType), start, stop).Result()
+}
+
+// CountByType returns the number of messages in a topic's per-type list
+func (d *RedisDLQ) CountByType(ctx context.Context, topic, eventType string) (int64, error) {
+	return d.client.LLen(ctx, d.typeKey(topic, eventType)).Result()
+}
+
+// EventTypes returns every event type that has had a per-type list for a topic
+func (d *RedisDLQ) EventTypes(ctx context.Context, topic string) ([]string, error) {
+	types, err := d.client.SMembers(ctx, d.typesKey(topic)).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(types)
+	return types, nil
+}
+
+// CountsByType returns the combined view of a topic's per-type lists: the number of
+// messages queued for each event type
+func (d *RedisDLQ) CountsByType(ctx context.Context, topic string) (map[string]int64, error) {
+	types, err := d.EventTypes(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(types))
+	for _, eventType := range types {
+		count, err := d.CountByType(ctx, topic, eventType)
+		if err != nil {
+			return nil, err
 		}
+		counts[eventType] = count
 	}
-	return "unknown"
+	return counts, nil
 }
@@ -0,0 +1,226 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"go.uber.org/zap"
+)
+
+// newTestRedisDLQ returns a RedisDLQ backed by an in-process Redis
+func newTestRedisDLQ(t *testing.T, mode Mode, routing Routing) (*RedisDLQ, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	d, err := NewRedisDLQ(RedisConfig{Addrs: []string{server.Addr()}}, mode, routing, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d, server
+}
+
+// pushEvents pushes one failure per event type, in order
+func pushEvents(t *testing.T, d *RedisDLQ, topic string, eventTypes ...string) {
+	t.Helper()
+
+	for i, eventType := range eventTypes {
+		payload := map[string]interface{}{"eventId": fmt.Sprintf("evt-%d", i), "type": eventType}
+		if err := d.PushMessage(context.Background(), topic, 0, int64(i), nil, payload, "boom", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRedisDLQRouting(t *testing.T) {
+	tests := []struct {
+		routing   Routing
+		wantCount int64
+		wantList  int
+		wantTypes map[string]int64
+	}{
+		{RouteTopic, 3, 3, nil},
+		{RouteType, 3, 0, map[string]int64{"OrderPlaced": 2, "UserCreated": 1}},
+		{RouteBoth, 3, 3, map[string]int64{"OrderPlaced": 2, "UserCreated": 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.routing), func(t *testing.T) {
+			ctx := context.Background()
+			d, _ := newTestRedisDLQ(t, ModeList, tt.routing)
+			pushEvents(t, d, "events", "OrderPlaced", "UserCreated", "OrderPlaced")
+
+			count, err := d.Count(ctx, "events")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != tt.wantCount {
+				t.Errorf("Count() = %d, want %d", count, tt.wantCount)
+			}
+
+			stats, err := d.Stats(ctx, "events")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Count != tt.wantCount {
+				t.Errorf("Stats().Count = %d, want %d", stats.Count, tt.wantCount)
+			}
+			if len(stats.ByType) != len(tt.wantTypes) {
+				t.Errorf("Stats().ByType = %v, want %v", stats.ByType, tt.wantTypes)
+			}
+			for eventType, want := range tt.wantTypes {
+				if stats.ByType[eventType] != want {
+					t.Errorf("Stats().ByType[%s] = %d, want %d", eventType, stats.ByType[eventType], want)
+				}
+			}
+
+			messages, err := d.GetMessages(ctx, "events", 0, -1)
+			if tt.routing == RouteType {
+				if !errors.Is(err, ErrNoTopicList) {
+					t.Errorf("GetMessages() error = %v, want ErrNoTopicList", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != tt.wantList {
+				t.Errorf("GetMessages() returned %d messages, want %d", len(messages), tt.wantList)
+			}
+		})
+	}
+}
+
+func TestRedisDLQTopicListReadersRejectTypeRouting(t *testing.T) {
+	ctx := context.Background()
+	d, _ := newTestRedisDLQ(t, ModeList, RouteType)
+	pushEvents(t, d, "events", "OrderPlaced")
+
+	if d.HasTopicList() {
+		t.Error("HasTopicList() = true with per-type routing")
+	}
+	if _, err := d.PopMessage(ctx, "events"); !errors.Is(err, ErrNoTopicList) {
+		t.Errorf("PopMessage() error = %v, want ErrNoTopicList", err)
+	}
+	if err := d.Requeue(ctx, "events", "{}"); !errors.Is(err, ErrNoTopicList) {
+		t.Errorf("Requeue() error = %v, want ErrNoTopicList", err)
+	}
+	err := d.StreamMessages(ctx, "events", 10, func([]string) error { return nil })
+	if !errors.Is(err, ErrNoTopicList) {
+		t.Errorf("StreamMessages() error = %v, want ErrNoTopicList", err)
+	}
+
+	// The per-type list still holds the failure
+	messages, err := d.GetMessagesByType(ctx, "events", "OrderPlaced", 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Errorf("GetMessagesByType() returned %d messages, want 1", len(messages))
+	}
+}
+
+func TestRedisDLQCompactMode(t *testing.T) {
+	ctx := context.Background()
+	d, _ := newTestRedisDLQ(t, ModeCompact, RouteTopic)
+
+	// The same event failing twice is one entry
+	pushEvents(t, d, "events", "OrderPlaced", "UserCreated")
+	pushEvents(t, d, "events", "OrderPlaced")
+
+	count, err := d.Count(ctx, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Count() = %d, want 2", count)
+	}
+
+	if d.HasTopicList() {
+		t.Error("HasTopicList() = true in compact mode")
+	}
+	if _, err := d.GetMessages(ctx, "events", 0, -1); !errors.Is(err, ErrNoTopicList) {
+		t.Errorf("GetMessages() error = %v, want ErrNoTopicList", err)
+	}
+	if _, err := d.PopMessage(ctx, "events"); !errors.Is(err, ErrNoTopicList) {
+		t.Errorf("PopMessage() error = %v, want ErrNoTopicList", err)
+	}
+	err = d.StreamMessages(ctx, "events", 10, func([]string) error { return nil })
+	if !errors.Is(err, ErrNoTopicList) {
+		t.Errorf("StreamMessages() error = %v, want ErrNoTopicList", err)
+	}
+
+	entries, err := d.GetCompactedMessages(ctx, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("GetCompactedMessages() returned %d entries, want 2", len(entries))
+	}
+}
+
+func TestRedisDLQMaxLen(t *testing.T) {
+	tests := []struct {
+		routing     Routing
+		wantDropped int64 // summed over every capped list
+	}{
+		{RouteTopic, 2},
+		{RouteType, 2},
+		{RouteBoth, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.routing), func(t *testing.T) {
+			ctx := context.Background()
+			d, _ := newTestRedisDLQ(t, ModeList, tt.routing)
+
+			var dropped int64
+			d.SetMaxLen(3, func(n int64) { dropped += n })
+			pushEvents(t, d, "events", "OrderPlaced", "OrderPlaced", "OrderPlaced", "OrderPlaced", "OrderPlaced")
+
+			// The oldest entries are dropped so the newest failures survive
+			if tt.routing != RouteType {
+				messages, err := d.GetMessages(ctx, "events", 0, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := eventIDs(t, messages); !reflect.DeepEqual(got, []string{"evt-4", "evt-3", "evt-2"}) {
+					t.Errorf("topic list = %v, want [evt-4 evt-3 evt-2]", got)
+				}
+			}
+			if tt.routing != RouteTopic {
+				messages, err := d.GetMessagesByType(ctx, "events", "OrderPlaced", 0, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := eventIDs(t, messages); !reflect.DeepEqual(got, []string{"evt-4", "evt-3", "evt-2"}) {
+					t.Errorf("type list = %v, want [evt-4 evt-3 evt-2]", got)
+				}
+			}
+
+			if dropped != tt.wantDropped {
+				t.Errorf("dropped = %d, want %d", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestRedisDLQUnbounded(t *testing.T) {
+	ctx := context.Background()
+	d, _ := newTestRedisDLQ(t, ModeList, RouteTopic)
+
+	var dropped int64
+	d.SetMaxLen(0, func(n int64) { dropped += n })
+	pushEvents(t, d, "events", "OrderPlaced", "OrderPlaced", "OrderPlaced", "OrderPlaced", "OrderPlaced")
+
+	if count, err := d.Count(ctx, "events"); err != nil || count != 5 {
+		t.Errorf("Count() = %d, %v; want 5", count, err)
+	}
+	if dropped != 0 {
+		t.Errorf("dropped = %d with no cap, want 0", dropped)
+	}
+}
@@ -0,0 +1,142 @@
+package dlq
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisMode selects how the DLQ connects to Redis
+type RedisMode string
+
+const (
+	// RedisStandalone connects to a single Redis server (the default)
+	RedisStandalone RedisMode = "standalone"
+	// RedisCluster connects to a Redis Cluster through any of its nodes
+	RedisCluster RedisMode = "cluster"
+	// RedisSentinel connects to the master Sentinel reports for MasterName and
+	// follows it across failovers
+	RedisSentinel RedisMode = "sentinel"
+)
+
+// ParseRedisMode validates a Redis mode name; "" is RedisStandalone
+func ParseRedisMode(value string) (RedisMode, error) {
+	switch RedisMode(value) {
+	case "":
+		return RedisStandalone, nil
+	case RedisStandalone, RedisCluster, RedisSentinel:
+		return RedisMode(value), nil
+	default:
+		return "", fmt.Errorf("unknown Redis mode: %s", value)
+	}
+}
+
+// RedisConfig configures the DLQ's Redis connection
+type RedisConfig struct {
+	Mode RedisMode
+	// Addrs is the server address in standalone mode, the seed nodes in cluster
+	// mode and the Sentinel addresses in sentinel mode
+	Addrs    []string
+	Password string
+	// MasterName and SentinelPassword are only used in sentinel mode
+	MasterName       string
+	SentinelPassword string
+
+	// PoolSize is the maximum number of connections per node; 0 keeps the go-redis
+	// default of 10 per CPU
+	PoolSize     int
+	MinIdleConns int
+	// DialTimeout bounds establishing a connection; 0 keeps the go-redis default of 5s
+	DialTimeout time.Duration
+}
+
+// newRedisClient creates the client for config's mode
+func newRedisClient(config RedisConfig) (redis.UniversalClient, error) {
+	if len(config.Addrs) == 0 {
+		return nil, fmt.Errorf("no Redis address configured")
+	}
+
+	switch config.Mode {
+	case "", RedisStandalone:
+		if len(config.Addrs) > 1 {
+			return nil, fmt.Errorf("standalone Redis takes one address, got %d", len(config.Addrs))
+		}
+		return redis.NewClient(&redis.Options{
+			Addr:         config.Addrs[0],
+			Password:     config.Password,
+			DB:           0,
+			PoolSize:     config.PoolSize,
+			MinIdleConns: config.MinIdleConns,
+			DialTimeout:  config.DialTimeout,
+		}), nil
+	case RedisCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        config.Addrs,
+			Password:     config.Password,
+			PoolSize:     config.PoolSize,
+			MinIdleConns: config.MinIdleConns,
+			DialTimeout:  config.DialTimeout,
+		}), nil
+	case RedisSentinel:
+		if config.MasterName == "" {
+			return nil, fmt.Errorf("sentinel mode needs a master name")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       config.MasterName,
+			SentinelAddrs:    config.Addrs,
+			SentinelPassword: config.SentinelPassword,
+			Password:         config.Password,
+			DB:               0,
+			PoolSize:         config.PoolSize,
+			MinIdleConns:     config.MinIdleConns,
+			DialTimeout:      config.DialTimeout,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown Redis mode: %s", config.Mode)
+	}
+}
+
+// A topic's lists, index and type set are written together in one transaction or
+// script, which Redis Cluster only allows for keys in the same hash slot. In
+// cluster mode every key embeds the topic as a {topic} hash tag, so all of a
+// topic's keys hash alike while different topics still spread across the
+// cluster. Other modes keep the plain key names, so existing queues stay readable.
+
+// slot returns the part of a key naming the topic, hash-tagged in cluster mode
+func (d *RedisDLQ) slot(topic string) string {
+	if d.cluster {
+		return "{" + topic + "}"
+	}
+	return topic
+}
+
+// listKey is the per-topic list of failed messages
+func (d *RedisDLQ) listKey(topic string) string {
+	return "dlq:" + d.slot(topic)
+}
+
+// compactKey is the hash of a topic's latest failure per eventId in compact mode
+func (d *RedisDLQ) compactKey(topic string) string {
+	return "dlq:compact:" + d.slot(topic)
+}
+
+// attemptsKey is the hash of a topic's failure counts per eventId in compact mode
+func (d *RedisDLQ) attemptsKey(topic string) string {
+	return "dlq:attempts:" + d.slot(topic)
+}
+
+// typeKey is the list holding a topic's failed messages of one event type
+func (d *RedisDLQ) typeKey(topic, eventType string) string {
+	return "dlq:" + d.slot(topic) + ":" + eventType
+}
+
+// typesKey is the set of event types that have a per-type list for a topic
+func (d *RedisDLQ) typesKey(topic string) string {
+	return "dlq:types:" + d.slot(topic)
+}
+
+// indexKey is the hash mapping a topic's eventIds to their latest list entry
+func (d *RedisDLQ) indexKey(topic string) string {
+	return "dlq:index:" + d.slot(topic)
+}
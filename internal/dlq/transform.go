@@ -0,0 +1,200 @@
+package dlq
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Transform rewrites a DLQ entry's original event before it is replayed, to repair
+// a data defect that made it fail. It may modify and return the event it is given.
+// Returning an error rejects the event, which is left in the DLQ.
+type Transform func(event map[string]interface{}) (map[string]interface{}, error)
+
+// Chain returns a Transform applying transforms in order, stopping at the first
+// rejection; with no transforms it returns nil
+func Chain(transforms ...Transform) Transform {
+	if len(transforms) == 0 {
+		return nil
+	}
+	return func(event map[string]interface{}) (map[string]interface{}, error) {
+		var err error
+		for _, transform := range transforms {
+			if event, err = transform(event); err != nil {
+				return nil, err
+			}
+		}
+		return event, nil
+	}
+}
+
+// fixer is a built-in Transform. A fixer that takes an argument is selected as
+// name=arg.
+type fixer struct {
+	usage string
+	build func(arg string) (Transform, error)
+}
+
+var fixers = map[string]fixer{
+	"rename-field": {
+		usage: "rename-field=old:new renames a data field; rejects events carrying both names",
+		build: renameField,
+	},
+	"fix-timestamps": {
+		usage: "fix-timestamps rewrites the event timestamp and data fields ending in At that are epoch seconds or RFC 3339 without a zone (assumed UTC) or with a space for the T",
+		build: noArg(fixTimestamps),
+	},
+	"trim-strings": {
+		usage: "trim-strings removes leading and trailing whitespace from string data fields",
+		build: noArg(trimStrings),
+	},
+	"lowercase-email": {
+		usage: "lowercase-email lowercases the email data field",
+		build: noArg(lowercaseEmail),
+	},
+}
+
+// ParseFixer returns the built-in Transform named by spec, name or name=arg
+func ParseFixer(spec string) (Transform, error) {
+	name, arg, _ := strings.Cut(spec, "=")
+	f, ok := fixers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown fixer: %s", name)
+	}
+	return f.build(arg)
+}
+
+// FixerUsage describes each built-in fixer, one per line, sorted by name
+func FixerUsage() string {
+	names := make([]string, 0, len(fixers))
+	for name := range fixers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = fixers[name].usage
+	}
+	return strings.Join(lines, "\n")
+}
+
+// noArg adapts a fixer taking no argument
+func noArg(transform Transform) func(arg string) (Transform, error) {
+	return func(arg string) (Transform, error) {
+		if arg != "" {
+			return nil, fmt.Errorf("fixer takes no argument: %s", arg)
+		}
+		return transform, nil
+	}
+}
+
+// eventData returns an event's data object, rejecting events without one
+func eventData(event map[string]interface{}) (map[string]interface{}, error) {
+	data, ok := event["data"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("event has no data object")
+	}
+	return data, nil
+}
+
+func renameField(arg string) (Transform, error) {
+	from, to, ok := strings.Cut(arg, ":")
+	if !ok || from == "" || to == "" {
+		return nil, fmt.Errorf("rename-field needs old:new, got %q", arg)
+	}
+
+	return func(event map[string]interface{}) (map[string]interface{}, error) {
+		data, err := eventData(event)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := data[from]
+		if !ok {
+			return event, nil
+		}
+		if _, exists := data[to]; exists {
+			return nil, fmt.Errorf("data has both %s and %s", from, to)
+		}
+		delete(data, from)
+		data[to] = value
+		return event, nil
+	}, nil
+}
+
+// timestampLayouts are the malformed timestamp formats fix-timestamps accepts,
+// each read as UTC
+var timestampLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// maxEpochSeconds separates epoch seconds from the epoch milliseconds events may
+// already carry: 1e11 seconds is in the year 5138, 1e11 milliseconds in 1973
+const maxEpochSeconds = 1e11
+
+func fixTimestamps(event map[string]interface{}) (map[string]interface{}, error) {
+	if err := fixTimestamp(event, "timestamp"); err != nil {
+		return nil, err
+	}
+	if data, ok := event["data"].(map[string]interface{}); ok {
+		for key := range data {
+			if strings.HasSuffix(key, "At") {
+				if err := fixTimestamp(data, key); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return event, nil
+}
+
+// fixTimestamp rewrites fields[key] as RFC 3339 if it is a timestamp in a format
+// fix-timestamps repairs, leaving valid and absent values alone
+func fixTimestamp(fields map[string]interface{}, key string) error {
+	switch value := fields[key].(type) {
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < maxEpochSeconds {
+			fields[key] = time.Unix(int64(value), 0).UTC().Format(time.RFC3339)
+		}
+	case string:
+		if _, err := time.Parse(time.RFC3339, value); err == nil {
+			return nil
+		}
+		for _, layout := range timestampLayouts {
+			if parsed, err := time.Parse(layout, value); err == nil {
+				fields[key] = parsed.UTC().Format(time.RFC3339Nano)
+				return nil
+			}
+		}
+		return fmt.Errorf("%s %q is not a timestamp fix-timestamps can repair", key, value)
+	}
+	return nil
+}
+
+func trimStrings(event map[string]interface{}) (map[string]interface{}, error) {
+	data, err := eventData(event)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range data {
+		if s, ok := value.(string); ok {
+			data[key] = strings.TrimSpace(s)
+		}
+	}
+	return event, nil
+}
+
+func lowercaseEmail(event map[string]interface{}) (map[string]interface{}, error) {
+	data, err := eventData(event)
+	if err != nil {
+		return nil, err
+	}
+	if email, ok := data["email"].(string); ok {
+		data["email"] = strings.ToLower(email)
+	}
+	return event, nil
+}
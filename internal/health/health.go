@@ -0,0 +1,68 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Check reports whether a dependency is reachable
+type Check func(ctx context.Context) error
+
+// Live answers liveness probes: if the process can serve the request, it is up
+func Live(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+type readiness struct {
+	Status string   `json:"status"`
+	Failed []string `json:"failed,omitempty"`
+}
+
+// Ready returns a readiness handler that runs every check concurrently within
+// timeout. It responds 200 when all pass and 503 naming the failed checks otherwise.
+func Ready(checks map[string]Check, timeout time.Duration, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		var (
+			mu     sync.Mutex
+			wg     sync.WaitGroup
+			failed []string
+		)
+		for name, check := range checks {
+			wg.Add(1)
+			go func(name string, check Check) {
+				defer wg.Done()
+				if err := check(ctx); err != nil {
+					logger.Warn("Readiness check failed", zap.String("dependency", name), zap.Error(err))
+					mu.Lock()
+					failed = append(failed, name)
+					mu.Unlock()
+				}
+			}(name, check)
+		}
+		wg.Wait()
+
+		// Prepare response
+		response := readiness{Status: "ok"}
+		status := http.StatusOK
+		if len(failed) > 0 {
+			sort.Strings(failed)
+			response = readiness{Status: "unavailable", Failed: failed}
+			status = http.StatusServiceUnavailable
+		}
+
+		// Set content type and write response
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}
+}
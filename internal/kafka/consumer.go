@@ -4,41 +4,189 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 	"go.uber.org/zap"
 )
 
+// CommitHook is called after a processed message's offset has been committed.
+// Hooks run after the at-least-once boundary: the message will not be
+// redelivered, so a failing hook is logged but never causes reprocessing.
+type CommitHook func(ctx context.Context, message *kafka.Message, event map[string]interface{})
+
 type Consumer struct {
 	reader *kafka.Reader
-	logger *zap.Logger
+	// grouped is false for partition readers, which have no offsets to commit
+	grouped bool
+	// readOnly consumers never commit
+	readOnly bool
+	hooks    []CommitHook
+	logger   *zap.Logger
+
+	// client, topic and groupID let PartitionLag query offsets outside the reader
+	client  *kafka.Client
+	topic   string
+	groupID string
+
+	// held maps a partition to the offset of a message that could be neither
+	// processed nor parked; see Hold
+	holdMu sync.Mutex
+	held   map[int]int64
+
+	stats statsTotals
+
+	commitRetries   int
+	commitBackoff   time.Duration
+	onCommitRetry   func()
+	onCommitFailure func(error)
+}
+
+// Default commit retry policy
+const (
+	defaultCommitRetries = 3
+	defaultCommitBackoff = 100 * time.Millisecond
+)
+
+// ConsumerConfig holds the group membership and fetch tuning for a Consumer.
+// Zero values fall back to the kafka-go defaults.
+//
+// The broker evicts a member that misses heartbeats for SessionTimeout, and
+// kafka-go heartbeats from a background goroutine, so slow processing alone
+// doesn't trigger eviction. Long GC pauses or a starved process can, so raise
+// SessionTimeout (keeping HeartbeatInterval at roughly a third of it or less)
+// if you see unexplained rebalances. RebalanceTimeout bounds how long members
+// get to rejoin during a rebalance and should exceed the longest time spent
+// processing a single message. MaxWait is how long a fetch waits for MinBytes.
+// Security is nil for plaintext brokers. ReadOnly fetches messages without ever
+// committing offsets, for dry runs against a live topic. CommitMode selects how
+// processed offsets reach the broker, see CommitMode; CommitInterval is the
+// CommitAuto flush period and defaults to a second. StartOffset only applies to
+// partitions the group has never committed on.
+type ConsumerConfig struct {
+	SessionTimeout    time.Duration
+	HeartbeatInterval time.Duration
+	RebalanceTimeout  time.Duration
+	MaxWait           time.Duration
+	Security          *Security
+	ReadOnly          bool
+	CommitMode        CommitMode
+	CommitInterval    time.Duration
+	StartOffset       StartOffset
+}
+
+// CommitMode is how a Consumer commits offsets. Reading a message never commits
+// it in either mode: only messages passed to CommitMessages are committed, once
+// they've been processed or parked in the DLQ.
+type CommitMode string
+
+const (
+	// CommitManual commits synchronously inside CommitMessages, so an offset is on
+	// the broker before the next message is processed. This is the default.
+	CommitManual CommitMode = "manual"
+	// CommitAuto queues offsets in CommitMessages and flushes them from the
+	// background every CommitInterval, trading up to an interval of redelivery
+	// after a crash for far fewer commit requests
+	CommitAuto CommitMode = "auto"
+)
+
+// defaultCommitInterval is the CommitAuto flush period when none is configured
+const defaultCommitInterval = time.Second
+
+// ParseCommitMode maps manual (the default) or auto to its CommitMode
+func ParseCommitMode(name string) (CommitMode, error) {
+	switch CommitMode(name) {
+	case "", CommitManual:
+		return CommitManual, nil
+	case CommitAuto:
+		return CommitAuto, nil
+	default:
+		return "", fmt.Errorf("unknown commit mode: %s", name)
+	}
 }
 
-func NewConsumer(brokers []string, topic, groupID string, logger *zap.Logger) *Consumer {
+func NewConsumer(brokers []string, topic, groupID string, config ConsumerConfig, logger *zap.Logger) *Consumer {
+	// A zero CommitInterval makes kafka-go commit synchronously
+	var commitInterval time.Duration
+	if config.CommitMode == CommitAuto {
+		commitInterval = config.CommitInterval
+		if commitInterval <= 0 {
+			commitInterval = defaultCommitInterval
+		}
+	}
+
 	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:        brokers,
-		Topic:          topic,
-		GroupID:        groupID,
-		MinBytes:       10e3, // 10KB
-		MaxBytes:       10e6, // 10MB
-		CommitInterval: time.Second,
-		StartOffset:    kafka.LastOffset,
+		Brokers:           brokers,
+		Topic:             topic,
+		GroupID:           groupID,
+		MinBytes:          10e3, // 10KB
+		MaxBytes:          10e6, // 10MB
+		MaxWait:           config.MaxWait,
+		Dialer:            config.Security.Dialer(),
+		SessionTimeout:    config.SessionTimeout,
+		HeartbeatInterval: config.HeartbeatInterval,
+		RebalanceTimeout:  config.RebalanceTimeout,
+		CommitInterval:    commitInterval,
+		StartOffset:       config.StartOffset.readerOffset(),
 	})
 
 	return &Consumer{
-		reader: reader,
-		logger: logger,
+		reader:        reader,
+		grouped:       true,
+		readOnly:      config.ReadOnly,
+		logger:        logger,
+		client:        &kafka.Client{Addr: kafka.TCP(brokers...), Transport: config.Security.Transport(), Timeout: 10 * time.Second},
+		topic:         topic,
+		groupID:       groupID,
+		commitRetries: defaultCommitRetries,
+		commitBackoff: defaultCommitBackoff,
 	}
 }
 
+// NewLatestConsumers creates one group-less reader per partition of topic, each
+// starting at the partition's latest offset. They never commit offsets.
+func NewLatestConsumers(brokers []string, topic string, security *Security, logger *zap.Logger) ([]*Consumer, error) {
+	dialer := security.Dialer()
+	conn, err := dialer.Dial("tcp", brokers[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial broker: %w", err)
+	}
+	defer conn.Close()
+
+	partitions, err := conn.ReadPartitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read partitions: %w", err)
+	}
+
+	consumers := make([]*Consumer, 0, len(partitions))
+	for _, p := range partitions {
+		reader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     brokers,
+			Topic:       topic,
+			Partition:   p.ID,
+			Dialer:      dialer,
+			MinBytes:    10e3, // 10KB
+			MaxBytes:    10e6, // 10MB
+			StartOffset: kafka.LastOffset,
+		})
+		consumers = append(consumers, &Consumer{
+			reader: reader,
+			logger: logger,
+		})
+	}
+
+	return consumers, nil
+}
+
 func (c *Consumer) Close() error {
 	return c.reader.Close()
 }
 
-// ReadMessage reads a message from Kafka
+// ReadMessage reads a message from Kafka without committing it; the offset is
+// committed by CommitMessages once the message has been handled
 func (c *Consumer) ReadMessage(ctx context.Context) (*kafka.Message, error) {
-	message, err := c.reader.ReadMessage(ctx)
+	message, err := c.reader.FetchMessage(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read message: %w", err)
 	}
@@ -48,7 +196,119 @@ func (c *Consumer) ReadMessage(ctx context.Context) (*kafka.Message, error) {
 
 // CommitMessage commits the offset for a message
 func (c *Consumer) CommitMessage(ctx context.Context, message *kafka.Message) error {
-	return c.reader.CommitMessages(ctx, *message)
+	return c.CommitMessages(ctx, *message)
+}
+
+// SetCommitRetry configures how failed commits are retried: up to maxRetries
+// further attempts, with the wait starting at backoff and doubling each time.
+// onRetry, if non-nil, is called before each retry and onFailure once retries
+// are exhausted.
+func (c *Consumer) SetCommitRetry(maxRetries int, backoff time.Duration, onRetry func(), onFailure func(error)) {
+	c.commitRetries = maxRetries
+	c.commitBackoff = backoff
+	c.onCommitRetry = onRetry
+	c.onCommitFailure = onFailure
+}
+
+// CommitMessages commits the offsets for a batch of messages, retrying transient
+// failures. If every attempt fails the offsets are left uncommitted and the
+// messages will be redelivered after a restart or rebalance. In CommitAuto mode
+// the offsets are only queued for the next background flush.
+// Messages past a held offset on their partition are skipped; see Hold.
+// It is a no-op for partition readers, which are not part of a consumer group,
+// and for read-only consumers.
+func (c *Consumer) CommitMessages(ctx context.Context, messages ...kafka.Message) error {
+	if !c.grouped || c.readOnly {
+		return nil
+	}
+
+	messages = c.withoutHeld(messages)
+	if len(messages) == 0 {
+		return nil
+	}
+
+	err := c.reader.CommitMessages(ctx, messages...)
+	backoff := c.commitBackoff
+	for attempt := 1; attempt <= c.commitRetries && err != nil && ctx.Err() == nil; attempt++ {
+		c.logger.Warn("Failed to commit offsets, retrying",
+			zap.Int("attempt", attempt),
+			zap.Int("maxRetries", c.commitRetries),
+			zap.Error(err),
+		)
+		if c.onCommitRetry != nil {
+			c.onCommitRetry()
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+
+		err = c.reader.CommitMessages(ctx, messages...)
+	}
+
+	if err != nil {
+		last := messages[len(messages)-1]
+		c.logger.Error("Giving up on offset commit, messages will be redelivered",
+			zap.String("topic", last.Topic),
+			zap.Int("partition", last.Partition),
+			zap.Int64("offset", last.Offset),
+			zap.Int("messages", len(messages)),
+			zap.Error(err),
+		)
+		if c.onCommitFailure != nil {
+			c.onCommitFailure(err)
+		}
+	}
+	return err
+}
+
+// OnCommit registers a hook to run after each processed message is committed.
+// Hooks run synchronously on the consuming goroutine, so they should be quick.
+func (c *Consumer) OnCommit(hook CommitHook) {
+	c.hooks = append(c.hooks, hook)
+}
+
+// CommitEvent commits a successfully processed message and then runs the commit hooks
+func (c *Consumer) CommitEvent(ctx context.Context, message *kafka.Message, event map[string]interface{}) error {
+	if err := c.CommitMessage(ctx, message); err != nil {
+		return err
+	}
+
+	c.RunCommitHooks(ctx, message, event)
+	return nil
+}
+
+// RunCommitHooks runs the commit hooks for a message whose offset is already committed.
+// Messages whose commit was withheld by a partition hold are skipped, since they
+// will be redelivered. A panicking hook is recovered and logged so it can't take
+// down the consumer.
+func (c *Consumer) RunCommitHooks(ctx context.Context, message *kafka.Message, event map[string]interface{}) {
+	if c.isHeld(message) {
+		return
+	}
+	for _, hook := range c.hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					c.logger.Error("commit hook panicked",
+						zap.String("topic", message.Topic),
+						zap.Int("partition", message.Partition),
+						zap.Int64("offset", message.Offset),
+						zap.Any("panic", r),
+					)
+				}
+			}()
+			hook(ctx, message, event)
+		}()
+	}
+}
+
+// Lag returns how many messages the reader is behind the partition high-watermark
+func (c *Consumer) Lag() int64 {
+	return c.Stats().Lag
 }
 
 // ParseEvent parses a Kafka message into an event structure
@@ -68,13 +328,63 @@ func (c *Consumer) ParseEvent(message *kafka.Message) (map[string]interface{}, e
 		return nil, fmt.Errorf("type field is required")
 	}
 
+	eventType, ok := event["type"].(string)
+	if !ok {
+		return nil, fmt.Errorf("type field must be a string")
+	}
+
+	// The producer stamps the type in a header; a mismatch means the body was altered
+	if headerType := headerValue(message, HeaderEventType); headerType != "" && headerType != eventType {
+		return nil, fmt.Errorf("type field %q does not match %s header %q", eventType, HeaderEventType, headerType)
+	}
+
 	if _, ok := event["data"]; !ok {
 		return nil, fmt.Errorf("data field is required")
 	}
 
+	if _, ok := event["data"].(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("data field must be an object")
+	}
+
 	return event, nil
 }
 
+// headerValue returns the value of a message header, or "" if it is absent
+func headerValue(message *kafka.Message, key string) string {
+	for _, h := range message.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// HeadersToMap converts Kafka message headers into a string map
+func HeadersToMap(headers []kafka.Header) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	result := make(map[string]string, len(headers))
+	for _, h := range headers {
+		result[h.Key] = string(h.Value)
+	}
+	return result
+}
+
+// MapToHeaders converts a string map back into Kafka message headers
+func MapToHeaders(headers map[string]string) []kafka.Header {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	result := make([]kafka.Header, 0, len(headers))
+	for k, v := range headers {
+		result = append(result, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	return result
+}
+
 // LogMessage logs a message with structured fields
 func (c *Consumer) LogMessage(level string, msg string, message *kafka.Message, event map[string]interface{}, fields ...zap.Field) {
 	baseFields := []zap.Field{
@@ -84,13 +394,23 @@ func (c *Consumer) LogMessage(level string, msg string, message *kafka.Message,
 		zap.String("key", string(message.Key)),
 	}
 
-	if event != nil {
-		if eventID, ok := event["eventId"].(string); ok {
-			baseFields = append(baseFields, zap.String("eventId", eventID))
-		}
-		if eventType, ok := event["type"].(string); ok {
-			baseFields = append(baseFields, zap.String("type", eventType))
-		}
+	// Prefer the headers so even unparseable messages are logged with their event
+	eventID := headerValue(message, HeaderEventID)
+	eventType := headerValue(message, HeaderEventType)
+	if eventID == "" {
+		eventID, _ = event["eventId"].(string)
+	}
+	if eventType == "" {
+		eventType, _ = event["type"].(string)
+	}
+	if eventID != "" {
+		baseFields = append(baseFields, zap.String("eventId", eventID))
+	}
+	if eventType != "" {
+		baseFields = append(baseFields, zap.String("type", eventType))
+	}
+	if schemaVersion := headerValue(message, HeaderSchemaVersion); schemaVersion != "" {
+		baseFields = append(baseFields, zap.String("schemaVersion", schemaVersion))
 	}
 
 	baseFields = append(baseFields, fields...)
@@ -0,0 +1,81 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+func TestParseCommitMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    CommitMode
+		wantErr bool
+	}{
+		{"", CommitManual, false},
+		{"manual", CommitManual, false},
+		{"auto", CommitAuto, false},
+		{"Auto", "", true},
+		{"periodic", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCommitMode(tt.name)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseCommitMode(%q) = %q, %v; want %q, error %v", tt.name, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewConsumerCommitInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     CommitMode
+		interval time.Duration
+		want     time.Duration
+	}{
+		// Manual mode always commits synchronously, whatever interval is configured
+		{"manual", CommitManual, 0, 0},
+		{"manual ignores the interval", CommitManual, 5 * time.Second, 0},
+		{"unset mode is manual", "", 5 * time.Second, 0},
+		{"auto", CommitAuto, 5 * time.Second, 5 * time.Second},
+		{"auto defaults the interval", CommitAuto, 0, defaultCommitInterval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Nothing listens here; the reader is only inspected, never read from
+			c := NewConsumer([]string{"127.0.0.1:1"}, "events", "group", ConsumerConfig{CommitMode: tt.mode, CommitInterval: tt.interval}, zap.NewNop())
+			defer c.Close()
+
+			if got := c.reader.Config().CommitInterval; got != tt.want {
+				t.Errorf("reader CommitInterval = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommitMessagesSkipsNonCommittingConsumers(t *testing.T) {
+	tests := []struct {
+		name     string
+		consumer *Consumer
+	}{
+		{"read-only", &Consumer{grouped: true, readOnly: true, logger: zap.NewNop()}},
+		{"partition reader", &Consumer{logger: zap.NewNop()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// No reader is set, so any commit attempt would panic
+			err := tt.consumer.CommitMessages(context.Background(), kafka.Message{Topic: "events", Partition: 0, Offset: 7})
+			if err != nil {
+				t.Errorf("CommitMessages() error = %v, want nil", err)
+			}
+		})
+	}
+}
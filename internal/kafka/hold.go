@@ -0,0 +1,75 @@
+package kafka
+
+import (
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// Hold marks a message that was neither processed nor safely parked in the DLQ.
+// A committed offset covers every earlier message in its partition, so from then
+// on no offset at or past the held one is committed on that partition; the
+// message is redelivered from the last good offset after a restart or rebalance.
+// The hold is released when the held message itself is committed, which happens
+// once its redelivered copy succeeds or reaches the DLQ.
+func (c *Consumer) Hold(message *kafka.Message) {
+	if !c.grouped {
+		return
+	}
+
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+
+	if held, ok := c.held[message.Partition]; ok && held <= message.Offset {
+		return
+	}
+	if c.held == nil {
+		c.held = make(map[int]int64)
+	}
+	c.held[message.Partition] = message.Offset
+
+	c.logger.Warn("Holding partition offset, later offsets will not be committed",
+		zap.String("topic", message.Topic),
+		zap.Int("partition", message.Partition),
+		zap.Int64("offset", message.Offset),
+	)
+}
+
+// isHeld reports whether message lies past a held offset on its partition
+func (c *Consumer) isHeld(message *kafka.Message) bool {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+
+	held, ok := c.held[message.Partition]
+	return ok && message.Offset > held
+}
+
+// withoutHeld releases holds on partitions whose held message is among messages
+// and returns the messages that may still be committed
+func (c *Consumer) withoutHeld(messages []kafka.Message) []kafka.Message {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+
+	if len(c.held) == 0 {
+		return messages
+	}
+
+	for _, m := range messages {
+		if held, ok := c.held[m.Partition]; ok && m.Offset == held {
+			delete(c.held, m.Partition)
+			c.logger.Info("Released partition hold",
+				zap.String("topic", m.Topic),
+				zap.Int("partition", m.Partition),
+				zap.Int64("offset", m.Offset),
+			)
+		}
+	}
+
+	allowed := make([]kafka.Message, 0, len(messages))
+	for _, m := range messages {
+		if held, ok := c.held[m.Partition]; ok && m.Offset > held {
+			continue
+		}
+		allowed = append(allowed, m)
+	}
+	return allowed
+}
@@ -0,0 +1,99 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// PartitionLag returns, for each partition of the consumer's topic, how many
+// messages lie between the group's committed offset and the partition
+// high-watermark. A partition the group has never committed on counts from the
+// earliest retained offset. Only group consumers have committed offsets.
+func (c *Consumer) PartitionLag(ctx context.Context) (map[int]int64, error) {
+	if !c.grouped {
+		return nil, errors.New("partition lag requires a consumer group")
+	}
+
+	partitions, err := topicPartitions(ctx, c.client, c.topic)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]kafka.OffsetRequest, 0, 2*len(partitions))
+	for _, p := range partitions {
+		requests = append(requests, kafka.FirstOffsetOf(p), kafka.LastOffsetOf(p))
+	}
+
+	commitOffsets, err := committedOffsets(ctx, c.client, c.groupID, c.topic, partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets, err := c.client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{c.topic: requests},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partition offsets: %w", err)
+	}
+
+	lag := make(map[int]int64, len(partitions))
+	for _, p := range offsets.Topics[c.topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("failed to list offsets of partition %d: %w", p.Partition, p.Error)
+		}
+		from, ok := commitOffsets[p.Partition]
+		if !ok || from < 0 {
+			from = p.FirstOffset
+		}
+		lag[p.Partition] = max(p.LastOffset-from, 0)
+	}
+
+	return lag, nil
+}
+
+// topicPartitions returns the IDs of topic's partitions
+func topicPartitions(ctx context.Context, client *kafka.Client, topic string) ([]int, error) {
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read topic metadata: %w", err)
+	}
+	if len(metadata.Topics) == 0 {
+		return nil, fmt.Errorf("topic %s not found", topic)
+	}
+	if err := metadata.Topics[0].Error; err != nil {
+		return nil, fmt.Errorf("failed to read topic metadata: %w", err)
+	}
+
+	partitions := make([]int, 0, len(metadata.Topics[0].Partitions))
+	for _, p := range metadata.Topics[0].Partitions {
+		partitions = append(partitions, p.ID)
+	}
+	return partitions, nil
+}
+
+// committedOffsets returns the group's committed offset for each of the topic's
+// partitions, negative where it has never committed
+func committedOffsets(ctx context.Context, client *kafka.Client, groupID, topic string, partitions []int) (map[int]int64, error) {
+	committed, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: groupID,
+		Topics:  map[string][]int{topic: partitions},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch committed offsets: %w", err)
+	}
+	if committed.Error != nil {
+		return nil, fmt.Errorf("failed to fetch committed offsets: %w", committed.Error)
+	}
+
+	offsets := make(map[int]int64, len(partitions))
+	for _, p := range committed.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("failed to fetch committed offset of partition %d: %w", p.Partition, p.Error)
+		}
+		offsets[p.Partition] = p.CommittedOffset
+	}
+	return offsets, nil
+}
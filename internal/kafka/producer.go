@@ -3,31 +3,146 @@ package kafka
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+// Headers set on every produced message so consumers and tools can route or
+// filter by event without deserializing the body
+const (
+	HeaderEventID       = "event-id"
+	HeaderEventType     = "event-type"
+	HeaderSchemaVersion = "schema-version"
+)
+
+// SchemaVersion is the envelope version stamped on events that don't carry a schemaVersion
+const SchemaVersion = "1"
+
 type Producer struct {
 	writer *kafka.Writer
-	logger *zap.Logger
+	// brokers and dialer are used by Ping
+	brokers []string
+	dialer  *kafka.Dialer
+	logger  *zap.Logger
+
+	stats statsTotals
+}
+
+// ProducerConfig tunes the Kafka writer. Zero values fall back to the kafka-go
+// defaults: no compression, no broker acknowledgement, batches of up to 100
+// messages and a 1s batch timeout. Each publish waits for its batch to be written,
+// so a shorter BatchTimeout lowers produce latency at the cost of smaller batches.
+// A nil Balancer hashes the message key, so events for the same entity land on one
+// partition and keep their order. Security is nil for plaintext brokers.
+type ProducerConfig struct {
+	Balancer     kafka.Balancer
+	Compression  kafka.Compression
+	RequiredAcks kafka.RequiredAcks
+	BatchSize    int
+	BatchTimeout time.Duration
+	Security     *Security
 }
 
-func NewProducer(brokers []string, topic string, logger *zap.Logger) *Producer {
+// ParseCompression maps a codec name (none, gzip, snappy, lz4 or zstd) to its kafka-go value
+func ParseCompression(name string) (kafka.Compression, error) {
+	switch name {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	default:
+		return 0, fmt.Errorf("unknown compression codec: %s", name)
+	}
+}
+
+// ParseBalancer maps a partitioning strategy name to its kafka-go balancer: hash
+// (key-based, the default), murmur2 (key-based, matching the Java client), crc32
+// (key-based, matching librdkafka), least-bytes or round-robin. Only the key-based
+// strategies keep same-key events on one partition.
+func ParseBalancer(name string) (kafka.Balancer, error) {
+	switch name {
+	case "", "hash":
+		return &kafka.Hash{}, nil
+	case "murmur2":
+		return kafka.Murmur2Balancer{}, nil
+	case "crc32":
+		return kafka.CRC32Balancer{}, nil
+	case "least-bytes":
+		return &kafka.LeastBytes{}, nil
+	case "round-robin":
+		return &kafka.RoundRobin{}, nil
+	default:
+		return nil, fmt.Errorf("unknown balancer: %s", name)
+	}
+}
+
+// ParseRequiredAcks maps none, one or all to the acknowledgement level a write waits for
+func ParseRequiredAcks(name string) (kafka.RequiredAcks, error) {
+	switch name {
+	case "", "none":
+		return kafka.RequireNone, nil
+	case "one":
+		return kafka.RequireOne, nil
+	case "all":
+		return kafka.RequireAll, nil
+	default:
+		return 0, fmt.Errorf("unknown required acks: %s", name)
+	}
+}
+
+func NewProducer(brokers []string, topic string, config ProducerConfig, logger *zap.Logger) *Producer {
+	balancer := config.Balancer
+	if balancer == nil {
+		balancer = &kafka.Hash{}
+	}
+
 	writer := &kafka.Writer{
 		Addr:         kafka.TCP(brokers...),
 		Topic:        topic,
-		Balancer:     &kafka.LeastBytes{},
+		Balancer:     balancer,
+		Transport:    config.Security.Transport(),
+		Compression:  config.Compression,
+		RequiredAcks: config.RequiredAcks,
+		BatchSize:    config.BatchSize,
+		BatchTimeout: config.BatchTimeout,
 		WriteTimeout: 10 * time.Second,
 		ReadTimeout:  10 * time.Second,
 	}
 
 	return &Producer{
-		writer: writer,
-		logger: logger,
+		writer:  writer,
+		brokers: brokers,
+		dialer:  config.Security.Dialer(),
+		logger:  logger,
+	}
+}
+
+// Ping checks that at least one broker accepts a connection
+func (p *Producer) Ping(ctx context.Context) error {
+	var err error
+	for _, broker := range p.brokers {
+		var conn *kafka.Conn
+		conn, err = p.dialer.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn.Close()
+		}
 	}
+	return fmt.Errorf("no broker reachable: %w", err)
 }
 
 func (p *Producer) Close() error {
@@ -36,24 +151,35 @@ func (p *Producer) Close() error {
 
 // PublishEvent publishes an event to Kafka with the appropriate key
 func (p *Producer) PublishEvent(ctx context.Context, event interface{}) error {
-	// Marshal the event to JSON
-	jsonData, err := json.Marshal(event)
-	if err != nil {
-		return fmt.Errorf("failed to marshal event: %w", err)
-	}
+	return p.PublishEventWithHeaders(ctx, event, nil)
+}
 
-	// Extract the key based on event type
-	key, err := p.extractKey(event)
+// PublishEventWithHeaders publishes an event to Kafka with the appropriate key
+// and the given message headers, e.g. when replaying a previously captured message.
+// The event-id, event-type and schema-version headers are always set from the event.
+func (p *Producer) PublishEventWithHeaders(ctx context.Context, event interface{}, headers map[string]string) (err error) {
+	ctx, span := tracer.Start(ctx, "publish "+p.writer.Topic, trace.WithSpanKind(trace.SpanKindProducer))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	message, err := p.newMessage(ctx, event, headers)
 	if err != nil {
-		return fmt.Errorf("failed to extract key: %w", err)
+		return err
 	}
+	eventID := p.extractEventID(event)
+	eventType := p.extractEventType(event)
+	key := string(message.Key)
 
-	// Create Kafka message
-	message := kafka.Message{
-		Key:   []byte(key),
-		Value: jsonData,
-		Time:  time.Now(),
-	}
+	span.SetAttributes(
+		attribute.String("messaging.system", "kafka"),
+		attribute.String("event.id", eventID),
+		attribute.String("event.type", eventType),
+	)
 
 	// Publish to Kafka
 	err = p.writer.WriteMessages(ctx, message)
@@ -62,8 +188,6 @@ func (p *Producer) PublishEvent(ctx context.Context, event interface{}) error {
 	}
 
 	// Log successful publish
-	eventID := p.extractEventID(event)
-	eventType := p.extractEventType(event)
 	p.logger.Info("event published to Kafka",
 		zap.String("eventId", eventID),
 		zap.String("type", eventType),
@@ -74,6 +198,106 @@ func (p *Producer) PublishEvent(ctx context.Context, event interface{}) error {
 	return nil
 }
 
+// PublishEvents publishes events with a single write, so they share broker round
+// trips instead of each waiting for its own batch. The returned slice holds each
+// event's error, or nil when it was published; events that can't be encoded are
+// reported without being written.
+func (p *Producer) PublishEvents(ctx context.Context, events []interface{}) []error {
+	ctx, span := tracer.Start(ctx, "publish "+p.writer.Topic, trace.WithSpanKind(trace.SpanKindProducer))
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("messaging.system", "kafka"),
+		attribute.Int("messaging.batch.message_count", len(events)),
+	)
+
+	errs := make([]error, len(events))
+	messages := make([]kafka.Message, 0, len(events))
+	indexes := make([]int, 0, len(events))
+	for i, event := range events {
+		message, err := p.newMessage(ctx, event, nil)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		messages = append(messages, message)
+		indexes = append(indexes, i)
+	}
+	if len(messages) == 0 {
+		return errs
+	}
+
+	err := p.writer.WriteMessages(ctx, messages...)
+	var writeErrs kafka.WriteErrors
+	switch {
+	case err == nil:
+	case errors.As(err, &writeErrs):
+		for j, writeErr := range writeErrs {
+			if writeErr != nil {
+				errs[indexes[j]] = fmt.Errorf("failed to write message to Kafka: %w", writeErr)
+			}
+		}
+	default:
+		for _, i := range indexes {
+			errs[i] = fmt.Errorf("failed to write message to Kafka: %w", err)
+		}
+	}
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("%d of %d events failed", failed, len(events)))
+	}
+
+	p.logger.Info("event batch published to Kafka",
+		zap.Int("events", len(events)),
+		zap.Int("failed", failed),
+		zap.String("topic", p.writer.Topic),
+	)
+
+	return errs
+}
+
+// newMessage builds the Kafka message for an event: keyed by event type, described
+// by the event headers plus any extra headers, and carrying the trace context of ctx
+func (p *Producer) newMessage(ctx context.Context, event interface{}, headers map[string]string) (kafka.Message, error) {
+	// Marshal the event to JSON
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		return kafka.Message{}, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	// Extract the key based on event type
+	key, err := p.extractKey(event)
+	if err != nil {
+		return kafka.Message{}, fmt.Errorf("failed to extract key: %w", err)
+	}
+
+	// Describe the event in headers
+	allHeaders := make(map[string]string, len(headers)+3)
+	for k, v := range headers {
+		allHeaders[k] = v
+	}
+	allHeaders[HeaderEventID] = p.extractEventID(event)
+	allHeaders[HeaderEventType] = p.extractEventType(event)
+	allHeaders[HeaderSchemaVersion] = p.extractSchemaVersion(event)
+
+	message := kafka.Message{
+		Key:     []byte(key),
+		Value:   jsonData,
+		Headers: MapToHeaders(allHeaders),
+		Time:    time.Now(),
+	}
+
+	// Carry the trace context to the consumer
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{&message.Headers})
+
+	return message, nil
+}
+
 // extractKey extracts the appropriate key based on event type
 func (p *Producer) extractKey(event interface{}) (string, error) {
 	eventMap, ok := event.(map[string]interface{})
@@ -97,11 +321,21 @@ func (p *Producer) extractKey(event interface{}) (string, error) {
 			return userID, nil
 		}
 		return "", fmt.Errorf("userId not found in UserCreated event")
+	case "UserUpdated":
+		if userID, ok := data["userId"].(string); ok {
+			return userID, nil
+		}
+		return "", fmt.Errorf("userId not found in UserUpdated event")
 	case "OrderPlaced":
 		if orderID, ok := data["orderId"].(string); ok {
 			return orderID, nil
 		}
 		return "", fmt.Errorf("orderId not found in OrderPlaced event")
+	case "OrderStatusChanged":
+		if orderID, ok := data["orderId"].(string); ok {
+			return orderID, nil
+		}
+		return "", fmt.Errorf("orderId not found in OrderStatusChanged event")
 	case "PaymentSettled":
 		if orderID, ok := data["orderId"].(string); ok {
 			return orderID, nil
@@ -112,6 +346,11 @@ func (p *Producer) extractKey(event interface{}) (string, error) {
 			return sku, nil
 		}
 		return "", fmt.Errorf("sku not found in InventoryAdjusted event")
+	case "InventoryReserved":
+		if sku, ok := data["sku"].(string); ok {
+			return sku, nil
+		}
+		return "", fmt.Errorf("sku not found in InventoryReserved event")
 	case "ProductReview":
 		if reviewID, ok := data["reviewId"].(string); ok {
 			return reviewID, nil
@@ -141,3 +380,16 @@ func (p *Producer) extractEventType(event interface{}) string {
 	}
 	return "unknown"
 }
+
+// extractSchemaVersion returns the event's schemaVersion, or SchemaVersion if it has none
+func (p *Producer) extractSchemaVersion(event interface{}) string {
+	if eventMap, ok := event.(map[string]interface{}); ok {
+		switch v := eventMap["schemaVersion"].(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return SchemaVersion
+}
@@ -0,0 +1,41 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestNewMessageCarriesHeaders(t *testing.T) {
+	p := NewProducer([]string{"localhost:9092"}, "events", ProducerConfig{}, zap.NewNop())
+	defer p.Close()
+
+	// Headers restored from a DLQ entry, including a stale event-type the
+	// producer must overwrite from the event itself
+	headers := map[string]string{
+		"traceparent":   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"retryCount":    "1",
+		HeaderEventType: "UserCreated",
+	}
+	event := map[string]interface{}{"eventId": "evt-1", "type": "OrderPlaced", "data": map[string]interface{}{"orderId": "order-1"}}
+
+	message, err := p.newMessage(context.Background(), event, headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := HeadersToMap(message.Headers)
+	want := map[string]string{
+		"traceparent":       headers["traceparent"],
+		"retryCount":        "1",
+		HeaderEventID:       "evt-1",
+		HeaderEventType:     "OrderPlaced",
+		HeaderSchemaVersion: "1",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("header %s = %q, want %q", k, got[k], v)
+		}
+	}
+}
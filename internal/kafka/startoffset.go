@@ -0,0 +1,115 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// StartOffset is where a consumer group starts reading a partition it has no
+// committed offset for. Partitions the group has committed on always resume from
+// the committed offset. The zero value starts from the newest message.
+type StartOffset struct {
+	// Earliest starts from the oldest retained message
+	Earliest bool
+	// At, when set, starts from the first message at or after this time; see
+	// SeedOffsetsAt
+	At time.Time
+}
+
+// ParseStartOffset parses earliest, latest (the default) or an RFC 3339 timestamp
+func ParseStartOffset(value string) (StartOffset, error) {
+	switch value {
+	case "", "latest":
+		return StartOffset{}, nil
+	case "earliest":
+		return StartOffset{Earliest: true}, nil
+	}
+
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return StartOffset{}, fmt.Errorf("start offset must be earliest, latest or an RFC 3339 timestamp: %s", value)
+	}
+	return StartOffset{At: at}, nil
+}
+
+// readerOffset is the kafka-go StartOffset for s. A timestamp falls back to the
+// newest message on partitions SeedOffsetsAt found nothing at or after it for.
+func (s StartOffset) readerOffset() int64 {
+	if s.Earliest {
+		return kafka.FirstOffset
+	}
+	return kafka.LastOffset
+}
+
+// SeedOffsetsAt commits, for each partition of topic the group has no committed
+// offset on, the offset of the first message at or after at, so the group starts
+// there. kafka-go can't seek a group reader (Reader.SetOffsetAt is only available
+// without a GroupID), so this must run before the group's reader is created: a
+// group with active members rejects the commit. It returns the offsets committed
+// by partition.
+func SeedOffsetsAt(ctx context.Context, brokers []string, topic, groupID string, security *Security, at time.Time) (map[int]int64, error) {
+	client := &kafka.Client{Addr: kafka.TCP(brokers...), Transport: security.Transport(), Timeout: 10 * time.Second}
+
+	partitions, err := topicPartitions(ctx, client, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	committed, err := committedOffsets(ctx, client, groupID, topic, partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	var requests []kafka.OffsetRequest
+	for _, p := range partitions {
+		if offset, ok := committed[p]; !ok || offset < 0 {
+			requests = append(requests, kafka.TimeOffsetOf(p, at))
+		}
+	}
+	if len(requests) == 0 {
+		return nil, nil
+	}
+
+	offsets, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{topic: requests},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up offsets by time: %w", err)
+	}
+
+	// A partition with no message at or after at has no offset in the response
+	var commits []kafka.OffsetCommit
+	seeded := make(map[int]int64)
+	for _, p := range offsets.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("failed to look up offset by time for partition %d: %w", p.Partition, p.Error)
+		}
+		for offset := range p.Offsets {
+			commits = append(commits, kafka.OffsetCommit{Partition: p.Partition, Offset: offset})
+			seeded[p.Partition] = offset
+		}
+	}
+	if len(commits) == 0 {
+		return nil, nil
+	}
+
+	// Generation -1 with no member ID commits for a group with no active members
+	response, err := client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      groupID,
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{topic: commits},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit start offsets: %w", err)
+	}
+	for _, p := range response.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("failed to commit start offset of partition %d: %w", p.Partition, p.Error)
+		}
+	}
+
+	return seeded, nil
+}
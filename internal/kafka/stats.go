@@ -0,0 +1,67 @@
+package kafka
+
+import (
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafka-go resets its reader and writer counters every time their stats are read,
+// so Consumer and Producer fold each snapshot into running totals. Their Stats can
+// then be called as often as needed, from any goroutine, without callers stealing
+// each other's counts. Counters are totals since the consumer or producer was
+// created; the duration and size summaries cover the time since the previous call.
+
+// statsTotals holds the running counter totals of a reader or writer
+type statsTotals struct {
+	mu     sync.Mutex
+	reader kafka.ReaderStats
+	writer kafka.WriterStats
+}
+
+// Stats returns the reader's statistics with cumulative counters
+func (c *Consumer) Stats() kafka.ReaderStats {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	s := c.reader.Stats()
+	t := &c.stats.reader
+	t.Dials += s.Dials
+	t.Fetches += s.Fetches
+	t.Messages += s.Messages
+	t.Bytes += s.Bytes
+	t.Rebalances += s.Rebalances
+	t.Timeouts += s.Timeouts
+	t.Errors += s.Errors
+
+	s.Dials = t.Dials
+	s.Fetches = t.Fetches
+	s.DeprecatedFetchesWithTypo = t.Fetches
+	s.Messages = t.Messages
+	s.Bytes = t.Bytes
+	s.Rebalances = t.Rebalances
+	s.Timeouts = t.Timeouts
+	s.Errors = t.Errors
+	return s
+}
+
+// Stats returns the writer's statistics with cumulative counters
+func (p *Producer) Stats() kafka.WriterStats {
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+
+	s := p.writer.Stats()
+	t := &p.stats.writer
+	t.Writes += s.Writes
+	t.Messages += s.Messages
+	t.Bytes += s.Bytes
+	t.Errors += s.Errors
+	t.Retries += s.Retries
+
+	s.Writes = t.Writes
+	s.Messages = t.Messages
+	s.Bytes = t.Bytes
+	s.Errors = t.Errors
+	s.Retries = t.Retries
+	return s
+}
@@ -0,0 +1,49 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("kafka-pipeline/internal/kafka")
+
+// headerCarrier lets the OpenTelemetry propagator read and write message headers
+type headerCarrier struct {
+	headers *[]kafka.Header
+}
+
+func (c headerCarrier) Get(key string) string {
+	for _, h := range *c.headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Set replaces any existing header with the same key, e.g. a stale traceparent on a replayed message
+func (c headerCarrier) Set(key, value string) {
+	for i, h := range *c.headers {
+		if h.Key == key {
+			(*c.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.headers = append(*c.headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, len(*c.headers))
+	for i, h := range *c.headers {
+		keys[i] = h.Key
+	}
+	return keys
+}
+
+// ContextFromMessage returns ctx carrying the trace context the producer injected
+// into the message headers, so spans started from it join the producer's trace
+func ContextFromMessage(ctx context.Context, message *kafka.Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier{&message.Headers})
+}
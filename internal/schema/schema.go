@@ -0,0 +1,98 @@
+package schema
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+//go:embed schemas/*.json
+var files embed.FS
+
+// byType maps each event type to the schema its events are validated against.
+// Every schema $refs envelope.json for the fields shared by all events. To add an
+// event type, add its schema under schemas/ and an entry here.
+var byType = map[string]string{
+	"UserCreated":        "user_created.json",
+	"UserUpdated":        "user_updated.json",
+	"OrderPlaced":        "order_placed.json",
+	"OrderStatusChanged": "order_status_changed.json",
+	"PaymentSettled":     "payment_settled.json",
+	"InventoryAdjusted":  "inventory_adjusted.json",
+	"InventoryReserved":  "inventory_reserved.json",
+	"ProductReview":      "product_review.json",
+}
+
+// Resource names are relative to baseURL so the schemas' $refs resolve among the
+// embedded files rather than on disk
+const baseURL = "embed:///schemas/"
+
+var (
+	envelope *jsonschema.Schema
+	compiled = make(map[string]*jsonschema.Schema, len(byType))
+)
+
+func init() {
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat = true
+
+	entries, err := files.ReadDir("schemas")
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		doc, err := files.ReadFile("schemas/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		if err := compiler.AddResource(baseURL+entry.Name(), bytes.NewReader(doc)); err != nil {
+			panic(fmt.Sprintf("schema %s: %v", entry.Name(), err))
+		}
+	}
+
+	envelope = compiler.MustCompile(baseURL + "envelope.json")
+	for eventType, name := range byType {
+		compiled[eventType] = compiler.MustCompile(baseURL + name)
+	}
+}
+
+// Validate checks a decoded event against the schema for its type. The error
+// names the offending field and the constraint it broke, such as
+// "/data/rating: must be <= 5 but found 7".
+func Validate(event map[string]interface{}) error {
+	eventType, _ := event["type"].(string)
+	s, ok := compiled[eventType]
+	if !ok {
+		// Report envelope problems such as a missing type before the unknown type
+		if err := envelope.Validate(event); err != nil {
+			return describe(err)
+		}
+		return fmt.Errorf("invalid event type: %s", eventType)
+	}
+
+	if err := s.Validate(event); err != nil {
+		return describe(err)
+	}
+	return nil
+}
+
+// describe reduces a validation error to its innermost cause and where it occurred
+func describe(err error) error {
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return err
+	}
+	for len(ve.Causes) > 0 {
+		ve = ve.Causes[0]
+	}
+
+	location := ve.InstanceLocation
+	if location == "" {
+		location = "event"
+	}
+	return fmt.Errorf("%s: %s", location, strings.TrimSpace(ve.Message))
+}
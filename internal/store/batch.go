@@ -0,0 +1,190 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// maxRowsPerStatement keeps multi-row statements under SQL Server's limit of 2100
+// parameters per request
+const maxRowsPerStatement = 300
+
+// batchMerge describes a multi-row MERGE keyed on the first of its columns
+type batchMerge struct {
+	table   string
+	columns []string
+	// update is the SET clause applied to existing rows
+	update string
+	// guard, when set, is the condition under which an existing row is updated.
+	// A batch with a row that fails it is rolled back.
+	guard string
+}
+
+// query returns the MERGE for n rows of values
+func (m batchMerge) query(n int) string {
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(m.columns)), ", ") + ")"
+	rows := strings.TrimSuffix(strings.Repeat(placeholders+", ", n), ", ")
+
+	source := make([]string, len(m.columns))
+	for i, column := range m.columns {
+		source[i] = "source." + column
+	}
+
+	guard := ""
+	if m.guard != "" {
+		guard = " AND " + m.guard
+	}
+
+	// MERGE with HOLDLOCK makes the existence check and write atomic, as for the
+	// single-row upserts
+	return fmt.Sprintf(`
+		MERGE %[1]s WITH (HOLDLOCK) AS target
+		USING (VALUES %[2]s) AS source (%[3]s)
+			ON target.%[4]s = source.%[4]s
+		WHEN MATCHED%[7]s THEN
+			UPDATE SET %[5]s
+		WHEN NOT MATCHED THEN
+			INSERT (%[3]s)
+			VALUES (%[6]s);
+	`, m.table, rows, strings.Join(m.columns, ", "), m.columns[0], m.update, strings.Join(source, ", "), guard)
+}
+
+var ordersMerge = batchMerge{
+	table:   "orders",
+	columns: []string{"order_id", "user_id", "total", "status", "created_at", "updated_at"},
+	update:  "user_id = source.user_id, total = source.total, status = source.status, updated_at = source.updated_at",
+}
+
+var paymentsMerge = batchMerge{
+	table:   "payments",
+	columns: []string{"order_id", "status", "amount", "settled_at", "updated_at"},
+	update:  "status = source.status, amount = source.amount, settled_at = source.settled_at, updated_at = source.updated_at",
+	guard:   paymentTransitionGuard,
+}
+
+// UpsertOrdersBatch creates or updates orders in one transaction, with a multi-row
+// MERGE per maxRowsPerStatement orders instead of a round trip per order. When an
+// order appears more than once the last one wins, as if upserted one at a time.
+func (s *MSSQLStore) UpsertOrdersBatch(ctx context.Context, orders []*Order) (err error) {
+	ctx, span := startSpan(ctx, "UpsertOrdersBatch")
+	defer endSpan(span, &err)
+
+	rows := make([][]interface{}, len(orders))
+	for i, o := range orders {
+		rows[i] = []interface{}{o.OrderID, o.UserID, o.Total, o.Status, o.CreatedAt, o.UpdatedAt}
+	}
+	return s.mergeBatch(ctx, ordersMerge, rows)
+}
+
+// UpsertPaymentsBatch creates or updates payments in one transaction; see
+// UpsertOrdersBatch. Unless forced, the whole batch fails if any payment would
+// make an illegal status transition, as UpsertPayment would for that payment.
+func (s *MSSQLStore) UpsertPaymentsBatch(ctx context.Context, payments []*Payment) (err error) {
+	ctx, span := startSpan(ctx, "UpsertPaymentsBatch")
+	defer endSpan(span, &err)
+
+	m := paymentsMerge
+	if s.forcePaymentTransitions {
+		m.guard = ""
+	} else if err := checkPaymentBatch(payments); err != nil {
+		return err
+	}
+
+	rows := make([][]interface{}, len(payments))
+	for i, p := range payments {
+		rows[i] = []interface{}{p.OrderID, p.Status, p.Amount, p.SettledAt, p.UpdatedAt}
+	}
+	return s.mergeBatch(ctx, m, rows)
+}
+
+// mergeBatch applies rows, keyed by their first value, with m in a single
+// transaction, retrying it on transient errors
+func (s *MSSQLStore) mergeBatch(ctx context.Context, m batchMerge, rows [][]interface{}) error {
+	rows = lastByKey(rows)
+	if len(rows) == 0 {
+		return nil
+	}
+
+	return s.retryTransient(ctx, func() error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		ids := make([]string, len(rows))
+		for start := 0; start < len(rows); start += maxRowsPerStatement {
+			chunk := rows[start:min(start+maxRowsPerStatement, len(rows))]
+
+			args := make([]interface{}, 0, len(chunk)*len(m.columns))
+			for i, row := range chunk {
+				args = append(args, row...)
+				ids[start+i] = row[0].(string)
+			}
+			result, err := tx.ExecContext(ctx, m.query(len(chunk)), args...)
+			if err != nil {
+				return err
+			}
+			if m.guard != "" {
+				affected, err := result.RowsAffected()
+				if err != nil {
+					return err
+				}
+				if affected < int64(len(chunk)) {
+					return fmt.Errorf("%d of %d %s rows rejected by the batch guard", int64(len(chunk))-affected, len(chunk), m.table)
+				}
+			}
+		}
+
+		if err := s.stampProcessedByBatch(ctx, tx, m.table, m.columns[0], ids); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// lastByKey drops all but the last row for each key, keeping the order of the
+// remaining rows. A MERGE fails if its source holds a key more than once.
+func lastByKey(rows [][]interface{}) [][]interface{} {
+	last := make(map[interface{}]int, len(rows))
+	for i, row := range rows {
+		last[row[0]] = i
+	}
+	if len(last) == len(rows) {
+		return rows
+	}
+
+	unique := make([][]interface{}, 0, len(last))
+	for i, row := range rows {
+		if last[row[0]] == i {
+			unique = append(unique, row)
+		}
+	}
+	return unique
+}
+
+// stampProcessedByBatch sets processed_by on a batch of upserted rows when enabled
+func (s *MSSQLStore) stampProcessedByBatch(ctx context.Context, tx *sql.Tx, table, idColumn string, ids []string) error {
+	if s.processedBy == "" {
+		return nil
+	}
+
+	for start := 0; start < len(ids); start += maxRowsPerStatement {
+		chunk := ids[start:min(start+maxRowsPerStatement, len(ids))]
+
+		args := make([]interface{}, 0, len(chunk)+1)
+		args = append(args, s.processedBy)
+		for _, id := range chunk {
+			args = append(args, id)
+		}
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(chunk)), ", ")
+		query := fmt.Sprintf(`UPDATE %s SET processed_by = ? WHERE %s IN (%s)`, table, idColumn, placeholders)
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// benchRoundTrip is the simulated cost of one statement round trip to SQL Server
+const benchRoundTrip = 200 * time.Microsecond
+
+func benchOrders(n int) []*Order {
+	now := time.Now()
+	orders := make([]*Order, n)
+	for i := range orders {
+		orders[i] = &Order{
+			OrderID:   fmt.Sprintf("order-%d", i),
+			UserID:    fmt.Sprintf("user-%d", i%50),
+			Total:     float64(i),
+			Status:    "placed",
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+	}
+	return orders
+}
+
+// BenchmarkUpsertOrders compares writing a flush of orders one statement per row
+// with UpsertOrdersBatch's multi-row MERGE, with every statement paying a round trip
+func BenchmarkUpsertOrders(b *testing.B) {
+	ctx := context.Background()
+	orders := benchOrders(maxRowsPerStatement)
+
+	b.Run("per-row", func(b *testing.B) {
+		s := newTestStore(b, &fakeDB{latency: benchRoundTrip})
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, o := range orders {
+				if err := s.UpsertOrder(ctx, o); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		b.ReportMetric(float64(b.N*len(orders))/b.Elapsed().Seconds(), "rows/s")
+	})
+
+	b.Run("batched", func(b *testing.B) {
+		s := newTestStore(b, &fakeDB{latency: benchRoundTrip, exec: func(string, []driver.NamedValue) (int64, error) {
+			return int64(len(orders)), nil
+		}})
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := s.UpsertOrdersBatch(ctx, orders); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(b.N*len(orders))/b.Elapsed().Seconds(), "rows/s")
+	})
+}
+
+func TestMergeBatchChunksAndKeepsLastCopy(t *testing.T) {
+	tests := []struct {
+		name       string
+		orders     int
+		duplicate  bool
+		wantMerges int
+		wantRows   int
+	}{
+		{"single chunk", 10, false, 1, 10},
+		{"split at the parameter limit", maxRowsPerStatement + 1, false, 2, maxRowsPerStatement + 1},
+		{"duplicate key sent once", 10, true, 1, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeDB{}
+			s := newTestStore(t, f)
+
+			orders := benchOrders(tt.orders)
+			if tt.duplicate {
+				latest := *orders[0]
+				latest.Status = "shipped"
+				orders = append(orders, &latest)
+			}
+			if err := s.UpsertOrdersBatch(context.Background(), orders); err != nil {
+				t.Fatal(err)
+			}
+
+			merges := f.calls("MERGE orders")
+			if len(merges) != tt.wantMerges {
+				t.Fatalf("ran %d MERGEs, want %d", len(merges), tt.wantMerges)
+			}
+			rows := 0
+			for _, m := range merges {
+				if !m.inTx {
+					t.Error("MERGE ran outside the batch transaction")
+				}
+				rows += len(m.args) / len(ordersMerge.columns)
+			}
+			if rows != tt.wantRows {
+				t.Errorf("merged %d rows, want %d", rows, tt.wantRows)
+			}
+			if tt.duplicate {
+				for _, m := range merges {
+					if m.arg(1) == "order-0" && m.arg(4) != "shipped" {
+						t.Errorf("order-0 merged with status %v, want the last copy's shipped", m.arg(4))
+					}
+				}
+			}
+			if begins, commits, _ := f.counts(); begins != 1 || commits != 1 {
+				t.Errorf("began %d and committed %d transactions, want 1 each", begins, commits)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeDB is an in-process database/sql driver standing in for SQL Server. Every
+// statement it runs waits latency, the cost of a round trip, and is recorded.
+// exec and query, when set, decide a statement's outcome; by default an exec
+// affects one row and a query returns no rows. Successful execs are kept as the
+// rows written, those inside a transaction only once it commits.
+type fakeDB struct {
+	latency time.Duration
+	// parseCost is added to every statement that isn't run through a prepared
+	// handle, as SQL Server parses each ad-hoc batch
+	parseCost time.Duration
+
+	exec  func(query string, args []driver.NamedValue) (rowsAffected int64, err error)
+	query func(query string, args []driver.NamedValue) (*fakeRows, error)
+
+	mu        sync.Mutex
+	execs     []fakeCall
+	writes    []fakeCall
+	prepares  int
+	begins    int
+	commits   int
+	rollbacks int
+}
+
+// fakeCall is a statement the fake ran
+type fakeCall struct {
+	query string
+	args  []driver.NamedValue
+	inTx  bool
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: f}, nil
+}
+
+func (f *fakeDB) Driver() driver.Driver {
+	return fakeDriver{f}
+}
+
+type fakeDriver struct{ db *fakeDB }
+
+func (d fakeDriver) Open(string) (driver.Conn, error) {
+	return &fakeConn{db: d.db}, nil
+}
+
+// calls returns the statements run so far whose query contains substr, leaving
+// out the round trips that prepared them
+func (f *fakeDB) calls(substr string) []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var matched []fakeCall
+	for _, c := range f.execs {
+		if strings.Contains(c.query, substr) && !strings.HasPrefix(c.query, "PREPARE ") {
+			matched = append(matched, c)
+		}
+	}
+	return matched
+}
+
+// written returns the committed writes whose query contains substr
+func (f *fakeDB) written(substr string) []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var matched []fakeCall
+	for _, c := range f.writes {
+		if strings.Contains(c.query, substr) {
+			matched = append(matched, c)
+		}
+	}
+	return matched
+}
+
+// prepared returns how many statements have been prepared
+func (f *fakeDB) prepared() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.prepares
+}
+
+func (f *fakeDB) counts() (begins, commits, rollbacks int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.begins, f.commits, f.rollbacks
+}
+
+func (f *fakeDB) run(ctx context.Context, query string, args []driver.NamedValue, inTx, prepared bool) error {
+	wait := f.latency
+	if !prepared {
+		wait += f.parseCost
+	}
+	if wait > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	f.mu.Lock()
+	f.execs = append(f.execs, fakeCall{query: query, args: args, inTx: inTx})
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *fakeDB) doExec(ctx context.Context, c *fakeConn, query string, args []driver.NamedValue, prepared bool) (driver.Result, error) {
+	if err := f.run(ctx, query, args, c.inTx, prepared); err != nil {
+		return nil, err
+	}
+	n := int64(1)
+	if f.exec != nil {
+		var err error
+		if n, err = f.exec(query, args); err != nil {
+			return nil, err
+		}
+	}
+
+	write := fakeCall{query: query, args: args, inTx: c.inTx}
+	if c.inTx {
+		c.pending = append(c.pending, write)
+	} else {
+		f.mu.Lock()
+		f.writes = append(f.writes, write)
+		f.mu.Unlock()
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (f *fakeDB) doQuery(ctx context.Context, query string, args []driver.NamedValue, inTx, prepared bool) (driver.Rows, error) {
+	if err := f.run(ctx, query, args, inTx, prepared); err != nil {
+		return nil, err
+	}
+	if f.query == nil {
+		return &fakeRows{}, nil
+	}
+	rows, err := f.query(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+type fakeConn struct {
+	db   *fakeDB
+	inTx bool
+	// pending holds the writes of the open transaction
+	pending []fakeCall
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *fakeConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.db.run(ctx, "PREPARE "+query, nil, c.inTx, false); err != nil {
+		return nil, err
+	}
+	c.db.mu.Lock()
+	c.db.prepares++
+	c.db.mu.Unlock()
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.db.mu.Lock()
+	c.db.begins++
+	c.db.mu.Unlock()
+	c.inTx = true
+	return &fakeTx{conn: c}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.db.doExec(ctx, c, query, args, false)
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.db.doQuery(ctx, query, args, c.inTx, false)
+}
+
+// CheckNamedValue accepts any argument, such as a table-valued parameter
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+type fakeTx struct{ conn *fakeConn }
+
+func (t *fakeTx) Commit() error {
+	t.conn.inTx = false
+	t.conn.db.mu.Lock()
+	t.conn.db.commits++
+	t.conn.db.writes = append(t.conn.db.writes, t.conn.pending...)
+	t.conn.db.mu.Unlock()
+	t.conn.pending = nil
+	return nil
+}
+
+func (t *fakeTx) Rollback() error {
+	t.conn.inTx = false
+	t.conn.pending = nil
+	t.conn.db.mu.Lock()
+	t.conn.db.rollbacks++
+	t.conn.db.mu.Unlock()
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *fakeStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.db.doExec(ctx, s.conn, s.query, args, true)
+}
+
+func (s *fakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.db.doQuery(ctx, s.query, args, s.conn.inTx, true)
+}
+
+func (s *fakeStmt) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+// fakeRows is a query result
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// newTestStore returns a store backed by f with fast retries
+func newTestStore(t testing.TB, f *fakeDB) *MSSQLStore {
+	t.Helper()
+
+	s := newStore(sql.OpenDB(f), zap.NewNop())
+	s.deadlockBackoff = time.Millisecond
+	s.transientBackoff = time.Millisecond
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// arg returns the value of a statement's i-th argument, counting from 1
+func (c fakeCall) arg(i int) driver.Value {
+	for _, a := range c.args {
+		if a.Ordinal == i {
+			return a.Value
+		}
+	}
+	return nil
+}
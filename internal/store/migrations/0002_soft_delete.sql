@@ -0,0 +1,14 @@
+-- Soft delete for users and orders: a non-NULL deleted_at hides the row from reads
+-- while rows referencing it stay valid
+
+IF COL_LENGTH('users', 'deleted_at') IS NULL
+BEGIN
+    ALTER TABLE users ADD deleted_at DATETIME2 NULL;
+END
+GO
+
+IF COL_LENGTH('orders', 'deleted_at') IS NULL
+BEGIN
+    ALTER TABLE orders ADD deleted_at DATETIME2 NULL;
+END
+GO
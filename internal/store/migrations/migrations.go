@@ -0,0 +1,195 @@
+package migrations
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"go.uber.org/zap"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// lockResource is the application lock held while migrating, so concurrent
+// runners apply each migration once
+const lockResource = "schema_migrations"
+
+// migration is one embedded .sql file, split into batches on GO lines as sqlcmd does
+type migration struct {
+	version string
+	batches []string
+}
+
+// Apply runs every embedded migration not yet recorded in schema_migrations, in
+// file name order, and returns the versions it applied. Each migration runs in its
+// own transaction together with its schema_migrations row, so a failed migration
+// leaves nothing behind and is attempted again on the next run.
+func Apply(ctx context.Context, db *sql.DB, logger *zap.Logger) ([]string, error) {
+	all, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	err = inLockedTx(ctx, db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			IF OBJECT_ID('schema_migrations', 'U') IS NULL
+			CREATE TABLE schema_migrations (
+				version VARCHAR(255) PRIMARY KEY,
+				applied_at DATETIME2 NOT NULL
+			)
+		`)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	applied := []string{}
+	for _, m := range all {
+		ran := false
+		err := inLockedTx(ctx, db, func(tx *sql.Tx) error {
+			var count int
+			if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, m.version).Scan(&count); err != nil {
+				return err
+			}
+			if count > 0 {
+				return nil
+			}
+
+			for i, batch := range m.batches {
+				if _, err := tx.ExecContext(ctx, batch); err != nil {
+					return fmt.Errorf("batch %d: %w", i+1, err)
+				}
+			}
+			if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, SYSUTCDATETIME())`, m.version); err != nil {
+				return err
+			}
+			ran = true
+			return nil
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %s failed: %w", m.version, err)
+		}
+		if ran {
+			logger.Info("Applied migration", zap.String("version", m.version))
+			applied = append(applied, m.version)
+		}
+	}
+
+	return applied, nil
+}
+
+// inLockedTx runs fn in a transaction holding the migration application lock
+func inLockedTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var result int
+	err = tx.QueryRowContext(ctx, `
+		DECLARE @result INT;
+		EXEC @result = sp_getapplock @Resource = ?, @LockMode = 'Exclusive', @LockOwner = 'Transaction', @LockTimeout = 60000;
+		SELECT @result;
+	`, lockResource).Scan(&result)
+	if err != nil {
+		return err
+	}
+	if result < 0 {
+		return fmt.Errorf("failed to acquire migration lock (sp_getapplock returned %d)", result)
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// load reads the embedded migrations in version order
+func load() ([]migration, error) {
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	all := make([]migration, 0, len(names))
+	for _, name := range names {
+		content, err := files.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, migration{
+			version: strings.TrimSuffix(name, ".sql"),
+			batches: splitBatches(string(content)),
+		})
+	}
+	return all, nil
+}
+
+// splitBatches splits a script on lines consisting of GO, dropping empty batches
+func splitBatches(script string) []string {
+	var batches []string
+	var current strings.Builder
+
+	flush := func() {
+		if batch := strings.TrimSpace(current.String()); batch != "" {
+			batches = append(batches, batch)
+		}
+		current.Reset()
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(script))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.EqualFold(strings.TrimSpace(line), "GO") {
+			flush()
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	flush()
+
+	return batches
+}
+
+// EnsureDatabase creates the database named in connStr if it doesn't exist yet,
+// connecting to master with the same credentials to do so
+func EnsureDatabase(ctx context.Context, connStr string) error {
+	config, _, err := msdsn.Parse(connStr)
+	if err != nil {
+		return fmt.Errorf("invalid connection string: %w", err)
+	}
+	name := config.Database
+	if name == "" {
+		return fmt.Errorf("connection string does not name a database")
+	}
+	config.Database = "master"
+
+	db := sql.OpenDB(mssql.NewConnectorConfig(config))
+	defer db.Close()
+
+	// Connectors take @p-style parameters rather than the ? of the "mssql" driver
+	_, err = db.ExecContext(ctx, `
+		DECLARE @name SYSNAME = @p1;
+		IF DB_ID(@name) IS NULL
+		BEGIN
+			DECLARE @stmt NVARCHAR(MAX) = N'CREATE DATABASE ' + QUOTENAME(@name);
+			EXEC (@stmt);
+		END
+	`, name)
+	if err != nil {
+		return fmt.Errorf("failed to create database %s: %w", name, err)
+	}
+	return nil
+}
@@ -1,15 +1,114 @@
 package store
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
-// Event represents the common structure for all events
+// Event represents the common structure for all events. Data is decoded into the
+// typed payload for Type with DecodeData. Timestamp is kept raw because its format
+// is enforced by the validation profile, which may accept non-RFC3339 values.
 type Event struct {
-	EventID   string      `json:"eventId"`
-	Type      string      `json:"type"`
-	Timestamp time.Time   `json:"timestamp"`
-	Data      interface{} `json:"data"`
+	EventID   string          `json:"eventId"`
+	Type      string          `json:"type"`
+	Timestamp json.RawMessage `json:"timestamp"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// EventData is a typed event payload that can check its own required fields
+type EventData interface {
+	Validate() error
+}
+
+// DecodeEvent parses an event envelope, leaving its data undecoded
+func DecodeEvent(value []byte) (*Event, error) {
+	var event Event
+	if err := json.Unmarshal(value, &event); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event: %w", err)
+	}
+	return &event, nil
+}
+
+// DecodeData unmarshals the event's data into v and validates it. Failures are
+// returned as a *DataError.
+func (e *Event) DecodeData(v EventData) error {
+	if err := json.Unmarshal(e.Data, v); err != nil {
+		return &DataError{Type: e.Type, Err: fmt.Errorf("invalid %s data: %w", e.Type, err)}
+	}
+	if err := v.Validate(); err != nil {
+		return &DataError{Type: e.Type, Err: err}
+	}
+	return nil
+}
+
+// DataError is an event whose data can't be decoded or is missing required fields
+type DataError struct {
+	Type string
+	Err  error
+}
+
+func (e *DataError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DataError) Unwrap() error {
+	return e.Err
+}
+
+// ErrNotFound is wrapped by updates of a row that doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicateEvent is returned by deduplicated writes whose eventId has already
+// been applied; nothing was written
+var ErrDuplicateEvent = errors.New("event already processed")
+
+// requireStrings returns an error naming the first empty value, given name/value pairs
+func requireStrings(pairs ...string) error {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			return fmt.Errorf("%s is required", pairs[i])
+		}
+	}
+	return nil
+}
+
+// Timestamp is a data timestamp that decodes from an RFC3339 string or from a
+// number of epoch milliseconds. Any other value fails to decode, so the event is
+// rejected rather than stamped with the processing time. The zero value means
+// the field was omitted or null.
+type Timestamp struct {
+	time.Time
+}
+
+func (t *Timestamp) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		parsed, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return fmt.Errorf("timestamp %q is not RFC3339", s)
+		}
+		t.Time = parsed
+		return nil
+	}
+
+	var millis int64
+	if err := json.Unmarshal(b, &millis); err != nil {
+		return fmt.Errorf("timestamp %s must be an RFC3339 string or epoch milliseconds", b)
+	}
+	t.Time = time.UnixMilli(millis).UTC()
+	return nil
 }
 
 // UserCreatedData represents the data payload for UserCreated events
@@ -17,7 +116,29 @@ type UserCreatedData struct {
 	UserID    string    `json:"userId"`
 	Name      string    `json:"name"`
 	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"createdAt"`
+	CreatedAt Timestamp `json:"createdAt"`
+}
+
+func (d *UserCreatedData) Validate() error {
+	return requireStrings("userId", d.UserID, "name", d.Name, "email", d.Email)
+}
+
+// UserUpdatedData represents the data payload for UserUpdated events.
+// Only fields present in the payload are changed; absent fields are left as-is.
+type UserUpdatedData struct {
+	UserID string  `json:"userId"`
+	Name   *string `json:"name,omitempty"`
+	Email  *string `json:"email,omitempty"`
+}
+
+func (d *UserUpdatedData) Validate() error {
+	if err := requireStrings("userId", d.UserID); err != nil {
+		return err
+	}
+	if d.Name == nil && d.Email == nil {
+		return fmt.Errorf("name or email is required")
+	}
+	return nil
 }
 
 // OrderPlacedData represents the data payload for OrderPlaced events
@@ -25,7 +146,22 @@ type OrderPlacedData struct {
 	OrderID   string    `json:"orderId"`
 	UserID    string    `json:"userId"`
 	Total     float64   `json:"total"`
-	CreatedAt time.Time `json:"createdAt"`
+	CreatedAt Timestamp `json:"createdAt"`
+}
+
+func (d *OrderPlacedData) Validate() error {
+	return requireStrings("orderId", d.OrderID, "userId", d.UserID)
+}
+
+// OrderStatusChangedData represents the data payload for OrderStatusChanged events
+type OrderStatusChangedData struct {
+	OrderID   string    `json:"orderId"`
+	Status    string    `json:"status"`
+	ChangedAt Timestamp `json:"changedAt"`
+}
+
+func (d *OrderStatusChangedData) Validate() error {
+	return requireStrings("orderId", d.OrderID, "status", d.Status)
 }
 
 // PaymentSettledData represents the data payload for PaymentSettled events
@@ -33,7 +169,11 @@ type PaymentSettledData struct {
 	OrderID   string    `json:"orderId"`
 	Status    string    `json:"status"`
 	Amount    float64   `json:"amount"`
-	SettledAt time.Time `json:"settledAt"`
+	SettledAt Timestamp `json:"settledAt"`
+}
+
+func (d *PaymentSettledData) Validate() error {
+	return requireStrings("orderId", d.OrderID, "status", d.Status)
 }
 
 // InventoryAdjustedData represents the data payload for InventoryAdjusted events
@@ -41,16 +181,49 @@ type InventoryAdjustedData struct {
 	SKU        string    `json:"sku"`
 	Delta      int       `json:"delta"`
 	Reason     string    `json:"reason"`
-	AdjustedAt time.Time `json:"adjustedAt"`
+	AdjustedAt Timestamp `json:"adjustedAt"`
 }
 
+func (d *InventoryAdjustedData) Validate() error {
+	return requireStrings("sku", d.SKU)
+}
+
+// InventoryReservedData represents the data payload for InventoryReserved events
+type InventoryReservedData struct {
+	SKU        string    `json:"sku"`
+	Quantity   int       `json:"quantity"`
+	OrderID    string    `json:"orderId"`
+	ReservedAt Timestamp `json:"reservedAt"`
+}
+
+func (d *InventoryReservedData) Validate() error {
+	if err := requireStrings("sku", d.SKU); err != nil {
+		return err
+	}
+	if d.Quantity <= 0 {
+		return fmt.Errorf("quantity must be positive")
+	}
+	return nil
+}
+
+// ProductReviewData represents the data payload for ProductReview events
 type ProductReviewData struct {
 	ReviewID    string    `json:"reviewId"`
 	ProductName string    `json:"productName"`
 	Username    string    `json:"username"`
 	Rating      int       `json:"rating"`
 	Remarks     string    `json:"remarks"`
-	CreatedAt   time.Time `json:"createdAt"`
+	CreatedAt   Timestamp `json:"createdAt"`
+}
+
+func (d *ProductReviewData) Validate() error {
+	if err := requireStrings("reviewId", d.ReviewID, "productName", d.ProductName, "username", d.Username); err != nil {
+		return err
+	}
+	if d.Rating < 1 || d.Rating > 5 {
+		return fmt.Errorf("rating must be between 1 and 5")
+	}
+	return nil
 }
 
 // Database models
@@ -60,6 +233,8 @@ type User struct {
 	Email     string    `json:"email" db:"email"`
 	CreatedAt time.Time `json:"createdAt" db:"created_at"`
 	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+	// DeletedAt is set on soft-deleted users, which are only read under IncludeDeleted
+	DeletedAt *time.Time `json:"deletedAt,omitempty" db:"deleted_at"`
 }
 
 type Order struct {
@@ -69,6 +244,21 @@ type Order struct {
 	Status    string    `json:"status" db:"status"`
 	CreatedAt time.Time `json:"createdAt" db:"created_at"`
 	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+	// DeletedAt is set on soft-deleted orders, which are only read under IncludeDeleted
+	DeletedAt *time.Time `json:"deletedAt,omitempty" db:"deleted_at"`
+}
+
+// OrderFilter selects orders for QueryOrders. Empty fields leave the result
+// unconstrained, except Limit, which must be between 1 and MaxOrderQueryLimit so
+// an empty filter can't read the whole table. From and To bound created_at to
+// [From, To).
+type OrderFilter struct {
+	UserID string
+	Status string
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
 }
 
 type Payment struct {
@@ -79,29 +269,135 @@ type Payment struct {
 	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
 }
 
+// OrderWithPayment is an order with its payment, which is nil until the order's
+// PaymentSettled event has been applied
+type OrderWithPayment struct {
+	*Order
+	Payment *Payment `json:"payment"`
+}
+
 type Inventory struct {
 	SKU            string    `json:"sku" db:"sku"`
 	Quantity       int       `json:"quantity" db:"quantity"`
 	LastAdjustedAt time.Time `json:"lastAdjustedAt" db:"last_adjusted_at"`
 }
 
+// InventoryAdjustment is one change to a SKU's quantity, from an InventoryAdjusted
+// event or a reservation
+type InventoryAdjustment struct {
+	EventID    string    `json:"eventId,omitempty"`
+	Delta      int       `json:"delta"`
+	Reason     string    `json:"reason,omitempty"`
+	AdjustedAt time.Time `json:"adjustedAt"`
+}
+
+// ReservationReason is the reason recorded for the adjustment a successful
+// InventoryReserved event makes
+const ReservationReason = "reservation"
+
+// ConsumerHeartbeat is the latest liveness record written by a consumer instance.
+// LastProcessedAt is nil until the instance has processed a message.
+type ConsumerHeartbeat struct {
+	PodID           string     `json:"podId" db:"pod_id"`
+	StartedAt       time.Time  `json:"startedAt" db:"started_at"`
+	LastSeenAt      time.Time  `json:"lastSeenAt" db:"last_seen_at"`
+	LastProcessedAt *time.Time `json:"lastProcessedAt,omitempty" db:"last_processed_at"`
+}
+
+// ProductReview is a customer review. Verified is set when the reviewer has placed
+// at least one order.
 type ProductReview struct {
 	ReviewID    string    `json:"reviewId" db:"review_id"`
 	ProductName string    `json:"productName" db:"product_name"`
 	Username    string    `json:"username" db:"username"`
 	Rating      int       `json:"rating" db:"rating"`
 	Remarks     string    `json:"remarks" db:"remarks"`
+	Verified    bool      `json:"verified" db:"verified"`
 	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
 	UpdatedAt   time.Time `json:"updatedAt" db:"updated_at"`
 }
 
+// UserExport is everything held about a user, for data subject access requests.
+// Payments are those for the user's orders; Reviews are matched on username = user ID.
+type UserExport struct {
+	User       *User            `json:"user"`
+	Orders     []*Order         `json:"orders"`
+	Payments   []*Payment       `json:"payments"`
+	Reviews    []*ProductReview `json:"reviews"`
+	ExportedAt time.Time        `json:"exportedAt"`
+}
+
+// Change is an entity's ID and when it was last updated, as returned by
+// GetRecentlyUpdated
+type Change struct {
+	ID        string    `json:"id"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// SearchResults holds the entities whose IDs match a search term
+type SearchResults struct {
+	Users    []*User          `json:"users"`
+	Orders   []*Order         `json:"orders"`
+	Payments []*Payment       `json:"payments"`
+	Reviews  []*ProductReview `json:"reviews"`
+}
+
+// RatingBucket is the average rating and review count for one period
+type RatingBucket struct {
+	PeriodStart time.Time `json:"periodStart"`
+	AvgRating   float64   `json:"avgRating"`
+	Count       int       `json:"count"`
+}
+
+// TimelineEvent is a single entry in an order's chronological history
+type TimelineEvent struct {
+	Type   string    `json:"type"`
+	At     time.Time `json:"at"`
+	Detail string    `json:"detail"`
+}
+
 // DLQMessage represents a message stored in the dead letter queue
 type DLQMessage struct {
-	EventID   string      `json:"eventId"`
-	Topic     string      `json:"topic"`
-	Partition int         `json:"partition"`
-	Offset    int64       `json:"offset"`
-	Payload   interface{} `json:"payload"`
-	Error     string      `json:"error"`
-	FailedAt  time.Time   `json:"failedAt"`
+	EventID    string            `json:"eventId"`
+	Topic      string            `json:"topic"`
+	Partition  int               `json:"partition"`
+	Offset     int64             `json:"offset"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Payload    interface{}       `json:"payload"`
+	Error      string            `json:"error"`
+	RetryCount int               `json:"retryCount"`
+	FailedAt   time.Time         `json:"failedAt"`
+	Attempts   int64             `json:"attempts,omitempty"`
+}
+
+// ReviewCursor marks the last review of a page; the next page starts after it
+type ReviewCursor struct {
+	CreatedAt time.Time
+	ReviewID  string
+}
+
+// Encode returns the cursor as an opaque URL-safe token
+func (c *ReviewCursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ReviewID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeReviewCursor parses a token produced by ReviewCursor.Encode
+func DecodeReviewCursor(token string) (*ReviewCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	createdAt, reviewID, ok := strings.Cut(string(raw), "|")
+	if !ok || reviewID == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &ReviewCursor{CreatedAt: t, ReviewID: reviewID}, nil
 }
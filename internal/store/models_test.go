@@ -0,0 +1,72 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTimestampUnmarshalJSON(t *testing.T) {
+	want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		input   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"RFC3339", `"2024-01-02T03:04:05Z"`, want, false},
+		{"RFC3339 with offset", `"2024-01-02T05:04:05+02:00"`, want, false},
+		{"epoch millis", `1704164645000`, want, false},
+		{"null is omitted", `null`, time.Time{}, false},
+		{"date only", `"2024-01-02"`, time.Time{}, true},
+		{"free text", `"yesterday"`, time.Time{}, true},
+		{"fractional millis", `1704164645000.5`, time.Time{}, true},
+		{"bool", `true`, time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ts Timestamp
+			err := json.Unmarshal([]byte(tt.input), &ts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, want error %v", tt.input, err, tt.wantErr)
+			}
+			if !ts.Equal(tt.want) {
+				t.Errorf("Unmarshal(%s) = %v, want %v", tt.input, ts.Time, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeDataRejectsBadTimestamp(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"RFC3339", `{"orderId":"order-1","userId":"user-1","total":10,"createdAt":"2024-01-02T03:04:05Z"}`, false},
+		{"epoch millis", `{"orderId":"order-1","userId":"user-1","total":10,"createdAt":1704164645000}`, false},
+		{"omitted", `{"orderId":"order-1","userId":"user-1","total":10}`, false},
+		{"unparseable", `{"orderId":"order-1","userId":"user-1","total":10,"createdAt":"02/01/2024"}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &Event{EventID: "evt-1", Type: "OrderPlaced", Data: json.RawMessage(tt.data)}
+
+			var data OrderPlacedData
+			err := event.DecodeData(&data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeData() error = %v, want error %v", err, tt.wantErr)
+			}
+			// A bad timestamp is a data error, so the consumer parks the event
+			// instead of stamping it with the processing time
+			var dataErr *DataError
+			if err != nil && !errors.As(err, &dataErr) {
+				t.Errorf("DecodeData() error = %T, want *DataError", err)
+			}
+		})
+	}
+}
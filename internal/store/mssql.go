@@ -4,89 +4,463 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
-	_ "github.com/denisenkom/go-mssqldb"
+	"kafka-pipeline/internal/store/migrations"
+
+	mssql "github.com/denisenkom/go-mssqldb"
 	"go.uber.org/zap"
 )
 
 type MSSQLStore struct {
-	db     *sql.DB
-	logger *zap.Logger
+	db *sql.DB
+	// replica serves reads when a read replica is configured; nil otherwise
+	replica *sql.DB
+	// readStmts caches prepared statements for the hot getters on the read pool
+	readStmts *stmtCache
+	// writeStmts caches prepared statements for writes on the primary
+	writeStmts *stmtCache
+	logger     *zap.Logger
+	// processedBy, when set, is written to the processed_by column on upserts
+	processedBy string
+	// forcePaymentTransitions skips payment status transition checks, see
+	// SetForcePaymentTransitions
+	forcePaymentTransitions bool
+	// deadlock retry policy, see SetDeadlockRetry
+	deadlockRetries int
+	deadlockBackoff time.Duration
+	onDeadlockRetry func()
+	// transient error retry policy, see SetTransientRetry
+	transientRetries int
+	transientBackoff time.Duration
+	onTransientRetry func()
 }
 
 func NewMSSQLStore(connStr string, logger *zap.Logger) (*MSSQLStore, error) {
+	db, err := openDB(connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return newStore(db, logger), nil
+}
+
+// newStore wraps an open pool with the default retry policies
+func newStore(db *sql.DB, logger *zap.Logger) *MSSQLStore {
+	return &MSSQLStore{
+		db:               db,
+		readStmts:        newStmtCache(db),
+		writeStmts:       newStmtCache(db),
+		logger:           logger,
+		deadlockRetries:  defaultDeadlockRetries,
+		deadlockBackoff:  defaultDeadlockBackoff,
+		transientRetries: defaultTransientRetries,
+		transientBackoff: defaultTransientBackoff,
+	}
+}
+
+// NewMSSQLStoreWithReplica creates a store that writes to the primary and routes
+// Get*/List* reads to a read replica. With an empty replicaConnStr it behaves like
+// NewMSSQLStore. Replica reads may lag the primary, so a read issued right after a
+// write (read-your-writes) can return stale or missing data.
+func NewMSSQLStoreWithReplica(connStr, replicaConnStr string, logger *zap.Logger) (*MSSQLStore, error) {
+	s, err := NewMSSQLStore(connStr, logger)
+	if err != nil || replicaConnStr == "" {
+		return s, err
+	}
+
+	replica, err := openDB(replicaConnStr)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("replica: %w", err)
+	}
+	s.replica = replica
+	s.readStmts = newStmtCache(replica)
+
+	return s, nil
+}
+
+// openDB opens a connection pool sized by DefaultPoolConfig and verifies it with a ping
+func openDB(connStr string) (*sql.DB, error) {
 	db, err := sql.Open("mssql", connStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	DefaultPoolConfig.apply(db)
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return &MSSQLStore{
-		db:     db,
-		logger: logger,
-	}, nil
+	return db, nil
+}
+
+// Migrate applies the embedded schema migrations that haven't run yet on the
+// primary and returns their versions; see migrations.Apply
+func (s *MSSQLStore) Migrate(ctx context.Context) ([]string, error) {
+	return migrations.Apply(ctx, s.db, s.logger)
 }
 
 func (s *MSSQLStore) Close() error {
+	s.readStmts.close()
+	s.writeStmts.close()
+	if s.replica != nil {
+		s.replica.Close()
+	}
 	return s.db.Close()
 }
 
+// PoolConfig sizes a store's SQL connection pools
+type PoolConfig struct {
+	// MaxOpenConns caps connections per pool, in use or idle
+	MaxOpenConns int
+	// MaxIdleConns is how many idle connections are kept for reuse
+	MaxIdleConns int
+	// ConnMaxLifetime closes connections older than this, so a failover's new
+	// primary picks up traffic instead of the pool holding stale connections
+	ConnMaxLifetime time.Duration
+}
+
+// DefaultPoolConfig is applied to every pool when it is opened
+var DefaultPoolConfig = PoolConfig{
+	MaxOpenConns:    25,
+	MaxIdleConns:    5,
+	ConnMaxLifetime: 5 * time.Minute,
+}
+
+func (c PoolConfig) apply(db *sql.DB) {
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+}
+
+// SetPoolConfig resizes the primary and replica pools and logs the effective settings
+func (s *MSSQLStore) SetPoolConfig(config PoolConfig) {
+	config.apply(s.db)
+	if s.replica != nil {
+		config.apply(s.replica)
+	}
+	s.logger.Info("Configured SQL connection pool",
+		zap.Int("maxOpenConns", config.MaxOpenConns),
+		zap.Int("maxIdleConns", config.MaxIdleConns),
+		zap.Duration("connMaxLifetime", config.ConnMaxLifetime),
+	)
+}
+
+// Ping checks that the primary, and the replica if configured, can be reached
+func (s *MSSQLStore) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return err
+	}
+	if s.replica != nil {
+		if err := s.replica.PingContext(ctx); err != nil {
+			return fmt.Errorf("replica: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetConnMaxIdleTime closes pooled connections that have been idle longer than d,
+// so connections silently dropped by a load balancer are not handed out
+func (s *MSSQLStore) SetConnMaxIdleTime(d time.Duration) {
+	s.db.SetConnMaxIdleTime(d)
+	if s.replica != nil {
+		s.replica.SetConnMaxIdleTime(d)
+	}
+}
+
+// Keepalive runs SELECT 1 against each pool every interval until ctx is done,
+// keeping connections warm and surfacing dead ones early. onFailure is called
+// for every failed probe.
+func (s *MSSQLStore) Keepalive(ctx context.Context, interval time.Duration, onFailure func(err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	pools := map[string]*sql.DB{"primary": s.db}
+	if s.replica != nil {
+		pools["replica"] = s.replica
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for name, db := range pools {
+				probeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+				var one int
+				err := db.QueryRowContext(probeCtx, "SELECT 1").Scan(&one)
+				cancel()
+				if err != nil {
+					s.logger.Warn("SQL keepalive failed", zap.String("pool", name), zap.Error(err))
+					if onFailure != nil {
+						onFailure(err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// readDB returns the pool to use for reads: the replica if configured, else the primary
+func (s *MSSQLStore) readDB() *sql.DB {
+	if s.replica != nil {
+		return s.replica
+	}
+	return s.db
+}
+
+// EnableProcessedBy records host in the processed_by column of every upserted row.
+// The column is optional; see sql/optional/processed_by.sql.
+func (s *MSSQLStore) EnableProcessedBy(host string) {
+	s.processedBy = host
+}
+
+// stampProcessedBy sets processed_by on an upserted row when enabled
+func (s *MSSQLStore) stampProcessedBy(ctx context.Context, ex execer, table, idColumn, id string) error {
+	if s.processedBy == "" {
+		return nil
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET processed_by = ? WHERE %s = ?`, table, idColumn)
+	_, err := ex.ExecContext(ctx, query, s.processedBy, id)
+	return err
+}
+
 // UpsertUser creates or updates a user record
-func (s *MSSQLStore) UpsertUser(ctx context.Context, user *User) error {
+func (s *MSSQLStore) UpsertUser(ctx context.Context, user *User) (err error) {
+	ctx, span := startSpan(ctx, "UpsertUser")
+	defer endSpan(span, &err)
+
+	return s.upsertUser(ctx, retryingExecer{s}, user)
+}
+
+func (s *MSSQLStore) upsertUser(ctx context.Context, ex execer, user *User) error {
+	// MERGE with HOLDLOCK makes the existence check and write atomic, so concurrent
+	// upserts of the same key can't both take the INSERT branch
 	query := `
-		IF EXISTS (SELECT 1 FROM users WHERE user_id = ?)
-			UPDATE users SET name = ?, email = ?, updated_at = ? WHERE user_id = ?
-		ELSE
-			INSERT INTO users (user_id, name, email, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		MERGE users WITH (HOLDLOCK) AS target
+		USING (SELECT ? AS user_id, ? AS name, ? AS email, ? AS created_at, ? AS updated_at) AS source
+			ON target.user_id = source.user_id
+		WHEN MATCHED THEN
+			UPDATE SET name = source.name, email = source.email, updated_at = source.updated_at
+		WHEN NOT MATCHED THEN
+			INSERT (user_id, name, email, created_at, updated_at)
+			VALUES (source.user_id, source.name, source.email, source.created_at, source.updated_at);
 	`
 
-	_, err := s.db.ExecContext(ctx, query,
-		user.UserID, user.Name, user.Email, user.UpdatedAt, user.UserID,
+	_, err := ex.ExecContext(ctx, query,
 		user.UserID, user.Name, user.Email, user.CreatedAt, user.UpdatedAt,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return s.stampProcessedBy(ctx, ex, "users", "user_id", user.UserID)
 }
 
-// UpsertOrder creates or updates an order record
-func (s *MSSQLStore) UpsertOrder(ctx context.Context, order *Order) error {
+// MaxUserBatchSize caps how many users UpsertUsersBatch accepts in one call
+const MaxUserBatchSize = 5000
+
+// userImportRow is a row of the dbo.UserImportType table type; field order must match the type
+type userImportRow struct {
+	UserID    string
+	Name      string
+	Email     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// UpsertUsersBatch inserts or updates users in a single MERGE, passing the rows as a
+// table-valued parameter (requires the dbo.UserImportType type from schema.sql).
+// The whole batch is applied in one transaction. When a user appears more than
+// once the last one wins.
+func (s *MSSQLStore) UpsertUsersBatch(ctx context.Context, users []*User) (inserted, updated int64, err error) {
+	if len(users) == 0 {
+		return 0, 0, nil
+	}
+	if len(users) > MaxUserBatchSize {
+		return 0, 0, fmt.Errorf("batch of %d users exceeds maximum of %d", len(users), MaxUserBatchSize)
+	}
+
+	// A MERGE fails if its source holds a key twice, so the last copy of a user wins
+	last := make(map[string]int, len(users))
+	for i, u := range users {
+		last[u.UserID] = i
+	}
+	rows := make([]userImportRow, 0, len(last))
+	for i, u := range users {
+		if last[u.UserID] != i {
+			continue
+		}
+		rows = append(rows, userImportRow{
+			UserID:    u.UserID,
+			Name:      u.Name,
+			Email:     u.Email,
+			CreatedAt: u.CreatedAt,
+			UpdatedAt: u.UpdatedAt,
+		})
+	}
+
 	query := `
-		IF EXISTS (SELECT 1 FROM orders WHERE order_id = ?)
-		BEGIN
-			UPDATE orders
-			SET user_id = ?,
-				total = ?,
-				status = ?,
-				updated_at = ?
-			WHERE order_id = ?
-		END
-		ELSE
-		BEGIN
-			INSERT INTO orders (order_id, user_id, total, status, created_at, updated_at)
-			VALUES (?, ?, ?, ?, ?, ?)
-		END
+		MERGE users WITH (HOLDLOCK) AS target
+		USING ? AS source
+			ON target.user_id = source.user_id
+		WHEN MATCHED THEN
+			UPDATE SET name = source.name, email = source.email, updated_at = source.updated_at
+		WHEN NOT MATCHED THEN
+			INSERT (user_id, name, email, created_at, updated_at)
+			VALUES (source.user_id, source.name, source.email, source.created_at, source.updated_at)
+		OUTPUT $action;
 	`
 
-	_, err := s.db.ExecContext(ctx, query,
-		// For IF EXISTS
-		order.OrderID,
+	err = s.retryTransient(ctx, func() error {
+		var err error
+		inserted, updated, err = s.mergeUsers(ctx, query, rows)
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
 
-		// For UPDATE
-		order.UserID,
-		order.Total,
-		order.Status,
-		order.UpdatedAt,
-		order.OrderID, // WHERE order_id = ?
+	return inserted, updated, nil
+}
 
-		// For INSERT
+// mergeUsers runs the bulk user MERGE in its own transaction and counts the actions taken
+func (s *MSSQLStore) mergeUsers(ctx context.Context, query string, rows []userImportRow) (inserted, updated int64, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.QueryContext(ctx, query, mssql.TVP{
+		TypeName: "dbo.UserImportType",
+		Value:    rows,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for result.Next() {
+		var action string
+		if err := result.Scan(&action); err != nil {
+			result.Close()
+			return 0, 0, err
+		}
+		if action == "INSERT" {
+			inserted++
+		} else {
+			updated++
+		}
+	}
+	if err := result.Err(); err != nil {
+		result.Close()
+		return 0, 0, err
+	}
+	result.Close()
+
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = row.UserID
+	}
+	if err := s.stampProcessedByBatch(ctx, tx, "users", "user_id", ids); err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return inserted, updated, nil
+}
+
+// patchableUserColumns maps the user fields a partial update may change to their columns
+var patchableUserColumns = map[string]string{
+	"name":  "name",
+	"email": "email",
+}
+
+// PatchUser updates only the given fields of an existing user. Field names are
+// checked against an allow-list before being used to build the SET clause.
+// It returns an error wrapping ErrNotFound if the user does not exist or has been
+// soft-deleted, so a profile change can't write data back onto a deleted user.
+func (s *MSSQLStore) PatchUser(ctx context.Context, userID string, fields map[string]interface{}) (err error) {
+	ctx, span := startSpan(ctx, "PatchUser")
+	defer endSpan(span, &err)
+
+	if len(fields) == 0 {
+		return fmt.Errorf("no fields to update")
+	}
+
+	// Sort field names so the generated statement is stable
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		if _, ok := patchableUserColumns[name]; !ok {
+			return fmt.Errorf("field %s cannot be updated", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	setClauses := make([]string, 0, len(names)+1)
+	args := make([]interface{}, 0, len(names)+2)
+	for _, name := range names {
+		setClauses = append(setClauses, patchableUserColumns[name]+" = ?")
+		args = append(args, fields[name])
+	}
+	setClauses = append(setClauses, "updated_at = ?")
+	args = append(args, time.Now(), userID)
+
+	query := `UPDATE users SET ` + strings.Join(setClauses, ", ") + ` WHERE user_id = ? AND deleted_at IS NULL`
+
+	result, err := s.execContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("user %w: %s", ErrNotFound, userID)
+	}
+
+	return nil
+}
+
+// UpsertOrder creates or updates an order record
+func (s *MSSQLStore) UpsertOrder(ctx context.Context, order *Order) (err error) {
+	ctx, span := startSpan(ctx, "UpsertOrder")
+	defer endSpan(span, &err)
+
+	return s.upsertOrder(ctx, retryingExecer{s}, order)
+}
+
+func (s *MSSQLStore) upsertOrder(ctx context.Context, ex execer, order *Order) error {
+	query := `
+		MERGE orders WITH (HOLDLOCK) AS target
+		USING (SELECT ? AS order_id, ? AS user_id, ? AS total, ? AS status, ? AS created_at, ? AS updated_at) AS source
+			ON target.order_id = source.order_id
+		WHEN MATCHED THEN
+			UPDATE SET user_id = source.user_id,
+				total = source.total,
+				status = source.status,
+				updated_at = source.updated_at
+		WHEN NOT MATCHED THEN
+			INSERT (order_id, user_id, total, status, created_at, updated_at)
+			VALUES (source.order_id, source.user_id, source.total, source.status, source.created_at, source.updated_at);
+	`
+
+	_, err := ex.ExecContext(ctx, query,
 		order.OrderID,
 		order.UserID,
 		order.Total,
@@ -94,69 +468,85 @@ func (s *MSSQLStore) UpsertOrder(ctx context.Context, order *Order) error {
 		order.CreatedAt,
 		order.UpdatedAt,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return s.stampProcessedBy(ctx, ex, "orders", "order_id", order.OrderID)
 }
 
-// UpsertPayment creates or updates a payment record
-func (s *MSSQLStore) UpsertPayment(ctx context.Context, payment *Payment) error {
-	query := `
-		IF EXISTS (SELECT 1 FROM payments WHERE order_id = ?)
-		BEGIN
-			UPDATE payments
-			SET status = ?,
-				amount = ?,
-				settled_at = ?,
-				updated_at = ?
-			WHERE order_id = ?
-		END
-		ELSE
-		BEGIN
-			INSERT INTO payments (order_id, status, amount, settled_at, updated_at)
-			VALUES (?, ?, ?, ?, ?)
-		END
-	`
+// UpdateOrderStatus changes an existing order's status, leaving its other columns
+// untouched. It returns an error if the order does not exist or is soft-deleted.
+func (s *MSSQLStore) UpdateOrderStatus(ctx context.Context, orderID, status string) (err error) {
+	ctx, span := startSpan(ctx, "UpdateOrderStatus")
+	defer endSpan(span, &err)
 
-	_, err := s.db.ExecContext(ctx, query,
-		// For IF EXISTS
-		payment.OrderID,
+	result, err := s.execContext(ctx, `UPDATE orders SET status = ?, updated_at = ? WHERE order_id = ? AND deleted_at IS NULL`, status, time.Now(), orderID)
+	if err != nil {
+		return err
+	}
 
-		// For UPDATE
-		payment.Status,
-		payment.Amount,
-		payment.SettledAt,
-		payment.UpdatedAt,
-		payment.OrderID, // WHERE order_id = ?
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("order %w: %s", ErrNotFound, orderID)
+	}
 
-		// For INSERT
+	return s.stampProcessedBy(ctx, retryingExecer{s}, "orders", "order_id", orderID)
+}
+
+// UpsertPayment creates or updates a payment record. Unless forced with
+// SetForcePaymentTransitions, it returns a *PaymentTransitionError instead of
+// moving an existing payment to a status it can't follow.
+func (s *MSSQLStore) UpsertPayment(ctx context.Context, payment *Payment) (err error) {
+	ctx, span := startSpan(ctx, "UpsertPayment")
+	defer endSpan(span, &err)
+
+	return s.upsertPayment(ctx, retryingExecer{s}, payment)
+}
+
+func (s *MSSQLStore) upsertPayment(ctx context.Context, ex execer, payment *Payment) error {
+	query := upsertPaymentGuardedQuery
+	if s.forcePaymentTransitions {
+		query = upsertPaymentForcedQuery
+	}
+
+	_, err := ex.ExecContext(ctx, query,
 		payment.OrderID,
 		payment.Status,
 		payment.Amount,
 		payment.SettledAt,
 		payment.UpdatedAt,
+		payment.OrderID, // For the rejected status lookup
 	)
+	if err != nil {
+		return asPaymentTransitionError(err, payment)
+	}
 
-	return err
+	return s.stampProcessedBy(ctx, ex, "payments", "order_id", payment.OrderID)
 }
 
-// UpsertInventory creates or updates an inventory record
-func (s *MSSQLStore) UpsertInventory(ctx context.Context, inventory *Inventory) error {
-	query := `
-		IF EXISTS (SELECT 1 FROM inventory WHERE sku = ?)
-		BEGIN
-			UPDATE inventory
-			SET quantity = quantity + ?,
-				last_adjusted_at = ?
-			WHERE sku = ?
-		END
-		ELSE
-		BEGIN
-			INSERT INTO inventory (sku, quantity, last_adjusted_at)
-			VALUES (?, ?, ?)
-		END
-	`
+// upsertInventoryQuery adds a delta to a SKU's quantity, creating the row if needed
+const upsertInventoryQuery = `
+	IF EXISTS (SELECT 1 FROM inventory WHERE sku = ?)
+	BEGIN
+		UPDATE inventory
+		SET quantity = quantity + ?,
+			last_adjusted_at = ?
+		WHERE sku = ?
+	END
+	ELSE
+	BEGIN
+		INSERT INTO inventory (sku, quantity, last_adjusted_at)
+		VALUES (?, ?, ?)
+	END
+`
 
-	_, err := s.db.ExecContext(ctx, query,
+// upsertInventoryArgs returns the arguments for upsertInventoryQuery
+func upsertInventoryArgs(inventory *Inventory) []interface{} {
+	return []interface{}{
 		// For IF EXISTS
 		inventory.SKU,
 
@@ -169,148 +559,1423 @@ func (s *MSSQLStore) UpsertInventory(ctx context.Context, inventory *Inventory)
 		inventory.SKU,
 		inventory.Quantity,
 		inventory.LastAdjustedAt,
-	)
-
-	return err
+	}
 }
 
-// GetUser retrieves a user by ID
-func (s *MSSQLStore) GetUser(ctx context.Context, userID string) (*User, error) {
-	query := `SELECT user_id, name, email, created_at, updated_at FROM users WHERE user_id = ?`
+// UpsertInventory creates or updates an inventory record
+func (s *MSSQLStore) UpsertInventory(ctx context.Context, inventory *Inventory) (err error) {
+	ctx, span := startSpan(ctx, "UpsertInventory")
+	defer endSpan(span, &err)
 
-	row := s.db.QueryRowContext(ctx, query, userID)
+	return s.upsertInventory(ctx, retryingExecer{s}, inventory)
+}
 
-	user := &User{}
-	err := row.Scan(&user.UserID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt)
+func (s *MSSQLStore) upsertInventory(ctx context.Context, ex execer, inventory *Inventory) error {
+	_, err := ex.ExecContext(ctx, upsertInventoryQuery, upsertInventoryArgs(inventory)...)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, err
+		return err
 	}
 
-	return user, nil
+	return s.stampProcessedBy(ctx, ex, "inventory", "sku", inventory.SKU)
 }
 
-// GetUserRecentOrders retrieves the last 5 orders for a user
-func (s *MSSQLStore) GetUserRecentOrders(ctx context.Context, userID string) ([]*Order, error) {
-	query := `
-		SELECT TOP 5 order_id, user_id, total, status, created_at, updated_at 
-		FROM orders 
-		WHERE user_id = ? 
-		ORDER BY created_at DESC
-	`
+// ApplyInventoryAdjustments adds the deltas of one or more InventoryAdjusted events
+// to a SKU. In the same transaction as the update, each eventId is recorded in
+// processed_events, and adjustments whose eventId is already there are skipped, so
+// an event redelivered after a crash between write and commit, or retried after
+// a connection dropped during commit, is never counted twice. Adjustments without
+// an eventId are always applied. Every applied
+// adjustment is also appended to inventory_adjustments with its reason. It returns
+// how many adjustments were skipped as duplicates.
+func (s *MSSQLStore) ApplyInventoryAdjustments(ctx context.Context, sku string, adjustments []InventoryAdjustment) (skipped int, err error) {
+	ctx, span := startSpan(ctx, "ApplyInventoryAdjustments")
+	defer endSpan(span, &err)
 
-	rows, err := s.db.QueryContext(ctx, query, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	err = s.retryTransient(ctx, func() error {
+		skipped = 0
 
-	var orders []*Order
-	for rows.Next() {
-		order := &Order{}
-		err := rows.Scan(&order.OrderID, &order.UserID, &order.Total, &order.Status, &order.CreatedAt, &order.UpdatedAt)
+		tx, err := s.db.BeginTx(ctx, nil)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		orders = append(orders, order)
-	}
+		defer tx.Rollback()
+		ex := txExecer{tx, s.writeStmts}
 
-	return orders, nil
-}
+		inventory := &Inventory{SKU: sku}
+		applied := 0
+		for _, adj := range adjustments {
+			if adj.EventID != "" {
+				first, err := recordProcessedEvent(ctx, ex, adj.EventID)
+				if err != nil {
+					return err
+				}
+				if !first {
+					skipped++
+					continue
+				}
+			}
 
-// GetOrder retrieves an order by ID
-func (s *MSSQLStore) GetOrder(ctx context.Context, orderID string) (*Order, error) {
-	query := `SELECT order_id, user_id, total, status, created_at, updated_at FROM orders WHERE order_id = ?`
+			_, err := ex.ExecContext(ctx, `
+				INSERT INTO inventory_adjustments (sku, event_id, delta, reason, adjusted_at)
+				VALUES (?, ?, ?, ?, ?)
+			`, sku, sql.NullString{String: adj.EventID, Valid: adj.EventID != ""}, adj.Delta, adj.Reason, adj.AdjustedAt)
+			if err != nil {
+				return fmt.Errorf("failed to record inventory adjustment: %w", err)
+			}
 
-	row := s.db.QueryRowContext(ctx, query, orderID)
+			inventory.Quantity += adj.Delta
+			if adj.AdjustedAt.After(inventory.LastAdjustedAt) {
+				inventory.LastAdjustedAt = adj.AdjustedAt
+			}
+			applied++
+		}
 
-	order := &Order{}
-	err := row.Scan(&order.OrderID, &order.UserID, &order.Total, &order.Status, &order.CreatedAt, &order.UpdatedAt)
+		if applied > 0 {
+			if _, err := ex.ExecContext(ctx, upsertInventoryQuery, upsertInventoryArgs(inventory)...); err != nil {
+				return err
+			}
+		}
+
+		return tx.Commit()
+	})
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
+		return 0, err
+	}
+
+	if skipped < len(adjustments) {
+		if err := s.stampProcessedBy(ctx, retryingExecer{s}, "inventory", "sku", sku); err != nil {
+			return skipped, err
 		}
-		return nil, err
 	}
+	return skipped, nil
+}
 
-	return order, nil
+// recordProcessedEvent inserts eventID into processed_events within ex's
+// transaction, reporting false if it was already there. The row lock it takes
+// makes a concurrent delivery of the same event wait for this transaction.
+func recordProcessedEvent(ctx context.Context, ex execer, eventID string) (bool, error) {
+	res, err := ex.ExecContext(ctx, `
+		INSERT INTO processed_events (event_id, processed_at)
+		SELECT ?, SYSUTCDATETIME()
+		WHERE NOT EXISTS (SELECT 1 FROM processed_events WITH (UPDLOCK, HOLDLOCK) WHERE event_id = ?)
+	`, eventID, eventID)
+	if err != nil {
+		return false, fmt.Errorf("failed to record processed event: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
 }
 
-// GetPayment retrieves a payment by order ID
-func (s *MSSQLStore) GetPayment(ctx context.Context, orderID string) (*Payment, error) {
-	query := `SELECT order_id, status, amount, settled_at, updated_at FROM payments WHERE order_id = ?`
+// ReserveInventory atomically decrements a SKU's quantity by qty unless that would take
+// it below zero. It reports whether the reservation succeeded and the quantity remaining,
+// read in the same transaction as the decrement; an unknown SKU is reported as not
+// reserved with zero remaining. A successful reservation records eventID in
+// processed_events in the same transaction, and a reservation whose eventId is
+// already there returns ErrDuplicateEvent without touching stock, so a redelivered
+// event never reserves twice and a transaction retried after a dropped connection is
+// safe. A successful reservation is also appended to inventory_adjustments as a
+// negative delta with ReservationReason, so the history sums to the stock. A refused
+// reservation isn't recorded and may be retried once stock arrives. An empty eventID
+// is never deduplicated.
+func (s *MSSQLStore) ReserveInventory(ctx context.Context, eventID, sku string, qty int) (reserved bool, remaining int, err error) {
+	ctx, span := startSpan(ctx, "ReserveInventory")
+	defer endSpan(span, &err)
+
+	if qty <= 0 {
+		return false, 0, fmt.Errorf("reservation quantity must be positive")
+	}
 
-	row := s.db.QueryRowContext(ctx, query, orderID)
+	err = s.retryTransient(ctx, func() error {
+		reserved, remaining = false, 0
 
-	payment := &Payment{}
-	err := row.Scan(&payment.OrderID, &payment.Status, &payment.Amount, &payment.SettledAt, &payment.UpdatedAt)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
 		}
-		return nil, err
-	}
+		// Rolling back also forgets the eventId of a refused reservation
+		defer tx.Rollback()
 
-	return payment, nil
-}
+		if eventID != "" {
+			first, err := recordProcessedEvent(ctx, txExecer{tx, s.writeStmts}, eventID)
+			if err != nil {
+				return err
+			}
+			if !first {
+				return ErrDuplicateEvent
+			}
+		}
 
-func (s *MSSQLStore) UpsertProductReview(ctx context.Context, review *ProductReview) error {
-	query := `
-		IF EXISTS (SELECT 1 FROM product_reviews WHERE review_id = ?)
-			UPDATE product_reviews 
-			SET product_name = ?, username = ?, rating = ?, remarks = ?, updated_at = ? 
-			WHERE review_id = ?
-		ELSE
-			INSERT INTO product_reviews (review_id, product_name, username, rating, remarks, created_at, updated_at) 
-			VALUES (?, ?, ?, ?, ?, ?, ?)
-	`
+		now := time.Now()
+		err = s.writeStmts.queryRowTx(ctx, tx, `
+			UPDATE inventory
+			SET quantity = quantity - ?,
+				last_adjusted_at = ?
+			OUTPUT inserted.quantity
+			WHERE sku = ? AND quantity >= ?
+		`, []interface{}{qty, now, sku, qty}, &remaining)
+		if err == nil {
+			_, err = txExecer{tx, s.writeStmts}.ExecContext(ctx, `
+				INSERT INTO inventory_adjustments (sku, event_id, delta, reason, adjusted_at)
+				VALUES (?, ?, ?, ?, ?)
+			`, sku, sql.NullString{String: eventID, Valid: eventID != ""}, -qty, ReservationReason, now)
+			if err != nil {
+				return fmt.Errorf("failed to record inventory reservation: %w", err)
+			}
+			reserved = true
+			return tx.Commit()
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
 
-	_, err := s.db.ExecContext(ctx, query,
-		review.ReviewID, review.ProductName, review.Username, review.Rating, review.Remarks, review.UpdatedAt, review.ReviewID,
-		review.ReviewID, review.ProductName, review.Username, review.Rating, review.Remarks, review.CreatedAt, review.UpdatedAt,
-	)
-	return err
+		// Not enough stock (or unknown SKU); report what is left, holding the row so
+		// the quantity read is the one the reservation was refused against
+		err = s.writeStmts.queryRowTx(ctx, tx, `
+			SELECT quantity FROM inventory WITH (UPDLOCK, HOLDLOCK) WHERE sku = ?
+		`, []interface{}{sku}, &remaining)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	return reserved, remaining, nil
 }
 
-// GetProductReview retrieves a product review by ID
-func (s *MSSQLStore) GetProductReview(ctx context.Context, reviewID string) (*ProductReview, error) {
-	query := `SELECT review_id, product_name, username, rating, remarks, created_at, updated_at FROM product_reviews WHERE review_id = ?`
+// GetUser retrieves a user by ID. Soft-deleted users are only returned under
+// IncludeDeleted.
+func (s *MSSQLStore) GetUser(ctx context.Context, userID string) (*User, error) {
+	query := `SELECT user_id, name, email, created_at, updated_at, deleted_at FROM users WHERE user_id = ? AND ` + notDeleted(ctx, "deleted_at")
+
+	stmt, err := s.readStmts.get(ctx, query)
+	if err != nil {
+		return nil, err
+	}
 
-	row := s.db.QueryRowContext(ctx, query, reviewID)
+	row := stmt.QueryRowContext(ctx, userID)
 
-	review := &ProductReview{}
-	err := row.Scan(&review.ReviewID, &review.ProductName, &review.Username, &review.Rating, &review.Remarks, &review.CreatedAt, &review.UpdatedAt)
+	user := &User{}
+	var deletedAt sql.NullTime
+	err = row.Scan(&user.UserID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt, &deletedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
+	user.DeletedAt = nullTimePtr(deletedAt)
 
-	return review, nil
+	return user, nil
+}
+
+// usersFilter returns the WHERE clause and arguments matching users that aren't
+// soft-deleted, and whose name or email contains nameLike when it is set
+func usersFilter(nameLike string) (string, []interface{}) {
+	if nameLike == "" {
+		return ` WHERE deleted_at IS NULL`, nil
+	}
+	pattern := "%" + escapeLike(nameLike) + "%"
+	return ` WHERE deleted_at IS NULL AND (name LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\')`, []interface{}{pattern, pattern}
 }
 
-// GetProductReviewsByProduct retrieves all reviews for a specific product
-func (s *MSSQLStore) GetProductReviewsByProduct(ctx context.Context, productName string) ([]*ProductReview, error) {
-	query := `SELECT review_id, product_name, username, rating, remarks, created_at, updated_at FROM product_reviews WHERE product_name = ? ORDER BY created_at DESC`
+// ListUsers returns a page of users ordered by ID, optionally only those whose
+// name or email contains nameLike
+func (s *MSSQLStore) ListUsers(ctx context.Context, limit, offset int, nameLike string) ([]*User, error) {
+	where, args := usersFilter(nameLike)
+	query := `SELECT user_id, name, email, created_at, updated_at FROM users` + where +
+		` ORDER BY user_id OFFSET ? ROWS FETCH NEXT ? ROWS ONLY`
+	args = append(args, offset, limit)
 
-	rows, err := s.db.QueryContext(ctx, query, productName)
+	rows, err := s.readDB().QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var reviews []*ProductReview
+	users := []*User{}
 	for rows.Next() {
-		review := &ProductReview{}
-		err := rows.Scan(&review.ReviewID, &review.ProductName, &review.Username, &review.Rating, &review.Remarks, &review.CreatedAt, &review.UpdatedAt)
-		if err != nil {
+		user := &User{}
+		if err := rows.Scan(&user.UserID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// CountUsers returns the number of users ListUsers pages through for nameLike
+func (s *MSSQLStore) CountUsers(ctx context.Context, nameLike string) (int64, error) {
+	where, args := usersFilter(nameLike)
+
+	var count int64
+	err := s.readDB().QueryRowContext(ctx, `SELECT COUNT(*) FROM users`+where, args...).Scan(&count)
+	return count, err
+}
+
+// exportPageSize is how many rows ExportUserData reads per query
+const exportPageSize = 1000
+
+// ExportUserData gathers the user row, all of their orders, the payments for those
+// orders and their reviews. Each collection is read in keyset-paginated pages so
+// users with long histories don't hold one huge result set open.
+// It returns nil if the user does not exist. Soft-deleted data is still held, so
+// it is exported too, with DeletedAt set.
+func (s *MSSQLStore) ExportUserData(ctx context.Context, userID string) (*UserExport, error) {
+	user, err := s.GetUser(IncludeDeleted(ctx), userID)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	export := &UserExport{
+		User:       user,
+		Orders:     []*Order{},
+		Payments:   []*Payment{},
+		Reviews:    []*ProductReview{},
+		ExportedAt: time.Now().UTC(),
+	}
+
+	err = s.queryPages(ctx, `
+		SELECT TOP (?) order_id, user_id, total, status, created_at, updated_at, deleted_at
+		FROM orders
+		WHERE user_id = ? AND order_id > ?
+		ORDER BY order_id
+	`, userID, func(rows *sql.Rows) (string, error) {
+		order := &Order{}
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&order.OrderID, &order.UserID, &order.Total, &order.Status, &order.CreatedAt, &order.UpdatedAt, &deletedAt); err != nil {
+			return "", err
+		}
+		order.DeletedAt = nullTimePtr(deletedAt)
+		export.Orders = append(export.Orders, order)
+		return order.OrderID, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export orders: %w", err)
+	}
+
+	err = s.queryPages(ctx, `
+		SELECT TOP (?) p.order_id, p.status, p.amount, p.settled_at, p.updated_at
+		FROM payments p
+		JOIN orders o ON o.order_id = p.order_id
+		WHERE o.user_id = ? AND p.order_id > ?
+		ORDER BY p.order_id
+	`, userID, func(rows *sql.Rows) (string, error) {
+		payment := &Payment{}
+		if err := rows.Scan(&payment.OrderID, &payment.Status, &payment.Amount, &payment.SettledAt, &payment.UpdatedAt); err != nil {
+			return "", err
+		}
+		export.Payments = append(export.Payments, payment)
+		return payment.OrderID, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export payments: %w", err)
+	}
+
+	err = s.queryPages(ctx, `
+		SELECT TOP (?) review_id, product_name, username, rating, remarks, verified, created_at, updated_at
+		FROM product_reviews
+		WHERE username = ? AND review_id > ?
+		ORDER BY review_id
+	`, userID, func(rows *sql.Rows) (string, error) {
+		review := &ProductReview{}
+		if err := rows.Scan(&review.ReviewID, &review.ProductName, &review.Username, &review.Rating, &review.Remarks, &review.Verified, &review.CreatedAt, &review.UpdatedAt); err != nil {
+			return "", err
+		}
+		export.Reviews = append(export.Reviews, review)
+		return review.ReviewID, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export reviews: %w", err)
+	}
+
+	return export, nil
+}
+
+// queryPages runs a keyset-paginated query until a short page is returned. The query
+// takes TOP (?), the owner ID and the last key seen, in that order; scan reads one
+// row and returns its key.
+func (s *MSSQLStore) queryPages(ctx context.Context, query, ownerID string, scan func(*sql.Rows) (string, error)) error {
+	after := ""
+	for {
+		rows, err := s.readDB().QueryContext(ctx, query, exportPageSize, ownerID, after)
+		if err != nil {
+			return err
+		}
+
+		n := 0
+		for rows.Next() {
+			key, err := scan(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			after = key
+			n++
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		if n < exportPageSize {
+			return nil
+		}
+	}
+}
+
+// Bounds for the number of recent orders returned with a user
+const (
+	DefaultRecentOrders = 5
+	MaxRecentOrders     = 50
+)
+
+// GetUserRecentOrders retrieves a user's most recent orders, newest first. A limit
+// outside 1..MaxRecentOrders falls back to DefaultRecentOrders or MaxRecentOrders.
+func (s *MSSQLStore) GetUserRecentOrders(ctx context.Context, userID string, limit int) ([]*Order, error) {
+	if limit <= 0 {
+		limit = DefaultRecentOrders
+	}
+	if limit > MaxRecentOrders {
+		limit = MaxRecentOrders
+	}
+
+	query := `
+		SELECT TOP (?) order_id, user_id, total, status, created_at, updated_at, deleted_at
+		FROM orders
+		WHERE user_id = ? AND ` + notDeleted(ctx, "deleted_at") + `
+		ORDER BY created_at DESC
+	`
+
+	stmt, err := s.readStmts.get(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, limit, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []*Order
+	for rows.Next() {
+		order := &Order{}
+		var deletedAt sql.NullTime
+		err := rows.Scan(&order.OrderID, &order.UserID, &order.Total, &order.Status, &order.CreatedAt, &order.UpdatedAt, &deletedAt)
+		if err != nil {
+			return nil, err
+		}
+		order.DeletedAt = nullTimePtr(deletedAt)
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+// Bounds for the number of orders returned by GetUserOrdersWithPayments
+const (
+	DefaultUserOrders = 20
+	MaxUserOrders     = 200
+)
+
+// GetUserOrdersWithPayments retrieves a user's orders, newest first, each with its
+// payment, in a single query. A limit outside 1..MaxUserOrders falls back to
+// DefaultUserOrders or MaxUserOrders.
+func (s *MSSQLStore) GetUserOrdersWithPayments(ctx context.Context, userID string, limit int) ([]*OrderWithPayment, error) {
+	if limit <= 0 {
+		limit = DefaultUserOrders
+	}
+	if limit > MaxUserOrders {
+		limit = MaxUserOrders
+	}
+
+	query := `
+		SELECT TOP (?) o.order_id, o.user_id, o.total, o.status, o.created_at, o.updated_at, o.deleted_at,
+			p.order_id, p.status, p.amount, p.settled_at, p.updated_at
+		FROM orders o
+		LEFT JOIN payments p ON p.order_id = o.order_id
+		WHERE o.user_id = ? AND ` + notDeleted(ctx, "o.deleted_at") + `
+		ORDER BY o.created_at DESC
+	`
+
+	stmt, err := s.readStmts.get(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, limit, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*OrderWithPayment{}
+	for rows.Next() {
+		order := &Order{}
+		var paymentOrderID, paymentStatus sql.NullString
+		var amount sql.NullFloat64
+		var deletedAt, settledAt, paymentUpdatedAt sql.NullTime
+		err := rows.Scan(&order.OrderID, &order.UserID, &order.Total, &order.Status, &order.CreatedAt, &order.UpdatedAt, &deletedAt,
+			&paymentOrderID, &paymentStatus, &amount, &settledAt, &paymentUpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		order.DeletedAt = nullTimePtr(deletedAt)
+
+		// Orders without a payment have NULL payment columns
+		result := &OrderWithPayment{Order: order}
+		if paymentOrderID.Valid {
+			result.Payment = &Payment{
+				OrderID:   paymentOrderID.String,
+				Status:    paymentStatus.String,
+				Amount:    amount.Float64,
+				SettledAt: settledAt.Time,
+				UpdatedAt: paymentUpdatedAt.Time,
+			}
+		}
+		orders = append(orders, result)
+	}
+
+	return orders, rows.Err()
+}
+
+// GetOrder retrieves an order by ID. Soft-deleted orders are only returned under
+// IncludeDeleted.
+func (s *MSSQLStore) GetOrder(ctx context.Context, orderID string) (*Order, error) {
+	query := `SELECT order_id, user_id, total, status, created_at, updated_at, deleted_at FROM orders WHERE order_id = ? AND ` + notDeleted(ctx, "deleted_at")
+
+	stmt, err := s.readStmts.get(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	row := stmt.QueryRowContext(ctx, orderID)
+
+	order := &Order{}
+	var deletedAt sql.NullTime
+	err = row.Scan(&order.OrderID, &order.UserID, &order.Total, &order.Status, &order.CreatedAt, &order.UpdatedAt, &deletedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	order.DeletedAt = nullTimePtr(deletedAt)
+
+	return order, nil
+}
+
+// GetInventory retrieves the inventory record for a SKU
+func (s *MSSQLStore) GetInventory(ctx context.Context, sku string) (*Inventory, error) {
+	query := `SELECT sku, quantity, last_adjusted_at FROM inventory WHERE sku = ?`
+
+	stmt, err := s.readStmts.get(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	row := stmt.QueryRowContext(ctx, sku)
+
+	inventory := &Inventory{}
+	err = row.Scan(&inventory.SKU, &inventory.Quantity, &inventory.LastAdjustedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return inventory, nil
+}
+
+// GetInventoryHistory returns every recorded adjustment to a SKU, oldest first,
+// including reservations. Adjustments applied before inventory_adjustments existed are not included.
+func (s *MSSQLStore) GetInventoryHistory(ctx context.Context, sku string) ([]InventoryAdjustment, error) {
+	query := `
+		SELECT event_id, delta, reason, adjusted_at
+		FROM inventory_adjustments
+		WHERE sku = ?
+		ORDER BY adjusted_at, id
+	`
+
+	rows, err := s.readDB().QueryContext(ctx, query, sku)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []InventoryAdjustment{}
+	for rows.Next() {
+		var adj InventoryAdjustment
+		var eventID sql.NullString
+		if err := rows.Scan(&eventID, &adj.Delta, &adj.Reason, &adj.AdjustedAt); err != nil {
+			return nil, err
+		}
+		adj.EventID = eventID.String
+		history = append(history, adj)
+	}
+
+	return history, rows.Err()
+}
+
+// GetPayment retrieves a payment by order ID
+func (s *MSSQLStore) GetPayment(ctx context.Context, orderID string) (*Payment, error) {
+	query := `SELECT order_id, status, amount, settled_at, updated_at FROM payments WHERE order_id = ?`
+
+	stmt, err := s.readStmts.get(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	row := stmt.QueryRowContext(ctx, orderID)
+
+	payment := &Payment{}
+	err = row.Scan(&payment.OrderID, &payment.Status, &payment.Amount, &payment.SettledAt, &payment.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// paymentTimelineTypes names the timeline entry for a payment in each status;
+// other statuses are shown as PaymentUpdated
+var paymentTimelineTypes = map[string]string{
+	"pending":  "PaymentPending",
+	"settled":  "PaymentSettled",
+	"failed":   "PaymentFailed",
+	"refunded": "PaymentRefunded",
+}
+
+// GetOrderTimeline assembles a chronological history for an order from the stored
+// order and payment timestamps. Only the current state is stored, so the timeline
+// holds the placement, the order's last update with its current status, and the
+// payment in its current status; earlier status changes aren't kept. It returns nil
+// if the order does not exist.
+func (s *MSSQLStore) GetOrderTimeline(ctx context.Context, orderID string) ([]TimelineEvent, error) {
+	order, err := s.GetOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order == nil {
+		return nil, nil
+	}
+
+	timeline := []TimelineEvent{{
+		Type:   "OrderPlaced",
+		At:     order.CreatedAt,
+		Detail: fmt.Sprintf("order placed by %s for %.2f", order.UserID, order.Total),
+	}}
+
+	if order.UpdatedAt.After(order.CreatedAt) {
+		timeline = append(timeline, TimelineEvent{
+			Type:   "OrderUpdated",
+			At:     order.UpdatedAt,
+			Detail: fmt.Sprintf("last updated, now %s", order.Status),
+		})
+	}
+
+	payment, err := s.GetPayment(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if payment != nil {
+		eventType, ok := paymentTimelineTypes[payment.Status]
+		if !ok {
+			eventType = "PaymentUpdated"
+		}
+		// settled_at is when the payment settled; any later move, such as a refund,
+		// happened at its last update
+		at := payment.SettledAt
+		if payment.Status != "settled" && !payment.UpdatedAt.IsZero() {
+			at = payment.UpdatedAt
+		}
+		timeline = append(timeline, TimelineEvent{
+			Type:   eventType,
+			At:     at,
+			Detail: fmt.Sprintf("payment %s for %.2f", payment.Status, payment.Amount),
+		})
+	}
+
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].At.Before(timeline[j].At)
+	})
+
+	return timeline, nil
+}
+
+// updatableEntities maps entity names to their table and ID column for change tracking
+var updatableEntities = map[string]struct{ table, idColumn string }{
+	"users":    {"users", "user_id"},
+	"orders":   {"orders", "order_id"},
+	"payments": {"payments", "order_id"},
+	"reviews":  {"product_reviews", "review_id"},
+}
+
+// IsTrackedEntity reports whether entity supports GetRecentlyUpdated
+func IsTrackedEntity(entity string) bool {
+	_, ok := updatableEntities[entity]
+	return ok
+}
+
+// Bounds for the number of changes returned by GetRecentlyUpdated
+const (
+	DefaultChanges = 100
+	MaxChanges     = 1000
+)
+
+// GetRecentlyUpdated returns up to limit entities updated after the watermark, oldest
+// change first, so a cache-invalidation worker can evict them and advance its
+// watermark to the last change returned. The watermark is since, and afterID, when
+// set, also takes the entities updated exactly at since whose ID sorts after it, so
+// a page cut between changes sharing a timestamp resumes where it stopped. A limit
+// outside 1..MaxChanges falls back to DefaultChanges or MaxChanges.
+func (s *MSSQLStore) GetRecentlyUpdated(ctx context.Context, entity string, since time.Time, afterID string, limit int) ([]Change, error) {
+	target, ok := updatableEntities[entity]
+	if !ok {
+		return nil, fmt.Errorf("unknown entity: %s", entity)
+	}
+	if limit <= 0 {
+		limit = DefaultChanges
+	}
+	if limit > MaxChanges {
+		limit = MaxChanges
+	}
+
+	where, args := "updated_at > ?", []interface{}{limit, since}
+	if afterID != "" {
+		where = fmt.Sprintf("(updated_at > ? OR (updated_at = ? AND %s > ?))", target.idColumn)
+		args = append(args, since, afterID)
+	}
+	query := fmt.Sprintf(`SELECT TOP (?) %s, updated_at FROM %s WHERE %s ORDER BY updated_at ASC, %s ASC`,
+		target.idColumn, target.table, where, target.idColumn)
+
+	rows, err := s.readDB().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []Change{}
+	for rows.Next() {
+		var c Change
+		if err := rows.Scan(&c.ID, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
+
+// GlobalSearch looks up term as a prefix of user, order and review IDs, returning up to
+// limit matches per entity. Payments are matched by order ID. Nothing matching yields
+// empty result slices rather than an error.
+func (s *MSSQLStore) GlobalSearch(ctx context.Context, term string, limit int) (*SearchResults, error) {
+	pattern := escapeLike(term) + "%"
+	results := &SearchResults{
+		Users:    []*User{},
+		Orders:   []*Order{},
+		Payments: []*Payment{},
+		Reviews:  []*ProductReview{},
+	}
+
+	// Users
+	rows, err := s.readDB().QueryContext(ctx, `SELECT TOP (?) user_id, name, email, created_at, updated_at FROM users WHERE user_id LIKE ? ESCAPE '\' AND deleted_at IS NULL ORDER BY user_id`, limit, pattern)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		user := &User{}
+		if err := rows.Scan(&user.UserID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		results.Users = append(results.Users, user)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Orders
+	rows, err = s.readDB().QueryContext(ctx, `SELECT TOP (?) order_id, user_id, total, status, created_at, updated_at FROM orders WHERE order_id LIKE ? ESCAPE '\' AND deleted_at IS NULL ORDER BY order_id`, limit, pattern)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		order := &Order{}
+		if err := rows.Scan(&order.OrderID, &order.UserID, &order.Total, &order.Status, &order.CreatedAt, &order.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		results.Orders = append(results.Orders, order)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Payments
+	rows, err = s.readDB().QueryContext(ctx, `SELECT TOP (?) order_id, status, amount, settled_at, updated_at FROM payments WHERE order_id LIKE ? ESCAPE '\' ORDER BY order_id`, limit, pattern)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		payment := &Payment{}
+		if err := rows.Scan(&payment.OrderID, &payment.Status, &payment.Amount, &payment.SettledAt, &payment.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		results.Payments = append(results.Payments, payment)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Reviews
+	rows, err = s.readDB().QueryContext(ctx, `SELECT TOP (?) review_id, product_name, username, rating, remarks, verified, created_at, updated_at FROM product_reviews WHERE review_id LIKE ? ESCAPE '\' ORDER BY review_id`, limit, pattern)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		review := &ProductReview{}
+		if err := rows.Scan(&review.ReviewID, &review.ProductName, &review.Username, &review.Rating, &review.Remarks, &review.Verified, &review.CreatedAt, &review.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		results.Reviews = append(results.Reviews, review)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// escapeLike escapes LIKE wildcards so user input is matched literally
+func escapeLike(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `[`, `\[`)
+	return replacer.Replace(s)
+}
+
+// ratingBucketExprs maps a trend bucket to the SQL expression for its period start.
+// Weeks start on Monday (1900-01-01 was a Monday).
+var ratingBucketExprs = map[string]string{
+	"week":  "DATEADD(day, (DATEDIFF(day, 0, created_at) / 7) * 7, 0)",
+	"month": "DATEFROMPARTS(YEAR(created_at), MONTH(created_at), 1)",
+}
+
+// IsValidRatingBucket reports whether bucket is a supported trend period
+func IsValidRatingBucket(bucket string) bool {
+	_, ok := ratingBucketExprs[bucket]
+	return ok
+}
+
+// GetRatingTrend returns the average rating and review count per week or month for a
+// product, oldest first. Periods with no reviews are omitted; see FillRatingGaps.
+func (s *MSSQLStore) GetRatingTrend(ctx context.Context, productName string, bucket string, from, to time.Time) ([]RatingBucket, error) {
+	expr, ok := ratingBucketExprs[bucket]
+	if !ok {
+		return nil, fmt.Errorf("invalid bucket: %s", bucket)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s AS period_start, AVG(CAST(rating AS FLOAT)), COUNT(*)
+		FROM product_reviews
+		WHERE product_name = ?`, expr)
+	args := []interface{}{productName}
+
+	if !from.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, from)
+	}
+	if !to.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, to)
+	}
+	query += fmt.Sprintf(` GROUP BY %s ORDER BY period_start`, expr)
+
+	rows, err := s.readDB().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []RatingBucket{}
+	for rows.Next() {
+		var b RatingBucket
+		if err := rows.Scan(&b.PeriodStart, &b.AvgRating, &b.Count); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+
+	return buckets, rows.Err()
+}
+
+// FillRatingGaps inserts zero buckets for periods between from and to that have no
+// reviews. A zero from or to falls back to the first or last existing bucket.
+func FillRatingGaps(buckets []RatingBucket, bucket string, from, to time.Time) []RatingBucket {
+	start, end := periodStart(from, bucket), periodStart(to, bucket)
+	if from.IsZero() {
+		if len(buckets) == 0 {
+			return buckets
+		}
+		start = buckets[0].PeriodStart
+	}
+	if to.IsZero() {
+		if len(buckets) == 0 {
+			return buckets
+		}
+		end = buckets[len(buckets)-1].PeriodStart
+	}
+
+	existing := make(map[time.Time]RatingBucket, len(buckets))
+	for _, b := range buckets {
+		existing[b.PeriodStart.UTC()] = b
+	}
+
+	filled := []RatingBucket{}
+	for p := start; !p.After(end); p = nextPeriod(p, bucket) {
+		if b, ok := existing[p]; ok {
+			filled = append(filled, b)
+		} else {
+			filled = append(filled, RatingBucket{PeriodStart: p})
+		}
+	}
+
+	return filled
+}
+
+// GetProductReviewStats returns a product's average rating, review count and the
+// number of reviews per star (1-5, always present). A product without reviews has
+// an average and count of 0.
+func (s *MSSQLStore) GetProductReviewStats(ctx context.Context, productName string) (float64, int, map[int]int, error) {
+	query := `
+		SELECT rating, COUNT(*)
+		FROM product_reviews
+		WHERE product_name = ?
+		GROUP BY rating
+	`
+
+	rows, err := s.readDB().QueryContext(ctx, query, productName)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer rows.Close()
+
+	histogram := map[int]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}
+	count, sum := 0, 0
+	for rows.Next() {
+		var rating, n int
+		if err := rows.Scan(&rating, &n); err != nil {
+			return 0, 0, nil, err
+		}
+		histogram[rating] = n
+		count += n
+		sum += rating * n
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, nil, err
+	}
+
+	// Averaging the grouped counts gives the same result as AVG(rating) without a second scan
+	var avg float64
+	if count > 0 {
+		avg = float64(sum) / float64(count)
+	}
+	return avg, count, histogram, nil
+}
+
+// periodStart truncates t to the start of its week (Monday) or month in UTC
+func periodStart(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if bucket == "month" {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+// nextPeriod returns the start of the period after p
+func nextPeriod(p time.Time, bucket string) time.Time {
+	if bucket == "month" {
+		return p.AddDate(0, 1, 0)
+	}
+	return p.AddDate(0, 0, 7)
+}
+
+// ListOrdersNeedingReminder returns orders placed more than olderThan ago that have no
+// settled payment and aren't cancelled or soft-deleted, oldest first. With excludeReminded, orders that
+// already had a reminder sent are skipped.
+func (s *MSSQLStore) ListOrdersNeedingReminder(ctx context.Context, olderThan time.Duration, excludeReminded bool, limit, offset int) ([]*Order, error) {
+	query := `
+		SELECT o.order_id, o.user_id, o.total, o.status, o.created_at, o.updated_at
+		FROM orders o
+		LEFT JOIN payments p ON p.order_id = o.order_id AND p.status = 'settled'
+		WHERE p.order_id IS NULL
+			AND o.status <> 'cancelled'
+			AND o.deleted_at IS NULL
+			AND o.created_at < ?`
+	if excludeReminded {
+		query += ` AND o.reminder_sent_at IS NULL`
+	}
+	query += ` ORDER BY o.created_at, o.order_id OFFSET ? ROWS FETCH NEXT ? ROWS ONLY`
+
+	rows, err := s.readDB().QueryContext(ctx, query, time.Now().Add(-olderThan), offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*Order{}
+	for rows.Next() {
+		order := &Order{}
+		err := rows.Scan(&order.OrderID, &order.UserID, &order.Total, &order.Status, &order.CreatedAt, &order.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, rows.Err()
+}
+
+// MarkReminderSent records that a payment reminder was sent for an order.
+// It returns false if the order does not exist or has been soft-deleted.
+func (s *MSSQLStore) MarkReminderSent(ctx context.Context, orderID string) (bool, error) {
+	result, err := s.execContext(ctx, `UPDATE orders SET reminder_sent_at = ? WHERE order_id = ? AND deleted_at IS NULL`, time.Now(), orderID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// MaxOrderQueryLimit is the largest page QueryOrders returns
+const MaxOrderQueryLimit = 500
+
+// QueryOrders returns a page of the orders matching filter, newest first
+func (s *MSSQLStore) QueryOrders(ctx context.Context, filter OrderFilter) ([]*Order, error) {
+	if filter.Limit <= 0 || filter.Limit > MaxOrderQueryLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", MaxOrderQueryLimit)
+	}
+
+	query := `SELECT order_id, user_id, total, status, created_at, updated_at FROM orders WHERE deleted_at IS NULL`
+	args := []interface{}{}
+	if filter.UserID != "" {
+		query += ` AND user_id = ?`
+		args = append(args, filter.UserID)
+	}
+	if filter.Status != "" {
+		query += ` AND status = ?`
+		args = append(args, filter.Status)
+	}
+	if !filter.From.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, filter.To)
+	}
+	query += ` ORDER BY created_at DESC, order_id OFFSET ? ROWS FETCH NEXT ? ROWS ONLY`
+	args = append(args, filter.Offset, filter.Limit)
+
+	rows, err := s.readDB().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*Order{}
+	for rows.Next() {
+		order := &Order{}
+		if err := rows.Scan(&order.OrderID, &order.UserID, &order.Total, &order.Status, &order.CreatedAt, &order.UpdatedAt); err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, rows.Err()
+}
+
+// OrderStatuses lists the order statuses always reported by GetOrderStatusCounts
+var OrderStatuses = []string{"placed", "paid", "shipped", "delivered", "cancelled"}
+
+// GetOrderStatusCounts counts live orders per status, optionally restricted to orders created
+// in [from, to); zero times leave that side of the range open. Every status in
+// OrderStatuses is present in the result, with zero if no orders have it; statuses
+// outside that list are included as found.
+func (s *MSSQLStore) GetOrderStatusCounts(ctx context.Context, from, to time.Time) (map[string]int64, error) {
+	query := `SELECT status, COUNT(*) FROM orders WHERE deleted_at IS NULL`
+	args := []interface{}{}
+	if !from.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, from)
+	}
+	if !to.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, to)
+	}
+	query += ` GROUP BY status`
+
+	rows, err := s.readDB().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64, len(OrderStatuses))
+	for _, status := range OrderStatuses {
+		counts[status] = 0
+	}
+	for rows.Next() {
+		var status sql.NullString
+		var count int64
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		name := status.String
+		if !status.Valid || name == "" {
+			name = "unknown"
+		}
+		counts[name] += count
+	}
+
+	return counts, rows.Err()
+}
+
+// RecordHeartbeat upserts a consumer instance's heartbeat row in the optional
+// consumer_heartbeats table. A zero lastProcessedAt is stored as NULL.
+func (s *MSSQLStore) RecordHeartbeat(ctx context.Context, podID string, startedAt, lastProcessedAt time.Time) error {
+	query := `
+		IF EXISTS (SELECT 1 FROM consumer_heartbeats WHERE pod_id = ?)
+			UPDATE consumer_heartbeats SET started_at = ?, last_seen_at = ?, last_processed_at = ? WHERE pod_id = ?
+		ELSE
+			INSERT INTO consumer_heartbeats (pod_id, started_at, last_seen_at, last_processed_at) VALUES (?, ?, ?, ?)
+	`
+
+	var processed sql.NullTime
+	if !lastProcessedAt.IsZero() {
+		processed = sql.NullTime{Time: lastProcessedAt, Valid: true}
+	}
+	now := time.Now()
+
+	_, err := s.execContext(ctx, query,
+		podID, startedAt, now, processed, podID,
+		podID, startedAt, now, processed,
+	)
+	return err
+}
+
+// GetConsumerHeartbeats returns every consumer heartbeat, most recently seen first
+func (s *MSSQLStore) GetConsumerHeartbeats(ctx context.Context) ([]*ConsumerHeartbeat, error) {
+	query := `SELECT pod_id, started_at, last_seen_at, last_processed_at FROM consumer_heartbeats ORDER BY last_seen_at DESC`
+
+	rows, err := s.readDB().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	heartbeats := []*ConsumerHeartbeat{}
+	for rows.Next() {
+		hb := &ConsumerHeartbeat{}
+		var processed sql.NullTime
+		if err := rows.Scan(&hb.PodID, &hb.StartedAt, &hb.LastSeenAt, &processed); err != nil {
+			return nil, err
+		}
+		if processed.Valid {
+			hb.LastProcessedAt = &processed.Time
+		}
+		heartbeats = append(heartbeats, hb)
+	}
+
+	return heartbeats, rows.Err()
+}
+
+// ListOrphanPayments returns payments whose order has never been ingested, oldest update first
+func (s *MSSQLStore) ListOrphanPayments(ctx context.Context, limit, offset int) ([]*Payment, error) {
+	query := `
+		SELECT p.order_id, p.status, p.amount, p.settled_at, p.updated_at
+		FROM payments p
+		LEFT JOIN orders o ON o.order_id = p.order_id
+		WHERE o.order_id IS NULL
+		ORDER BY p.updated_at, p.order_id
+		OFFSET ? ROWS FETCH NEXT ? ROWS ONLY
+	`
+
+	rows, err := s.readDB().QueryContext(ctx, query, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []*Payment{}
+	for rows.Next() {
+		payment := &Payment{}
+		if err := rows.Scan(&payment.OrderID, &payment.Status, &payment.Amount, &payment.SettledAt, &payment.UpdatedAt); err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+
+	return payments, rows.Err()
+}
+
+// CountOrphanPayments returns the number of payments whose order has never been ingested
+func (s *MSSQLStore) CountOrphanPayments(ctx context.Context) (int64, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM payments p
+		LEFT JOIN orders o ON o.order_id = p.order_id
+		WHERE o.order_id IS NULL
+	`
+
+	var count int64
+	err := s.readDB().QueryRowContext(ctx, query).Scan(&count)
+	return count, err
+}
+
+// ResolveOrphanPayment deletes a payment if its order still doesn't exist. It returns
+// false if there is no such payment or its order has since arrived, in which case
+// nothing is deleted.
+func (s *MSSQLStore) ResolveOrphanPayment(ctx context.Context, orderID string) (bool, error) {
+	query := `
+		DELETE FROM payments
+		WHERE order_id = ?
+			AND NOT EXISTS (SELECT 1 FROM orders WHERE order_id = ?)
+	`
+
+	result, err := s.execContext(ctx, query, orderID, orderID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// paymentStatuses is the set of payment statuses that can be queried
+var paymentStatuses = map[string]bool{
+	"pending":  true,
+	"settled":  true,
+	"failed":   true,
+	"refunded": true,
+}
+
+// IsValidPaymentStatus reports whether status is a known payment status
+func IsValidPaymentStatus(status string) bool {
+	return paymentStatuses[status]
+}
+
+// ListPaymentsByStatus retrieves payments in a given status, optionally bounded by settled_at.
+// A zero from or to leaves that side of the range open.
+func (s *MSSQLStore) ListPaymentsByStatus(ctx context.Context, status string, from, to time.Time, limit, offset int) ([]*Payment, error) {
+	if !IsValidPaymentStatus(status) {
+		return nil, fmt.Errorf("invalid payment status: %s", status)
+	}
+
+	query := `SELECT order_id, status, amount, settled_at, updated_at FROM payments WHERE status = ?`
+	args := []interface{}{status}
+
+	if !from.IsZero() {
+		query += ` AND settled_at >= ?`
+		args = append(args, from)
+	}
+	if !to.IsZero() {
+		query += ` AND settled_at < ?`
+		args = append(args, to)
+	}
+
+	query += ` ORDER BY settled_at DESC, order_id OFFSET ? ROWS FETCH NEXT ? ROWS ONLY`
+	args = append(args, offset, limit)
+
+	rows, err := s.readDB().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []*Payment{}
+	for rows.Next() {
+		payment := &Payment{}
+		err := rows.Scan(&payment.OrderID, &payment.Status, &payment.Amount, &payment.SettledAt, &payment.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+
+	return payments, rows.Err()
+}
+
+func (s *MSSQLStore) UpsertProductReview(ctx context.Context, review *ProductReview) (err error) {
+	ctx, span := startSpan(ctx, "UpsertProductReview")
+	defer endSpan(span, &err)
+
+	return s.upsertProductReview(ctx, retryingExecer{s}, review)
+}
+
+func (s *MSSQLStore) upsertProductReview(ctx context.Context, ex execer, review *ProductReview) error {
+	query := `
+		MERGE product_reviews WITH (HOLDLOCK) AS target
+		USING (
+			SELECT ? AS review_id, ? AS product_name, ? AS username, ? AS rating, ? AS remarks,
+				CASE WHEN EXISTS (SELECT 1 FROM orders WHERE user_id = ?) THEN 1 ELSE 0 END AS verified,
+				? AS created_at, ? AS updated_at
+		) AS source
+			ON target.review_id = source.review_id
+		WHEN MATCHED THEN
+			UPDATE SET product_name = source.product_name, username = source.username, rating = source.rating,
+				remarks = source.remarks, verified = source.verified, updated_at = source.updated_at
+		WHEN NOT MATCHED THEN
+			INSERT (review_id, product_name, username, rating, remarks, verified, created_at, updated_at)
+			VALUES (source.review_id, source.product_name, source.username, source.rating, source.remarks,
+				source.verified, source.created_at, source.updated_at);
+	`
+
+	// A review is a verified purchase when its username is a user ID with at least one order
+	_, err := ex.ExecContext(ctx, query,
+		review.ReviewID, review.ProductName, review.Username, review.Rating, review.Remarks,
+		review.Username, review.CreatedAt, review.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return s.stampProcessedBy(ctx, ex, "product_reviews", "review_id", review.ReviewID)
+}
+
+// GetProductReview retrieves a product review by ID
+func (s *MSSQLStore) GetProductReview(ctx context.Context, reviewID string) (*ProductReview, error) {
+	query := `SELECT review_id, product_name, username, rating, remarks, verified, created_at, updated_at FROM product_reviews WHERE review_id = ?`
+
+	stmt, err := s.readStmts.get(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	row := stmt.QueryRowContext(ctx, reviewID)
+
+	review := &ProductReview{}
+	err = row.Scan(&review.ReviewID, &review.ProductName, &review.Username, &review.Rating, &review.Remarks, &review.Verified, &review.CreatedAt, &review.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return review, nil
+}
+
+// GetProductReviewsByProduct retrieves a page of reviews for a product, newest first.
+// With verifiedOnly, only verified-purchase reviews are returned.
+// Pages are keyed on (created_at, review_id) so each page is an index seek rather than
+// an ever-growing OFFSET scan. Pass a nil cursor for the first page; the returned cursor
+// is nil when there are no more reviews.
+func (s *MSSQLStore) GetProductReviewsByProduct(ctx context.Context, productName string, verifiedOnly bool, limit int, cursor *ReviewCursor) ([]*ProductReview, *ReviewCursor, error) {
+	query := `SELECT TOP (?) review_id, product_name, username, rating, remarks, verified, created_at, updated_at FROM product_reviews WHERE product_name = ?`
+	// Fetch one extra row to learn whether another page exists
+	args := []interface{}{limit + 1, productName}
+	if verifiedOnly {
+		query += ` AND verified = 1`
+	}
+	if cursor != nil {
+		query += ` AND (created_at < ? OR (created_at = ? AND review_id < ?))`
+		args = append(args, cursor.CreatedAt, cursor.CreatedAt, cursor.ReviewID)
+	}
+	query += ` ORDER BY created_at DESC, review_id DESC`
+
+	rows, err := s.readDB().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	reviews := []*ProductReview{}
+	for rows.Next() {
+		review := &ProductReview{}
+		err := rows.Scan(&review.ReviewID, &review.ProductName, &review.Username, &review.Rating, &review.Remarks, &review.Verified, &review.CreatedAt, &review.UpdatedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+		reviews = append(reviews, review)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(reviews) <= limit {
+		return reviews, nil, nil
+	}
+
+	reviews = reviews[:limit]
+	last := reviews[limit-1]
+	return reviews, &ReviewCursor{CreatedAt: last.CreatedAt, ReviewID: last.ReviewID}, nil
+}
+
+// GetVerifiedReviews retrieves all verified-purchase reviews for a product, newest first
+func (s *MSSQLStore) GetVerifiedReviews(ctx context.Context, productName string) ([]*ProductReview, error) {
+	query := `SELECT review_id, product_name, username, rating, remarks, verified, created_at, updated_at FROM product_reviews WHERE product_name = ? AND verified = 1 ORDER BY created_at DESC`
+
+	rows, err := s.readDB().QueryContext(ctx, query, productName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []*ProductReview{}
+	for rows.Next() {
+		review := &ProductReview{}
+		err := rows.Scan(&review.ReviewID, &review.ProductName, &review.Username, &review.Rating, &review.Remarks, &review.Verified, &review.CreatedAt, &review.UpdatedAt)
+		if err != nil {
 			return nil, err
 		}
 		reviews = append(reviews, review)
 	}
 
-	return reviews, nil
+	return reviews, rows.Err()
+}
+
+// RefreshVerifiedReviews re-evaluates the verified flag for every review, picking up
+// orders placed after the review was ingested. It returns the number of reviews changed.
+func (s *MSSQLStore) RefreshVerifiedReviews(ctx context.Context) (int64, error) {
+	query := `
+		UPDATE r
+		SET verified = CASE WHEN o.user_id IS NULL THEN 0 ELSE 1 END
+		FROM product_reviews r
+		LEFT JOIN (SELECT DISTINCT user_id FROM orders) o ON o.user_id = r.username
+		WHERE r.verified <> CASE WHEN o.user_id IS NULL THEN 0 ELSE 1 END
+	`
+
+	result, err := s.execContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
@@ -0,0 +1,607 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestUpdateOrderStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		wantErr  error
+	}{
+		{"live order updated", 1, nil},
+		{"missing or soft-deleted order not found", 0, ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{exec: func(query string, args []driver.NamedValue) (int64, error) {
+				if strings.HasPrefix(query, "UPDATE orders") {
+					return tt.affected, nil
+				}
+				return 1, nil
+			}}
+			s := newTestStore(t, db)
+
+			err := s.UpdateOrderStatus(context.Background(), "order-1", "shipped")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateOrderStatus() error = %v, want %v", err, tt.wantErr)
+			}
+
+			updates := db.calls("UPDATE orders SET status")
+			if len(updates) != 1 {
+				t.Fatalf("ran %d status updates, want 1", len(updates))
+			}
+			// A soft-deleted order must not be brought back to life by a late status event
+			if !strings.Contains(updates[0].query, "deleted_at IS NULL") {
+				t.Errorf("status update doesn't skip soft-deleted orders: %s", updates[0].query)
+			}
+		})
+	}
+}
+
+func TestUpsertConcurrentSameKey(t *testing.T) {
+	const writers = 50
+	now := time.Now()
+
+	tests := []struct {
+		table  string
+		upsert func(ctx context.Context, s *MSSQLStore) error
+	}{
+		{"users", func(ctx context.Context, s *MSSQLStore) error {
+			return s.UpsertUser(ctx, &User{UserID: "user-1", Name: "Ada", Email: "ada@example.com", CreatedAt: now, UpdatedAt: now})
+		}},
+		{"orders", func(ctx context.Context, s *MSSQLStore) error {
+			return s.UpsertOrder(ctx, &Order{OrderID: "order-1", UserID: "user-1", Total: 10, Status: "placed", CreatedAt: now, UpdatedAt: now})
+		}},
+		{"payments", func(ctx context.Context, s *MSSQLStore) error {
+			return s.UpsertPayment(ctx, &Payment{OrderID: "order-1", Status: "settled", Amount: 10, SettledAt: now, UpdatedAt: now})
+		}},
+		{"product_reviews", func(ctx context.Context, s *MSSQLStore) error {
+			return s.UpsertProductReview(ctx, &ProductReview{ReviewID: "review-1", ProductName: "Widget", Username: "user-1", Rating: 5, CreatedAt: now, UpdatedAt: now})
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.table, func(t *testing.T) {
+			// Every writer is in flight at once, as replicas are during a rebalance
+			db := &fakeDB{latency: time.Millisecond}
+			s := newTestStore(t, db)
+
+			var wg sync.WaitGroup
+			errs := make(chan error, writers)
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := tt.upsert(context.Background(), s); err != nil {
+						errs <- err
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				t.Errorf("concurrent upsert failed: %v", err)
+			}
+
+			// Each write is one atomic MERGE, never a separate existence check and insert
+			merges := db.calls("MERGE " + tt.table + " WITH (HOLDLOCK)")
+			if len(merges) != writers {
+				t.Errorf("ran %d MERGE ... WITH (HOLDLOCK) statements, want %d", len(merges), writers)
+			}
+			if n := len(db.calls("INSERT INTO " + tt.table)); n != 0 {
+				t.Errorf("ran %d inserts outside a MERGE", n)
+			}
+			if n := len(db.calls("IF EXISTS")); n != 0 {
+				t.Errorf("ran %d IF EXISTS upserts", n)
+			}
+		})
+	}
+}
+
+// fakeStock answers ReserveInventory's queries from an in-memory inventory table
+func fakeStock(stock map[string]int) func(query string, args []driver.NamedValue) (*fakeRows, error) {
+	return func(query string, args []driver.NamedValue) (*fakeRows, error) {
+		switch {
+		case strings.Contains(query, "UPDATE inventory"):
+			qty, sku := args[0].Value.(int), args[2].Value.(string)
+			have, ok := stock[sku]
+			if !ok || have < qty {
+				return &fakeRows{columns: []string{"quantity"}}, nil
+			}
+			stock[sku] = have - qty
+			return &fakeRows{columns: []string{"quantity"}, rows: [][]driver.Value{{int64(stock[sku])}}}, nil
+		case strings.Contains(query, "SELECT quantity FROM inventory"):
+			have, ok := stock[args[0].Value.(string)]
+			if !ok {
+				return &fakeRows{columns: []string{"quantity"}}, nil
+			}
+			return &fakeRows{columns: []string{"quantity"}, rows: [][]driver.Value{{int64(have)}}}, nil
+		}
+		return &fakeRows{}, nil
+	}
+}
+
+func TestReserveInventory(t *testing.T) {
+	tests := []struct {
+		name          string
+		stock         int
+		sku           string
+		qty           int
+		wantReserved  bool
+		wantRemaining int
+		wantStock     int
+	}{
+		{"less than stock", 5, "sku-1", 3, true, 2, 2},
+		{"exactly the stock", 5, "sku-1", 5, true, 0, 0},
+		{"one more than stock", 5, "sku-1", 6, false, 5, 5},
+		{"unknown sku", 5, "sku-2", 1, false, 0, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stock := map[string]int{"sku-1": tt.stock}
+			db := &fakeDB{query: fakeStock(stock)}
+			s := newTestStore(t, db)
+
+			reserved, remaining, err := s.ReserveInventory(context.Background(), "evt-1", tt.sku, tt.qty)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reserved != tt.wantReserved || remaining != tt.wantRemaining {
+				t.Errorf("ReserveInventory() = %v, %d; want %v, %d", reserved, remaining, tt.wantReserved, tt.wantRemaining)
+			}
+			// A refused reservation leaves the stock untouched rather than going negative
+			if stock["sku-1"] != tt.wantStock {
+				t.Errorf("stock = %d, want %d", stock["sku-1"], tt.wantStock)
+			}
+
+			// The floor is enforced by the UPDATE itself, not by a separate read first
+			updates := db.calls("UPDATE inventory")
+			if len(updates) != 1 || !strings.Contains(updates[0].query, "quantity >= ?") {
+				t.Errorf("ran %d conditional updates, want 1", len(updates))
+			}
+
+			// Only a granted reservation is remembered; a refused one may be retried
+			if recorded := len(db.written("processed_events")) > 0; recorded != tt.wantReserved {
+				t.Errorf("eventId recorded = %v, want %v", recorded, tt.wantReserved)
+			}
+
+			// A granted reservation is logged as a negative adjustment, so the history
+			// still sums to the stock
+			history := db.written("inventory_adjustments")
+			if tt.wantReserved {
+				if len(history) != 1 {
+					t.Fatalf("logged %d adjustments, want 1", len(history))
+				}
+				h := history[0]
+				if h.arg(1) != tt.sku || h.arg(3) != -tt.qty || h.arg(4) != ReservationReason {
+					t.Errorf("logged adjustment %v, want %s %d %q", h.args, tt.sku, -tt.qty, ReservationReason)
+				}
+			} else if len(history) != 0 {
+				t.Errorf("logged %d adjustments for a refused reservation, want none", len(history))
+			}
+
+			// The remaining quantity is read in the reservation's transaction
+			for _, c := range db.calls("inventory") {
+				if !c.inTx {
+					t.Errorf("ran outside the reservation transaction: %s", c.query)
+				}
+			}
+		})
+	}
+}
+
+func TestReserveInventoryRetriesTransientErrors(t *testing.T) {
+	stock := map[string]int{"sku-1": 5}
+	var dropped bool
+	reserve := fakeStock(stock)
+	db := &fakeDB{query: func(query string, args []driver.NamedValue) (*fakeRows, error) {
+		if strings.Contains(query, "UPDATE inventory") && !dropped {
+			dropped = true
+			return nil, syscall.ECONNRESET
+		}
+		return reserve(query, args)
+	}}
+	s := newTestStore(t, db)
+	ctx := context.Background()
+
+	reserved, remaining, err := s.ReserveInventory(ctx, "evt-1", "sku-1", 2)
+	if err != nil || !reserved || remaining != 3 {
+		t.Fatalf("ReserveInventory() = %v, %d, %v; want reserved with 3 remaining", reserved, remaining, err)
+	}
+	if begins, commits, _ := db.counts(); begins != 2 || commits != 1 {
+		t.Errorf("began %d transactions and committed %d, want 2 and 1", begins, commits)
+	}
+	if n := len(db.written("processed_events")); n != 1 {
+		t.Errorf("recorded the eventId %d times, want 1", n)
+	}
+
+	// Refused reservations reuse the prepared statements rather than parsing again
+	prepares := 0
+	for i := 0; i < 3; i++ {
+		if reserved, _, err := s.ReserveInventory(ctx, "", "sku-1", 10); err != nil || reserved {
+			t.Fatalf("ReserveInventory() = %v, %v; want refused", reserved, err)
+		}
+		if i == 0 {
+			prepares = db.prepared()
+		}
+	}
+	if db.prepared() != prepares {
+		t.Errorf("prepared %d statements after the first refusal, want none", db.prepared()-prepares)
+	}
+}
+
+func TestReserveInventoryDeduplicates(t *testing.T) {
+	stock := map[string]int{"sku-1": 5}
+	processed := map[string]bool{}
+	db := &fakeDB{
+		query: fakeStock(stock),
+		exec: func(query string, args []driver.NamedValue) (int64, error) {
+			if !strings.Contains(query, "processed_events") {
+				return 1, nil
+			}
+			eventID := args[0].Value.(string)
+			if processed[eventID] {
+				return 0, nil
+			}
+			processed[eventID] = true
+			return 1, nil
+		},
+	}
+	s := newTestStore(t, db)
+	ctx := context.Background()
+
+	if reserved, _, err := s.ReserveInventory(ctx, "evt-1", "sku-1", 2); err != nil || !reserved {
+		t.Fatalf("first ReserveInventory() = %v, %v; want reserved", reserved, err)
+	}
+
+	// The redelivered event is skipped without touching stock
+	_, _, err := s.ReserveInventory(ctx, "evt-1", "sku-1", 2)
+	if !errors.Is(err, ErrDuplicateEvent) {
+		t.Fatalf("redelivered ReserveInventory() error = %v, want ErrDuplicateEvent", err)
+	}
+	if stock["sku-1"] != 3 {
+		t.Errorf("stock = %d after a redelivery, want 3", stock["sku-1"])
+	}
+	if n := len(db.calls("UPDATE inventory")); n != 1 {
+		t.Errorf("ran %d stock updates, want 1", n)
+	}
+
+	// The check and the decrement share one transaction
+	for _, c := range append(db.calls("processed_events"), db.calls("UPDATE inventory")...) {
+		if !c.inTx {
+			t.Errorf("ran outside the reservation transaction: %s", c.query)
+		}
+	}
+
+	// Events without an eventId are never deduplicated
+	for i := 0; i < 2; i++ {
+		if reserved, _, err := s.ReserveInventory(ctx, "", "sku-1", 1); err != nil || !reserved {
+			t.Fatalf("ReserveInventory() without an eventId = %v, %v; want reserved", reserved, err)
+		}
+	}
+	if stock["sku-1"] != 1 {
+		t.Errorf("stock = %d, want 1", stock["sku-1"])
+	}
+}
+
+func TestReserveInventoryRejectsNonPositive(t *testing.T) {
+	db := &fakeDB{}
+	s := newTestStore(t, db)
+
+	for _, qty := range []int{0, -1} {
+		if _, _, err := s.ReserveInventory(context.Background(), "evt-1", "sku-1", qty); err == nil {
+			t.Errorf("ReserveInventory(%d) succeeded, want an error", qty)
+		}
+	}
+	if n := len(db.calls("inventory")); n != 0 {
+		t.Errorf("ran %d inventory statements for a non-positive quantity", n)
+	}
+}
+
+// fakeUsers applies PatchUser's UPDATE statements to in-memory user rows, setting
+// only the columns named in the SET clause
+func fakeUsers(users map[string]map[string]interface{}) func(query string, args []driver.NamedValue) (int64, error) {
+	return func(query string, args []driver.NamedValue) (int64, error) {
+		if !strings.HasPrefix(query, "UPDATE users SET ") {
+			return 1, nil
+		}
+		set := strings.TrimPrefix(query[:strings.Index(query, " WHERE ")], "UPDATE users SET ")
+		clauses := strings.Split(set, ", ")
+		row, ok := users[args[len(args)-1].Value.(string)]
+		if !ok {
+			return 0, nil
+		}
+		for i, clause := range clauses {
+			row[strings.TrimSuffix(clause, " = ?")] = args[i].Value
+		}
+		return 1, nil
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  map[string]interface{}
+		wantSet string
+		want    map[string]interface{}
+	}{
+		{
+			name:    "email only keeps the name",
+			fields:  map[string]interface{}{"email": "ada@new.example.com"},
+			wantSet: "SET email = ?, updated_at = ?",
+			want:    map[string]interface{}{"name": "Ada", "email": "ada@new.example.com"},
+		},
+		{
+			name:    "name only keeps the email",
+			fields:  map[string]interface{}{"name": "Ada Lovelace"},
+			wantSet: "SET name = ?, updated_at = ?",
+			want:    map[string]interface{}{"name": "Ada Lovelace", "email": "ada@example.com"},
+		},
+		{
+			name:    "both fields",
+			fields:  map[string]interface{}{"name": "Ada Lovelace", "email": "ada@new.example.com"},
+			wantSet: "SET email = ?, name = ?, updated_at = ?",
+			want:    map[string]interface{}{"name": "Ada Lovelace", "email": "ada@new.example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := map[string]map[string]interface{}{
+				"user-1": {"name": "Ada", "email": "ada@example.com"},
+			}
+			db := &fakeDB{exec: fakeUsers(users)}
+			s := newTestStore(t, db)
+
+			if err := s.PatchUser(context.Background(), "user-1", tt.fields); err != nil {
+				t.Fatal(err)
+			}
+
+			updates := db.calls("UPDATE users")
+			if len(updates) != 1 {
+				t.Fatalf("ran %d user updates, want 1", len(updates))
+			}
+			if !strings.Contains(updates[0].query, tt.wantSet+" WHERE") {
+				t.Errorf("update = %q, want %q", updates[0].query, tt.wantSet)
+			}
+			for column, want := range tt.want {
+				if got := users["user-1"][column]; got != want {
+					t.Errorf("%s = %v, want %v", column, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPatchUserRejects(t *testing.T) {
+	tests := []struct {
+		name        string
+		userID      string
+		fields      map[string]interface{}
+		wantErr     string
+		wantUpdates int
+	}{
+		{"no fields", "user-1", map[string]interface{}{}, "no fields to update", 0},
+		{"field outside the allow-list", "user-1", map[string]interface{}{"email": "a@example.com", "user_id = user_id; --": "x"}, "cannot be updated", 0},
+		{"unknown user", "user-2", map[string]interface{}{"name": "Bob"}, "not found", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := map[string]map[string]interface{}{"user-1": {"name": "Ada"}}
+			db := &fakeDB{exec: fakeUsers(users)}
+			s := newTestStore(t, db)
+
+			err := s.PatchUser(context.Background(), tt.userID, tt.fields)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("PatchUser() error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if n := len(db.calls("UPDATE users")); n != tt.wantUpdates {
+				t.Errorf("ran %d user updates, want %d", n, tt.wantUpdates)
+			}
+		})
+	}
+}
+
+func TestReminderAndStatusQueriesSkipDeletedOrders(t *testing.T) {
+	tests := []struct {
+		name  string
+		run   func(ctx context.Context, s *MSSQLStore) error
+		match string // the statement that must filter out soft-deleted orders
+	}{
+		{"ListOrdersNeedingReminder", func(ctx context.Context, s *MSSQLStore) error {
+			_, err := s.ListOrdersNeedingReminder(ctx, 7*24*time.Hour, true, 50, 0)
+			return err
+		}, "FROM orders o"},
+		{"MarkReminderSent", func(ctx context.Context, s *MSSQLStore) error {
+			_, err := s.MarkReminderSent(ctx, "order-1")
+			return err
+		}, "UPDATE orders SET reminder_sent_at"},
+		{"GetOrderStatusCounts", func(ctx context.Context, s *MSSQLStore) error {
+			_, err := s.GetOrderStatusCounts(ctx, time.Time{}, time.Time{})
+			return err
+		}, "SELECT status, COUNT(*)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{}
+			s := newTestStore(t, db)
+
+			if err := tt.run(context.Background(), s); err != nil {
+				t.Fatal(err)
+			}
+			calls := db.calls(tt.match)
+			if len(calls) != 1 {
+				t.Fatalf("ran %d matching statements, want 1", len(calls))
+			}
+			if !strings.Contains(calls[0].query, "deleted_at IS NULL") {
+				t.Errorf("statement doesn't skip soft-deleted orders: %s", calls[0].query)
+			}
+		})
+	}
+}
+
+func TestMarkReminderSentDeletedOrder(t *testing.T) {
+	// A soft-deleted order matches no row, as a missing one doesn't
+	db := &fakeDB{exec: func(query string, args []driver.NamedValue) (int64, error) { return 0, nil }}
+	s := newTestStore(t, db)
+
+	sent, err := s.MarkReminderSent(context.Background(), "order-1")
+	if err != nil || sent {
+		t.Errorf("MarkReminderSent() = %v, %v; want false, nil", sent, err)
+	}
+}
+
+// fakeChanges answers GetRecentlyUpdated from rows sorted by updated_at then ID,
+// applying its TOP and watermark
+func fakeChanges(rows []Change) func(query string, args []driver.NamedValue) (*fakeRows, error) {
+	return func(query string, args []driver.NamedValue) (*fakeRows, error) {
+		limit, since := args[0].Value.(int), args[1].Value.(time.Time)
+		afterID := ""
+		if len(args) > 2 {
+			afterID = args[3].Value.(string)
+		}
+
+		result := &fakeRows{columns: []string{"id", "updated_at"}}
+		for _, c := range rows {
+			if len(result.rows) == limit {
+				break
+			}
+			if c.UpdatedAt.After(since) || (afterID != "" && c.UpdatedAt.Equal(since) && c.ID > afterID) {
+				result.rows = append(result.rows, []driver.Value{c.ID, c.UpdatedAt})
+			}
+		}
+		return result, nil
+	}
+}
+
+func TestGetRecentlyUpdatedPages(t *testing.T) {
+	t0 := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	t1 := t0.Add(time.Second)
+	rows := []Change{
+		{"order-1", t0},
+		{"order-2", t1},
+		{"order-3", t1},
+		{"order-4", t1},
+		{"order-5", t1.Add(time.Second)},
+	}
+	db := &fakeDB{query: fakeChanges(rows)}
+	s := newTestStore(t, db)
+
+	// Follow the watermark two changes at a time; a page cut inside the changes
+	// sharing t1 must neither skip nor repeat any of them
+	var seen []string
+	since, afterID := t0.Add(-time.Second), ""
+	for page := 0; page < 10; page++ {
+		changes, err := s.GetRecentlyUpdated(context.Background(), "orders", since, afterID, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range changes {
+			seen = append(seen, c.ID)
+		}
+		if len(changes) < 2 {
+			break
+		}
+		last := changes[len(changes)-1]
+		since, afterID = last.UpdatedAt, last.ID
+	}
+
+	want := []string{"order-1", "order-2", "order-3", "order-4", "order-5"}
+	if strings.Join(seen, ",") != strings.Join(want, ",") {
+		t.Errorf("paged through %v, want %v", seen, want)
+	}
+	for _, c := range db.calls("SELECT TOP (?) order_id, updated_at FROM orders") {
+		if !strings.Contains(c.query, "ORDER BY updated_at ASC, order_id ASC") {
+			t.Errorf("changes aren't in a stable order: %s", c.query)
+		}
+	}
+}
+
+func TestGetRecentlyUpdatedLimit(t *testing.T) {
+	tests := []struct {
+		limit int
+		want  int
+	}{
+		{0, DefaultChanges},
+		{-1, DefaultChanges},
+		{10, 10},
+		{MaxChanges + 1, MaxChanges},
+	}
+
+	for _, tt := range tests {
+		db := &fakeDB{}
+		s := newTestStore(t, db)
+
+		if _, err := s.GetRecentlyUpdated(context.Background(), "users", time.Time{}, "", tt.limit); err != nil {
+			t.Fatal(err)
+		}
+		calls := db.calls("FROM users")
+		if len(calls) != 1 || calls[0].arg(1) != tt.want {
+			t.Errorf("limit %d ran %v, want TOP (%d)", tt.limit, calls, tt.want)
+		}
+	}
+}
+
+func TestGetOrderTimelinePaymentStatus(t *testing.T) {
+	placed := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	settled := placed.Add(time.Hour)
+	updated := placed.Add(2 * time.Hour)
+
+	tests := []struct {
+		status   string
+		wantType string
+		wantAt   time.Time
+	}{
+		{"pending", "PaymentPending", updated},
+		{"settled", "PaymentSettled", settled},
+		{"failed", "PaymentFailed", updated},
+		{"refunded", "PaymentRefunded", updated},
+		{"disputed", "PaymentUpdated", updated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			db := &fakeDB{query: func(query string, args []driver.NamedValue) (*fakeRows, error) {
+				switch {
+				case strings.Contains(query, "FROM orders"):
+					return &fakeRows{
+						columns: []string{"order_id", "user_id", "total", "status", "created_at", "updated_at", "deleted_at"},
+						rows:    [][]driver.Value{{"order-1", "user-1", 10.0, "placed", placed, placed, nil}},
+					}, nil
+				case strings.Contains(query, "FROM payments"):
+					return &fakeRows{
+						columns: []string{"order_id", "status", "amount", "settled_at", "updated_at"},
+						rows:    [][]driver.Value{{"order-1", tt.status, 10.0, settled, updated}},
+					}, nil
+				}
+				return &fakeRows{}, nil
+			}}
+			s := newTestStore(t, db)
+
+			timeline, err := s.GetOrderTimeline(context.Background(), "order-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(timeline) != 2 {
+				t.Fatalf("timeline = %v, want the placement and the payment", timeline)
+			}
+			if got := timeline[1]; got.Type != tt.wantType || !got.At.Equal(tt.wantAt) {
+				t.Errorf("payment entry = %s at %v, want %s at %v", got.Type, got.At, tt.wantType, tt.wantAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+// paymentTransitions lists the statuses a payment may move to from each status.
+// Moving to the same status is always allowed, so a redelivered event is applied
+// again harmlessly; statuses outside paymentStatuses aren't checked.
+var paymentTransitions = map[string][]string{
+	"pending": {"settled", "failed"},
+	"failed":  {"pending", "settled"},
+	"settled": {"refunded"},
+}
+
+// sqlPaymentTransitionRejected is the error upsertPaymentQuery throws when the
+// stored status can't move to the new one; its message is the stored status
+const sqlPaymentTransitionRejected = 50001
+
+// PaymentTransitionError is returned when a payment write would move a payment
+// from its stored status to one it can't follow, e.g. from settled back to pending
+type PaymentTransitionError struct {
+	OrderID string
+	From    string
+	To      string
+}
+
+func (e *PaymentTransitionError) Error() string {
+	if e.From == "" {
+		return fmt.Sprintf("illegal payment status transition for order %s to %s", e.OrderID, e.To)
+	}
+	return fmt.Sprintf("illegal payment status transition for order %s: %s -> %s", e.OrderID, e.From, e.To)
+}
+
+// IsPaymentTransitionAllowed reports whether a payment in status from may move to status to
+func IsPaymentTransitionAllowed(from, to string) bool {
+	if from == to || !paymentStatuses[from] || !paymentStatuses[to] {
+		return true
+	}
+	for _, next := range paymentTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// SetForcePaymentTransitions turns off payment status transition checks, so
+// payment writes overwrite the stored status as they did before. It is meant for
+// backfills that replay history out of order.
+func (s *MSSQLStore) SetForcePaymentTransitions(force bool) {
+	s.forcePaymentTransitions = force
+}
+
+// paymentTransitionGuard is the MERGE condition, over target and source, under
+// which an existing payment may be updated; it mirrors IsPaymentTransitionAllowed
+var paymentTransitionGuard = func() string {
+	known := make([]string, 0, len(paymentStatuses))
+	for status := range paymentStatuses {
+		known = append(known, "'"+status+"'")
+	}
+	sort.Strings(known)
+	in := strings.Join(known, ", ")
+
+	conditions := []string{
+		"target.status = source.status",
+		fmt.Sprintf("target.status IS NULL OR target.status NOT IN (%s)", in),
+		fmt.Sprintf("source.status NOT IN (%s)", in),
+	}
+
+	from := make([]string, 0, len(paymentTransitions))
+	for status := range paymentTransitions {
+		from = append(from, status)
+	}
+	sort.Strings(from)
+	for _, status := range from {
+		to := make([]string, len(paymentTransitions[status]))
+		for i, next := range paymentTransitions[status] {
+			to[i] = "'" + next + "'"
+		}
+		conditions = append(conditions, fmt.Sprintf("target.status = '%s' AND source.status IN (%s)", status, strings.Join(to, ", ")))
+	}
+	return "((" + strings.Join(conditions, ") OR (") + "))"
+}()
+
+// upsertPaymentQuery returns the payment MERGE. Unless forced, an update the
+// stored status can't make is skipped and sqlPaymentTransitionRejected thrown, so
+// the check and the write are atomic.
+func upsertPaymentQuery(force bool) string {
+	guard := ""
+	if !force {
+		guard = " AND " + paymentTransitionGuard
+	}
+
+	return fmt.Sprintf(`
+		MERGE payments WITH (HOLDLOCK) AS target
+		USING (SELECT ? AS order_id, ? AS status, ? AS amount, ? AS settled_at, ? AS updated_at) AS source
+			ON target.order_id = source.order_id
+		WHEN MATCHED%s THEN
+			UPDATE SET status = source.status,
+				amount = source.amount,
+				settled_at = source.settled_at,
+				updated_at = source.updated_at
+		WHEN NOT MATCHED THEN
+			INSERT (order_id, status, amount, settled_at, updated_at)
+			VALUES (source.order_id, source.status, source.amount, source.settled_at, source.updated_at);
+		IF @@ROWCOUNT = 0
+		BEGIN
+			DECLARE @current NVARCHAR(2048) = ISNULL((SELECT status FROM payments WHERE order_id = ?), '');
+			THROW %d, @current, 1;
+		END
+	`, guard, sqlPaymentTransitionRejected)
+}
+
+var (
+	upsertPaymentGuardedQuery = upsertPaymentQuery(false)
+	upsertPaymentForcedQuery  = upsertPaymentQuery(true)
+)
+
+// asPaymentTransitionError turns the error thrown by upsertPaymentQuery into a
+// PaymentTransitionError, leaving other errors as they are
+func asPaymentTransitionError(err error, payment *Payment) error {
+	var sqlErr mssql.Error
+	if errors.As(err, &sqlErr) && sqlErr.Number == sqlPaymentTransitionRejected {
+		return &PaymentTransitionError{OrderID: payment.OrderID, From: sqlErr.Message, To: payment.Status}
+	}
+	return err
+}
+
+// checkPaymentBatch rejects a batch that moves a payment through an illegal
+// transition between its own rows, which the MERGE only sees the last of
+func checkPaymentBatch(payments []*Payment) error {
+	last := make(map[string]string, len(payments))
+	for _, p := range payments {
+		if from, ok := last[p.OrderID]; ok && !IsPaymentTransitionAllowed(from, p.Status) {
+			return &PaymentTransitionError{OrderID: p.OrderID, From: from, To: p.Status}
+		}
+		last[p.OrderID] = p.Status
+	}
+	return nil
+}
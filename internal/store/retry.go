@@ -0,0 +1,165 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"go.uber.org/zap"
+)
+
+// sqlDeadlockVictim is the SQL Server error raised when a transaction is chosen as a deadlock victim
+const sqlDeadlockVictim = 1205
+
+// Default deadlock retry policy
+const (
+	defaultDeadlockRetries = 3
+	defaultDeadlockBackoff = 50 * time.Millisecond
+)
+
+// Default transient error retry policy; backoff doubles per attempt up to the cap
+const (
+	defaultTransientRetries = 5
+	defaultTransientBackoff = 200 * time.Millisecond
+	maxTransientBackoff     = 10 * time.Second
+)
+
+// IsDeadlock reports whether err is SQL Server error 1205 (deadlock victim).
+// The victim's transaction has been rolled back, so the operation is safe to retry.
+func IsDeadlock(err error) bool {
+	var sqlErr mssql.Error
+	return errors.As(err, &sqlErr) && sqlErr.Number == sqlDeadlockVictim
+}
+
+// SetDeadlockRetry configures how writes chosen as deadlock victims are retried:
+// up to maxRetries further attempts, waiting backoff multiplied by the attempt number
+// between them. onRetry, if non-nil, is called before each retry.
+func (s *MSSQLStore) SetDeadlockRetry(maxRetries int, backoff time.Duration, onRetry func()) {
+	s.deadlockRetries = maxRetries
+	s.deadlockBackoff = backoff
+	s.onDeadlockRetry = onRetry
+}
+
+// SetTransientRetry configures how writes that fail with a transient connection
+// error (see IsTransient) are retried: up to maxRetries further attempts, waiting
+// backoff doubled on each attempt and capped at maxTransientBackoff, so a failover
+// of a few seconds is ridden out. onRetry, if non-nil, is called before each retry.
+func (s *MSSQLStore) SetTransientRetry(maxRetries int, backoff time.Duration, onRetry func()) {
+	s.transientRetries = maxRetries
+	s.transientBackoff = backoff
+	s.onTransientRetry = onRetry
+}
+
+// retryOnDeadlock runs op, retrying it while it fails as a deadlock victim. It is
+// for writes that aren't idempotent: after a dropped connection the write may
+// already have committed, so only a deadlock, which rolled it back, is retried.
+func (s *MSSQLStore) retryOnDeadlock(ctx context.Context, op func() error) error {
+	return s.retry(ctx, false, op)
+}
+
+// retryTransient runs op, retrying deadlock victims and transient connection
+// errors under their own policies. op must be safe to run again after a connection
+// drops mid-statement, as an upsert is.
+func (s *MSSQLStore) retryTransient(ctx context.Context, op func() error) error {
+	return s.retry(ctx, true, op)
+}
+
+func (s *MSSQLStore) retry(ctx context.Context, transient bool, op func() error) error {
+	deadlocks, failures := 0, 0
+	for {
+		err := op()
+
+		var wait time.Duration
+		switch {
+		case err == nil:
+			return nil
+		case IsDeadlock(err) && deadlocks < s.deadlockRetries:
+			deadlocks++
+			s.logger.Warn("SQL deadlock detected, retrying",
+				zap.Int("attempt", deadlocks),
+				zap.Int("maxRetries", s.deadlockRetries),
+			)
+			if s.onDeadlockRetry != nil {
+				s.onDeadlockRetry()
+			}
+			wait = s.deadlockBackoff * time.Duration(deadlocks)
+		case transient && IsTransient(err) && failures < s.transientRetries:
+			failures++
+			wait = min(s.transientBackoff<<(failures-1), maxTransientBackoff)
+			s.logger.Warn("Transient SQL error, retrying",
+				zap.Int("attempt", failures),
+				zap.Int("maxRetries", s.transientRetries),
+				zap.Duration("backoff", wait),
+				zap.Error(err),
+			)
+			if s.onTransientRetry != nil {
+				s.onTransientRetry()
+			}
+		default:
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// transientErrorNumbers are SQL Server errors raised while a server or database
+// is restarting or failing over
+var transientErrorNumbers = map[int32]bool{
+	233:   true, // no process is on the other end of the pipe
+	4060:  true, // cannot open database requested by the login
+	4221:  true, // login to a read-secondary failed during failover
+	10053: true, // transport-level error, connection aborted
+	10054: true, // transport-level error, connection reset by peer
+	18456: true, // login failed, as seen while a failover completes
+	40197: true, // service error processing the request
+	40501: true, // service is busy
+	40613: true, // database is not currently available
+	49918: true, // not enough resources to process the request
+}
+
+// IsTransient reports whether err means the connection to SQL Server was lost or
+// refused, or the server is failing over, so the same statement may succeed once
+// it is back. Errors about the statement itself, such as constraint violations,
+// are not transient.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var sqlErr mssql.Error
+	if errors.As(err, &sqlErr) {
+		return transientErrorNumbers[sqlErr.Number]
+	}
+
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.As(err, &netErr)
+}
+
+// execContext executes a write on the primary as a cached prepared statement,
+// retrying deadlock victims and transient connection errors
+func (s *MSSQLStore) execContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := s.retryTransient(ctx, func() error {
+		var err error
+		result, err = s.writeStmts.exec(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
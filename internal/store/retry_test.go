@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+func TestDeadlockRetry(t *testing.T) {
+	deadlock := mssql.Error{Number: sqlDeadlockVictim, Message: "Transaction was deadlocked on lock resources with another process and has been chosen as the deadlock victim"}
+	constraint := mssql.Error{Number: 2627, Message: "Violation of PRIMARY KEY constraint"}
+
+	tests := []struct {
+		name         string
+		failures     []error // returned by the first attempts, in order
+		wantErr      bool
+		wantAttempts int
+		wantRetries  int
+	}{
+		{"deadlock then success", []error{deadlock}, false, 2, 1},
+		{"deadlocks up to the limit then success", []error{deadlock, deadlock, deadlock}, false, 4, 3},
+		{"deadlocks past the limit", []error{deadlock, deadlock, deadlock, deadlock}, true, 4, 3},
+		{"other errors aren't retried", []error{constraint}, true, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			db := &fakeDB{exec: func(query string, args []driver.NamedValue) (int64, error) {
+				if !strings.Contains(query, "MERGE orders") {
+					return 1, nil
+				}
+				n := atomic.AddInt32(&attempts, 1)
+				if int(n) <= len(tt.failures) {
+					return 0, tt.failures[n-1]
+				}
+				return 1, nil
+			}}
+			s := newTestStore(t, db)
+
+			// The consumer counts these in deadlock_retries_total
+			var retries int
+			s.SetDeadlockRetry(3, time.Millisecond, func() { retries++ })
+
+			now := time.Now()
+			err := s.UpsertOrder(context.Background(), &Order{OrderID: "order-1", UserID: "user-1", Total: 10, Status: "placed", CreatedAt: now, UpdatedAt: now})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UpsertOrder() error = %v, want error %v", err, tt.wantErr)
+			}
+			// Once retries run out the caller still sees the deadlock
+			if err != nil && tt.wantRetries > 0 && !IsDeadlock(err) {
+				t.Errorf("IsDeadlock(%v) = false after retries ran out", err)
+			}
+			if got := int(atomic.LoadInt32(&attempts)); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if retries != tt.wantRetries {
+				t.Errorf("deadlock retries = %d, want %d", retries, tt.wantRetries)
+			}
+		})
+	}
+}
+
+func TestIsDeadlock(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"deadlock victim", mssql.Error{Number: sqlDeadlockVictim}, true},
+		{"wrapped deadlock victim", errors.Join(errors.New("upsert failed"), mssql.Error{Number: sqlDeadlockVictim}), true},
+		{"other SQL error", mssql.Error{Number: 2627}, false},
+		{"not a SQL error", errors.New("connection reset"), false},
+		{"nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsDeadlock(tt.err); got != tt.want {
+				t.Errorf("IsDeadlock(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// includeDeletedKey marks a context whose reads include soft-deleted rows
+type includeDeletedKey struct{}
+
+// IncludeDeleted returns a context under which GetUser, GetOrder and the per-user
+// order reads also return soft-deleted rows, with DeletedAt set. Lists and
+// searches always leave them out.
+func IncludeDeleted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, includeDeletedKey{}, true)
+}
+
+// notDeleted returns the condition excluding soft-deleted rows by column, or an
+// always-true one under IncludeDeleted
+func notDeleted(ctx context.Context, column string) string {
+	if include, _ := ctx.Value(includeDeletedKey{}).(bool); include {
+		return "1 = 1"
+	}
+	return column + " IS NULL"
+}
+
+// nullTimePtr returns the time held by t, or nil if it is NULL
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// SoftDeleteUser marks a user and all of their orders deleted, hiding them from
+// reads without removing rows that payments and reviews may still reference. It
+// returns an error wrapping ErrNotFound if the user doesn't exist or is already
+// deleted.
+func (s *MSSQLStore) SoftDeleteUser(ctx context.Context, userID string) (err error) {
+	ctx, span := startSpan(ctx, "SoftDeleteUser")
+	defer endSpan(span, &err)
+
+	return s.WithTx(ctx, func(tx *Tx) error {
+		now := time.Now()
+		result, err := tx.ex.ExecContext(ctx, `UPDATE users SET deleted_at = ?, updated_at = ? WHERE user_id = ? AND deleted_at IS NULL`, now, now, userID)
+		if err != nil {
+			return err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return fmt.Errorf("user %w: %s", ErrNotFound, userID)
+		}
+
+		_, err = tx.ex.ExecContext(ctx, `UPDATE orders SET deleted_at = ?, updated_at = ? WHERE user_id = ? AND deleted_at IS NULL`, now, now, userID)
+		return err
+	})
+}
+
+// SoftDeleteOrder marks an order deleted. It returns an error wrapping ErrNotFound
+// if the order doesn't exist or is already deleted.
+func (s *MSSQLStore) SoftDeleteOrder(ctx context.Context, orderID string) (err error) {
+	ctx, span := startSpan(ctx, "SoftDeleteOrder")
+	defer endSpan(span, &err)
+
+	now := time.Now()
+	result, err := s.execContext(ctx, `UPDATE orders SET deleted_at = ?, updated_at = ? WHERE order_id = ? AND deleted_at IS NULL`, now, now, orderID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("order %w: %s", ErrNotFound, orderID)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+// sqlPreparedStatementNotFound is the SQL Server error raised when a prepared
+// statement handle is no longer known to the server, e.g. after a failover
+const sqlPreparedStatementNotFound = 8179
+
+// stmtCache prepares each query once and reuses the statement for later calls.
+// A *sql.Stmt is safe for concurrent use: database/sql transparently re-prepares
+// it on whichever pooled connection executes it, so one Stmt per query is enough.
+type stmtCache struct {
+	db    *sql.DB
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+func newStmtCache(db *sql.DB) *stmtCache {
+	return &stmtCache{
+		db:    db,
+		stmts: make(map[string]*sql.Stmt),
+	}
+}
+
+// get returns the prepared statement for query, preparing it on first use
+func (c *stmtCache) get(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if stmt, ok := c.stmts[query]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	c.stmts[query] = stmt
+	return stmt, nil
+}
+
+// exec runs query through its cached statement. Connections opened after a
+// reconnect prepare the statement afresh, but a handle the server has dropped on a
+// live connection fails with error 8179; the statement is then evicted and the
+// query prepared and run once more.
+func (c *stmtCache) exec(ctx context.Context, query string, args ...interface{}) (result sql.Result, err error) {
+	err = c.withStmt(ctx, query, func(stmt *sql.Stmt) error {
+		result, err = stmt.ExecContext(ctx, args...)
+		return err
+	})
+	return result, err
+}
+
+// execTx is exec for a statement run inside tx
+func (c *stmtCache) execTx(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (result sql.Result, err error) {
+	err = c.withStmt(ctx, query, func(stmt *sql.Stmt) error {
+		txStmt := tx.StmtContext(ctx, stmt)
+		defer txStmt.Close()
+		result, err = txStmt.ExecContext(ctx, args...)
+		return err
+	})
+	return result, err
+}
+
+// queryRowTx runs query inside tx through its cached statement and scans the
+// single row it returns into dest, failing with sql.ErrNoRows if there is none
+func (c *stmtCache) queryRowTx(ctx context.Context, tx *sql.Tx, query string, args []interface{}, dest ...interface{}) error {
+	return c.withStmt(ctx, query, func(stmt *sql.Stmt) error {
+		txStmt := tx.StmtContext(ctx, stmt)
+		defer txStmt.Close()
+		return txStmt.QueryRowContext(ctx, args...).Scan(dest...)
+	})
+}
+
+func (c *stmtCache) withStmt(ctx context.Context, query string, run func(*sql.Stmt) error) error {
+	stmt, err := c.get(ctx, query)
+	if err != nil {
+		return err
+	}
+	err = run(stmt)
+	if !isPreparedStatementNotFound(err) {
+		return err
+	}
+
+	c.evict(query, stmt)
+	if stmt, err = c.get(ctx, query); err != nil {
+		return err
+	}
+	return run(stmt)
+}
+
+// evict drops stmt from the cache unless another caller has already replaced it
+func (c *stmtCache) evict(query string, stmt *sql.Stmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stmts[query] == stmt {
+		delete(c.stmts, query)
+		stmt.Close()
+	}
+}
+
+// isPreparedStatementNotFound reports whether err is SQL Server error 8179
+func isPreparedStatementNotFound(err error) bool {
+	var sqlErr mssql.Error
+	return errors.As(err, &sqlErr) && sqlErr.Number == sqlPreparedStatementNotFound
+}
+
+// close closes every cached statement; it must run before the pool is closed
+func (c *stmtCache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	for query, stmt := range c.stmts {
+		if err := stmt.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(c.stmts, query)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+// benchParseCost is the simulated cost of SQL Server parsing and compiling an
+// ad-hoc statement
+const benchParseCost = time.Millisecond
+
+func TestStmtCache(t *testing.T) {
+	now := time.Now()
+	order := &Order{OrderID: "order-1", UserID: "user-1", Total: 10, Status: "placed", CreatedAt: now, UpdatedAt: now}
+
+	tests := []struct {
+		name         string
+		dropHandle   bool // the server forgets the prepared handle on the first exec
+		wantPrepares int
+	}{
+		{"prepared once and reused", false, 1},
+		{"dropped handle prepared again", true, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dropped int32
+			db := &fakeDB{exec: func(query string, args []driver.NamedValue) (int64, error) {
+				if tt.dropHandle && atomic.CompareAndSwapInt32(&dropped, 0, 1) {
+					return 0, mssql.Error{Number: sqlPreparedStatementNotFound, Message: "Could not find prepared statement with handle 1"}
+				}
+				return 1, nil
+			}}
+			s := newTestStore(t, db)
+
+			for i := 0; i < 10; i++ {
+				if err := s.UpsertOrder(context.Background(), order); err != nil {
+					t.Fatalf("UpsertOrder() error = %v", err)
+				}
+			}
+
+			if prepares := db.prepared(); prepares != tt.wantPrepares {
+				t.Errorf("prepared %d times, want %d", prepares, tt.wantPrepares)
+			}
+			if n := len(db.written("MERGE orders")); n != 10 {
+				t.Errorf("wrote %d orders, want 10", n)
+			}
+		})
+	}
+}
+
+// BenchmarkUpsertOrderPrepared compares an upsert through the store's cached
+// prepared statement with the same statement sent ad hoc, which SQL Server parses
+// on every call
+func BenchmarkUpsertOrderPrepared(b *testing.B) {
+	ctx := context.Background()
+	order := benchOrders(1)[0]
+
+	b.Run("prepared", func(b *testing.B) {
+		db := &fakeDB{latency: benchRoundTrip, parseCost: benchParseCost}
+		s := newTestStore(b, db)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := s.UpsertOrder(ctx, order); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(db.prepared())/float64(b.N), "parses/op")
+	})
+
+	b.Run("ad-hoc", func(b *testing.B) {
+		db := &fakeDB{latency: benchRoundTrip, parseCost: benchParseCost}
+		s := newTestStore(b, db)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := s.upsertOrder(ctx, s.db, order); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(len(db.calls("MERGE orders")))/float64(b.N), "parses/op")
+	})
+}
+
+// fakeUserRow answers every query with one live user
+func fakeUserRow(query string, args []driver.NamedValue) (*fakeRows, error) {
+	now := time.Now()
+	return &fakeRows{
+		columns: []string{"user_id", "name", "email", "created_at", "updated_at", "deleted_at"},
+		rows:    [][]driver.Value{{"user-1", "Ada", "ada@example.com", now, now, nil}},
+	}, nil
+}
+
+func TestGetterStatementsSharedAcrossGoroutines(t *testing.T) {
+	const readers = 20
+	db := &fakeDB{latency: time.Millisecond, query: fakeUserRow}
+	s := newTestStore(t, db)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user, err := s.GetUser(context.Background(), "user-1")
+			if err == nil && (user == nil || user.UserID != "user-1") {
+				err = fmt.Errorf("GetUser() = %+v, want user-1", user)
+			}
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// One cached statement serves every pooled connection
+	s.readStmts.mu.Lock()
+	cached := len(s.readStmts.stmts)
+	s.readStmts.mu.Unlock()
+	if cached != 1 {
+		t.Errorf("cached %d statements, want 1", cached)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.readStmts.stmts); n != 0 {
+		t.Errorf("%d statements left open after Close", n)
+	}
+}
+
+// BenchmarkGetUser compares GetUser's cached prepared statement with sending the
+// same query ad hoc on every call
+func BenchmarkGetUser(b *testing.B) {
+	ctx := context.Background()
+	query := `SELECT user_id, name, email, created_at, updated_at, deleted_at FROM users WHERE user_id = ? AND ` + notDeleted(ctx, "deleted_at")
+
+	b.Run("prepared", func(b *testing.B) {
+		db := &fakeDB{latency: benchRoundTrip, parseCost: benchParseCost, query: fakeUserRow}
+		s := newTestStore(b, db)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := s.GetUser(ctx, "user-1"); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(db.prepared())/float64(b.N), "parses/op")
+	})
+
+	b.Run("ad-hoc", func(b *testing.B) {
+		db := &fakeDB{latency: benchRoundTrip, parseCost: benchParseCost, query: fakeUserRow}
+		s := newTestStore(b, db)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			user := &User{}
+			var deletedAt sql.NullTime
+			err := s.db.QueryRowContext(ctx, query, "user-1").Scan(&user.UserID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt, &deletedAt)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(len(db.calls("SELECT user_id")))/float64(b.N), "parses/op")
+	})
+}
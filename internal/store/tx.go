@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// execer is satisfied by retryingExecer and txExecer, so upserts can run either on
+// their own or as part of a caller's transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// retryingExecer runs each statement on the pool, retrying deadlock victims
+type retryingExecer struct {
+	s *MSSQLStore
+}
+
+func (e retryingExecer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return e.s.execContext(ctx, query, args...)
+}
+
+// txExecer runs each statement inside tx, reusing the store's prepared statements
+type txExecer struct {
+	tx    *sql.Tx
+	stmts *stmtCache
+}
+
+func (e txExecer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return e.stmts.execTx(ctx, e.tx, query, args...)
+}
+
+// Tx is a transaction opened by WithTx. Its writes commit or roll back together.
+type Tx struct {
+	ex txExecer
+	s  *MSSQLStore
+}
+
+// WithTx runs fn inside a transaction, committing if it returns nil and rolling
+// back otherwise. If the transaction is chosen as a deadlock victim or hits a
+// transient connection error the whole of fn is run again in a new transaction, so
+// fn must not have side effects outside tx, and its writes must be idempotent in
+// case a dropped connection hid a successful commit.
+func (s *MSSQLStore) WithTx(ctx context.Context, fn func(tx *Tx) error) error {
+	return s.retryTransient(ctx, func() (err error) {
+		sqlTx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				sqlTx.Rollback()
+				panic(p)
+			}
+			if err != nil {
+				sqlTx.Rollback()
+			}
+		}()
+
+		if err = fn(&Tx{ex: txExecer{sqlTx, s.writeStmts}, s: s}); err != nil {
+			return err
+		}
+		return sqlTx.Commit()
+	})
+}
+
+// UpsertUser inserts or updates a user within the transaction
+func (t *Tx) UpsertUser(ctx context.Context, user *User) error {
+	return t.s.upsertUser(ctx, t.ex, user)
+}
+
+// UpsertOrder inserts or updates an order within the transaction
+func (t *Tx) UpsertOrder(ctx context.Context, order *Order) error {
+	return t.s.upsertOrder(ctx, t.ex, order)
+}
+
+// UpsertPayment inserts or updates a payment within the transaction
+func (t *Tx) UpsertPayment(ctx context.Context, payment *Payment) error {
+	return t.s.upsertPayment(ctx, t.ex, payment)
+}
+
+// UpsertInventory inserts or updates inventory within the transaction
+func (t *Tx) UpsertInventory(ctx context.Context, inventory *Inventory) error {
+	return t.s.upsertInventory(ctx, t.ex, inventory)
+}
+
+// UpsertProductReview inserts or updates a product review within the transaction
+func (t *Tx) UpsertProductReview(ctx context.Context, review *ProductReview) error {
+	return t.s.upsertProductReview(ctx, t.ex, review)
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithTx(t *testing.T) {
+	errAbort := errors.New("abort")
+	now := time.Now()
+	user := &User{UserID: "user-1", Name: "Ada", Email: "ada@example.com", CreatedAt: now, UpdatedAt: now}
+	order := &Order{OrderID: "order-1", UserID: "user-1", Total: 10, Status: "placed", CreatedAt: now, UpdatedAt: now}
+
+	tests := []struct {
+		name          string
+		after         func() error // run once both rows are upserted
+		wantErr       error
+		wantPanic     bool
+		wantWritten   bool
+		wantCommits   int
+		wantRollbacks int
+	}{
+		{
+			name:        "success commits both rows",
+			after:       func() error { return nil },
+			wantWritten: true,
+			wantCommits: 1,
+		},
+		{
+			name:          "error mid-callback rolls back",
+			after:         func() error { return errAbort },
+			wantErr:       errAbort,
+			wantRollbacks: 1,
+		},
+		{
+			name:          "panic mid-callback rolls back",
+			after:         func() error { panic("boom") },
+			wantPanic:     true,
+			wantRollbacks: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{}
+			s := newTestStore(t, db)
+
+			var err error
+			panicked := func() (panicked bool) {
+				defer func() { panicked = recover() != nil }()
+				err = s.WithTx(context.Background(), func(tx *Tx) error {
+					if err := tx.UpsertUser(context.Background(), user); err != nil {
+						return err
+					}
+					if err := tx.UpsertOrder(context.Background(), order); err != nil {
+						return err
+					}
+					return tt.after()
+				})
+				return false
+			}()
+
+			if panicked != tt.wantPanic {
+				t.Fatalf("panicked = %v, want %v", panicked, tt.wantPanic)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("WithTx() error = %v, want %v", err, tt.wantErr)
+			}
+
+			// The upserts ran inside the transaction either way
+			if got := len(db.calls("MERGE users")) + len(db.calls("MERGE orders")); got != 2 {
+				t.Errorf("ran %d upserts, want 2", got)
+			}
+			for _, table := range []string{"MERGE users", "MERGE orders"} {
+				if written := len(db.written(table)) > 0; written != tt.wantWritten {
+					t.Errorf("%s written = %v, want %v", table, written, tt.wantWritten)
+				}
+			}
+
+			begins, commits, rollbacks := db.counts()
+			if begins != 1 || commits != tt.wantCommits || rollbacks != tt.wantRollbacks {
+				t.Errorf("begins, commits, rollbacks = %d, %d, %d; want 1, %d, %d", begins, commits, rollbacks, tt.wantCommits, tt.wantRollbacks)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"time"
+)
+
+// Profile selects how strictly event formats are enforced
+type Profile string
+
+const (
+	// Strict rejects events whose timestamp is missing or not RFC3339, or whose
+	// email is malformed
+	Strict Profile = "strict"
+	// Lenient accepts such events and reports the problems as warnings
+	Lenient Profile = "lenient"
+)
+
+// ParseProfile validates a profile name
+func ParseProfile(value string) (Profile, error) {
+	switch Profile(value) {
+	case Strict, Lenient:
+		return Profile(value), nil
+	default:
+		return "", fmt.Errorf("unknown validation profile: %s", value)
+	}
+}
+
+// Check runs the profile-dependent format checks on an event envelope. Under
+// Strict the first problem is returned as an error; under Lenient all problems
+// are returned as warnings for the caller to log and the event is accepted.
+func (p Profile) Check(event map[string]interface{}) (warnings []error, err error) {
+	problems := formatProblems(event)
+	if len(problems) == 0 {
+		return nil, nil
+	}
+	if p == Strict {
+		return nil, problems[0]
+	}
+	return problems, nil
+}
+
+// formatProblems returns every timestamp and email format problem in an event
+func formatProblems(event map[string]interface{}) []error {
+	var problems []error
+
+	switch ts := event["timestamp"].(type) {
+	case nil:
+		problems = append(problems, fmt.Errorf("timestamp is required"))
+	case string:
+		if _, err := time.Parse(time.RFC3339, ts); err != nil {
+			problems = append(problems, fmt.Errorf("timestamp must be an RFC3339 timestamp"))
+		}
+	default:
+		problems = append(problems, fmt.Errorf("timestamp must be a string"))
+	}
+
+	if data, ok := event["data"].(map[string]interface{}); ok {
+		if email, exists := data["email"]; exists {
+			if s, ok := email.(string); !ok || !ValidEmail(s) {
+				problems = append(problems, fmt.Errorf("email is invalid"))
+			}
+		}
+	}
+
+	return problems
+}
+
+// ValidEmail reports whether s is a bare email address such as user@example.com
+func ValidEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
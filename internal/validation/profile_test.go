@@ -0,0 +1,92 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfileCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		event        map[string]interface{}
+		wantProblems []string // reported by both profiles, in order
+	}{
+		{
+			name:  "valid",
+			event: map[string]interface{}{"timestamp": "2024-01-02T03:04:05Z", "data": map[string]interface{}{"email": "ada@example.com"}},
+		},
+		{
+			name:  "no email to check",
+			event: map[string]interface{}{"timestamp": "2024-01-02T03:04:05Z", "data": map[string]interface{}{"orderId": "order-1"}},
+		},
+		{
+			name:         "missing timestamp",
+			event:        map[string]interface{}{"data": map[string]interface{}{}},
+			wantProblems: []string{"timestamp is required"},
+		},
+		{
+			name:         "timestamp not RFC3339",
+			event:        map[string]interface{}{"timestamp": "2024-01-02 03:04:05", "data": map[string]interface{}{}},
+			wantProblems: []string{"timestamp must be an RFC3339 timestamp"},
+		},
+		{
+			name:         "timestamp as epoch millis",
+			event:        map[string]interface{}{"timestamp": float64(1704164645000), "data": map[string]interface{}{}},
+			wantProblems: []string{"timestamp must be a string"},
+		},
+		{
+			name:         "malformed email",
+			event:        map[string]interface{}{"timestamp": "2024-01-02T03:04:05Z", "data": map[string]interface{}{"email": "not-an-email"}},
+			wantProblems: []string{"email is invalid"},
+		},
+		{
+			name:         "both",
+			event:        map[string]interface{}{"timestamp": "yesterday", "data": map[string]interface{}{"email": 42.0}},
+			wantProblems: []string{"timestamp must be an RFC3339 timestamp", "email is invalid"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Strict rejects the event with its first problem
+			warnings, err := Strict.Check(tt.event)
+			if len(warnings) != 0 {
+				t.Errorf("Strict.Check() warnings = %v, want none", warnings)
+			}
+			if len(tt.wantProblems) == 0 {
+				if err != nil {
+					t.Errorf("Strict.Check() error = %v, want nil", err)
+				}
+			} else if err == nil || err.Error() != tt.wantProblems[0] {
+				t.Errorf("Strict.Check() error = %v, want %q", err, tt.wantProblems[0])
+			}
+
+			// Lenient accepts it and reports every problem as a warning
+			warnings, err = Lenient.Check(tt.event)
+			if err != nil {
+				t.Errorf("Lenient.Check() error = %v, want nil", err)
+			}
+			if len(warnings) != len(tt.wantProblems) {
+				t.Fatalf("Lenient.Check() warnings = %v, want %q", warnings, tt.wantProblems)
+			}
+			for i, w := range warnings {
+				if w.Error() != tt.wantProblems[i] {
+					t.Errorf("warning %d = %q, want %q", i, w, tt.wantProblems[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseProfile(t *testing.T) {
+	for _, value := range []string{"strict", "lenient"} {
+		if p, err := ParseProfile(value); err != nil || string(p) != value {
+			t.Errorf("ParseProfile(%q) = %q, %v", value, p, err)
+		}
+	}
+	for _, value := range []string{"", "Strict", "relaxed"} {
+		if _, err := ParseProfile(value); err == nil || !strings.Contains(err.Error(), "unknown validation profile") {
+			t.Errorf("ParseProfile(%q) error = %v, want unknown profile", value, err)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+-- Optional: one row per consumer instance, refreshed periodically.
+-- Apply this before enabling CONSUMER_HEARTBEAT_INTERVAL on the consumer.
+USE events;
+GO
+
+IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='consumer_heartbeats' AND xtype='U')
+BEGIN
+    CREATE TABLE consumer_heartbeats (
+        pod_id VARCHAR(255) PRIMARY KEY,
+        started_at DATETIME2 NOT NULL,
+        last_seen_at DATETIME2 NOT NULL,
+        last_processed_at DATETIME2 NULL
+    );
+END
+GO
+
+PRINT 'consumer_heartbeats table created';
@@ -0,0 +1,26 @@
+-- Optional: record which consumer instance last wrote each row.
+-- Apply this before enabling PROCESSED_BY_COLUMN=true on the consumer.
+USE events;
+GO
+
+IF COL_LENGTH('users', 'processed_by') IS NULL
+    ALTER TABLE users ADD processed_by VARCHAR(255) NULL;
+GO
+
+IF COL_LENGTH('orders', 'processed_by') IS NULL
+    ALTER TABLE orders ADD processed_by VARCHAR(255) NULL;
+GO
+
+IF COL_LENGTH('payments', 'processed_by') IS NULL
+    ALTER TABLE payments ADD processed_by VARCHAR(255) NULL;
+GO
+
+IF COL_LENGTH('inventory', 'processed_by') IS NULL
+    ALTER TABLE inventory ADD processed_by VARCHAR(255) NULL;
+GO
+
+IF COL_LENGTH('product_reviews', 'processed_by') IS NULL
+    ALTER TABLE product_reviews ADD processed_by VARCHAR(255) NULL;
+GO
+
+PRINT 'processed_by columns added';
@@ -21,6 +21,19 @@ BEGIN
 END
 GO
 
+-- Table type used by bulk user imports
+IF NOT EXISTS (SELECT * FROM sys.types WHERE name = 'UserImportType' AND is_table_type = 1)
+BEGIN
+    CREATE TYPE dbo.UserImportType AS TABLE (
+        user_id VARCHAR(100) NOT NULL PRIMARY KEY,
+        name VARCHAR(255),
+        email VARCHAR(255),
+        created_at DATETIME2,
+        updated_at DATETIME2
+    );
+END
+GO
+
 -- Create orders table
 IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='orders' AND xtype='U')
 BEGIN
@@ -31,11 +44,19 @@ BEGIN
         status VARCHAR(50),
         created_at DATETIME2,
         updated_at DATETIME2,
+        reminder_sent_at DATETIME2 NULL,
         CONSTRAINT FK_Orders_Users FOREIGN KEY (user_id) REFERENCES users(user_id)
     );
 END
 GO
 
+-- Add reminder tracking to existing orders tables
+IF COL_LENGTH('orders', 'reminder_sent_at') IS NULL
+BEGIN
+    ALTER TABLE orders ADD reminder_sent_at DATETIME2 NULL;
+END
+GO
+
 -- Create payments table
 IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='payments' AND xtype='U')
 BEGIN
@@ -60,6 +81,31 @@ BEGIN
 END
 GO
 
+-- Event IDs already applied, so non-idempotent events (InventoryAdjusted) are
+-- skipped when redelivered
+IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='processed_events' AND xtype='U')
+BEGIN
+    CREATE TABLE processed_events (
+        event_id VARCHAR(100) PRIMARY KEY,
+        processed_at DATETIME2 NOT NULL
+    );
+END
+GO
+
+-- Append-only log of inventory adjustments and their reasons; inventory keeps the running total
+IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='inventory_adjustments' AND xtype='U')
+BEGIN
+    CREATE TABLE inventory_adjustments (
+        id BIGINT IDENTITY(1,1) PRIMARY KEY,
+        sku VARCHAR(100) NOT NULL,
+        event_id VARCHAR(100) NULL,
+        delta INT NOT NULL,
+        reason NVARCHAR(255) NOT NULL DEFAULT '',
+        adjusted_at DATETIME2 NOT NULL
+    );
+    CREATE INDEX IX_inventory_adjustments_sku ON inventory_adjustments (sku, adjusted_at);
+END
+GO
 
 IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='product_reviews' AND xtype='U')
 BEGIN
@@ -69,6 +115,7 @@ BEGIN
         username VARCHAR(255) NOT NULL,
         rating INT NOT NULL CHECK (rating >= 1 AND rating <= 5),
         remarks VARCHAR(MAX),
+        verified BIT NOT NULL DEFAULT 0,
         created_at DATETIME2,
         updated_at DATETIME2
     );
@@ -88,12 +135,32 @@ BEGIN
 END
 GO
 
+IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'IX_orders_status_created_at')
+BEGIN
+    CREATE INDEX IX_orders_status_created_at ON orders(status, created_at DESC);
+END
+GO
+
 IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'IX_product_reviews_product_name')
 BEGIN
     CREATE INDEX IX_product_reviews_product_name ON product_reviews(product_name);
 END
 GO
 
+-- Add verified-purchase flag to existing product_reviews tables
+IF COL_LENGTH('product_reviews', 'verified') IS NULL
+BEGIN
+    ALTER TABLE product_reviews ADD verified BIT NOT NULL CONSTRAINT DF_product_reviews_verified DEFAULT 0;
+END
+GO
+
+-- Supports keyset pagination of a product's reviews, newest first
+IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'IX_product_reviews_product_created')
+BEGIN
+    CREATE INDEX IX_product_reviews_product_created ON product_reviews(product_name, created_at DESC, review_id DESC);
+END
+GO
+
 IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'IX_product_reviews_username')
 BEGIN
     CREATE INDEX IX_product_reviews_username ON product_reviews(username);
@@ -106,4 +173,17 @@ BEGIN
 END
 GO
 
+-- Soft delete for users and orders: a non-NULL deleted_at hides the row from reads
+IF COL_LENGTH('users', 'deleted_at') IS NULL
+BEGIN
+    ALTER TABLE users ADD deleted_at DATETIME2 NULL;
+END
+GO
+
+IF COL_LENGTH('orders', 'deleted_at') IS NULL
+BEGIN
+    ALTER TABLE orders ADD deleted_at DATETIME2 NULL;
+END
+GO
+
 PRINT 'Database schema created successfully';